@@ -18,6 +18,7 @@ package v1alpha1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	types "k8s.io/apimachinery/pkg/types"
@@ -34,6 +35,32 @@ const (
 	AllocationStatusUngated  AllocationStatusController = "ungated"
 	AllocationStatusCreating AllocationStatusController = "creating"
 	AllocationStatusCreated  AllocationStatusDaemonset  = "created"
+	// AllocationStatusReserved marks the slices held by a SliceReservation in the allocation cache
+	// of the controller, it is never written to an Instaslice
+	AllocationStatusReserved AllocationStatusController = "reserved"
+)
+
+// Conditions of an allocation, Placed, Ungated and Releasing are set by the controller, the
+// others by the daemonset of the node
+const (
+	// AllocationConditionPlaced is set once the node, GPUs and starts of the slices are chosen
+	AllocationConditionPlaced = "Placed"
+	// AllocationConditionSliceCreated is set once the MIG slices exist on the GPUs
+	AllocationConditionSliceCreated = "SliceCreated"
+	// AllocationConditionConfigMapReady is set once the ConfigMaps of the GPU containers exist
+	AllocationConditionConfigMapReady = "ConfigMapReady"
+	// AllocationConditionUngated is set once the scheduling gate of the pod is removed
+	AllocationConditionUngated = "Ungated"
+	// AllocationConditionReleasing is set once the controller releases the slices of the
+	// allocation, Released tells when they are deleted
+	AllocationConditionReleasing = "Releasing"
+	// AllocationConditionReleased is set once the MIG slices and ConfigMaps are deleted
+	AllocationConditionReleased = "Released"
+	// AllocationConditionFailed is true while a step of the allocation fails, it is retried
+	AllocationConditionFailed = "Failed"
+	// AllocationConditionTimedOut is set when the daemonset did not move an allocation out of
+	// creating or deleting before the deadline of the state
+	AllocationConditionTimedOut = "TimedOut"
 )
 
 type AllocationRequest struct {
@@ -44,6 +71,73 @@ type AllocationRequest struct {
 	// podRef is a reference to the gated Pod requesting the allocation
 	// +optional
 	PodRef corev1.ObjectReference `json:"podRef"`
+
+	// quantity is the number of slices of the profile requested by the pod, 0 is treated as 1
+	// +optional
+	Quantity int32 `json:"quantity,omitempty"`
+
+	// containers lists the slice requests of every GPU container of the pod, profile and
+	// quantity mirror the first main container for clients that only know about one container.
+	// +optional
+	Containers []ContainerRequest `json:"containers,omitempty"`
+}
+
+// SliceCount returns the number of slices requested, at least 1
+func (r AllocationRequest) SliceCount() int32 {
+	if r.Quantity < 1 {
+		return 1
+	}
+	return r.Quantity
+}
+
+// AllContainers returns the container requests, falling back to a single container using
+// profile and quantity for requests written before containers existed.
+func (r AllocationRequest) AllContainers() []ContainerRequest {
+	if len(r.Containers) > 0 {
+		return r.Containers
+	}
+	return []ContainerRequest{{Profile: r.Profile, Quantity: r.Quantity}}
+}
+
+type ContainerRequest struct {
+	// name of the container requesting the slices
+	// +required
+	Name string `json:"name"`
+
+	// profile specifies the MIG slice profile requested by the container, for containers accepting
+	// several profiles it is the profile the slices were allocated with
+	// +required
+	Profile string `json:"profile"`
+
+	// acceptableProfiles lists in order of preference the profiles the container can run with,
+	// empty when the container only accepts profile
+	// +optional
+	AcceptableProfiles []string `json:"acceptableProfiles,omitempty"`
+
+	// acceleratorMemory is the GPU memory requested by the container instead of a profile, the
+	// smallest profile of the node with as much memory and computePercent is allocated
+	// +optional
+	AcceleratorMemory *resource.Quantity `json:"acceleratorMemory,omitempty"`
+
+	// computePercent is the share of the compute of a GPU requested by the container instead of a profile
+	// +optional
+	ComputePercent int32 `json:"computePercent,omitempty"`
+
+	// quantity is the number of slices of the profile requested by the container, 0 is treated as 1
+	// +optional
+	Quantity int32 `json:"quantity,omitempty"`
+
+	// init is set for init containers, which run before the main containers and can reuse their slices
+	// +optional
+	Init bool `json:"init,omitempty"`
+}
+
+// SliceCount returns the number of slices requested by the container, at least 1
+func (r ContainerRequest) SliceCount() int32 {
+	if r.Quantity < 1 {
+		return 1
+	}
+	return r.Quantity
 }
 
 type AllocationStatus struct {
@@ -83,6 +177,76 @@ type AllocationResult struct {
 	// configMapResourceIdentifier represents the UUID used for creating the ConfigMap resource
 	// +required
 	ConfigMapResourceIdentifier types.UID `json:"configMapResourceIdentifier"`
+
+	// slices lists every slice allocated to the pod, all on the same node. migPlacement
+	// and gpuUUID mirror the first slice for clients that only know about one slice.
+	// +optional
+	Slices []SliceResult `json:"slices,omitempty"`
+
+	// containers maps the GPU containers of the pod to their ConfigMap and slices
+	// +optional
+	Containers []ContainerResult `json:"containers,omitempty"`
+}
+
+type SliceResult struct {
+	// migPlacement specifies the MIG placement details
+	// +required
+	MigPlacement Placement `json:"migPlacement"`
+
+	// gpuUUID represents the UUID of the selected GPU
+	// +required
+	GPUUUID string `json:"gpuUUID"`
+
+	// profile specifies the MIG slice profile of the slice, empty means the profile of the allocation request
+	// +optional
+	Profile string `json:"profile,omitempty"`
+
+	// computePlacement specifies the compute slices of the GPU instance at migPlacement taken by the
+	// compute instance of the slice, set for profiles sharing a GPU instance with other slices
+	// +optional
+	ComputePlacement *Placement `json:"computePlacement,omitempty"`
+}
+
+type ContainerResult struct {
+	// name of the container the slices are exposed to
+	// +required
+	Name string `json:"name"`
+
+	// configMapResourceIdentifier represents the UUID used for creating the ConfigMap of the container
+	// +required
+	ConfigMapResourceIdentifier types.UID `json:"configMapResourceIdentifier"`
+
+	// slices are the indexes of the slices of the allocation used by the container
+	// +optional
+	Slices []int32 `json:"slices,omitempty"`
+}
+
+// SetCondition sets a condition of the allocation, its transition time only changes with its
+// status. It reports whether the condition changed.
+func (r *AllocationResult) SetCondition(conditionType string, status metav1.ConditionStatus, reason, message string) bool {
+	return meta.SetStatusCondition(&r.Conditions, metav1.Condition{Type: conditionType, Status: status, Reason: reason, Message: message})
+}
+
+// AllSlices returns the slices of the allocation, falling back to migPlacement and gpuUUID
+// for allocations written before slices existed.
+func (r AllocationResult) AllSlices() []SliceResult {
+	if len(r.Slices) > 0 {
+		return r.Slices
+	}
+	return []SliceResult{{MigPlacement: r.MigPlacement, GPUUUID: r.GPUUUID}}
+}
+
+// AllContainers returns the containers of the allocation, falling back to a single container
+// using configMapResourceIdentifier and every slice for allocations written before containers existed.
+func (r AllocationResult) AllContainers() []ContainerResult {
+	if len(r.Containers) > 0 {
+		return r.Containers
+	}
+	container := ContainerResult{ConfigMapResourceIdentifier: r.ConfigMapResourceIdentifier}
+	for i := range r.AllSlices() {
+		container.Slices = append(container.Slices, int32(i))
+	}
+	return []ContainerResult{container}
 }
 
 type DiscoveredGPU struct {
@@ -129,6 +293,20 @@ type Mig struct {
 	// ciEngProfileId provides the compute instance engineering ID of a profile
 	// +optional
 	CIEngProfileID int32 `json:"ciEngProfileId,omitempty"`
+
+	// ciSliceCount is the number of compute slices of the compute instance of a profile
+	// +optional
+	CISliceCount int32 `json:"ciSliceCount,omitempty"`
+
+	// giSliceCount is the number of compute slices of the GPU instance of a profile
+	// +optional
+	GISliceCount int32 `json:"giSliceCount,omitempty"`
+}
+
+// SharesGPUInstance reports whether the compute instance of the profile takes only part of its
+// GPU instance, which is then shared with other slices of the same GPU instance profile
+func (m Mig) SharesGPUInstance() bool {
+	return m.CISliceCount > 0 && m.CISliceCount < m.GISliceCount
 }
 
 type Placement struct {
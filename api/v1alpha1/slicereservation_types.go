@@ -0,0 +1,111 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+)
+
+type SliceReservationSpec struct {
+	// profile specifies the MIG slice profile of the reserved slices, profiles sharing a GPU
+	// instance cannot be reserved
+	// +required
+	Profile string `json:"profile"`
+
+	// count is the number of slices held for the pods of the namespace
+	// +kubebuilder:validation:Minimum=0
+	// +required
+	Count int32 `json:"count"`
+}
+
+type ReservedSlice struct {
+	// nodename represents the name of the node of the slice
+	// +required
+	Nodename types.NodeName `json:"nodename"`
+
+	// gpuUUID represents the UUID of the GPU of the slice
+	// +required
+	GPUUUID string `json:"gpuUUID"`
+
+	// migPlacement specifies the MIG placement of the slice
+	// +required
+	MigPlacement Placement `json:"migPlacement"`
+
+	// podUID is the pod the slice is bound to, empty while the slice is free
+	// +optional
+	PodUID types.UID `json:"podUID,omitempty"`
+}
+
+type SliceReservationStatus struct {
+	// slices lists the placements held by the reservation
+	// +optional
+	Slices []ReservedSlice `json:"slices,omitempty"`
+
+	// bound is the number of reserved slices used by pods of the namespace
+	// +optional
+	Bound int32 `json:"bound"`
+
+	// free is the number of reserved slices waiting for a pod of the namespace
+	// +optional
+	Free int32 `json:"free"`
+
+	// pending is the number of slices of count that could not be placed on any node yet
+	// +optional
+	Pending int32 `json:"pending"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Profile",type=string,JSONPath=`.spec.profile`
+//+kubebuilder:printcolumn:name="Count",type=integer,JSONPath=`.spec.count`
+//+kubebuilder:printcolumn:name="Bound",type=integer,JSONPath=`.status.bound`
+//+kubebuilder:printcolumn:name="Free",type=integer,JSONPath=`.status.free`
+//+kubebuilder:printcolumn:name="Pending",type=integer,JSONPath=`.status.pending`
+
+// SliceReservation holds slices of a profile for the pods of its namespace, no other pod is
+// allocated their placements
+type SliceReservation struct {
+	metav1.TypeMeta `json:",inline"`
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// spec specifies the profile and number of reserved slices
+	// +optional
+	Spec SliceReservationSpec `json:"spec"`
+
+	// status provides the placements held by the reservation and the pods using them
+	// +optional
+	Status SliceReservationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SliceReservationList contains a list of SliceReservation resources
+type SliceReservationList struct {
+	metav1.TypeMeta `json:",inline"`
+	// +optional
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	// items provides the list of slice reservations
+	// +optional
+	Items []SliceReservation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SliceReservation{}, &SliceReservationList{})
+}
@@ -30,6 +30,13 @@ import (
 func (in *AllocationRequest) DeepCopyInto(out *AllocationRequest) {
 	*out = *in
 	out.PodRef = in.PodRef
+	if in.Containers != nil {
+		in, out := &in.Containers, &out.Containers
+		*out = make([]ContainerRequest, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AllocationRequest.
@@ -54,6 +61,20 @@ func (in *AllocationResult) DeepCopyInto(out *AllocationResult) {
 	}
 	out.MigPlacement = in.MigPlacement
 	out.AllocationStatus = in.AllocationStatus
+	if in.Slices != nil {
+		in, out := &in.Slices, &out.Slices
+		*out = make([]SliceResult, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Containers != nil {
+		in, out := &in.Containers, &out.Containers
+		*out = make([]ContainerResult, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AllocationResult.
@@ -81,6 +102,51 @@ func (in *AllocationStatus) DeepCopy() *AllocationStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerRequest) DeepCopyInto(out *ContainerRequest) {
+	*out = *in
+	if in.AcceptableProfiles != nil {
+		in, out := &in.AcceptableProfiles, &out.AcceptableProfiles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AcceleratorMemory != nil {
+		in, out := &in.AcceleratorMemory, &out.AcceleratorMemory
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerRequest.
+func (in *ContainerRequest) DeepCopy() *ContainerRequest {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerRequest)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerResult) DeepCopyInto(out *ContainerResult) {
+	*out = *in
+	if in.Slices != nil {
+		in, out := &in.Slices, &out.Slices
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerResult.
+func (in *ContainerResult) DeepCopy() *ContainerResult {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DiscoveredGPU) DeepCopyInto(out *DiscoveredGPU) {
 	*out = *in
@@ -192,7 +258,7 @@ func (in *InstasliceSpec) DeepCopyInto(out *InstasliceSpec) {
 		in, out := &in.PodAllocationRequests, &out.PodAllocationRequests
 		*out = make(map[types.UID]AllocationRequest, len(*in))
 		for key, val := range *in {
-			(*out)[key] = val
+			(*out)[key] = *val.DeepCopy()
 		}
 	}
 }
@@ -271,3 +337,134 @@ func (in *Placement) DeepCopy() *Placement {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReservedSlice) DeepCopyInto(out *ReservedSlice) {
+	*out = *in
+	out.MigPlacement = in.MigPlacement
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReservedSlice.
+func (in *ReservedSlice) DeepCopy() *ReservedSlice {
+	if in == nil {
+		return nil
+	}
+	out := new(ReservedSlice)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SliceReservation) DeepCopyInto(out *SliceReservation) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SliceReservation.
+func (in *SliceReservation) DeepCopy() *SliceReservation {
+	if in == nil {
+		return nil
+	}
+	out := new(SliceReservation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SliceReservation) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SliceReservationList) DeepCopyInto(out *SliceReservationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]SliceReservation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SliceReservationList.
+func (in *SliceReservationList) DeepCopy() *SliceReservationList {
+	if in == nil {
+		return nil
+	}
+	out := new(SliceReservationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SliceReservationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SliceReservationSpec) DeepCopyInto(out *SliceReservationSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SliceReservationSpec.
+func (in *SliceReservationSpec) DeepCopy() *SliceReservationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SliceReservationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SliceReservationStatus) DeepCopyInto(out *SliceReservationStatus) {
+	*out = *in
+	if in.Slices != nil {
+		in, out := &in.Slices, &out.Slices
+		*out = make([]ReservedSlice, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SliceReservationStatus.
+func (in *SliceReservationStatus) DeepCopy() *SliceReservationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SliceReservationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SliceResult) DeepCopyInto(out *SliceResult) {
+	*out = *in
+	out.MigPlacement = in.MigPlacement
+	if in.ComputePlacement != nil {
+		in, out := &in.ComputePlacement, &out.ComputePlacement
+		*out = new(Placement)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SliceResult.
+func (in *SliceResult) DeepCopy() *SliceResult {
+	if in == nil {
+		return nil
+	}
+	out := new(SliceResult)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -144,6 +144,14 @@ func main() {
 
 	config := config.ConfigFromEnvironment()
 	setupLog.Info("using config", "config", config.ToString())
+	if _, err := controller.NewAllocationPolicy(config.AllocationPolicy); err != nil {
+		setupLog.Error(err, "invalid allocation policy")
+		os.Exit(1)
+	}
+	if err := controller.ValidateNodeSelectionStrategy(config.NodeSelectionStrategy); err != nil {
+		setupLog.Error(err, "invalid node selection strategy")
+		os.Exit(1)
+	}
 	runningOnOpenShift := utils.RunningOnOpenshift(context.Background(), mgr.GetClient())
 	if runningOnOpenShift {
 		setupLog.Info("Running on OpenShift")
@@ -165,13 +173,15 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controller.InstasliceReconciler{
+	reconciler := &controller.InstasliceReconciler{
 		Client:             mgr.GetClient(),
 		Scheme:             mgr.GetScheme(),
 		Config:             config,
 		RunningOnOpenShift: runningOnOpenShift,
 		ResourceCache:      tracker.Cache(),
-	}).SetupWithManager(mgr); err != nil {
+		Recorder:           mgr.GetEventRecorderFor("instaslice-controller"),
+	}
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Instaslice")
 		os.Exit(1)
 	}
@@ -189,7 +199,8 @@ func main() {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	// the leader is ready once it recovered the allocation state
+	if err := mgr.AddReadyzCheck("readyz", reconciler.ReadyzCheck); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
@@ -38,6 +38,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller"
 	"github.com/openshift/instaslice-operator/internal/controller/config"
 	"github.com/openshift/instaslice-operator/internal/controller/daemonset"
 	//+kubebuilder:scaffold:imports
@@ -146,6 +147,8 @@ func main() {
 		setupLog.Error(err, "could not create daemonset reconciler")
 		os.Exit(1)
 	}
+	reconciler.Recorder = mgr.GetEventRecorderFor("instaslice-daemonset")
+	controller.RegisterOrphanMetrics()
 
 	if err := reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "InstaSliceDaemonsetReconciler")
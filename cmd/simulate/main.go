@@ -0,0 +1,219 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// simulate reports where pods would be placed on the GPUs of a cluster without changing it.
+//
+//	simulate --pods pods.yaml [--snapshot cluster.yaml] [--output yaml|json]
+//
+// The pods file holds the pods to place as YAML documents. The snapshot file holds the
+// Instaslice, Node, Pod and SliceReservation objects the simulation starts from, the cluster
+// of the current kubeconfig is read when it is not given.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+	"github.com/openshift/instaslice-operator/internal/simulation"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(inferencev1alpha1.AddToScheme(scheme))
+}
+
+func main() {
+	var podsFile, snapshotFile, output string
+	cfg := config.ConfigFromEnvironment()
+	flag.StringVar(&podsFile, "pods", "", "YAML file with the pods to place.")
+	flag.StringVar(&snapshotFile, "snapshot", "",
+		"YAML file with the Instaslice, Node, Pod and SliceReservation objects to start from. "+
+			"The cluster of the current kubeconfig is read when empty.")
+	flag.StringVar(&output, "output", "yaml", "Format of the report, yaml or json.")
+	flag.StringVar(&cfg.AllocationPolicy, "allocation-policy", cfg.AllocationPolicy,
+		"Policy that picks the GPU and start of a slice.")
+	flag.StringVar(&cfg.NodeSelectionStrategy, "node-selection-strategy", cfg.NodeSelectionStrategy,
+		"Order in which nodes are tried for a slice.")
+	flag.Parse()
+
+	if err := run(context.Background(), podsFile, snapshotFile, output, cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, podsFile, snapshotFile, output string, cfg *config.Config) error {
+	if podsFile == "" {
+		return errors.New("--pods is required")
+	}
+	if output != "yaml" && output != "json" {
+		return fmt.Errorf("unknown output format %q", output)
+	}
+	podObjects, err := readObjects(podsFile)
+	if err != nil {
+		return err
+	}
+	var pods []v1.Pod
+	for _, object := range podObjects {
+		pod, ok := object.(*v1.Pod)
+		if !ok {
+			return fmt.Errorf("%s holds a %T, only pods can be placed", podsFile, object)
+		}
+		pods = append(pods, *pod)
+	}
+
+	var snapshot simulation.Snapshot
+	if snapshotFile != "" {
+		snapshot, err = readSnapshot(snapshotFile)
+	} else {
+		snapshot, err = liveSnapshot(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	report, err := simulation.Run(ctx, snapshot, pods, cfg)
+	if err != nil {
+		return err
+	}
+	var out []byte
+	if output == "json" {
+		out, err = json.MarshalIndent(report, "", "  ")
+		out = append(out, '\n')
+	} else {
+		out, err = yaml.Marshal(report)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// readObjects decodes the YAML documents of a file
+func readObjects(path string) ([]runtime.Object, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(file))
+	var objects []runtime.Object
+	for {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if len(bytes.TrimSpace(document)) == 0 {
+			continue
+		}
+		object, _, err := decoder.Decode(document, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", path, err)
+		}
+		objects = append(objects, object)
+	}
+}
+
+// readSnapshot reads the Instaslice, Node, Pod and SliceReservation objects of a file, lists are expanded
+func readSnapshot(path string) (simulation.Snapshot, error) {
+	var snapshot simulation.Snapshot
+	objects, err := readObjects(path)
+	if err != nil {
+		return snapshot, err
+	}
+	for _, object := range objects {
+		switch o := object.(type) {
+		case *inferencev1alpha1.Instaslice:
+			snapshot.Instaslices = append(snapshot.Instaslices, *o)
+		case *inferencev1alpha1.InstasliceList:
+			snapshot.Instaslices = append(snapshot.Instaslices, o.Items...)
+		case *v1.Node:
+			snapshot.Nodes = append(snapshot.Nodes, *o)
+		case *v1.NodeList:
+			snapshot.Nodes = append(snapshot.Nodes, o.Items...)
+		case *v1.Pod:
+			snapshot.Pods = append(snapshot.Pods, *o)
+		case *v1.PodList:
+			snapshot.Pods = append(snapshot.Pods, o.Items...)
+		case *inferencev1alpha1.SliceReservation:
+			snapshot.SliceReservations = append(snapshot.SliceReservations, *o)
+		case *inferencev1alpha1.SliceReservationList:
+			snapshot.SliceReservations = append(snapshot.SliceReservations, o.Items...)
+		default:
+			return snapshot, fmt.Errorf("%s holds a %T, a snapshot has Instaslice, Node, Pod and SliceReservation objects", path, object)
+		}
+	}
+	return snapshot, nil
+}
+
+// liveSnapshot lists the Instaslice, Node, Pod and SliceReservation objects of the cluster
+func liveSnapshot(ctx context.Context) (simulation.Snapshot, error) {
+	var snapshot simulation.Snapshot
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		return snapshot, err
+	}
+	var instaslices inferencev1alpha1.InstasliceList
+	if err := c.List(ctx, &instaslices, client.InNamespace(controller.InstaSliceOperatorNamespace)); err != nil {
+		return snapshot, err
+	}
+	var nodes v1.NodeList
+	if err := c.List(ctx, &nodes); err != nil {
+		return snapshot, err
+	}
+	var pods v1.PodList
+	if err := c.List(ctx, &pods); err != nil {
+		return snapshot, err
+	}
+	var reservations inferencev1alpha1.SliceReservationList
+	if err := c.List(ctx, &reservations); err != nil {
+		return snapshot, err
+	}
+	snapshot.Instaslices, snapshot.Nodes, snapshot.Pods = instaslices.Items, nodes.Items, pods.Items
+	snapshot.SliceReservations = reservations.Items
+	return snapshot, nil
+}
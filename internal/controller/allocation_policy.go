@@ -0,0 +1,296 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	FirstFitPolicyName    = "first-fit"
+	BestFitPolicyName     = "best-fit"
+	LeftToRightPolicyName = "left-to-right"
+	RightToLeftPolicyName = "right-to-left"
+	// FragmentationAwarePolicyName scores every free placement by the capacity it leaves
+	// for the other profiles
+	FragmentationAwarePolicyName = "fragmentation-aware"
+)
+
+// GPUCandidate is a GPU that may host a slice, along with the slots
+// that are already allocated on it.
+type GPUCandidate struct {
+	GPUUUID   string
+	Allocated GPUSlots
+	// Shared holds the GPU instances of the GPU split between compute instances by their start
+	Shared map[int32]*SharedGPUInstance
+}
+
+// AllocationPolicy decides on which GPU and at which start index a slice is placed
+// and builds the allocation request and result for the chosen placement.
+type AllocationPolicy interface {
+	// Name returns the name the policy is registered under.
+	Name() string
+
+	// SelectPlacement picks a GPU and a start index for profileName. Candidates are
+	// passed in preference order, policies following the device order of the node may
+	// reorder them; ok is false when the profile fits on none of them.
+	SelectPlacement(instaslice *inferencev1alpha1.Instaslice, profileName string, candidates []GPUCandidate) (gpuUUID string, start int32, ok bool)
+
+	SetAllocationDetails(profileName string, newStart, size int32, podUUID types.UID, nodename types.NodeName, allocationStatus inferencev1alpha1.AllocationStatus,
+		discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32, namespace string, podName string, gpuUuid string, resourceIndetifier types.UID) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult)
+}
+
+var (
+	allocationPoliciesMu sync.RWMutex
+	allocationPolicies   = map[string]func() AllocationPolicy{
+		FirstFitPolicyName:           func() AllocationPolicy { return &FirstFitPolicy{} },
+		BestFitPolicyName:            func() AllocationPolicy { return &BestFitPolicy{} },
+		LeftToRightPolicyName:        func() AllocationPolicy { return &LeftToRightPolicy{} },
+		RightToLeftPolicyName:        func() AllocationPolicy { return &RightToLeftPolicy{} },
+		FragmentationAwarePolicyName: func() AllocationPolicy { return &FragmentationAwarePolicy{} },
+	}
+)
+
+// RegisterAllocationPolicy makes a policy selectable by name through config.Config.
+// Registering an existing name replaces the previous factory.
+func RegisterAllocationPolicy(name string, factory func() AllocationPolicy) {
+	allocationPoliciesMu.Lock()
+	defer allocationPoliciesMu.Unlock()
+	allocationPolicies[name] = factory
+}
+
+// NewAllocationPolicy returns a new instance of the policy registered under name.
+func NewAllocationPolicy(name string) (AllocationPolicy, error) {
+	allocationPoliciesMu.RLock()
+	defer allocationPoliciesMu.RUnlock()
+	factory, ok := allocationPolicies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown allocation policy %q, valid policies are %v", name, registeredPolicyNames())
+	}
+	return factory(), nil
+}
+
+// registeredPolicyNames returns the sorted policy names, callers must hold allocationPoliciesMu.
+func registeredPolicyNames() []string {
+	names := make([]string, 0, len(allocationPolicies))
+	for name := range allocationPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// allocationDetails builds the allocation structs and is shared by all policies
+type allocationDetails struct{}
+
+// first fit picks the first discovered placement on the first GPU with room
+type FirstFitPolicy struct {
+	allocationDetails
+}
+
+// best fit picks the placement that leaves the smallest free block behind
+type BestFitPolicy struct {
+	allocationDetails
+}
+
+// left to right walks the GPUs in the order the node enumerates them, from device 0 up, and
+// picks the lowest start index on the first GPU with room
+type LeftToRightPolicy struct {
+	allocationDetails
+}
+
+// right to left walks the GPUs from the last device of the node down and picks the highest
+// start index on the first GPU with room, which keeps the low indexes that large profiles
+// depend on free for longer
+type RightToLeftPolicy struct {
+	allocationDetails
+}
+
+// fragmentation aware simulates every free placement and picks the one that loses the
+// least capacity for the profiles of the node, weighted by profile size so that room
+// for 3g, 4g and 7g slices is worth more than room for 1g slices
+type FragmentationAwarePolicy struct {
+	allocationDetails
+}
+
+func (*FirstFitPolicy) Name() string { return FirstFitPolicyName }
+
+func (*BestFitPolicy) Name() string { return BestFitPolicyName }
+
+func (*LeftToRightPolicy) Name() string { return LeftToRightPolicyName }
+
+func (*RightToLeftPolicy) Name() string { return RightToLeftPolicyName }
+
+func (*FragmentationAwarePolicy) Name() string { return FragmentationAwarePolicyName }
+
+// Policy based allocation - FirstFit
+func (*FirstFitPolicy) SelectPlacement(instaslice *inferencev1alpha1.Instaslice, profileName string, candidates []GPUCandidate) (string, int32, bool) {
+	for _, candidate := range candidates {
+		starts := freePlacementStarts(instaslice, profileName, candidate.Allocated)
+		if len(starts) > 0 {
+			return candidate.GPUUUID, starts[0], true
+		}
+	}
+	return "", 0, false
+}
+
+// Policy based allocation - BestFit
+func (*BestFitPolicy) SelectPlacement(instaslice *inferencev1alpha1.Instaslice, profileName string, candidates []GPUCandidate) (string, int32, bool) {
+	size := profileSize(instaslice, profileName)
+	bestGPU, bestStart, bestLeftover := "", int32(0), int32(-1)
+	for _, candidate := range candidates {
+		for _, start := range freePlacementStarts(instaslice, profileName, candidate.Allocated) {
+			leftover := candidate.Allocated.FreeBlockLength(start) - size
+			if bestLeftover < 0 || leftover < bestLeftover {
+				bestGPU, bestStart, bestLeftover = candidate.GPUUUID, start, leftover
+			}
+		}
+	}
+	return bestGPU, bestStart, bestLeftover >= 0
+}
+
+// Policy based allocation - LeftToRight
+func (*LeftToRightPolicy) SelectPlacement(instaslice *inferencev1alpha1.Instaslice, profileName string, candidates []GPUCandidate) (string, int32, bool) {
+	for _, candidate := range deviceOrder(instaslice, candidates) {
+		starts := freePlacementStarts(instaslice, profileName, candidate.Allocated)
+		if len(starts) > 0 {
+			sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+			return candidate.GPUUUID, starts[0], true
+		}
+	}
+	return "", 0, false
+}
+
+// Policy based allocation - RightToLeft
+func (*RightToLeftPolicy) SelectPlacement(instaslice *inferencev1alpha1.Instaslice, profileName string, candidates []GPUCandidate) (string, int32, bool) {
+	ordered := deviceOrder(instaslice, candidates)
+	for i := len(ordered) - 1; i >= 0; i-- {
+		starts := freePlacementStarts(instaslice, profileName, ordered[i].Allocated)
+		if len(starts) > 0 {
+			sort.Slice(starts, func(i, j int) bool { return starts[i] > starts[j] })
+			return ordered[i].GPUUUID, starts[0], true
+		}
+	}
+	return "", 0, false
+}
+
+// deviceOrder returns the candidates in the order the GPUs are listed in the node resources,
+// which is the device index order of the node. GPUs the node does not list go last.
+func deviceOrder(instaslice *inferencev1alpha1.Instaslice, candidates []GPUCandidate) []GPUCandidate {
+	index := make(map[string]int, len(instaslice.Status.NodeResources.NodeGPUs))
+	for i, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+		index[gpu.GPUUUID] = i
+	}
+	position := func(gpuUUID string) int {
+		if i, ok := index[gpuUUID]; ok {
+			return i
+		}
+		return len(index)
+	}
+	ordered := append([]GPUCandidate(nil), candidates...)
+	sort.SliceStable(ordered, func(i, j int) bool { return position(ordered[i].GPUUUID) < position(ordered[j].GPUUUID) })
+	return ordered
+}
+
+// Policy based allocation - FragmentationAware
+func (*FragmentationAwarePolicy) SelectPlacement(instaslice *inferencev1alpha1.Instaslice, profileName string, candidates []GPUCandidate) (string, int32, bool) {
+	size := profileSize(instaslice, profileName)
+	bestGPU, bestStart, bestLoss, found := "", int32(0), int32(0), false
+	for _, candidate := range candidates {
+		before := fragmentationScore(instaslice, candidate.Allocated)
+		for _, start := range freePlacementStarts(instaslice, profileName, candidate.Allocated) {
+			after := candidate.Allocated.Clone()
+			after.Allocate(start, size)
+			// ties keep the earlier candidate, so equal scores fall back to first fit
+			loss := before - fragmentationScore(instaslice, after)
+			if !found || loss < bestLoss {
+				bestGPU, bestStart, bestLoss, found = candidate.GPUUUID, start, loss, true
+			}
+		}
+	}
+	return bestGPU, bestStart, found
+}
+
+// fragmentationScore sums, over every profile of the node, the number of slices that still
+// fit on the GPU multiplied by the profile size.
+func fragmentationScore(instaslice *inferencev1alpha1.Instaslice, gpuAllocatedIndex GPUSlots) int32 {
+	var score int32
+	for profileName, mig := range instaslice.Status.NodeResources.MigPlacement {
+		// profiles sharing a GPU instance fit wherever their GPU instance profile fits
+		if mig.SharesGPUInstance() {
+			continue
+		}
+		score += countProfileFits(instaslice, profileName, gpuAllocatedIndex) * profileSize(instaslice, profileName)
+	}
+	return score
+}
+
+func (allocationDetails) SetAllocationDetails(profileName string, newStart, size int32, podUUID types.UID, nodename types.NodeName,
+	allocationStatus inferencev1alpha1.AllocationStatus, discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32,
+	namespace string, podName string, gpuUuid string, resourceIdentifier types.UID) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult) {
+	return &inferencev1alpha1.AllocationRequest{
+		Profile: profileName,
+		PodRef: v1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: namespace,
+			Name:      podName,
+			UID:       podUUID,
+		},
+	}, &inferencev1alpha1.AllocationResult{
+		MigPlacement: inferencev1alpha1.Placement{
+			Size:  size,
+			Start: newStart,
+		},
+		GPUUUID:                     gpuUuid,
+		Nodename:                    nodename,
+		AllocationStatus:            allocationStatus,
+		ConfigMapResourceIdentifier: resourceIdentifier,
+		Conditions:                  []metav1.Condition{},
+	}
+}
+
+// profileSize returns the number of contiguous slots a profile needs, 0 if the profile is unknown
+func profileSize(instaslice *inferencev1alpha1.Instaslice, profileName string) int32 {
+	placement, ok := instaslice.Status.NodeResources.MigPlacement[profileName]
+	if !ok || len(placement.Placements) == 0 {
+		return 0
+	}
+	return placement.Placements[0].Size
+}
+
+// freePlacementStarts returns the discovered start indexes of a profile whose slots are
+// all free, in the order the placements were discovered.
+func freePlacementStarts(instaslice *inferencev1alpha1.Instaslice, profileName string, gpuAllocatedIndex GPUSlots) []int32 {
+	var starts []int32
+	placement, ok := instaslice.Status.NodeResources.MigPlacement[profileName]
+	if !ok {
+		return starts
+	}
+	for _, p := range placement.Placements {
+		if gpuAllocatedIndex.IsFree(p.Start, p.Size) {
+			starts = append(starts, p.Start)
+		}
+	}
+	return starts
+}
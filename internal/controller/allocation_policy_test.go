@@ -0,0 +1,175 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestAllocationPolicy_SelectPlacement(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("fake-node")
+	gpus := sortGPUs(instaslice)
+	gpu0, gpu1 := gpus[0], gpus[1]
+	// the node lists its devices in the opposite order of their sorted UUIDs
+	assert.Equal(t, gpu1, instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID)
+
+	type want struct {
+		gpu   string
+		start int32
+		ok    bool
+	}
+	tests := []struct {
+		name       string
+		profile    string
+		allocated0 string
+		allocated1 string
+		want       map[string]want
+	}{
+		{
+			name:    "empty GPUs",
+			profile: "1g.5gb",
+			want: map[string]want{
+				FirstFitPolicyName:           {gpu0, 0, true},
+				BestFitPolicyName:            {gpu0, 0, true},
+				LeftToRightPolicyName:        {gpu1, 0, true},
+				RightToLeftPolicyName:        {gpu0, 6, true},
+				FragmentationAwarePolicyName: {gpu0, 6, true},
+			},
+		},
+		{
+			name:       "left to right starts from the first device, first fit from the first candidate",
+			profile:    "2g.10gb",
+			allocated0: "xx......",
+			allocated1: "....xx..",
+			want: map[string]want{
+				FirstFitPolicyName:           {gpu0, 2, true},
+				BestFitPolicyName:            {gpu1, 0, true},
+				LeftToRightPolicyName:        {gpu1, 0, true},
+				RightToLeftPolicyName:        {gpu0, 4, true},
+				FragmentationAwarePolicyName: {gpu0, 2, true},
+			},
+		},
+		{
+			name:       "best fit fills the smallest hole",
+			profile:    "1g.5gb",
+			allocated0: "x.......",
+			allocated1: "xxxxxx..",
+			want: map[string]want{
+				FirstFitPolicyName:           {gpu0, 1, true},
+				BestFitPolicyName:            {gpu1, 6, true},
+				LeftToRightPolicyName:        {gpu1, 6, true},
+				RightToLeftPolicyName:        {gpu0, 6, true},
+				FragmentationAwarePolicyName: {gpu0, 1, true},
+			},
+		},
+		{
+			name:       "first GPU full moves to the next GPU",
+			profile:    "2g.10gb",
+			allocated0: "xxxxxxxx",
+			allocated1: "..xx....",
+			want: map[string]want{
+				FirstFitPolicyName:           {gpu1, 0, true},
+				BestFitPolicyName:            {gpu1, 0, true},
+				LeftToRightPolicyName:        {gpu1, 0, true},
+				RightToLeftPolicyName:        {gpu1, 4, true},
+				FragmentationAwarePolicyName: {gpu1, 0, true},
+			},
+		},
+		{
+			name:       "profile with a single placement",
+			profile:    "4g.20gb",
+			allocated0: ".x......",
+			want: map[string]want{
+				FirstFitPolicyName:           {gpu1, 0, true},
+				BestFitPolicyName:            {gpu1, 0, true},
+				LeftToRightPolicyName:        {gpu1, 0, true},
+				RightToLeftPolicyName:        {gpu1, 0, true},
+				FragmentationAwarePolicyName: {gpu1, 0, true},
+			},
+		},
+		{
+			name:       "no fit on any GPU",
+			profile:    "7g.40gb",
+			allocated0: ".......x",
+			allocated1: "x.......",
+			want: map[string]want{
+				FirstFitPolicyName:           {},
+				BestFitPolicyName:            {},
+				LeftToRightPolicyName:        {},
+				RightToLeftPolicyName:        {},
+				FragmentationAwarePolicyName: {},
+			},
+		},
+		{
+			name:       "fragmentation aware keeps the empty GPU whole",
+			profile:    "3g.20gb",
+			allocated1: "xxxx....",
+			want: map[string]want{
+				FirstFitPolicyName:           {gpu0, 0, true},
+				BestFitPolicyName:            {gpu1, 4, true},
+				LeftToRightPolicyName:        {gpu1, 4, true},
+				RightToLeftPolicyName:        {gpu0, 4, true},
+				FragmentationAwarePolicyName: {gpu1, 4, true},
+			},
+		},
+		{
+			name:    "unknown profile",
+			profile: "9g.99gb",
+			want: map[string]want{
+				FirstFitPolicyName:           {},
+				BestFitPolicyName:            {},
+				LeftToRightPolicyName:        {},
+				RightToLeftPolicyName:        {},
+				FragmentationAwarePolicyName: {},
+			},
+		},
+	}
+	for _, tt := range tests {
+		for policyName, expected := range tt.want {
+			t.Run(tt.name+"/"+policyName, func(t *testing.T) {
+				policy, err := NewAllocationPolicy(policyName)
+				assert.NoError(t, err)
+				assert.Equal(t, policyName, policy.Name())
+				candidates := []GPUCandidate{
+					{GPUUUID: gpu0, Allocated: parseGPUSlots(tt.allocated0, 8)},
+					{GPUUUID: gpu1, Allocated: parseGPUSlots(tt.allocated1, 8)},
+				}
+				gpu, start, ok := policy.SelectPlacement(instaslice, tt.profile, candidates)
+				assert.Equal(t, expected, want{gpu, start, ok})
+			})
+		}
+	}
+}
+
+func TestNewAllocationPolicy(t *testing.T) {
+	_, err := NewAllocationPolicy("worst-fit")
+	assert.ErrorContains(t, err, "unknown allocation policy")
+
+	RegisterAllocationPolicy("custom", func() AllocationPolicy { return &RightToLeftPolicy{} })
+	defer func() {
+		allocationPoliciesMu.Lock()
+		delete(allocationPolicies, "custom")
+		allocationPoliciesMu.Unlock()
+	}()
+	policy, err := NewAllocationPolicy("custom")
+	assert.NoError(t, err)
+	assert.IsType(t, &RightToLeftPolicy{}, policy)
+}
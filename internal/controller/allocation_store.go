@@ -0,0 +1,297 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// The allocation store holds the allocation cache so that pods are reconciled by several
+// workers. A placement is reserved in the store before it is written to the Instaslice of its
+// node, so that the placements computed meanwhile by other workers see its slots as taken, and
+// is then committed, or rolled back when the write fails. Reserve refuses placements overlapping
+// the slots of another allocation, two pods never get the same slots even when their placements
+// were computed from the same state.
+//
+// The slices of a pod are placed on a node and reserved under the slot lock of the node, so
+// placements on the same node follow each other while placements on different nodes run in
+// parallel. Writes to the Instaslice of a node are serialized by the write lock of the node,
+// placements do not wait for them since the slots being written are reserved already. The
+// pending queue, the SliceReservations, the nominations of the preemptions and the holds of the
+// defragmenter span all nodes and stay under r.mu, a placement copies what it needs of them
+// before it takes the slot locks.
+//
+// The Instaslices are read from the informer, which lags behind the writes of the store. An
+// allocation committed less than allocationCommitGracePeriod ago may be missing from them, it is
+// kept when the store is rebuilt and is not settled yet for the cleanup of orphaned allocations.
+
+// allocationCommitGracePeriod is how long a committed allocation may be missing from the Instaslices read
+const allocationCommitGracePeriod = 30 * time.Second
+
+// errPlacementConflict is returned by Reserve for placements taking slots of another allocation
+var errPlacementConflict = fmt.Errorf("placement overlaps the slices of another allocation")
+
+type allocationStore struct {
+	mu          sync.RWMutex
+	allocations map[types.UID]inferencev1alpha1.AllocationResult
+	// reserved are the reserved allocations not committed yet, with the allocation they replaced
+	reserved map[types.UID]*inferencev1alpha1.AllocationResult
+	// committed are the times the allocations were last written
+	committed map[types.UID]time.Time
+
+	// nodeLocks serialize the writes to the Instaslice of each node, slotLocks the placements on it
+	nodeLocks keyedLocks[types.NodeName]
+	slotLocks keyedLocks[types.NodeName]
+}
+
+// newAllocationStore returns a store holding a copy of allocations
+func newAllocationStore(allocations map[types.UID]inferencev1alpha1.AllocationResult) *allocationStore {
+	s := &allocationStore{}
+	s.Reset(allocations)
+	return s
+}
+
+// Reset replaces the allocations of the store with a copy of allocations. The reservations not
+// committed yet are kept, their writes are still in flight, and so are the allocations committed
+// within the grace period, allocations may have been read before them.
+func (s *allocationStore) Reset(allocations map[types.UID]inferencev1alpha1.AllocationResult) {
+	copied := make(map[types.UID]inferencev1alpha1.AllocationResult, len(allocations))
+	for podUID, allocResult := range allocations {
+		copied[podUID] = allocResult
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for podUID := range s.reserved {
+		if allocResult, ok := s.allocations[podUID]; ok {
+			copied[podUID] = allocResult
+		}
+	}
+	for podUID, committed := range s.committed {
+		if now.Sub(committed) >= allocationCommitGracePeriod {
+			delete(s.committed, podUID)
+			continue
+		}
+		if allocResult, ok := s.allocations[podUID]; ok {
+			copied[podUID] = allocResult
+		}
+	}
+	s.allocations = copied
+	if s.reserved == nil {
+		s.reserved = make(map[types.UID]*inferencev1alpha1.AllocationResult)
+	}
+	if s.committed == nil {
+		s.committed = make(map[types.UID]time.Time)
+	}
+}
+
+// Get returns the allocation of a pod. A nil store holds no allocation.
+func (s *allocationStore) Get(podUID types.UID) (inferencev1alpha1.AllocationResult, bool) {
+	if s == nil {
+		return inferencev1alpha1.AllocationResult{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	allocResult, ok := s.allocations[podUID]
+	return allocResult, ok
+}
+
+// Len returns the number of allocations
+func (s *allocationStore) Len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.allocations)
+}
+
+// Snapshot returns a copy of the allocations
+func (s *allocationStore) Snapshot() map[types.UID]inferencev1alpha1.AllocationResult {
+	snapshot := make(map[types.UID]inferencev1alpha1.AllocationResult, s.Len())
+	s.Range(func(podUID types.UID, allocResult inferencev1alpha1.AllocationResult) bool {
+		snapshot[podUID] = allocResult
+		return true
+	})
+	return snapshot
+}
+
+// Range calls fn for every allocation until fn returns false. fn must not modify the store.
+func (s *allocationStore) Range(fn func(podUID types.UID, allocResult inferencev1alpha1.AllocationResult) bool) {
+	if s == nil {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for podUID, allocResult := range s.allocations {
+		if !fn(podUID, allocResult) {
+			return
+		}
+	}
+}
+
+// Set stores the allocation of a pod as it is written in its Instaslice
+func (s *allocationStore) Set(podUID types.UID, allocResult inferencev1alpha1.AllocationResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allocations[podUID] = allocResult
+	delete(s.reserved, podUID)
+	s.committed[podUID] = time.Now()
+}
+
+// Delete drops the allocation of a pod
+func (s *allocationStore) Delete(podUID types.UID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.allocations, podUID)
+	delete(s.reserved, podUID)
+	delete(s.committed, podUID)
+}
+
+// Reserve stores the allocation of a pod before it is written to its Instaslice, it fails with
+// errPlacementConflict when a slice overlaps the slices of the allocation of another pod.
+// Slices held by SliceReservations are handed over to the pods of their namespace and deleted
+// allocations release their slots, neither conflicts.
+func (s *allocationStore) Reserve(podUID types.UID, allocResult inferencev1alpha1.AllocationResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for otherUID, other := range s.allocations {
+		if otherUID == podUID || other.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusReserved ||
+			other.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		if allocationsOverlap(allocResult, other) {
+			return fmt.Errorf("%w: pod %s", errPlacementConflict, otherUID)
+		}
+	}
+	if _, pending := s.reserved[podUID]; !pending {
+		var previous *inferencev1alpha1.AllocationResult
+		if existing, ok := s.allocations[podUID]; ok {
+			previous = &existing
+		}
+		s.reserved[podUID] = previous
+	}
+	s.allocations[podUID] = allocResult
+	return nil
+}
+
+// Commit keeps the reserved allocation of a pod once it is written to its Instaslice
+func (s *allocationStore) Commit(podUID types.UID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reserved, podUID)
+	s.committed[podUID] = time.Now()
+}
+
+// Rollback restores the allocation a reserved allocation replaced after its write failed
+func (s *allocationStore) Rollback(podUID types.UID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, pending := s.reserved[podUID]
+	if !pending {
+		return
+	}
+	delete(s.reserved, podUID)
+	if previous == nil {
+		delete(s.allocations, podUID)
+		return
+	}
+	s.allocations[podUID] = *previous
+}
+
+// Reserved reports whether the allocation of a pod is reserved and not committed yet
+func (s *allocationStore) Reserved(podUID types.UID) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, pending := s.reserved[podUID]
+	return pending
+}
+
+// Settled reports whether the allocation of a pod is committed and was last written long enough
+// ago to be in the Instaslices read from the informer
+func (s *allocationStore) Settled(podUID types.UID) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, pending := s.reserved[podUID]; pending {
+		return false
+	}
+	committed, ok := s.committed[podUID]
+	return !ok || time.Since(committed) >= allocationCommitGracePeriod
+}
+
+// LockNode locks the Instaslice of a node for writing and returns the function unlocking it
+func (s *allocationStore) LockNode(nodeName types.NodeName) func() {
+	return s.nodeLocks.Lock(nodeName)
+}
+
+// LockNodeSlots locks the slots of a node while a placement is computed from the store and
+// reserved in it, and returns the function unlocking it. Callers holding r.mu may take it, r.mu
+// must not be taken while it is held.
+func (s *allocationStore) LockNodeSlots(nodeName types.NodeName) func() {
+	return s.slotLocks.Lock(nodeName)
+}
+
+// keyedLocks hands out a mutex for every key
+type keyedLocks[K comparable] struct {
+	mu    sync.Mutex
+	locks map[K]*sync.Mutex
+}
+
+// Lock locks the mutex of key and returns the function unlocking it
+func (l *keyedLocks[K]) Lock(key K) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[K]*sync.Mutex)
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.locks[key] = lock
+	}
+	l.mu.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// allocationsOverlap reports whether two allocations take a slot of the same GPU. Compute
+// instances sharing a GPU instance only overlap when their compute slices do.
+func allocationsOverlap(a, b inferencev1alpha1.AllocationResult) bool {
+	for _, sliceA := range a.AllSlices() {
+		for _, sliceB := range b.AllSlices() {
+			if sliceA.GPUUUID != sliceB.GPUUUID || !placementsOverlap(sliceA.MigPlacement, sliceB.MigPlacement) {
+				continue
+			}
+			if sliceA.ComputePlacement != nil && sliceB.ComputePlacement != nil && sliceA.MigPlacement == sliceB.MigPlacement &&
+				!placementsOverlap(*sliceA.ComputePlacement, *sliceB.ComputePlacement) {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// placementsOverlap reports whether two placements share a slot
+func placementsOverlap(a, b inferencev1alpha1.Placement) bool {
+	return a.Start < b.Start+b.Size && b.Start < a.Start+a.Size
+}
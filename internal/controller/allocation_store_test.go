@@ -0,0 +1,348 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestAllocationStoreReserve(t *testing.T) {
+	slice := func(gpu string, start, size int32) inferencev1alpha1.AllocationResult {
+		return inferencev1alpha1.AllocationResult{Nodename: "node-1", GPUUUID: gpu, MigPlacement: inferencev1alpha1.Placement{Start: start, Size: size}}
+	}
+	store := newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{"placed": slice("gpu-0", 0, 4)})
+
+	assert.ErrorIs(t, store.Reserve("overlapping", slice("gpu-0", 2, 4)), errPlacementConflict)
+	_, ok := store.Get("overlapping")
+	assert.False(t, ok)
+	assert.NoError(t, store.Reserve("next", slice("gpu-0", 4, 4)))
+	assert.NoError(t, store.Reserve("other-gpu", slice("gpu-1", 0, 4)))
+
+	// a pod placed again only conflicts with the other pods
+	assert.NoError(t, store.Reserve("placed", slice("gpu-0", 0, 2)))
+
+	// slices being deleted and slices held by a reservation are free
+	deleted := slice("gpu-1", 4, 4)
+	deleted.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+	store.Set("deleted", deleted)
+	assert.NoError(t, store.Reserve("after-deleted", slice("gpu-1", 4, 4)))
+	held := slice("gpu-2", 0, 4)
+	held.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusReserved
+	store.Set("reservation", held)
+	assert.NoError(t, store.Reserve("in-reservation", slice("gpu-2", 0, 4)))
+
+	// compute instances of a GPU instance only overlap on the same compute slices
+	compute := func(start int32) inferencev1alpha1.AllocationResult {
+		allocResult := slice("gpu-3", 0, 4)
+		allocResult.Slices = []inferencev1alpha1.SliceResult{{GPUUUID: "gpu-3", MigPlacement: allocResult.MigPlacement,
+			ComputePlacement: &inferencev1alpha1.Placement{Start: start, Size: 1}}}
+		return allocResult
+	}
+	assert.NoError(t, store.Reserve("compute-0", compute(0)))
+	assert.NoError(t, store.Reserve("compute-1", compute(1)))
+	assert.ErrorIs(t, store.Reserve("compute-1-again", compute(1)), errPlacementConflict)
+}
+
+func TestAllocationStoreCommitAndRollback(t *testing.T) {
+	previous := inferencev1alpha1.AllocationResult{Nodename: "node-1", GPUUUID: "gpu-0", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 4}}
+	moved := inferencev1alpha1.AllocationResult{Nodename: "node-1", GPUUUID: "gpu-1", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 4}}
+	store := newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{"placed": previous})
+
+	// a failed write restores the allocation the reservation replaced
+	assert.NoError(t, store.Reserve("placed", moved))
+	store.Rollback("placed")
+	allocResult, ok := store.Get("placed")
+	assert.True(t, ok)
+	assert.Equal(t, previous, allocResult)
+
+	// and drops a new allocation
+	assert.NoError(t, store.Reserve("new", moved))
+	store.Rollback("new")
+	_, ok = store.Get("new")
+	assert.False(t, ok)
+
+	// committed allocations stay
+	assert.NoError(t, store.Reserve("new", moved))
+	store.Commit("new")
+	store.Rollback("new")
+	allocResult, ok = store.Get("new")
+	assert.True(t, ok)
+	assert.Equal(t, moved, allocResult)
+	assert.Equal(t, 2, store.Len())
+
+	// reservations being written survive a rebuild of the store
+	writing := inferencev1alpha1.AllocationResult{Nodename: "node-1", GPUUUID: "gpu-2", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 4}}
+	assert.NoError(t, store.Reserve("writing", writing))
+	store.Reset(map[types.UID]inferencev1alpha1.AllocationResult{"new": moved})
+	_, ok = store.Get("writing")
+	assert.True(t, ok)
+	assert.ErrorIs(t, store.Reserve("other", writing), errPlacementConflict)
+
+	// allocations committed within the grace period may be missing from the Instaslices read
+	assert.False(t, store.Settled("writing"))
+	store.Commit("writing")
+	assert.False(t, store.Settled("writing"))
+	store.Reset(map[types.UID]inferencev1alpha1.AllocationResult{"new": moved})
+	_, ok = store.Get("writing")
+	assert.True(t, ok)
+	assert.True(t, store.Settled("unknown"))
+}
+
+func TestPlacePodsConcurrently(t *testing.T) {
+	ctx := context.Background()
+	const pods = 24
+	objects := make([]client.Object, 0, pods)
+	for i := 0; i < pods; i++ {
+		name := fmt.Sprintf("model-%d", i)
+		objects = append(objects, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name)}})
+	}
+	r, instaslice := newAllocationFixture(t, nil, objects...)
+	containers := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "1g.5gb"}}
+	slots := len(instaslice.Status.NodeResources.NodeGPUs) * len(instaslice.Status.NodeResources.MigPlacement["1g.5gb"].Placements)
+	placedSlots := func() (int, error) {
+		var instasliceList inferencev1alpha1.InstasliceList
+		if err := r.List(ctx, &instasliceList, &client.ListOptions{}); err != nil {
+			return 0, err
+		}
+		return len(instasliceList.Items[0].Status.PodAllocationResults), nil
+	}
+
+	// every pod is reconciled again until it is placed or every slot is taken
+	deadline := time.Now().Add(time.Minute)
+	var wg sync.WaitGroup
+	for _, object := range objects {
+		wg.Add(1)
+		go func(pod *v1.Pod) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				if placed, err := placedSlots(); !assert.NoError(t, err) || placed == slots {
+					return
+				}
+				var instasliceList inferencev1alpha1.InstasliceList
+				if !assert.NoError(t, r.List(ctx, &instasliceList, &client.ListOptions{})) {
+					return
+				}
+				placement, err := r.placePod(ctx, pod, containers, &instasliceList, instasliceList.Items, nil, &FirstFitPolicy{})
+				if !assert.NoError(t, err) {
+					return
+				}
+				if placement.allocResult == nil {
+					// waiting for the pods ahead of it in the pending queue
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				_, err = r.commitPlacement(ctx, pod, placement, instasliceList.Items, &FirstFitPolicy{})
+				if !assert.NoError(t, err) {
+					return
+				}
+				if _, ok := r.allocationCache.Get(pod.UID); ok {
+					return
+				}
+			}
+		}(object.(*v1.Pod))
+	}
+	wg.Wait()
+	placed, err := placedSlots()
+	assert.NoError(t, err)
+	if placed != slots {
+		t.Fatalf("%d of the %d slots were placed before the deadline", placed, slots)
+	}
+
+	// every 1g.5gb placement of the GPUs is taken once
+	var instasliceList inferencev1alpha1.InstasliceList
+	assert.NoError(t, r.List(ctx, &instasliceList, &client.ListOptions{}))
+	results := instasliceList.Items[0].Status.PodAllocationResults
+	for podUID, allocResult := range results {
+		for otherUID, other := range results {
+			if podUID != otherUID {
+				assert.False(t, allocationsOverlap(allocResult, other), "pods %s and %s share a slot", podUID, otherUID)
+			}
+		}
+	}
+	assert.Equal(t, len(results), r.allocationCache.Len())
+}
+
+func TestPlacePodsOnTwoNodesConcurrently(t *testing.T) {
+	ctx := context.Background()
+	pods := make([]*v1.Pod, 0, 32)
+	objects := make([]client.Object, 0, cap(pods)+2)
+	for i := 0; i < cap(pods); i++ {
+		name := fmt.Sprintf("model-%d", i)
+		pods = append(pods, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name)}})
+		objects = append(objects, pods[i])
+	}
+	other := utils.GenerateFakeCapacity("node-2")
+	// the emulated GPUs of every node share their UUIDs
+	for i := range other.Status.NodeResources.NodeGPUs {
+		other.Status.NodeResources.NodeGPUs[i].GPUUUID += "-node-2"
+	}
+	otherNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
+		Status:     v1.NodeStatus{Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("8Gi")}},
+	}
+	r, instaslice := newAllocationFixture(t, nil, append(objects, other, otherNode)...)
+	r.ResourceCache.ResourceEventHandlerForNode().AddFunc(otherNode)
+	containers := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "1g.5gb"}}
+	slots := len(instaslice.Status.NodeResources.NodeGPUs) * len(instaslice.Status.NodeResources.MigPlacement["1g.5gb"].Placements)
+	placedSlots := func() (map[string]int, error) {
+		var instasliceList inferencev1alpha1.InstasliceList
+		if err := r.List(ctx, &instasliceList, &client.ListOptions{}); err != nil {
+			return nil, err
+		}
+		placed := make(map[string]int)
+		for _, item := range instasliceList.Items {
+			placed[item.Name] = len(item.Status.PodAllocationResults)
+		}
+		return placed, nil
+	}
+
+	// timed out allocations exclude nodes and the exclusions end meanwhile, under r.mu
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			r.mu.Lock()
+			r.excludeTimedOutPlacement(inferencev1alpha1.AllocationResult{Nodename: "node-3"}, time.Now(), time.Now())
+			r.pruneAllocationExclusions(time.Now().Add(time.Hour))
+			r.mu.Unlock()
+		}
+	}()
+
+	// every pod is reconciled again until it is placed or every slot of both nodes is taken
+	deadline := time.Now().Add(time.Minute)
+	var placing sync.WaitGroup
+	for _, pod := range pods {
+		placing.Add(1)
+		go func(pod *v1.Pod) {
+			defer placing.Done()
+			for time.Now().Before(deadline) {
+				if placed, err := placedSlots(); !assert.NoError(t, err) || placed["node-1"]+placed["node-2"] == 2*slots {
+					return
+				}
+				var instasliceList inferencev1alpha1.InstasliceList
+				if !assert.NoError(t, r.List(ctx, &instasliceList, &client.ListOptions{})) {
+					return
+				}
+				placement, err := r.placePod(ctx, pod, containers, &instasliceList, instasliceList.Items, nil, &FirstFitPolicy{})
+				if !assert.NoError(t, err) {
+					return
+				}
+				if placement.allocResult == nil {
+					// waiting for the pods ahead of it in the pending queue
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				_, err = r.commitPlacement(ctx, pod, placement, instasliceList.Items, &FirstFitPolicy{})
+				if !assert.NoError(t, err) {
+					return
+				}
+				if _, ok := r.allocationCache.Get(pod.UID); ok {
+					return
+				}
+			}
+		}(pod)
+	}
+	placing.Wait()
+	close(done)
+	wg.Wait()
+	placed, err := placedSlots()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"node-1": slots, "node-2": slots}, placed)
+
+	// every 1g.5gb placement of the GPUs of both nodes is taken once
+	var instasliceList inferencev1alpha1.InstasliceList
+	assert.NoError(t, r.List(ctx, &instasliceList, &client.ListOptions{}))
+	total := 0
+	for _, item := range instasliceList.Items {
+		results := item.Status.PodAllocationResults
+		for podUID, allocResult := range results {
+			assert.Equal(t, types.NodeName(item.Name), allocResult.Nodename)
+			for otherUID, other := range results {
+				if podUID != otherUID {
+					assert.False(t, allocationsOverlap(allocResult, other), "pods %s and %s share a slot", podUID, otherUID)
+				}
+			}
+		}
+		total += len(results)
+	}
+	assert.Equal(t, total, r.allocationCache.Len())
+}
+
+func TestReconcileRemovesRequestWithoutResult(t *testing.T) {
+	ctx := context.Background()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "model", Namespace: "default", UID: "model"},
+		Spec: v1.PodSpec{
+			SchedulingGates: []v1.PodSchedulingGate{{Name: GateName}},
+			Containers: []v1.Container{{Name: "main", Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{"nvidia.com/mig-1g.5gb": resource.MustParse("1")},
+			}}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodPending, Conditions: []v1.PodCondition{{
+			Type: v1.PodScheduled, Status: v1.ConditionFalse, Message: "Scheduling is blocked due to non-empty scheduling gates",
+		}}},
+	}
+	r, instaslice := newAllocationFixture(t, nil, pod)
+	// the result of the previous placement of the pod failed to be written
+	instaslice.Spec.PodAllocationRequests["model"] = inferencev1alpha1.AllocationRequest{
+		Profile: "1g.5gb",
+		PodRef:  v1.ObjectReference{Name: "model", Namespace: "default", UID: "model"},
+	}
+	assert.NoError(t, r.Update(ctx, instaslice))
+	node := &v1.Node{}
+	assert.NoError(t, r.Get(ctx, types.NamespacedName{Name: "node-1"}, node))
+	node.Status.NodeInfo.BootID = instaslice.Status.NodeResources.BootID
+	assert.NoError(t, r.Status().Update(ctx, node))
+	r.allocationCache = newAllocationStore(nil)
+	r.isCacheInitialized = true
+
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+	result, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.True(t, result.Requeue)
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	assert.NotContains(t, instaslice.Spec.PodAllocationRequests, types.UID("model"))
+
+	// and the pod is placed again
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	assert.Contains(t, instaslice.Spec.PodAllocationRequests, types.UID("model"))
+	assert.Contains(t, instaslice.Status.PodAllocationResults, types.UID("model"))
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// An allocation waits on the daemonset of its node in two states: creating until the daemonset
+// reports its slices created, and deleting until it reports them deleted. An allocation that
+// stays in one of them past config.Config.AllocationCreatingTimeout or AllocationDeletingTimeout,
+// because the daemonset crashloops or NVML hangs, gets a TimedOut condition and no slice is placed
+// on its node for config.Config.AllocationTimeoutBackoff. Only its GPUs are excluded when the
+// daemonset moved other allocations of the node in the meantime. Allocations timed out in
+// creating are withdrawn: they move to deleting so that the daemonset tears down the slices it
+// created, and their pod is placed again once it reports them deleted. The time an allocation
+// entered its state is only known to the running controller, deadlines restart with it.
+
+const (
+	allocationCreatingTimeoutReason = "CreatingTimeout"
+	allocationDeletingTimeoutReason = "DeletingTimeout"
+	// allocationDeadlineInterval time between two checks of the allocation deadlines
+	allocationDeadlineInterval = 10 * time.Second
+)
+
+// allocationState is the status of an allocation and the time it was first seen in it
+type allocationState struct {
+	status   inferencev1alpha1.AllocationStatus
+	since    time.Time
+	timedOut bool
+}
+
+// allocationDeadline returns the timeout reason and the deadline of an allocation waiting on the
+// daemonset, ok is false for allocations that do not wait on it
+func (r *InstasliceReconciler) allocationDeadline(status inferencev1alpha1.AllocationStatus) (reason string, deadline time.Duration, ok bool) {
+	switch {
+	case status.AllocationStatusController == inferencev1alpha1.AllocationStatusCreating && status.AllocationStatusDaemonset == "":
+		return allocationCreatingTimeoutReason, r.Config.AllocationCreatingTimeout, true
+	case status.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting && status.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted:
+		return allocationDeletingTimeoutReason, r.Config.AllocationDeletingTimeout, true
+	}
+	return "", 0, false
+}
+
+// observeAllocationStates records when the allocations entered their status and when the
+// daemonset of each node last moved one of them
+func (r *InstasliceReconciler) observeAllocationStates(instaslices []inferencev1alpha1.Instaslice, now time.Time) {
+	if r.allocationStates == nil {
+		r.allocationStates = make(map[types.UID]allocationState)
+	}
+	if r.nodeProgress == nil {
+		r.nodeProgress = make(map[types.NodeName]time.Time)
+	}
+	seen := make(map[types.UID]bool)
+	for _, instaslice := range instaslices {
+		for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+			seen[podUID] = true
+			state, ok := r.allocationStates[podUID]
+			if ok && state.status == allocResult.AllocationStatus {
+				continue
+			}
+			if ok && state.status.AllocationStatusDaemonset != allocResult.AllocationStatus.AllocationStatusDaemonset {
+				r.nodeProgress[allocResult.Nodename] = now
+			}
+			r.allocationStates[podUID] = allocationState{status: allocResult.AllocationStatus, since: now}
+		}
+	}
+	for podUID := range r.allocationStates {
+		if !seen[podUID] {
+			delete(r.allocationStates, podUID)
+		}
+	}
+}
+
+// excludeTimedOutPlacement keeps new slices off the node of a timed out allocation, or only off
+// its GPUs when the daemonset of the node moved other allocations since the allocation got stuck
+func (r *InstasliceReconciler) excludeTimedOutPlacement(allocResult inferencev1alpha1.AllocationResult, since, now time.Time) {
+	until := now.Add(r.Config.AllocationTimeoutBackoff)
+	if progress, ok := r.nodeProgress[allocResult.Nodename]; ok && progress.After(since) {
+		if r.excludedGPUs == nil {
+			r.excludedGPUs = make(map[string]time.Time)
+		}
+		for _, slice := range allocResult.AllSlices() {
+			r.excludedGPUs[slice.GPUUUID] = until
+		}
+		return
+	}
+	if r.excludedNodes == nil {
+		r.excludedNodes = make(map[types.NodeName]time.Time)
+	}
+	r.excludedNodes[allocResult.Nodename] = until
+}
+
+// isNodeExcluded reports whether an allocation timed out on the node within the back-off
+func (s *placementState) isNodeExcluded(nodeName string) bool {
+	until, ok := s.excludedNodes[types.NodeName(nodeName)]
+	return ok && time.Now().Before(until)
+}
+
+// withoutExcludedGPUs returns the candidates no allocation timed out on within the back-off. The
+// slots of the returned candidates are shared with candidates.
+func (s *placementState) withoutExcludedGPUs(candidates []GPUCandidate) []GPUCandidate {
+	if len(s.excludedGPUs) == 0 {
+		return candidates
+	}
+	now := time.Now()
+	var allowed []GPUCandidate
+	for _, candidate := range candidates {
+		if until, ok := s.excludedGPUs[candidate.GPUUUID]; ok && now.Before(until) {
+			continue
+		}
+		allowed = append(allowed, candidate)
+	}
+	return allowed
+}
+
+// pruneAllocationExclusions forgets the exclusions and the withdrawn allocations whose back-off is over
+func (r *InstasliceReconciler) pruneAllocationExclusions(now time.Time) {
+	for nodeName, until := range r.excludedNodes {
+		if !now.Before(until) {
+			delete(r.excludedNodes, nodeName)
+		}
+	}
+	for gpuUUID, until := range r.excludedGPUs {
+		if !now.Before(until) {
+			delete(r.excludedGPUs, gpuUUID)
+		}
+	}
+	for podUID, condition := range r.timedOutConditions {
+		if !now.Before(condition.LastTransitionTime.Add(r.Config.AllocationTimeoutBackoff)) {
+			delete(r.timedOutConditions, podUID)
+		}
+	}
+}
+
+// carryTimedOutCondition records on the new allocation of a pod that its previous allocation timed out
+func (r *InstasliceReconciler) carryTimedOutCondition(podUID types.UID, allocResult *inferencev1alpha1.AllocationResult) {
+	if condition, ok := r.timedOutConditions[podUID]; ok {
+		meta.SetStatusCondition(&allocResult.Conditions, condition)
+		delete(r.timedOutConditions, podUID)
+	}
+}
+
+// runAllocationDeadlines checks the deadlines of the allocations every allocationDeadlineInterval
+// until ctx is done
+func (r *InstasliceReconciler) runAllocationDeadlines(ctx context.Context) error {
+	log := logr.FromContext(ctx)
+	ticker := time.NewTicker(allocationDeadlineInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if !r.isRecovered() {
+				continue
+			}
+			if err := r.checkAllocationDeadlines(ctx, time.Now()); err != nil {
+				log.Error(err, "checking allocation deadlines failed")
+			}
+		}
+	}
+}
+
+// checkAllocationDeadlines times out the allocations the daemonset did not move before the deadline
+// of their state. The Instaslices are read and written outside r.mu, which is only held to pick
+// the allocations to time out and to record them.
+func (r *InstasliceReconciler) checkAllocationDeadlines(ctx context.Context, now time.Time) error {
+	if err := r.ensureAllocationCache(ctx); err != nil {
+		return err
+	}
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := r.List(ctx, &instasliceList, client.InNamespace(InstaSliceOperatorNamespace)); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.observeAllocationStates(instasliceList.Items, now)
+	r.pruneAllocationExclusions(now)
+	expired := r.expireAllocations(ctx, instasliceList.Items, now)
+	r.mu.Unlock()
+
+	for _, allocation := range expired {
+		var err error
+		if allocation.condition.Reason == allocationCreatingTimeoutReason {
+			err = r.withdrawAllocation(ctx, allocation)
+		} else {
+			err = r.markAllocationTimedOut(ctx, allocation)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expiredAllocation is an allocation past the deadline of its state
+type expiredAllocation struct {
+	instasliceName string
+	podUID         types.UID
+	allocRequest   inferencev1alpha1.AllocationRequest
+	allocResult    inferencev1alpha1.AllocationResult
+	condition      metav1.Condition
+}
+
+// expireAllocations returns the allocations past the deadline of their state and excludes their
+// placement, under r.mu. Allocations the daemonset did not create in time are withdrawn by the caller.
+func (r *InstasliceReconciler) expireAllocations(ctx context.Context, instaslices []inferencev1alpha1.Instaslice, now time.Time) []expiredAllocation {
+	log := logr.FromContext(ctx)
+	var expired []expiredAllocation
+	for _, instaslice := range instaslices {
+		for podUID, result := range instaslice.Status.PodAllocationResults {
+			state, ok := r.allocationStates[podUID]
+			if !ok || state.timedOut {
+				continue
+			}
+			reason, deadline, waiting := r.allocationDeadline(result.AllocationStatus)
+			if !waiting || now.Sub(state.since) < deadline {
+				continue
+			}
+			log.Info("allocation timed out", "pod", podUID, "node", result.Nodename, "reason", reason, "deadline", deadline)
+			r.excludeTimedOutPlacement(result, state.since, now)
+			state.timedOut = true
+			r.allocationStates[podUID] = state
+			expired = append(expired, expiredAllocation{
+				instasliceName: instaslice.Name,
+				podUID:         podUID,
+				allocRequest:   instaslice.Spec.PodAllocationRequests[podUID],
+				allocResult:    *result.DeepCopy(),
+				condition: metav1.Condition{
+					Type:               inferencev1alpha1.AllocationConditionTimedOut,
+					Status:             metav1.ConditionTrue,
+					Reason:             reason,
+					Message:            fmt.Sprintf("the daemonset of node %s did not handle the allocation within %s", result.Nodename, deadline),
+					LastTransitionTime: metav1.NewTime(now),
+				},
+			})
+		}
+	}
+	return expired
+}
+
+// markAllocationTimedOut sets the timed out condition on an allocation the daemonset did not delete in time
+func (r *InstasliceReconciler) markAllocationTimedOut(ctx context.Context, allocation expiredAllocation) error {
+	allocResult, allocRequest := allocation.allocResult, allocation.allocRequest
+	if !meta.SetStatusCondition(&allocResult.Conditions, allocation.condition) {
+		return nil
+	}
+	if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, allocation.instasliceName, &allocResult, &allocRequest); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.updateCacheWithNewAllocation(allocation.podUID, allocResult)
+	r.mu.Unlock()
+	return nil
+}
+
+// withdrawAllocation moves an allocation the daemonset did not create in time to deleting, so
+// that the daemonset tears down the slices it created meanwhile. Its slots stay taken until the
+// daemonset reports them deleted, the pod is then reconciled and placed again. The timed out
+// condition is written to the allocation and carried over to the next allocation of the pod.
+func (r *InstasliceReconciler) withdrawAllocation(ctx context.Context, allocation expiredAllocation) error {
+	allocResult, allocRequest, condition := allocation.allocResult, allocation.allocRequest, allocation.condition
+	condition.Message += ", the allocation was withdrawn"
+	meta.SetStatusCondition(&allocResult.Conditions, condition)
+	r.markReleasing(allocation.instasliceName, &allocRequest, &allocResult, condition.Reason, condition.Message)
+	if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, allocation.instasliceName, &allocResult, &allocRequest); err != nil {
+		return err
+	}
+	r.ResetDeployedPodTotalMetrics(&allocResult, &allocRequest)
+	r.mu.Lock()
+	r.updateCacheWithNewAllocation(allocation.podUID, allocResult)
+	r.mu.Unlock()
+
+	RecordAllocationEvent(r.Recorder, allocation.instasliceName, &allocRequest, v1.EventTypeWarning, EventReasonAllocationTimedOut, condition.Message)
+	return nil
+}
+
+// keepTimedOutCondition remembers the timed out condition of a withdrawn allocation whose slices
+// the daemonset deleted, until it is carried over to the next allocation of the pod
+func (r *InstasliceReconciler) keepTimedOutCondition(podUID types.UID, allocResult inferencev1alpha1.AllocationResult) {
+	releasing := meta.FindStatusCondition(allocResult.Conditions, inferencev1alpha1.AllocationConditionReleasing)
+	condition := meta.FindStatusCondition(allocResult.Conditions, inferencev1alpha1.AllocationConditionTimedOut)
+	if releasing == nil || releasing.Reason != allocationCreatingTimeoutReason || condition == nil {
+		return
+	}
+	if r.timedOutConditions == nil {
+		r.timedOutConditions = make(map[types.UID]metav1.Condition)
+	}
+	r.timedOutConditions[podUID] = *condition
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestAllocationDeadline(t *testing.T) {
+	r := &InstasliceReconciler{Config: config.NewConfig()}
+	tests := []struct {
+		name     string
+		status   inferencev1alpha1.AllocationStatus
+		reason   string
+		deadline time.Duration
+	}{
+		{"creating", inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating},
+			allocationCreatingTimeoutReason, config.DefaultAllocationCreatingTimeout},
+		{"created", inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+			AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}, "", 0},
+		{"ungated", inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+			AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}, "", 0},
+		{"deleting", inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting,
+			AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}, allocationDeletingTimeoutReason, config.DefaultAllocationDeletingTimeout},
+		{"deleted", inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting,
+			AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusDeleted}, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, deadline, ok := r.allocationDeadline(tt.status)
+			assert.Equal(t, tt.reason != "", ok)
+			assert.Equal(t, tt.reason, reason)
+			assert.Equal(t, tt.deadline, deadline)
+		})
+	}
+}
+
+func TestCreatingAllocationTimeout(t *testing.T) {
+	ctx := context.Background()
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "model", Namespace: "default", UID: "model"}}
+	gpus := sortGPUs(utils.GenerateFakeCapacity("node-1"))
+	r, instaslice := newAllocationFixture(t, []testAllocation{{pod: "model", gpu: 0, profile: "3g.20gb", start: 0, size: 4,
+		status: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}}}, pod)
+	containers := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "3g.20gb"}}
+
+	now := time.Now()
+	assert.NoError(t, r.checkAllocationDeadlines(ctx, now))
+	assert.NoError(t, r.checkAllocationDeadlines(ctx, now.Add(time.Minute)))
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	assert.Contains(t, instaslice.Status.PodAllocationResults, types.UID("model"))
+
+	// the daemonset did not create the slice in time, the allocation is released with its
+	// condition and the node backs off
+	assert.NoError(t, r.checkAllocationDeadlines(ctx, now.Add(3*time.Minute)))
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	withdrawn := instaslice.Status.PodAllocationResults["model"]
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, withdrawn.AllocationStatus.AllocationStatusController)
+	releasing := meta.FindStatusCondition(withdrawn.Conditions, inferencev1alpha1.AllocationConditionReleasing)
+	if assert.NotNil(t, releasing) {
+		assert.Equal(t, allocationCreatingTimeoutReason, releasing.Reason)
+	}
+	assert.True(t, meta.IsStatusConditionTrue(withdrawn.Conditions, inferencev1alpha1.AllocationConditionTimedOut))
+	assert.True(t, r.isNodeExcluded("node-1"))
+
+	// the slots are held until the daemonset deleted the slices it created meanwhile
+	cached, ok := r.allocationCache.Get("model")
+	assert.True(t, ok)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, cached.AllocationStatus.AllocationStatusController)
+	withdrawn.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+	allocRequest := instaslice.Spec.PodAllocationRequests["model"]
+	assert.NoError(t, utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.DaemonsetFieldManager, "node-1", &withdrawn, &allocRequest))
+	assert.NoError(t, r.removeReleasedAllocation(ctx, "node-1", "model"))
+	r.keepTimedOutCondition("model", withdrawn)
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	assert.NotContains(t, instaslice.Status.PodAllocationResults, types.UID("model"))
+	assert.NotContains(t, instaslice.Spec.PodAllocationRequests, types.UID("model"))
+	assert.NotContains(t, r.allocationCache.Snapshot(), types.UID("model"))
+	_, _, err := r.findNodeAndDeviceForASlice(ctx, instaslice, containers, &FirstFitPolicy{}, pod)
+	assert.Error(t, err)
+
+	// the next allocation of the pod tells why the previous one was released
+	var next inferencev1alpha1.AllocationResult
+	r.carryTimedOutCondition("model", &next)
+	condition := meta.FindStatusCondition(next.Conditions, inferencev1alpha1.AllocationConditionTimedOut)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, allocationCreatingTimeoutReason, condition.Reason)
+	}
+
+	// the node gets slices again after the back-off
+	r.pruneAllocationExclusions(now.Add(3*time.Minute + config.DefaultAllocationTimeoutBackoff))
+	assert.False(t, r.isNodeExcluded("node-1"))
+	_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, containers, &FirstFitPolicy{}, pod)
+	assert.NoError(t, err)
+	assert.Equal(t, gpus[0], allocResult.GPUUUID)
+}
+
+func TestWithdrawnAllocationPlacedOnAnotherNode(t *testing.T) {
+	ctx := context.Background()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "model", Namespace: "default", UID: "model", Finalizers: []string{FinalizerName}},
+		Spec: v1.PodSpec{
+			SchedulingGates: []v1.PodSchedulingGate{{Name: GateName}},
+			Containers: []v1.Container{{Name: "main", Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{"nvidia.com/mig-3g.20gb": resource.MustParse("1")},
+			}}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodPending, Conditions: []v1.PodCondition{{
+			Type: v1.PodScheduled, Status: v1.ConditionFalse, Message: "Scheduling is blocked due to non-empty scheduling gates",
+		}}},
+	}
+	other := utils.GenerateFakeCapacity("node-2")
+	otherNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
+		Status: v1.NodeStatus{
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("8Gi")},
+			NodeInfo:    v1.NodeSystemInfo{BootID: other.Status.NodeResources.BootID},
+		},
+	}
+	r, instaslice := newAllocationFixture(t, []testAllocation{{pod: "model", gpu: 0, profile: "3g.20gb", start: 0, size: 4,
+		status: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}}}, pod, other, otherNode)
+	node := &v1.Node{}
+	assert.NoError(t, r.Get(ctx, types.NamespacedName{Name: "node-1"}, node))
+	node.Status.NodeInfo.BootID = instaslice.Status.NodeResources.BootID
+	assert.NoError(t, r.Status().Update(ctx, node))
+	r.ResourceCache.ResourceEventHandlerForNode().AddFunc(otherNode)
+
+	// the daemonset of node-1 answers late, the condition of the allocation is read back from the Instaslice
+	now := time.Now()
+	assert.NoError(t, r.checkAllocationDeadlines(ctx, now))
+	assert.NoError(t, r.checkAllocationDeadlines(ctx, now.Add(3*time.Minute)))
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	withdrawn := instaslice.Status.PodAllocationResults["model"]
+	withdrawn.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+	allocRequest := instaslice.Spec.PodAllocationRequests["model"]
+	assert.NoError(t, utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.DaemonsetFieldManager, "node-1", &withdrawn, &allocRequest))
+
+	// the released allocation is removed, then the pod is placed again
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+	result, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.True(t, result.Requeue)
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	assert.NotContains(t, instaslice.Status.PodAllocationResults, types.UID("model"))
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(other), other))
+	placed, ok := other.Status.PodAllocationResults["model"]
+	if assert.True(t, ok) {
+		assert.Equal(t, types.NodeName("node-2"), placed.Nodename)
+		assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, placed.AllocationStatus.AllocationStatusController)
+		assert.True(t, meta.IsStatusConditionTrue(placed.Conditions, inferencev1alpha1.AllocationConditionTimedOut))
+	}
+}
+
+func TestAllocationTimeoutExcludesGPU(t *testing.T) {
+	ctx := context.Background()
+	gpus := sortGPUs(utils.GenerateFakeCapacity("node-1"))
+	creating := inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}
+	r, instaslice := newAllocationFixture(t, []testAllocation{
+		{pod: "stuck", gpu: 0, profile: "3g.20gb", start: 0, size: 4, status: creating},
+		{pod: "moving", gpu: 1, profile: "3g.20gb", start: 0, size: 4, status: creating},
+		{pod: "leaving", gpu: 1, profile: "3g.20gb", start: 4, size: 4, status: inferencev1alpha1.AllocationStatus{
+			AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}},
+	})
+
+	now := time.Now()
+	assert.NoError(t, r.checkAllocationDeadlines(ctx, now))
+	// the daemonset still creates slices on the other GPU
+	moving := instaslice.Status.PodAllocationResults["moving"]
+	moving.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+	instaslice.Status.PodAllocationResults["moving"] = moving
+	assert.NoError(t, r.Status().Update(ctx, instaslice))
+	assert.NoError(t, r.checkAllocationDeadlines(ctx, now.Add(time.Minute)))
+
+	assert.NoError(t, r.checkAllocationDeadlines(ctx, now.Add(3*time.Minute)))
+	assert.False(t, r.isNodeExcluded("node-1"))
+	candidates := r.withoutExcludedGPUs(r.gpuCandidates(instaslice))
+	if assert.Len(t, candidates, 1) {
+		assert.Equal(t, gpus[1], candidates[0].GPUUUID)
+	}
+
+	// an allocation stuck in deleting keeps its slices and is marked timed out
+	assert.NoError(t, r.checkAllocationDeadlines(ctx, now.Add(6*time.Minute)))
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	leaving, ok := instaslice.Status.PodAllocationResults["leaving"]
+	if assert.True(t, ok) {
+		assert.True(t, meta.IsStatusConditionTrue(leaving.Conditions, inferencev1alpha1.AllocationConditionTimedOut))
+		assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, leaving.AllocationStatus.AllocationStatusController)
+	}
+	assert.Contains(t, r.allocationCache.Snapshot(), types.UID("leaving"))
+	assert.Empty(t, r.withoutExcludedGPUs(r.gpuCandidates(instaslice)))
+}
@@ -29,12 +29,33 @@ import (
 // takes time to propagate and often causes controller to assign same slice
 // to multiple pods.
 
-func (r *InstasliceReconciler) rebuildAllocationCache(ctx context.Context) error {
-	// Only rebuild if the cache is empty (indicating a controller restart)
-	if r.isCacheInitialized {
+// ensureAllocationCache builds the allocation cache unless it is built. The Instaslices and
+// SliceReservations are read outside r.mu, the caller must not hold it.
+func (r *InstasliceReconciler) ensureAllocationCache(ctx context.Context) error {
+	r.mu.Lock()
+	initialized := r.isCacheInitialized
+	r.mu.Unlock()
+	if initialized {
 		return nil
 	}
+	return r.reloadAllocationCache(ctx)
+}
+
+// reloadAllocationCache rebuilds the allocation cache from the Instaslices and SliceReservations,
+// which are read outside r.mu. The caller must not hold it.
+func (r *InstasliceReconciler) reloadAllocationCache(ctx context.Context) error {
+	allocations, reservedSlices, err := r.readAllocations(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resetAllocationCache(allocations, reservedSlices)
+	return nil
+}
 
+// readAllocations reads the allocations of every Instaslice and the slices held by SliceReservations
+func (r *InstasliceReconciler) readAllocations(ctx context.Context) (map[types.UID]inferencev1alpha1.AllocationResult, map[types.UID]*reservedSlice, error) {
 	// Fetch all Instaslice objects in the cluster
 	// TODO: cache is rebuilt on node failure we should
 	// avoid instaslice objects that are related to failed
@@ -44,54 +65,85 @@ func (r *InstasliceReconciler) rebuildAllocationCache(ctx context.Context) error
 	// Use cached informer to list Instaslice objects
 	if err := r.Client.List(ctx, instaslices, client.InNamespace(InstaSliceOperatorNamespace)); err != nil {
 		log.FromContext(ctx).Error(err, "Error listing Instaslice objects from cache")
-		return err
+		return nil, nil, err
 	}
 
-	r.allocationCache = make(map[types.UID]inferencev1alpha1.AllocationResult)
-
+	allocations := make(map[types.UID]inferencev1alpha1.AllocationResult)
 	for _, instaslice := range instaslices.Items {
 		for podUid, allocResult := range instaslice.Status.PodAllocationResults {
 			if allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
 				continue
 			}
-			r.allocationCache[podUid] = allocResult
+			allocations[podUid] = allocResult
 		}
 	}
+	// slices held by reservations are only known from the status of the reservations
+	reservedSlices, err := r.readReservedSlices(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Error listing SliceReservation objects")
+		return nil, nil, err
+	}
+	return allocations, reservedSlices, nil
+}
 
+// resetAllocationCache replaces the allocations of the cache and the reserved slices, under r.mu
+func (r *InstasliceReconciler) resetAllocationCache(allocations map[types.UID]inferencev1alpha1.AllocationResult, reservedSlices map[types.UID]*reservedSlice) {
+	if r.allocationCache == nil {
+		r.allocationCache = newAllocationStore(allocations)
+	} else {
+		r.allocationCache.Reset(allocations)
+	}
+	r.reservedSlices = reservedSlices
+	r.syncReservedSlices()
 	r.isCacheInitialized = true
-	return nil
 }
 
 func (r *InstasliceReconciler) updateCacheWithNewAllocation(podUid types.UID, allocResult inferencev1alpha1.AllocationResult) {
 
-	r.allocationCache[podUid] = allocResult
+	r.allocationCache.Set(podUid, allocResult)
+	// reserved slices taken by the allocation are bound to the pod
+	r.syncReservedSlices()
 }
 
-// clean allocations that do not exists in spec
+// clean allocations that do not exists in spec. The latest Instaslices are read outside r.mu,
+// the caller must not hold it.
 // TODO fix scalability issue, loops over all instaslice objects in the cluster
 func (r *InstasliceReconciler) CleanupOrphanedAllocations(ctx context.Context, instasliceList *inferencev1alpha1.InstasliceList) {
-	var keysToDelete []types.UID
-
-	for uuid := range r.allocationCache {
-		found := false
-		for _, instaslice := range instasliceList.Items {
-			// Fetch latest Instaslice state before updating metrics
-			updatedInstaslice, err := r.getInstasliceObject(ctx, instaslice.Name, instaslice.Namespace)
-			if err != nil {
-				log.FromContext(ctx).Error(err, "Failed to get latest Instaslice object", "instaslice", instaslice.Name)
-				return
-			}
-			if _, exists := updatedInstaslice.Spec.PodAllocationRequests[uuid]; exists {
-				found = true
-				break
-			}
+	// allocations committed after the snapshot may not be in the Instaslices read below yet,
+	// only the ones in it are considered
+	var candidates []types.UID
+	for uuid, allocResult := range r.allocationCache.Snapshot() {
+		// reserved slices have no allocation request, placements being written have none yet and
+		// the informer may not have seen the ones written recently
+		if allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusReserved || !r.allocationCache.Settled(uuid) {
+			continue
 		}
-		if !found {
-			keysToDelete = append(keysToDelete, uuid)
+		candidates = append(candidates, uuid)
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	requested := make(map[types.UID]bool)
+	for _, instaslice := range instasliceList.Items {
+		// Fetch latest Instaslice state
+		updatedInstaslice, err := r.getInstasliceObject(ctx, instaslice.Name, instaslice.Namespace)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Failed to get latest Instaslice object", "instaslice", instaslice.Name)
+			return
+		}
+		for uuid := range updatedInstaslice.Spec.PodAllocationRequests {
+			requested[uuid] = true
 		}
 	}
 
-	for _, uuid := range keysToDelete {
-		delete(r.allocationCache, uuid)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, uuid := range candidates {
+		if !requested[uuid] {
+			r.allocationCache.Delete(uuid)
+		}
 	}
+	// reserved slices of released allocations are held for their reservation again
+	r.syncReservedSlices()
 }
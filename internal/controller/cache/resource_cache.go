@@ -279,6 +279,71 @@ func (c *ResourceCache) Fits(nodeName string, pod *v1.Pod) bool {
 	return fits
 }
 
+// Insufficient returns the resources the pod requests more of than the node has left, in the
+// words of the kube-scheduler, none when the pod fits. A node missing from the cache is reported
+// as such.
+func (c *ResourceCache) Insufficient(nodeName string, pod *v1.Pod) []string {
+	req, _ := kuResource.PodRequestsAndLimits(pod)
+
+	c.RLock()
+	ni, ok := c.nodes[nodeName]
+	if !ok {
+		c.RUnlock()
+		return []string{"node not found in the resource cache"}
+	}
+	requested, allocatable := ni.Requested, ni.Allocatable
+	c.RUnlock()
+
+	var insufficient []string
+	for _, r := range []struct {
+		name                 v1.ResourceName
+		alloc, used, request int64
+	}{
+		{v1.ResourceCPU, allocatable.MilliCPU, requested.MilliCPU, req.Cpu().MilliValue()},
+		{v1.ResourceMemory, allocatable.Memory, requested.Memory, req.Memory().Value()},
+		{v1.ResourceStorage, allocatable.Storage, requested.Storage, req.Storage().Value()},
+		{v1.ResourceEphemeralStorage, allocatable.EphemeralStorage, requested.EphemeralStorage, req.StorageEphemeral().Value()},
+	} {
+		if r.alloc-r.used-r.request < 0 {
+			insufficient = append(insufficient, "Insufficient "+string(r.name))
+		}
+	}
+	return insufficient
+}
+
+// Headroom returns the smallest fraction of allocatable CPU and memory that is still unrequested
+// on a node, 1 for an idle node and 0 for a node where either resource is exhausted.
+// ok is false when the node is not in the cache.
+func (c *ResourceCache) Headroom(nodeName string) (headroom float64, ok bool) {
+	c.RLock()
+	ni, ok := c.nodes[nodeName]
+	if !ok {
+		c.RUnlock()
+		return 0, false
+	}
+	requested, allocatable := ni.Requested, ni.Allocatable
+	c.RUnlock()
+
+	headroom = 1
+	for _, r := range [][2]int64{
+		{requested.MilliCPU, allocatable.MilliCPU},
+		{requested.Memory, allocatable.Memory},
+	} {
+		used, alloc := r[0], r[1]
+		if alloc <= 0 {
+			continue
+		}
+		free := float64(alloc-used) / float64(alloc)
+		if free < 0 {
+			free = 0
+		}
+		if free < headroom {
+			headroom = free
+		}
+	}
+	return headroom, true
+}
+
 func convertToLocalResource(res v1.ResourceList) LocalResource {
 	cpuQty := res[v1.ResourceCPU]
 	memQty := res[v1.ResourceMemory]
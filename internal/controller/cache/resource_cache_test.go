@@ -378,3 +378,51 @@ func TestResourceCacheConcurrencySafety(t *testing.T) {
 
 	// If the test runs without panic or data race, it passes.
 }
+
+func TestHeadroom(t *testing.T) {
+	rc := NewResourceCache()
+	rc.ResourceEventHandlerForNode().AddFunc(n("nodeA"))
+	ph := rc.ResourceEventHandlerForPod()
+
+	if _, ok := rc.Headroom("missing"); ok {
+		t.Fatalf("expected no headroom for a node missing from the cache")
+	}
+	if got, _ := rc.Headroom("nodeA"); got != 1 {
+		t.Errorf("idle node headroom: got %v, want 1", got)
+	}
+
+	// CPU is the scarcer resource, 1000m of 4000m requested
+	ph.AddFunc(newPod("ns", "p1", "nodeA", "1000m", "1Gi", "1Gi", "1Gi", v1.PodRunning))
+	if got, _ := rc.Headroom("nodeA"); got != 0.75 {
+		t.Errorf("headroom after cpu request: got %v, want 0.75", got)
+	}
+
+	// memory becomes the scarcer resource, 6Gi of 8Gi requested
+	ph.AddFunc(newPod("ns", "p2", "nodeA", "0m", "5Gi", "1Gi", "1Gi", v1.PodRunning))
+	if got, _ := rc.Headroom("nodeA"); got != 0.25 {
+		t.Errorf("headroom after memory request: got %v, want 0.25", got)
+	}
+
+	// overcommitted nodes are clamped to 0
+	ph.AddFunc(newPod("ns", "p3", "nodeA", "0m", "4Gi", "1Gi", "1Gi", v1.PodRunning))
+	if got, _ := rc.Headroom("nodeA"); got != 0 {
+		t.Errorf("headroom of an overcommitted node: got %v, want 0", got)
+	}
+}
+
+func TestInsufficient(t *testing.T) {
+	rc := NewResourceCache()
+	rc.ResourceEventHandlerForNode().AddFunc(n("nodeA"))
+	rc.ResourceEventHandlerForPod().AddFunc(newPod("ns", "p1", "nodeA", "3000m", "1Gi", "1Gi", "1Gi", v1.PodRunning))
+
+	if got := rc.Insufficient("nodeA", newPod("ns", "small", "", "500m", "1Gi", "0", "0", v1.PodPending)); len(got) != 0 {
+		t.Errorf("pod that fits: got %v, want none", got)
+	}
+	got := rc.Insufficient("nodeA", newPod("ns", "big", "", "2000m", "1Gi", "0", "0", v1.PodPending))
+	if len(got) != 1 || got[0] != "Insufficient cpu" {
+		t.Errorf("pod requesting too much cpu: got %v, want [Insufficient cpu]", got)
+	}
+	if got := rc.Insufficient("missing", newPod("ns", "small", "", "500m", "1Gi", "0", "0", v1.PodPending)); len(got) != 1 {
+		t.Errorf("missing node: got %v, want one reason", got)
+	}
+}
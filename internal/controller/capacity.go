@@ -18,45 +18,145 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"sort"
+	"strings"
+	"time"
 
 	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
 // checks the classical resources like CPU and memory and continuous GPU index available
 // before making an allocation.
 
-// find node, gpu and gpu index to place the slice
-func (r *InstasliceReconciler) findNodeAndDeviceForASlice(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, profileName string, policy AllocationPolicy, pod *v1.Pod) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult, error) {
+// placementNode is the state of a node read for the placement of a pod, the slices of the pod
+// are placed from it without reading the API
+type placementNode struct {
+	instaslice *inferencev1alpha1.Instaslice
+	// fits and reason are the outcome of the scheduler filters of the node for the pod, err the
+	// error reading the node
+	fits   bool
+	reason string
+	err    error
+}
+
+// placementState is the state of the placements spanning all nodes that the slices of a pod are
+// placed from. The InstasliceReconciler holds it under r.mu, placements place the slices from a
+// copy under the slot locks of the nodes. A nomination or a defragmenter hold recorded after the
+// copy was made may be missed, their slots are only freed once the pods evicted for them are
+// gone and another pod getting them meanwhile is still refused the slots of other allocations.
+type placementState struct {
+	// nominations reserves the slots freed by preemptions for the preempting pods
+	nominations map[types.UID]nomination
+	// reservedSlices are the slices held by SliceReservations by their key in the allocation cache
+	reservedSlices map[types.UID]*reservedSlice
+	// excludedNodes and excludedGPUs get no new slices until the back-off after a timeout ends
+	excludedNodes map[types.NodeName]time.Time
+	excludedGPUs  map[string]time.Time
+}
+
+// copyPlacementState copies the placementState of the reconciler, under r.mu
+func (r *InstasliceReconciler) copyPlacementState() *placementState {
+	state := &placementState{
+		nominations:    maps.Clone(r.nominations),
+		reservedSlices: make(map[types.UID]*reservedSlice, len(r.reservedSlices)),
+		excludedNodes:  maps.Clone(r.excludedNodes),
+		excludedGPUs:   maps.Clone(r.excludedGPUs),
+	}
+	// the bindings of the reserved slices are updated in place
+	for key, slice := range r.reservedSlices {
+		copied := *slice
+		state.reservedSlices[key] = &copied
+	}
+	return state
+}
+
+// readPlacementNode reads the latest Instaslice of a node and runs the scheduler filters of the
+// node for pod. It reads the API and must not run under r.mu.
+func (r *InstasliceReconciler) readPlacementNode(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, pod *v1.Pod) (*placementNode, error) {
 	updatedInstaSliceObject, err := r.getInstasliceObject(ctx, instaslice.Name, instaslice.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	node := &placementNode{instaslice: updatedInstaSliceObject}
+	node.fits, node.reason, node.err = r.podFitsInstasliceNode(ctx, updatedInstaSliceObject.Name, pod)
+	return node, nil
+}
+
+// find node, gpu and gpu index to place the slices of every GPU container, all slices are placed on the same node
+func (r *InstasliceReconciler) findNodeAndDeviceForASlice(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, containers []inferencev1alpha1.ContainerRequest, policy AllocationPolicy, pod *v1.Pod) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult, error) {
+	node, err := r.readPlacementNode(ctx, instaslice, pod)
 	if err != nil {
 		return nil, nil, err
 	}
+	return r.placeOnNode(node, &r.placementState, containers, policy, pod)
+}
+
+// placeOnNode places the slices of every GPU container on the GPUs of a node read by
+// readPlacementNode, from state. It reads no API, callers placing pods hold the slot lock of the
+// node and either r.mu or a copy of the placementState.
+func (r *InstasliceReconciler) placeOnNode(node *placementNode, state *placementState, containers []inferencev1alpha1.ContainerRequest, policy AllocationPolicy, pod *v1.Pod) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult, error) {
+	updatedInstaSliceObject := node.instaslice.DeepCopy()
+	// the pod is pinned to the node of its slices, which must pass the scheduler filters for the pod
+	if allocResult, exists := r.allocationCache.Get(pod.UID); !exists || string(allocResult.Nodename) != updatedInstaSliceObject.Name {
+		if state.isNodeExcluded(updatedInstaSliceObject.Name) {
+			return nil, nil, rejectNode(RejectionNodeBackingOff, "node %s is backing off after an allocation timed out on it", updatedInstaSliceObject.Name)
+		}
+		if node.err != nil {
+			return nil, nil, node.err
+		}
+		if !node.fits {
+			return nil, nil, rejectNode(RejectionNodeFiltered, "pod %s cannot run on node %s: %s", pod.Name, updatedInstaSliceObject.Name, node.reason)
+		}
+	}
 
-	if r.ResourceCache.Fits(instaslice.Name, pod) {
-		// TODO: Discover GPU UUIDs for selection. (This may work for A100 and H100 for now.)
-		gpuUUIDs := sortGPUs(updatedInstaSliceObject)
-		for _, gpuuuid := range gpuUUIDs {
-			if updatedInstaSliceObject.Spec.PodAllocationRequests == nil {
-				updatedInstaSliceObject.Spec.PodAllocationRequests = make(map[types.UID]inferencev1alpha1.AllocationRequest)
+	if len(containers) > 0 && r.ResourceCache.Fits(updatedInstaSliceObject.Name, pod) {
+		if updatedInstaSliceObject.Spec.PodAllocationRequests == nil {
+			updatedInstaSliceObject.Spec.PodAllocationRequests = make(map[types.UID]inferencev1alpha1.AllocationRequest)
+		}
+		var slices []inferencev1alpha1.SliceResult
+		var containerResults []inferencev1alpha1.ContainerResult
+		var found bool
+		// allocation already exists in cache, reuse its placement
+		if allocResult, exists := r.allocationCache.Get(pod.UID); exists && string(allocResult.Nodename) == updatedInstaSliceObject.Name {
+			slices, found = allocResult.AllSlices(), true
+			containerResults = append([]inferencev1alpha1.ContainerResult(nil), allocResult.Containers...)
+		} else {
+			// slots nominated to a preempting pod are kept free for it
+			candidates := state.withoutExcludedGPUs(podGPUCandidates(updatedInstaSliceObject, pod, r.gpuCandidates(updatedInstaSliceObject)))
+			state.reserveNominatedSlices(updatedInstaSliceObject.Name, pod, containers, candidates)
+			state.reserveOtherReservedSlices(updatedInstaSliceObject.Name, pod.Namespace, candidates)
+			// slices reserved for the namespace of the pod are taken before any other slot
+			reserved := state.freeReservedSlices(updatedInstaSliceObject.Name, pod.Namespace, nil)
+			slices, containerResults, _, found = r.placeReservedSlices(updatedInstaSliceObject, containers, policy, candidates, reserved)
+			// GPUs of a preferred model are tried before the other GPUs the pod accepts
+			if _, preferred, _ := podGPUModels(pod); !found && len(preferred) > 0 {
+				slices, containerResults, found = r.placeContainerSlices(updatedInstaSliceObject, containers, policy,
+					gpuModelCandidates(updatedInstaSliceObject, cloneGPUCandidates(candidates), preferred))
 			}
-			gpuAllocatedIndex := r.gpuAllocatedSlices(gpuuuid)
-			newStart := r.getStartIndexFromAllocationResults(updatedInstaSliceObject, profileName, gpuAllocatedIndex, &pod.UID, false)
-			// For example, a newStart of 9 is considered invalid.
-			notValidIndex := int32(9)
-			if newStart == notValidIndex {
-				// Move to next GPU if the index is not valid.
-				continue
+			if !found {
+				slices, containerResults, found = r.placeContainerSlices(updatedInstaSliceObject, containers, policy, candidates)
+			}
+		}
+		if found {
+			// containers accepting several profiles record the profile they got
+			containers = allocatedContainers(containers, slices, containerResults)
+			// the first container mirrors the single container allocations of older clients
+			primary := primaryContainerRequest(containers)
+			size, discoveredGiprofile, Ciprofileid, Ciengprofileid := r.extractGpuProfile(updatedInstaSliceObject, primary.Profile)
+			configMaps := containerConfigMaps(pod)
+			for i := range containerResults {
+				containerResults[i].ConfigMapResourceIdentifier = types.UID(configMaps[containerResults[i].Name])
 			}
-			size, discoveredGiprofile, Ciprofileid, Ciengprofileid := r.extractGpuProfile(updatedInstaSliceObject, profileName)
-			resourceIdentifier := pod.Spec.Containers[0].EnvFrom[0].ConfigMapRef.Name
 
 			allocRequest, allocResult := policy.SetAllocationDetails(
-				profileName,
-				newStart,
+				primary.Profile,
+				slices[0].MigPlacement.Start,
 				size,
 				pod.GetUID(),
 				types.NodeName(updatedInstaSliceObject.GetName()),
@@ -66,119 +166,294 @@ func (r *InstasliceReconciler) findNodeAndDeviceForASlice(ctx context.Context, i
 				Ciengprofileid,
 				pod.GetNamespace(),
 				pod.GetName(),
-				gpuuuid,
-				types.UID(resourceIdentifier),
+				slices[0].GPUUUID,
+				types.UID(configMaps[primary.Name]),
 			)
+			allocRequest.Quantity = primary.Quantity
+			allocRequest.Containers = containers
+			allocResult.Slices = slices
+			allocResult.Containers = containerResults
+			allocResult.SetCondition(inferencev1alpha1.AllocationConditionPlaced, metav1.ConditionTrue, "SlicesPlaced", placementMessage(allocRequest, allocResult))
 			return allocRequest, allocResult, nil
 		}
 	}
-	return nil, nil, fmt.Errorf("failed to find allocatable node and gpu")
+	if len(containers) == 0 {
+		return nil, nil, fmt.Errorf("failed to find allocatable node and gpu")
+	}
+	return nil, nil, r.placementRejection(updatedInstaSliceObject, containers, pod)
 }
 
-// sortGPUs returns the sorted gpu IDs stored in the instaslice object
-func sortGPUs(updatedInstaSliceObject *inferencev1alpha1.Instaslice) []string {
-	gpuUUIDs := make([]string, 0, len(updatedInstaSliceObject.Status.NodeResources.NodeGPUs))
-	for _, discoveredGpu := range updatedInstaSliceObject.Status.NodeResources.NodeGPUs {
-		gpuUUIDs = append(gpuUUIDs, discoveredGpu.GPUUUID)
+// placementMessage describes where the slices of an allocation are placed
+func placementMessage(allocRequest *inferencev1alpha1.AllocationRequest, allocResult *inferencev1alpha1.AllocationResult) string {
+	placements := make([]string, 0, len(allocResult.Slices))
+	for _, slice := range allocResult.AllSlices() {
+		profile := slice.Profile
+		if profile == "" {
+			profile = allocRequest.Profile
+		}
+		placements = append(placements, fmt.Sprintf("%s on GPU %s at start %d", profile, slice.GPUUUID, slice.MigPlacement.Start))
 	}
-	sort.Strings(gpuUUIDs)
-	return gpuUUIDs
+	return fmt.Sprintf("placed on node %s: %s", allocResult.Nodename, strings.Join(placements, ", "))
 }
 
-// gpuAllocatedSlices stores already allocated slices in indexes
-func (r *InstasliceReconciler) gpuAllocatedSlices(gpuUUID string) [8]int32 {
-	//TODO: generalize, A100 and H100 have 8 indexes for 3g and 7g and 7 for rest, so go with 8 and we are bounded by
-	var gpuAllocatedIndex [8]int32
-	// clean slate init
-	for i := range gpuAllocatedIndex {
-		gpuAllocatedIndex[i] = 0
+// placeContainerSlices places the slices of every GPU container on the candidate GPUs of the node, ok is
+// false unless all of them fit. Containers requesting a slice size get the profile it resolves to on the node,
+// containers accepting several profiles get the first of their profiles, in order, for which the slices of
+// every container fit. The profile of each slice is set in the result.
+func (r *InstasliceReconciler) placeContainerSlices(instaslice *inferencev1alpha1.Instaslice, containers []inferencev1alpha1.ContainerRequest, policy AllocationPolicy, candidates []GPUCandidate) ([]inferencev1alpha1.SliceResult, []inferencev1alpha1.ContainerResult, bool) {
+	containers, ok := sizedContainerProfiles(instaslice, containers)
+	if !ok {
+		return nil, nil, false
 	}
-	// deleted allocations can be reused
-	// ungated allocations are already counted in prepared
+	for _, choice := range profileChoices(containers) {
+		trial := cloneGPUCandidates(candidates)
+		if slices, results, ok := r.placeContainerProfiles(instaslice, choice, policy, trial); ok {
+			// the slots are shared with the callers, copy them instead of replacing them
+			for i := range candidates {
+				copy(candidates[i].Allocated, trial[i].Allocated)
+			}
+			return slices, results, true
+		}
+	}
+	return nil, nil, false
+}
 
-	for _, allocResult := range r.allocationCache {
-		if allocResult.GPUUUID == gpuUUID && allocResult.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted {
-			for i := 0; i < int(allocResult.MigPlacement.Size); i++ {
-				gpuAllocatedIndex[int(allocResult.MigPlacement.Start)+i] = 1
+// profileChoices returns the containers with each of the acceptable profiles in order, the
+// k-th choice gives every container accepting several profiles its k-th profile
+func profileChoices(containers []inferencev1alpha1.ContainerRequest) [][]inferencev1alpha1.ContainerRequest {
+	choices := 1
+	for _, container := range containers {
+		if len(container.AcceptableProfiles) > choices {
+			choices = len(container.AcceptableProfiles)
+		}
+	}
+	result := make([][]inferencev1alpha1.ContainerRequest, 0, choices)
+	for k := 0; k < choices; k++ {
+		choice := append([]inferencev1alpha1.ContainerRequest(nil), containers...)
+		for i := range choice {
+			if k < len(choice[i].AcceptableProfiles) {
+				choice[i].Profile = choice[i].AcceptableProfiles[k]
 			}
 		}
+		result = append(result, choice)
 	}
-	return gpuAllocatedIndex
+	return result
 }
 
-// getStartIndexFromPreparedState finds the correct GPU and index where a slice could be placed.
-func (r *InstasliceReconciler) getStartIndexFromAllocationResults(instaslice *inferencev1alpha1.Instaslice, profileName string, gpuAllocatedIndex [8]int32, podUid *types.UID, simulate bool) int32 {
-	// if actual allocation, check if allocation already exists
-	if !simulate {
-		allocResult, exists := r.allocationCache[*podUid]
-		// allocation already exists in cache
-		if exists {
-			return allocResult.MigPlacement.Start
+// allocatedContainers returns the containers with the profile their slices were allocated with
+func allocatedContainers(containers []inferencev1alpha1.ContainerRequest, slices []inferencev1alpha1.SliceResult, results []inferencev1alpha1.ContainerResult) []inferencev1alpha1.ContainerRequest {
+	allocated := append([]inferencev1alpha1.ContainerRequest(nil), containers...)
+	for i := range allocated {
+		for _, result := range results {
+			if result.Name == allocated[i].Name && len(result.Slices) > 0 && slices[result.Slices[0]].Profile != "" {
+				allocated[i].Profile = slices[result.Slices[0]].Profile
+			}
 		}
 	}
-	// Check if all indices are allocated
-	allAllocated := true
-	for _, allocated := range gpuAllocatedIndex {
-		if allocated != 1 {
-			allAllocated = false
-			break
+	return allocated
+}
+
+// placeContainerProfiles places the slices of every GPU container with its profile. Init containers run
+// one at a time before the main containers, so they reuse slices of the same profile from the main
+// containers and from earlier init containers before new slices are placed.
+func (r *InstasliceReconciler) placeContainerProfiles(instaslice *inferencev1alpha1.Instaslice, containers []inferencev1alpha1.ContainerRequest, policy AllocationPolicy, candidates []GPUCandidate) ([]inferencev1alpha1.SliceResult, []inferencev1alpha1.ContainerResult, bool) {
+	var slices []inferencev1alpha1.SliceResult
+	results := make([]inferencev1alpha1.ContainerResult, len(containers))
+	place := func(profileName string, quantity int32) ([]int32, bool) {
+		placed, ok := r.selectSlices(instaslice, profileName, quantity, policy, candidates)
+		if !ok {
+			return nil, false
+		}
+		var indexes []int32
+		for _, slice := range placed {
+			slice.Profile = profileName
+			indexes = append(indexes, int32(len(slices)))
+			slices = append(slices, slice)
 		}
+		return indexes, true
 	}
-	if allAllocated {
-		// invalid index
-		return int32(9)
+
+	mainSlices := make(map[string][]int32)
+	for i, container := range containers {
+		if container.Init {
+			continue
+		}
+		indexes, ok := place(container.Profile, container.SliceCount())
+		if !ok {
+			return nil, nil, false
+		}
+		results[i] = inferencev1alpha1.ContainerResult{Name: container.Name, Slices: indexes}
+		mainSlices[container.Profile] = append(mainSlices[container.Profile], indexes...)
 	}
-	var neededContinousSlot int32
-	var possiblePlacements []int32
-	for profile, placement := range instaslice.Status.NodeResources.MigPlacement {
-		if profile == profileName {
-			neededContinousSlot = placement.Placements[0].Size
-			for _, placement := range placement.Placements {
-				possiblePlacements = append(possiblePlacements, placement.Start)
+	initSlices := make(map[string][]int32)
+	for i, container := range containers {
+		if !container.Init {
+			continue
+		}
+		reusable := append(append([]int32{}, mainSlices[container.Profile]...), initSlices[container.Profile]...)
+		if missing := container.SliceCount() - int32(len(reusable)); missing > 0 {
+			indexes, ok := place(container.Profile, missing)
+			if !ok {
+				return nil, nil, false
 			}
-			break
+			initSlices[container.Profile] = append(initSlices[container.Profile], indexes...)
+			reusable = append(reusable, indexes...)
 		}
+		results[i] = inferencev1alpha1.ContainerResult{Name: container.Name, Slices: reusable[:container.SliceCount()]}
 	}
-	//TODO: generalize for other hardware models like A30, no slices can be placed on 9th index
-	//if we return 9 then assume no valid index is found.
-	var newStart = int32(9)
-	for _, value := range possiblePlacements {
-		if gpuAllocatedIndex[value] == 0 {
-			if neededContinousSlot == 1 {
-				newStart = value
-				break
-			}
-			if neededContinousSlot == 2 {
-				if value+neededContinousSlot <= int32(len(gpuAllocatedIndex)) {
-					if gpuAllocatedIndex[value] == 0 && gpuAllocatedIndex[value+1] == 0 {
-						newStart = value
-						break
-					}
-				}
+	if len(slices) == 0 {
+		return nil, nil, false
+	}
+	return slices, results, true
+}
 
+// gpuCandidates returns the GPUs of the node in sorted order with their allocated slots
+func (r *InstasliceReconciler) gpuCandidates(instaslice *inferencev1alpha1.Instaslice) []GPUCandidate {
+	return r.gpuCandidatesExcluding(instaslice, nil)
+}
+
+// gpuCandidatesExcluding returns the GPUs of the node in sorted order with the slots allocated
+// to pods other than the excluded ones
+func (r *InstasliceReconciler) gpuCandidatesExcluding(instaslice *inferencev1alpha1.Instaslice, excluded map[types.UID]bool) []GPUCandidate {
+	var candidates []GPUCandidate
+	for _, gpuUUID := range sortGPUs(instaslice) {
+		candidates = append(candidates, GPUCandidate{
+			GPUUUID:   gpuUUID,
+			Allocated: r.gpuAllocatedSlicesExcluding(instaslice, gpuUUID, excluded),
+			Shared:    r.sharedGPUInstancesExcluding(instaslice, gpuUUID, excluded),
+		})
+	}
+	return candidates
+}
+
+// selectSlices asks the policy for a placement for each of the quantity slices of a profile,
+// ok is false unless all of them fit on the node. Slices may end up on different GPUs, the
+// selected slots are marked as allocated in candidates.
+func (r *InstasliceReconciler) selectSlices(instaslice *inferencev1alpha1.Instaslice, profileName string, quantity int32, policy AllocationPolicy, candidates []GPUCandidate) ([]inferencev1alpha1.SliceResult, bool) {
+	size := profileSize(instaslice, profileName)
+	slices := make([]inferencev1alpha1.SliceResult, 0, quantity)
+	for i := int32(0); i < quantity; i++ {
+		var slice inferencev1alpha1.SliceResult
+		mig, shared := sharedMig(instaslice, profileName)
+		if shared {
+			var ok bool
+			if slice, ok = selectComputeSlice(instaslice, profileName, mig, policy, candidates); !ok {
+				return nil, false
+			}
+		} else {
+			gpuUUID, start, ok := policy.SelectPlacement(instaslice, profileName, candidates)
+			if !ok {
+				return nil, false
 			}
-			if neededContinousSlot == 4 {
-				if value+neededContinousSlot <= int32(len(gpuAllocatedIndex)) {
-					if gpuAllocatedIndex[value] == 0 && gpuAllocatedIndex[value+1] == 0 && gpuAllocatedIndex[value+2] == 0 && gpuAllocatedIndex[value+3] == 0 {
-						newStart = value
-						break
-					}
-				}
+			slice = inferencev1alpha1.SliceResult{
+				MigPlacement: inferencev1alpha1.Placement{Start: start, Size: size},
+				GPUUUID:      gpuUUID,
 			}
+		}
+		// mark the slots so that the next slice of the pod is placed elsewhere
+		reserveSlice(candidates, slice, mig)
+		slices = append(slices, slice)
+	}
+	return slices, true
+}
+
+// primaryContainerRequest returns the first main container requesting slices, or the first
+// init container when only init containers request slices
+func primaryContainerRequest(containers []inferencev1alpha1.ContainerRequest) inferencev1alpha1.ContainerRequest {
+	for _, container := range containers {
+		if !container.Init {
+			return container
+		}
+	}
+	return containers[0]
+}
 
-			if neededContinousSlot == 8 {
-				//special case
-				if value+neededContinousSlot <= int32(len(gpuAllocatedIndex)) {
-					if gpuAllocatedIndex[value] == 0 && gpuAllocatedIndex[value+1] == 0 &&
-						gpuAllocatedIndex[value+2] == 0 && gpuAllocatedIndex[value+3] == 0 &&
-						gpuAllocatedIndex[value+4] == 0 && gpuAllocatedIndex[value+5] == 0 &&
-						gpuAllocatedIndex[value+6] == 0 && gpuAllocatedIndex[value+7] == 0 {
-						newStart = value
-					}
-				}
+// containerConfigMaps returns the ConfigMap name of every GPU container as recorded by the
+// webhook. Pods admitted before the annotation existed have a single container whose first
+// envFrom is the ConfigMap.
+func containerConfigMaps(pod *v1.Pod) map[string]string {
+	configMaps := make(map[string]string)
+	if value, ok := pod.Annotations[ContainerConfigMapsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &configMaps); err == nil {
+			return configMaps
+		}
+	}
+	if len(pod.Spec.Containers) > 0 && len(pod.Spec.Containers[0].EnvFrom) > 0 && pod.Spec.Containers[0].EnvFrom[0].ConfigMapRef != nil {
+		configMaps[pod.Spec.Containers[0].Name] = pod.Spec.Containers[0].EnvFrom[0].ConfigMapRef.Name
+	}
+	return configMaps
+}
+
+// containerAcceptableProfiles returns the profiles accepted by the containers whose MIG limits
+// were replaced by the webhook
+func containerAcceptableProfiles(pod *v1.Pod) map[string]containerProfiles {
+	acceptable := make(map[string]containerProfiles)
+	if value, ok := pod.Annotations[ContainerProfilesAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &acceptable); err != nil {
+			return map[string]containerProfiles{}
+		}
+	}
+	return acceptable
+}
+
+// sortGPUs returns the sorted gpu IDs stored in the instaslice object
+func sortGPUs(updatedInstaSliceObject *inferencev1alpha1.Instaslice) []string {
+	gpuUUIDs := make([]string, 0, len(updatedInstaSliceObject.Status.NodeResources.NodeGPUs))
+	for _, discoveredGpu := range updatedInstaSliceObject.Status.NodeResources.NodeGPUs {
+		gpuUUIDs = append(gpuUUIDs, discoveredGpu.GPUUUID)
+	}
+	sort.Strings(gpuUUIDs)
+	return gpuUUIDs
+}
+
+// gpuAllocatedSlices returns the slots of a GPU that are taken by allocations, sized
+// to the slot count discovered for the node.
+func (r *InstasliceReconciler) gpuAllocatedSlices(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) GPUSlots {
+	return r.gpuAllocatedSlicesExcluding(instaslice, gpuUUID, nil)
+}
+
+// gpuAllocatedSlicesExcluding is gpuAllocatedSlices ignoring the allocations of the excluded pods
+func (r *InstasliceReconciler) gpuAllocatedSlicesExcluding(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, excluded map[types.UID]bool) GPUSlots {
+	gpuAllocatedIndex := NewGPUSlots(gpuSlotCount(instaslice))
+	// deleted allocations can be reused
+	// ungated allocations are already counted in prepared
+	r.allocationCache.Range(func(podUID types.UID, allocResult inferencev1alpha1.AllocationResult) bool {
+		if allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted || excluded[podUID] {
+			return true
+		}
+		for _, slice := range allocResult.AllSlices() {
+			if slice.GPUUUID == gpuUUID {
+				gpuAllocatedIndex.Allocate(slice.MigPlacement.Start, slice.MigPlacement.Size)
 			}
 		}
+		return true
+	})
+	return gpuAllocatedIndex
+}
+
+// slotsPerGPU returns the number of slots the slices of an allocation take on each GPU
+func slotsPerGPU(allocResult *inferencev1alpha1.AllocationResult) map[string]int32 {
+	count := make(map[string]int32)
+	for _, slice := range allocResult.AllSlices() {
+		count[slice.GPUUUID] += slice.MigPlacement.Size
+	}
+	return count
+}
 
+// getStartIndexFromAllocationResults finds the index where a slice could be placed on a GPU,
+// ok is false when the profile does not fit.
+func (r *InstasliceReconciler) getStartIndexFromAllocationResults(instaslice *inferencev1alpha1.Instaslice, profileName string, gpuAllocatedIndex GPUSlots, podUid *types.UID, simulate bool) (int32, bool) {
+	// if actual allocation, check if allocation already exists
+	if !simulate {
+		allocResult, exists := r.allocationCache.Get(*podUid)
+		// allocation already exists in cache
+		if exists {
+			return allocResult.MigPlacement.Start, true
+		}
+	}
+	starts := freePlacementStarts(instaslice, profileName, gpuAllocatedIndex)
+	if len(starts) == 0 {
+		return 0, false
 	}
-	return newStart
+	return starts[0], true
 }
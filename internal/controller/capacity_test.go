@@ -0,0 +1,343 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestSelectSlices(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("fake-node")
+	gpus := sortGPUs(instaslice)
+	gpu0, gpu1 := gpus[0], gpus[1]
+
+	tests := []struct {
+		name     string
+		profile  string
+		quantity int32
+		want     []inferencev1alpha1.SliceResult
+		wantOk   bool
+	}{
+		{
+			name:     "single slice",
+			profile:  "3g.20gb",
+			quantity: 1,
+			want: []inferencev1alpha1.SliceResult{
+				{GPUUUID: gpu0, MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 4}},
+			},
+			wantOk: true,
+		},
+		{
+			name:     "slices spill over to the next GPU",
+			profile:  "3g.20gb",
+			quantity: 3,
+			want: []inferencev1alpha1.SliceResult{
+				{GPUUUID: gpu0, MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 4}},
+				{GPUUUID: gpu0, MigPlacement: inferencev1alpha1.Placement{Start: 4, Size: 4}},
+				{GPUUUID: gpu1, MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 4}},
+			},
+			wantOk: true,
+		},
+		{
+			name:     "not all slices fit on the node",
+			profile:  "7g.40gb",
+			quantity: 3,
+			wantOk:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &InstasliceReconciler{}
+			slices, ok := r.selectSlices(instaslice, tt.profile, tt.quantity, &FirstFitPolicy{}, r.gpuCandidates(instaslice))
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, slices)
+		})
+	}
+}
+
+func TestGpuAllocatedSlices_MultipleSlices(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("fake-node")
+	gpus := sortGPUs(instaslice)
+	allocResult := inferencev1alpha1.AllocationResult{
+		GPUUUID:      gpus[0],
+		MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1},
+		Slices: []inferencev1alpha1.SliceResult{
+			{GPUUUID: gpus[0], MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}},
+			{GPUUUID: gpus[0], MigPlacement: inferencev1alpha1.Placement{Start: 1, Size: 1}},
+			{GPUUUID: gpus[1], MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}},
+		},
+	}
+	r := &InstasliceReconciler{allocationCache: newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{"pod-1": allocResult})}
+
+	assert.Equal(t, parseGPUSlots("xx......", 8), r.gpuAllocatedSlices(instaslice, gpus[0]))
+	assert.Equal(t, parseGPUSlots("x.......", 8), r.gpuAllocatedSlices(instaslice, gpus[1]))
+	assert.Equal(t, map[string]int32{gpus[0]: 2, gpus[1]: 1}, slotsPerGPU(&allocResult))
+
+	// allocations written before slices existed only carry the first slice
+	allocResult.Slices = nil
+	assert.Equal(t, map[string]int32{gpus[0]: 1}, slotsPerGPU(&allocResult))
+}
+
+func TestPlaceContainerSlices(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("fake-node")
+	gpus := sortGPUs(instaslice)
+	gpu0, gpu1 := gpus[0], gpus[1]
+	slice := func(gpu string, start, size int32, profile string) inferencev1alpha1.SliceResult {
+		return inferencev1alpha1.SliceResult{GPUUUID: gpu, MigPlacement: inferencev1alpha1.Placement{Start: start, Size: size}, Profile: profile}
+	}
+
+	tests := []struct {
+		name       string
+		containers []inferencev1alpha1.ContainerRequest
+		wantSlices []inferencev1alpha1.SliceResult
+		wantResult []inferencev1alpha1.ContainerResult
+		wantOk     bool
+	}{
+		{
+			name: "containers with different profiles",
+			containers: []inferencev1alpha1.ContainerRequest{
+				{Name: "model", Profile: "3g.20gb"},
+				{Name: "embedder", Profile: "1g.5gb", Quantity: 2},
+			},
+			wantSlices: []inferencev1alpha1.SliceResult{
+				slice(gpu0, 0, 4, "3g.20gb"),
+				slice(gpu0, 4, 1, "1g.5gb"),
+				slice(gpu0, 5, 1, "1g.5gb"),
+			},
+			wantResult: []inferencev1alpha1.ContainerResult{
+				{Name: "model", Slices: []int32{0}},
+				{Name: "embedder", Slices: []int32{1, 2}},
+			},
+			wantOk: true,
+		},
+		{
+			name: "init container reuses the slice of the main container",
+			containers: []inferencev1alpha1.ContainerRequest{
+				{Name: "warmup", Profile: "7g.40gb", Init: true},
+				{Name: "model", Profile: "7g.40gb"},
+			},
+			wantSlices: []inferencev1alpha1.SliceResult{slice(gpu0, 0, 8, "7g.40gb")},
+			wantResult: []inferencev1alpha1.ContainerResult{
+				{Name: "warmup", Slices: []int32{0}},
+				{Name: "model", Slices: []int32{0}},
+			},
+			wantOk: true,
+		},
+		{
+			name: "init containers share the slices they need beyond the main containers",
+			containers: []inferencev1alpha1.ContainerRequest{
+				{Name: "download", Profile: "3g.20gb", Quantity: 2, Init: true},
+				{Name: "warmup", Profile: "3g.20gb", Quantity: 3, Init: true},
+				{Name: "model", Profile: "3g.20gb"},
+			},
+			wantSlices: []inferencev1alpha1.SliceResult{
+				slice(gpu0, 0, 4, "3g.20gb"),
+				slice(gpu0, 4, 4, "3g.20gb"),
+				slice(gpu1, 0, 4, "3g.20gb"),
+			},
+			wantResult: []inferencev1alpha1.ContainerResult{
+				{Name: "download", Slices: []int32{0, 1}},
+				{Name: "warmup", Slices: []int32{0, 1, 2}},
+				{Name: "model", Slices: []int32{0}},
+			},
+			wantOk: true,
+		},
+		{
+			name: "init container with another profile gets its own slice",
+			containers: []inferencev1alpha1.ContainerRequest{
+				{Name: "warmup", Profile: "1g.5gb", Init: true},
+				{Name: "model", Profile: "7g.40gb"},
+			},
+			wantSlices: []inferencev1alpha1.SliceResult{
+				slice(gpu0, 0, 8, "7g.40gb"),
+				slice(gpu1, 0, 1, "1g.5gb"),
+			},
+			wantResult: []inferencev1alpha1.ContainerResult{
+				{Name: "warmup", Slices: []int32{1}},
+				{Name: "model", Slices: []int32{0}},
+			},
+			wantOk: true,
+		},
+		{
+			name: "first acceptable profile that fits",
+			containers: []inferencev1alpha1.ContainerRequest{
+				{Name: "model", Profile: "7g.40gb", AcceptableProfiles: []string{"7g.40gb", "3g.20gb"}, Quantity: 3},
+			},
+			wantSlices: []inferencev1alpha1.SliceResult{
+				slice(gpu0, 0, 4, "3g.20gb"),
+				slice(gpu0, 4, 4, "3g.20gb"),
+				slice(gpu1, 0, 4, "3g.20gb"),
+			},
+			wantResult: []inferencev1alpha1.ContainerResult{
+				{Name: "model", Slices: []int32{0, 1, 2}},
+			},
+			wantOk: true,
+		},
+		{
+			name: "acceptable profiles are tried in order",
+			containers: []inferencev1alpha1.ContainerRequest{
+				{Name: "model", Profile: "3g.20gb", AcceptableProfiles: []string{"3g.20gb", "1g.5gb"}},
+			},
+			wantSlices: []inferencev1alpha1.SliceResult{slice(gpu0, 0, 4, "3g.20gb")},
+			wantResult: []inferencev1alpha1.ContainerResult{
+				{Name: "model", Slices: []int32{0}},
+			},
+			wantOk: true,
+		},
+		{
+			name: "main containers do not fit together",
+			containers: []inferencev1alpha1.ContainerRequest{
+				{Name: "model", Profile: "7g.40gb", Quantity: 2},
+				{Name: "embedder", Profile: "1g.5gb"},
+			},
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &InstasliceReconciler{}
+			slices, results, ok := r.placeContainerSlices(instaslice, tt.containers, &FirstFitPolicy{}, r.gpuCandidates(instaslice))
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantSlices, slices)
+			if tt.wantOk {
+				assert.Equal(t, tt.wantResult, results)
+			}
+		})
+	}
+}
+
+func TestExtractContainerRequests(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{Spec: v1.PodSpec{
+		InitContainers: []v1.Container{
+			{Name: "warmup", Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+				v1.ResourceName(OrgInstaslicePrefix + "mig-1g.5gb"): resource.MustParse("1"),
+			}}},
+			{Name: "setup"},
+		},
+		Containers: []v1.Container{
+			{Name: "proxy", Resources: v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")}}},
+			{Name: "model", Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+				v1.ResourceName(OrgInstaslicePrefix + "mig-3g.20gb"): resource.MustParse("2"),
+				v1.ResourceName(QuotaResourceName):                   resource.MustParse("40Gi"),
+			}}},
+		},
+	}}
+	assert.Equal(t, []inferencev1alpha1.ContainerRequest{
+		{Name: "warmup", Profile: "1g.5gb", Quantity: 1, Init: true},
+		{Name: "model", Profile: "3g.20gb", Quantity: 2},
+	}, r.extractContainerRequests(pod))
+	assert.Equal(t, "model", primaryContainerRequest(r.extractContainerRequests(pod)).Name)
+}
+
+func TestPlaceAcceptableProfileFallback(t *testing.T) {
+	ctx := context.Background()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "model", Namespace: "default", UID: "model",
+			Annotations: map[string]string{AcceptableProfilesAnnotation: "1g.5gb,1g.10gb"}},
+		Spec: v1.PodSpec{Containers: []v1.Container{{Name: "model", Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+			"nvidia.com/mig-1g.5gb": resource.MustParse("1"),
+		}}}}},
+	}
+	assert.Nil(t, (&PodAnnotator{}).mutatePod(ctx, admission.Request{}, pod))
+
+	// neither the instaslice nor the node offer the profile the pod names
+	r, instaslice := newAllocationFixture(t, nil, pod)
+	delete(instaslice.Status.NodeResources.MigPlacement, "1g.5gb")
+	assert.NoError(t, r.Status().Update(ctx, instaslice))
+	var instasliceList inferencev1alpha1.InstasliceList
+	assert.NoError(t, r.List(ctx, &instasliceList))
+
+	placement, err := r.placePod(ctx, pod, r.extractContainerRequests(pod), &instasliceList, instasliceList.Items, nil, &FirstFitPolicy{})
+	assert.NoError(t, err)
+	if assert.NotNil(t, placement.allocResult) {
+		assert.Equal(t, "1g.10gb", placement.allocRequest.Containers[0].Profile)
+		assert.Equal(t, "1g.10gb", placement.allocResult.Slices[0].Profile)
+	}
+}
+
+func TestContainerConfigMaps(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			ContainerConfigMapsAnnotation: `{"warmup":"cm-1","model":"cm-2"}`,
+		}},
+		Spec: v1.PodSpec{Containers: []v1.Container{{Name: "model"}}},
+	}
+	assert.Equal(t, map[string]string{"warmup": "cm-1", "model": "cm-2"}, containerConfigMaps(pod))
+
+	// pods admitted before the annotation existed use the first envFrom of the container
+	pod.Annotations = nil
+	pod.Spec.Containers[0].EnvFrom = []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "cm-3"}}}}
+	assert.Equal(t, map[string]string{"model": "cm-3"}, containerConfigMaps(pod))
+}
+
+func TestExtractProfileQuantity(t *testing.T) {
+	r := &InstasliceReconciler{}
+	limits := v1.ResourceList{
+		v1.ResourceName(OrgInstaslicePrefix + "mig-1g.5gb"): resource.MustParse("3"),
+		v1.ResourceName(QuotaResourceName):                  resource.MustParse("15Gi"),
+	}
+	assert.Equal(t, int32(3), r.extractProfileQuantity(limits, "1g.5gb"))
+	assert.Equal(t, int32(1), r.extractProfileQuantity(limits, "2g.10gb"))
+	assert.Equal(t, int32(1), r.extractProfileQuantity(v1.ResourceList{}, "1g.5gb"))
+
+	// the compute instance of a shared GPU instance is not mistaken for the GPU instance
+	limits = v1.ResourceList{v1.ResourceName(OrgInstaslicePrefix + "mig-1c.4g.20gb"): resource.MustParse("2")}
+	assert.Equal(t, "1c.4g.20gb", r.extractProfileName(limits))
+	assert.Equal(t, int32(2), r.extractProfileQuantity(limits, "1c.4g.20gb"))
+	assert.Equal(t, int32(1), r.extractProfileQuantity(limits, "4g.20gb"))
+}
+
+func TestAllocatedContainers(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("fake-node")
+	gpus := sortGPUs(instaslice)
+	r := &InstasliceReconciler{allocationCache: newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{
+		"running": {GPUUUID: gpus[0], MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 8}},
+	})}
+	containers := []inferencev1alpha1.ContainerRequest{
+		{Name: "model", Profile: "7g.40gb", AcceptableProfiles: []string{"7g.40gb", "3g.20gb"}, Quantity: 2},
+		{Name: "embedder", Profile: "1g.5gb"},
+	}
+
+	// only the second GPU is free, no choice fits and the candidates are left untouched
+	candidates := r.gpuCandidates(instaslice)
+	_, _, ok := r.placeContainerSlices(instaslice, containers, &FirstFitPolicy{}, candidates)
+	assert.False(t, ok)
+	assert.Equal(t, parseGPUSlots("........", 8), candidates[1].Allocated)
+
+	// a 7g slice leaves no room for the embedder, the 3g slice does
+	containers[0].Quantity = 1
+	slices, results, ok := r.placeContainerSlices(instaslice, containers, &FirstFitPolicy{}, candidates)
+	assert.True(t, ok)
+	assert.Equal(t, parseGPUSlots("xxxxx...", 8), candidates[1].Allocated)
+	allocated := allocatedContainers(containers, slices, results)
+	assert.Equal(t, "3g.20gb", allocated[0].Profile)
+	assert.Equal(t, []string{"7g.40gb", "3g.20gb"}, allocated[0].AcceptableProfiles)
+	assert.Equal(t, "1g.5gb", allocated[1].Profile)
+	assert.Equal(t, "7g.40gb", containers[0].Profile)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// Profiles such as 1c.4g.20gb create a compute instance with part of the compute slices of a
+// GPU instance. Slices of profiles with the same GPU instance profile share the GPU instance and
+// its memory until its compute slices are used up, the slots of the GPU instance stay allocated
+// until its last compute instance is released. Compute instances are aligned to their size
+// within the GPU instance.
+
+// SharedGPUInstance is a GPU instance split between compute instances, along with the compute
+// slices that are already allocated in it.
+type SharedGPUInstance struct {
+	GIProfileID int32
+	Placement   inferencev1alpha1.Placement
+	Allocated   GPUSlots
+}
+
+// sharedMig returns the discovered profile when its compute instance shares a GPU instance
+func sharedMig(instaslice *inferencev1alpha1.Instaslice, profileName string) (inferencev1alpha1.Mig, bool) {
+	mig, ok := instaslice.Status.NodeResources.MigPlacement[profileName]
+	return mig, ok && mig.SharesGPUInstance()
+}
+
+// computeSliceStarts returns the free starts of a compute instance with size compute slices
+// in a shared GPU instance
+func computeSliceStarts(allocated GPUSlots, size int32) []int32 {
+	var starts []int32
+	if size <= 0 {
+		return starts
+	}
+	for start := int32(0); start+size <= allocated.Len(); start += size {
+		if allocated.IsFree(start, size) {
+			starts = append(starts, start)
+		}
+	}
+	return starts
+}
+
+// sharedStarts returns the starts of the shared GPU instances of a candidate in ascending order
+func sharedStarts(shared map[int32]*SharedGPUInstance) []int32 {
+	starts := make([]int32, 0, len(shared))
+	for start := range shared {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	return starts
+}
+
+// selectComputeSlice places a slice of a profile sharing its GPU instance. GPU instances of
+// the same GPU instance profile with free compute slices are filled first, in candidate order,
+// before the policy picks the placement of a new GPU instance.
+func selectComputeSlice(instaslice *inferencev1alpha1.Instaslice, profileName string, mig inferencev1alpha1.Mig, policy AllocationPolicy, candidates []GPUCandidate) (inferencev1alpha1.SliceResult, bool) {
+	for _, candidate := range candidates {
+		for _, start := range sharedStarts(candidate.Shared) {
+			shared := candidate.Shared[start]
+			if shared.GIProfileID != mig.GIProfileID {
+				continue
+			}
+			if starts := computeSliceStarts(shared.Allocated, mig.CISliceCount); len(starts) > 0 {
+				return inferencev1alpha1.SliceResult{
+					MigPlacement:     shared.Placement,
+					GPUUUID:          candidate.GPUUUID,
+					ComputePlacement: &inferencev1alpha1.Placement{Start: starts[0], Size: mig.CISliceCount},
+				}, true
+			}
+		}
+	}
+	gpuUUID, start, ok := policy.SelectPlacement(instaslice, profileName, candidates)
+	if !ok {
+		return inferencev1alpha1.SliceResult{}, false
+	}
+	return inferencev1alpha1.SliceResult{
+		MigPlacement:     inferencev1alpha1.Placement{Start: start, Size: profileSize(instaslice, profileName)},
+		GPUUUID:          gpuUUID,
+		ComputePlacement: &inferencev1alpha1.Placement{Start: 0, Size: mig.CISliceCount},
+	}, true
+}
+
+// reserveSlice marks the slots of a slice as allocated on its candidate GPU along with its compute
+// slices when it joins a shared GPU instance. A slice starting a GPU instance shares it with later
+// slices when mig is the profile of the slice and shares its GPU instance.
+func reserveSlice(candidates []GPUCandidate, slice inferencev1alpha1.SliceResult, mig inferencev1alpha1.Mig) {
+	for i := range candidates {
+		if candidates[i].GPUUUID != slice.GPUUUID {
+			continue
+		}
+		candidates[i].Allocated.Allocate(slice.MigPlacement.Start, slice.MigPlacement.Size)
+		if slice.ComputePlacement == nil {
+			continue
+		}
+		shared, ok := candidates[i].Shared[slice.MigPlacement.Start]
+		if !ok {
+			if !mig.SharesGPUInstance() {
+				continue
+			}
+			if candidates[i].Shared == nil {
+				candidates[i].Shared = make(map[int32]*SharedGPUInstance)
+			}
+			shared = &SharedGPUInstance{GIProfileID: mig.GIProfileID, Placement: slice.MigPlacement, Allocated: NewGPUSlots(mig.GISliceCount)}
+			candidates[i].Shared[slice.MigPlacement.Start] = shared
+		}
+		shared.Allocated.Allocate(slice.ComputePlacement.Start, slice.ComputePlacement.Size)
+	}
+}
+
+// sharedGPUInstancesExcluding returns the shared GPU instances of a GPU with the compute slices
+// allocated to pods other than the excluded ones, by their start
+func (r *InstasliceReconciler) sharedGPUInstancesExcluding(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, excluded map[types.UID]bool) map[int32]*SharedGPUInstance {
+	shared := make(map[int32]*SharedGPUInstance)
+	candidates := []GPUCandidate{{GPUUUID: gpuUUID, Allocated: NewGPUSlots(gpuSlotCount(instaslice)), Shared: shared}}
+	r.allocationCache.Range(func(podUID types.UID, allocResult inferencev1alpha1.AllocationResult) bool {
+		if allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted || excluded[podUID] {
+			return true
+		}
+		for _, slice := range allocResult.AllSlices() {
+			if slice.GPUUUID != gpuUUID || slice.ComputePlacement == nil {
+				continue
+			}
+			if mig, ok := sharedMig(instaslice, slice.Profile); ok {
+				reserveSlice(candidates, slice, mig)
+			}
+		}
+		return true
+	})
+	return shared
+}
+
+// cloneSharedGPUInstances returns a copy of shared GPU instances whose compute slices can be
+// allocated without changing the originals
+func cloneSharedGPUInstances(shared map[int32]*SharedGPUInstance) map[int32]*SharedGPUInstance {
+	clones := make(map[int32]*SharedGPUInstance, len(shared))
+	for start, instance := range shared {
+		clones[start] = &SharedGPUInstance{GIProfileID: instance.GIProfileID, Placement: instance.Placement, Allocated: instance.Allocated.Clone()}
+	}
+	return clones
+}
+
+// hasSharedSlice reports whether a slice of the allocation shares its GPU instance
+func hasSharedSlice(allocResult *inferencev1alpha1.AllocationResult) bool {
+	for _, slice := range allocResult.AllSlices() {
+		if slice.ComputePlacement != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// sharedCapacity returns a node whose 4g.20gb GPU instances can be shared by 1c and 2c compute instances
+func sharedCapacity(nodeName string) *inferencev1alpha1.Instaslice {
+	instaslice := utils.GenerateFakeCapacity(nodeName)
+	placements := instaslice.Status.NodeResources.MigPlacement["4g.20gb"].Placements
+	instaslice.Status.NodeResources.MigPlacement["1c.4g.20gb"] = inferencev1alpha1.Mig{Placements: placements, GIProfileID: 3, CIProfileID: 0, CISliceCount: 1, GISliceCount: 4}
+	instaslice.Status.NodeResources.MigPlacement["2c.4g.20gb"] = inferencev1alpha1.Mig{Placements: placements, GIProfileID: 3, CIProfileID: 1, CISliceCount: 2, GISliceCount: 4}
+	return instaslice
+}
+
+func TestPlaceContainerSlicesSharedGPUInstance(t *testing.T) {
+	instaslice := sharedCapacity("node-1")
+	gpus := sortGPUs(instaslice)
+	r := &InstasliceReconciler{allocationCache: newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{})}
+	allocate := func(podUID types.UID, profileName string) []inferencev1alpha1.SliceResult {
+		containers := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: profileName}}
+		slices, _, ok := r.placeContainerSlices(instaslice, containers, &FirstFitPolicy{}, r.gpuCandidates(instaslice))
+		if !ok {
+			return nil
+		}
+		r.allocationCache.Set(podUID, inferencev1alpha1.AllocationResult{Slices: slices, GPUUUID: slices[0].GPUUUID, MigPlacement: slices[0].MigPlacement, Nodename: "node-1"})
+		return slices
+	}
+
+	// four pods share the compute slices of one GPU instance
+	for i := int32(0); i < 4; i++ {
+		slices := allocate(types.UID(fmt.Sprintf("pod-%d", i)), "1c.4g.20gb")
+		assert.Equal(t, []inferencev1alpha1.SliceResult{{
+			GPUUUID:          gpus[0],
+			MigPlacement:     inferencev1alpha1.Placement{Start: 0, Size: 4},
+			Profile:          "1c.4g.20gb",
+			ComputePlacement: &inferencev1alpha1.Placement{Start: i, Size: 1},
+		}}, slices)
+	}
+	// the next one starts a GPU instance on the other GPU
+	slices := allocate("pod-4", "1c.4g.20gb")
+	assert.Equal(t, gpus[1], slices[0].GPUUUID)
+	assert.Equal(t, inferencev1alpha1.Placement{Start: 0, Size: 1}, *slices[0].ComputePlacement)
+
+	// a compute instance of another size is aligned to its size
+	slices = allocate("pod-5", "2c.4g.20gb")
+	assert.Equal(t, gpus[1], slices[0].GPUUUID)
+	assert.Equal(t, inferencev1alpha1.Placement{Start: 2, Size: 2}, *slices[0].ComputePlacement)
+
+	// the slots of the shared GPU instances are not available to whole GPU instances
+	assert.Nil(t, allocate("pod-6", "4g.20gb"))
+	candidates := r.gpuCandidates(instaslice)
+	assert.False(t, candidates[0].Allocated.IsFree(0, 4))
+	assert.Equal(t, int32(4), candidates[0].Allocated.FreeCount())
+}
+
+func TestPlacePodsSharingGPUInstance(t *testing.T) {
+	ctx := context.Background()
+	// the webhook turned the nvidia.com/mig-1c.4g.20gb limits of the pods into instaslice ones
+	gatedPod := func(name string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name), Finalizers: []string{FinalizerName}},
+			Spec: v1.PodSpec{
+				SchedulingGates: []v1.PodSchedulingGate{{Name: GateName}},
+				Containers: []v1.Container{{Name: "main", Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{v1.ResourceName(OrgInstaslicePrefix + "mig-1c.4g.20gb"): resource.MustParse("1")},
+				}}},
+			},
+		}
+	}
+	pods := []*v1.Pod{gatedPod("model-a"), gatedPod("model-b")}
+	r, instaslice := newAllocationFixture(t, nil, pods[0], pods[1])
+	instaslice.Status.NodeResources.MigPlacement = sharedCapacity("node-1").Status.NodeResources.MigPlacement
+	assert.NoError(t, r.Status().Update(ctx, instaslice))
+
+	for _, pod := range pods {
+		containers := r.extractContainerRequests(pod)
+		assert.Equal(t, "1c.4g.20gb", containers[0].Profile)
+		var instasliceList inferencev1alpha1.InstasliceList
+		assert.NoError(t, r.List(ctx, &instasliceList))
+		placement, err := r.placePod(ctx, pod, containers, &instasliceList, instasliceList.Items, nil, &FirstFitPolicy{})
+		assert.NoError(t, err)
+		if !assert.NotNil(t, placement.allocResult, pod.Name) {
+			return
+		}
+		_, err = r.commitPlacement(ctx, pod, placement, instasliceList.Items, &FirstFitPolicy{})
+		assert.NoError(t, err)
+	}
+
+	// both compute instances are created in the same GPU instance
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	first := instaslice.Status.PodAllocationResults["model-a"].Slices
+	second := instaslice.Status.PodAllocationResults["model-b"].Slices
+	if assert.Len(t, first, 1) && assert.Len(t, second, 1) {
+		assert.Equal(t, first[0].GPUUUID, second[0].GPUUUID)
+		assert.Equal(t, inferencev1alpha1.Placement{Start: 0, Size: 4}, first[0].MigPlacement)
+		assert.Equal(t, first[0].MigPlacement, second[0].MigPlacement)
+		assert.Equal(t, &inferencev1alpha1.Placement{Start: 0, Size: 1}, first[0].ComputePlacement)
+		assert.Equal(t, &inferencev1alpha1.Placement{Start: 1, Size: 1}, second[0].ComputePlacement)
+	}
+}
+
+func TestGPUCandidatesExcludingSharedGPUInstance(t *testing.T) {
+	instaslice := sharedCapacity("node-1")
+	gpu := sortGPUs(instaslice)[0]
+	slice := func(computeStart int32) inferencev1alpha1.SliceResult {
+		return inferencev1alpha1.SliceResult{
+			GPUUUID:          gpu,
+			MigPlacement:     inferencev1alpha1.Placement{Start: 0, Size: 4},
+			Profile:          "1c.4g.20gb",
+			ComputePlacement: &inferencev1alpha1.Placement{Start: computeStart, Size: 1},
+		}
+	}
+	r := &InstasliceReconciler{allocationCache: newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{
+		"a": {Slices: []inferencev1alpha1.SliceResult{slice(0)}},
+		"b": {Slices: []inferencev1alpha1.SliceResult{slice(1)}},
+	})}
+
+	candidates := r.gpuCandidatesExcluding(instaslice, map[types.UID]bool{"a": true})
+	assert.False(t, candidates[0].Allocated.IsFree(0, 4))
+	assert.Equal(t, GPUSlots{false, true, false, false}, candidates[0].Shared[0].Allocated)
+
+	// the GPU instance is free once every pod sharing it is gone
+	candidates = r.gpuCandidatesExcluding(instaslice, map[types.UID]bool{"a": true, "b": true})
+	assert.True(t, candidates[0].Allocated.IsFree(0, 4))
+	assert.Empty(t, candidates[0].Shared)
+
+	// clones do not share the compute slices
+	clones := cloneGPUCandidates(r.gpuCandidates(instaslice))
+	clones[0].Shared[0].Allocated.Allocate(2, 1)
+	assert.Equal(t, GPUSlots{true, true, false, false}, r.gpuCandidates(instaslice)[0].Shared[0].Allocated)
+}
+
+func TestComputeSliceStarts(t *testing.T) {
+	assert.Equal(t, []int32{0, 1, 2, 3}, computeSliceStarts(NewGPUSlots(4), 1))
+	assert.Equal(t, []int32{0, 2}, computeSliceStarts(NewGPUSlots(4), 2))
+	assert.Equal(t, []int32{0}, computeSliceStarts(NewGPUSlots(4), 3))
+	assert.Equal(t, []int32{2}, computeSliceStarts(GPUSlots{false, true, false, false}, 2))
+	assert.Empty(t, computeSliceStarts(NewGPUSlots(4), 0))
+}
@@ -3,7 +3,9 @@ package config
 import (
 	"encoding/json"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -13,6 +15,33 @@ const (
 	// TODO fix this image
 	DefaultDaemonsetImage    = "quay.io/amalvank/instaslicev2-daemonset:latest"
 	DefaultManifestConfigDir = "/config"
+	DefaultAllocationPolicy  = "first-fit"
+	// DefaultNodeSelectionStrategy tries nodes in the order of their names
+	DefaultNodeSelectionStrategy = "ordered"
+	// DefaultPodGroupTimeout time a pod group gets to reserve slices for all its members
+	DefaultPodGroupTimeout = 5 * time.Minute
+	DefaultDefragEnable    = false
+	DefaultDefragDryRun    = false
+	// DefaultDefragInterval time between two passes of the defragmenter
+	DefaultDefragInterval = 10 * time.Minute
+	// DefaultDefragMaxEvictions pods the defragmenter evicts at most per pass
+	DefaultDefragMaxEvictions = 2
+	// DefaultPendingQueueFairShare orders pending pods by priority and creation time only
+	DefaultPendingQueueFairShare = false
+	// DefaultAllocationCreatingTimeout time the daemonset gets to create the slices of an allocation
+	DefaultAllocationCreatingTimeout = 2 * time.Minute
+	// DefaultAllocationDeletingTimeout time the daemonset gets to delete the slices of an allocation
+	DefaultAllocationDeletingTimeout = 5 * time.Minute
+	// DefaultAllocationTimeoutBackoff time a node or GPU is excluded after an allocation timed out on it
+	DefaultAllocationTimeoutBackoff = 5 * time.Minute
+	// DefaultMaxConcurrentReconciles pods reconciled at the same time
+	DefaultMaxConcurrentReconciles = 4
+	DefaultOrphanGCEnable          = false
+	DefaultOrphanGCDryRun          = false
+	// DefaultOrphanGCInterval time between two sweeps of the orphan collector
+	DefaultOrphanGCInterval = 5 * time.Minute
+	// DefaultOrphanGCGracePeriod time an orphan is seen before it is reclaimed
+	DefaultOrphanGCGracePeriod = 2 * time.Minute
 )
 
 type Config struct {
@@ -30,15 +59,79 @@ type Config struct {
 
 	// AutoLabelManagedNodes automatically labels mig capable nodes with "instaslice.redhat.com/managed "at daemonset startup
 	AutoLabelManagedNodes bool `json:"auto_label_managed_nodes"`
+
+	// AllocationPolicy name of the policy that picks the GPU and start index for a slice
+	AllocationPolicy string `json:"allocation_policy"`
+
+	// NodeSelectionStrategy order in which nodes are tried for a slice, can be overridden per pod
+	NodeSelectionStrategy string `json:"node_selection_strategy"`
+
+	// PodGroupTimeout time after which the slices reserved by an incomplete pod group are released
+	PodGroupTimeout time.Duration `json:"pod_group_timeout"`
+
+	// DefragEnable periodically evicts pods of restartable workloads to repack fragmented GPUs
+	DefragEnable bool `json:"defrag_enable"`
+
+	// DefragDryRun only reports the evictions the defragmenter would make
+	DefragDryRun bool `json:"defrag_dry_run"`
+
+	// DefragInterval time between two passes of the defragmenter
+	DefragInterval time.Duration `json:"defrag_interval"`
+
+	// DefragMaxEvictions maximum number of pods evicted per pass of the defragmenter
+	DefragMaxEvictions int32 `json:"defrag_max_evictions"`
+
+	// PendingQueueFairShare orders pending pods of the same priority by the slots held by their namespace
+	PendingQueueFairShare bool `json:"pending_queue_fair_share"`
+
+	// AllocationCreatingTimeout time after which an allocation the daemonset did not create is placed elsewhere
+	AllocationCreatingTimeout time.Duration `json:"allocation_creating_timeout"`
+
+	// AllocationDeletingTimeout time after which an allocation the daemonset did not delete is marked timed out
+	AllocationDeletingTimeout time.Duration `json:"allocation_deleting_timeout"`
+
+	// AllocationTimeoutBackoff time no slice is placed on the node or GPU of a timed out allocation
+	AllocationTimeoutBackoff time.Duration `json:"allocation_timeout_backoff"`
+
+	// MaxConcurrentReconciles number of pods reconciled at the same time
+	MaxConcurrentReconciles int `json:"max_concurrent_reconciles"`
+
+	// OrphanGCEnable periodically releases the allocations, ConfigMaps and MIG instances left by deleted pods
+	OrphanGCEnable bool `json:"orphan_gc_enable"`
+
+	// OrphanGCDryRun only reports the orphans the collector would reclaim
+	OrphanGCDryRun bool `json:"orphan_gc_dry_run"`
+
+	// OrphanGCInterval time between two sweeps of the orphan collector
+	OrphanGCInterval time.Duration `json:"orphan_gc_interval"`
+
+	// OrphanGCGracePeriod time an orphan is seen by consecutive sweeps before it is reclaimed
+	OrphanGCGracePeriod time.Duration `json:"orphan_gc_grace_period"`
 }
 
 func NewConfig() *Config {
 	return &Config{
-		EmulatorModeEnable:    DefaultEmulatorMode,
-		WebhookEnable:         DefaultWebhookMode,
-		DaemonsetImage:        DefaultDaemonsetImage,
-		ManifestConfigDir:     DefaultManifestConfigDir,
-		AutoLabelManagedNodes: DefaultAutoLabelManagedNodes,
+		EmulatorModeEnable:        DefaultEmulatorMode,
+		WebhookEnable:             DefaultWebhookMode,
+		DaemonsetImage:            DefaultDaemonsetImage,
+		ManifestConfigDir:         DefaultManifestConfigDir,
+		AutoLabelManagedNodes:     DefaultAutoLabelManagedNodes,
+		AllocationPolicy:          DefaultAllocationPolicy,
+		NodeSelectionStrategy:     DefaultNodeSelectionStrategy,
+		PodGroupTimeout:           DefaultPodGroupTimeout,
+		DefragEnable:              DefaultDefragEnable,
+		DefragDryRun:              DefaultDefragDryRun,
+		DefragInterval:            DefaultDefragInterval,
+		DefragMaxEvictions:        DefaultDefragMaxEvictions,
+		PendingQueueFairShare:     DefaultPendingQueueFairShare,
+		AllocationCreatingTimeout: DefaultAllocationCreatingTimeout,
+		AllocationDeletingTimeout: DefaultAllocationDeletingTimeout,
+		AllocationTimeoutBackoff:  DefaultAllocationTimeoutBackoff,
+		MaxConcurrentReconciles:   DefaultMaxConcurrentReconciles,
+		OrphanGCEnable:            DefaultOrphanGCEnable,
+		OrphanGCDryRun:            DefaultOrphanGCDryRun,
+		OrphanGCInterval:          DefaultOrphanGCInterval,
+		OrphanGCGracePeriod:       DefaultOrphanGCGracePeriod,
 	}
 }
 
@@ -70,5 +163,87 @@ func ConfigFromEnvironment() *Config {
 		config.AutoLabelManagedNodes = strings.EqualFold(autoLabel, "true")
 	}
 
+	if allocationPolicy, ok := os.LookupEnv("ALLOCATION_POLICY"); ok {
+		config.AllocationPolicy = allocationPolicy
+	}
+
+	if nodeSelectionStrategy, ok := os.LookupEnv("NODE_SELECTION_STRATEGY"); ok {
+		config.NodeSelectionStrategy = nodeSelectionStrategy
+	}
+
+	if podGroupTimeout, ok := os.LookupEnv("POD_GROUP_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(podGroupTimeout); err == nil && timeout > 0 {
+			config.PodGroupTimeout = timeout
+		}
+	}
+
+	if defragEnable, ok := os.LookupEnv("DEFRAG_ENABLE"); ok {
+		config.DefragEnable = strings.EqualFold(defragEnable, "true")
+	}
+
+	if defragDryRun, ok := os.LookupEnv("DEFRAG_DRY_RUN"); ok {
+		config.DefragDryRun = strings.EqualFold(defragDryRun, "true")
+	}
+
+	if defragInterval, ok := os.LookupEnv("DEFRAG_INTERVAL"); ok {
+		if interval, err := time.ParseDuration(defragInterval); err == nil && interval > 0 {
+			config.DefragInterval = interval
+		}
+	}
+
+	if defragMaxEvictions, ok := os.LookupEnv("DEFRAG_MAX_EVICTIONS"); ok {
+		if maxEvictions, err := strconv.ParseInt(defragMaxEvictions, 10, 32); err == nil && maxEvictions > 0 {
+			config.DefragMaxEvictions = int32(maxEvictions)
+		}
+	}
+
+	if fairShare, ok := os.LookupEnv("PENDING_QUEUE_FAIR_SHARE"); ok {
+		config.PendingQueueFairShare = strings.EqualFold(fairShare, "true")
+	}
+
+	if creatingTimeout, ok := os.LookupEnv("ALLOCATION_CREATING_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(creatingTimeout); err == nil && timeout > 0 {
+			config.AllocationCreatingTimeout = timeout
+		}
+	}
+
+	if deletingTimeout, ok := os.LookupEnv("ALLOCATION_DELETING_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(deletingTimeout); err == nil && timeout > 0 {
+			config.AllocationDeletingTimeout = timeout
+		}
+	}
+
+	if timeoutBackoff, ok := os.LookupEnv("ALLOCATION_TIMEOUT_BACKOFF"); ok {
+		if backoff, err := time.ParseDuration(timeoutBackoff); err == nil && backoff > 0 {
+			config.AllocationTimeoutBackoff = backoff
+		}
+	}
+
+	if maxConcurrentReconciles, ok := os.LookupEnv("MAX_CONCURRENT_RECONCILES"); ok {
+		if workers, err := strconv.Atoi(maxConcurrentReconciles); err == nil && workers > 0 {
+			config.MaxConcurrentReconciles = workers
+		}
+	}
+
+	if orphanGCEnable, ok := os.LookupEnv("ORPHAN_GC_ENABLE"); ok {
+		config.OrphanGCEnable = strings.EqualFold(orphanGCEnable, "true")
+	}
+
+	if orphanGCDryRun, ok := os.LookupEnv("ORPHAN_GC_DRY_RUN"); ok {
+		config.OrphanGCDryRun = strings.EqualFold(orphanGCDryRun, "true")
+	}
+
+	if orphanGCInterval, ok := os.LookupEnv("ORPHAN_GC_INTERVAL"); ok {
+		if interval, err := time.ParseDuration(orphanGCInterval); err == nil && interval > 0 {
+			config.OrphanGCInterval = interval
+		}
+	}
+
+	if orphanGCGracePeriod, ok := os.LookupEnv("ORPHAN_GC_GRACE_PERIOD"); ok {
+		if gracePeriod, err := time.ParseDuration(orphanGCGracePeriod); err == nil && gracePeriod >= 0 {
+			config.OrphanGCGracePeriod = gracePeriod
+		}
+	}
+
 	return config
 }
@@ -19,27 +19,42 @@ package controller
 import "time"
 
 const (
-	OrgInstaslicePrefix              = "instaslice.redhat.com/"
-	ManagedLabel                     = OrgInstaslicePrefix + "managed"
-	GateName                         = OrgInstaslicePrefix + "accelerator"
-	FinalizerName                    = GateName
-	QuotaResourceName                = OrgInstaslicePrefix + "accelerator-memory-quota"
-	GPUMemoryLabelName               = "nvidia.com/gpu.memory"
-	GPUCountLabelName                = "nvidia.com/gpu.count"
-	EmulatorModeFalse                = "false"
-	EmulatorModeTrue                 = "true"
-	InstasliceManagedTrue            = "true"
-	MigCapableTrue                   = "true"
-	AttributeMediaExtensions         = "me"
-	InstaSliceOperatorNamespace      = "instaslice-system"
-	NvidiaMIGPrefix                  = "nvidia.com/mig-"
-	NodeLabel                        = "kubernetes.io/hostname"
-	multipleContainersUnsupportedErr = "multiple containers per pod not supported"
-	noContainerInsidePodErr          = "no containers present inside the pod"
-	InstasliceDaemonsetName          = "instaslice-operator-controller-daemonset"
-	daemonSetImageName               = "quay.io/amalvank/instaslicev2-daemonset:latest"
-	daemonSetName                    = "daemonset"
-	serviceAccountName               = "instaslice-operator-controller-manager"
+	OrgInstaslicePrefix             = "instaslice.redhat.com/"
+	ManagedLabel                    = OrgInstaslicePrefix + "managed"
+	AllocationConfigMapLabel        = OrgInstaslicePrefix + "allocation-configmap"
+	GateName                        = OrgInstaslicePrefix + "accelerator"
+	FinalizerName                   = GateName
+	QuotaResourceName               = OrgInstaslicePrefix + "accelerator-memory-quota"
+	AcceleratorMemoryResourceName   = OrgInstaslicePrefix + "accelerator-memory"
+	AcceleratorComputeResourceName  = OrgInstaslicePrefix + "accelerator-compute-percent"
+	NodeSelectionStrategyAnnotation = OrgInstaslicePrefix + "node-selection-strategy"
+	ContainerConfigMapsAnnotation   = OrgInstaslicePrefix + "container-configmaps"
+	AcceptableProfilesAnnotation    = OrgInstaslicePrefix + "acceptable-profiles"
+	ContainerProfilesAnnotation     = OrgInstaslicePrefix + "container-profiles"
+	AllocatedProfilesAnnotation     = OrgInstaslicePrefix + "allocated-profiles"
+	PodGroupAnnotation              = OrgInstaslicePrefix + "pod-group"
+	PodGroupMinMemberAnnotation     = OrgInstaslicePrefix + "pod-group-min-member"
+	DefragAllowedAnnotation         = OrgInstaslicePrefix + "defrag-allowed"
+	GPUModelAnnotation              = OrgInstaslicePrefix + "gpu-model"
+	PreferredGPUModelAnnotation     = OrgInstaslicePrefix + "preferred-gpu-model"
+	DefragReportConfigMapName       = "instaslice-defrag-report"
+	GPUMemoryLabelName              = "nvidia.com/gpu.memory"
+	GPUCountLabelName               = "nvidia.com/gpu.count"
+	EmulatorModeFalse               = "false"
+	EmulatorModeTrue                = "true"
+	InstasliceManagedTrue           = "true"
+	AllocationConfigMapTrue         = "true"
+	MigCapableTrue                  = "true"
+	AttributeMediaExtensions        = "me"
+	InstaSliceOperatorNamespace     = "instaslice-system"
+	NvidiaMIGPrefix                 = "nvidia.com/mig-"
+	NodeLabel                       = "kubernetes.io/hostname"
+	noContainerInsidePodErr         = "no containers present inside the pod"
+	noGPUContainerInsidePodErr      = "no containers requesting GPU slices present inside the pod"
+	InstasliceDaemonsetName         = "instaslice-operator-controller-daemonset"
+	daemonSetImageName              = "quay.io/amalvank/instaslicev2-daemonset:latest"
+	daemonSetName                   = "daemonset"
+	serviceAccountName              = "instaslice-operator-controller-manager"
 
 	Requeue1sDelay  = 1 * time.Second
 	Requeue2sDelay  = 2 * time.Second
@@ -19,12 +19,15 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -39,6 +42,12 @@ type InstaSliceDaemonsetReconciler struct {
 	kubeClient *kubernetes.Clientset
 	NodeName   string
 	Config     *config.Config
+	Recorder   record.EventRecorder
+	// orphanSlices records when the MIG devices no allocation holds were first seen
+	orphanSlices map[string]time.Time
+	// migMu serializes the MIG changes of Reconcile and of the orphan collector, so that the
+	// collector never sees the slices of an allocation being created or deleted
+	migMu sync.Mutex
 }
 
 // +kubebuilder:rbac:groups=inference.redhat.com,resources=instaslices,verbs=get;list;watch;create;update;patch;delete
@@ -47,6 +56,7 @@ type InstaSliceDaemonsetReconciler struct {
 // +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;update;patch;watch
 // +kubebuilder:rbac:groups="",resources=nodes/status,verbs=get;list;update;patch;watch
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 var (
 	discoveredGpusOnHost []string
 	initNvmlOnce         sync.Once
@@ -117,6 +127,8 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 	if req.Name != r.NodeName {
 		return ctrl.Result{}, nil
 	}
+	r.migMu.Lock()
+	defer r.migMu.Unlock()
 
 	nsName := types.NamespacedName{
 		Name:      r.NodeName,
@@ -156,7 +168,7 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 	if instaslice.Status.NodeResources.BootID == "" {
 		originalInstaSliceObj := instaslice.DeepCopy()
 		instaslice.Status.NodeResources.BootID = node.Status.NodeInfo.BootID
-		err := r.Status().Patch(ctx, &instaslice, client.MergeFrom(originalInstaSliceObj)) // TODO - try with update
+		err := r.Status().Patch(ctx, &instaslice, client.MergeFrom(originalInstaSliceObj), client.FieldOwner(string(utils.DaemonsetFieldManager)))
 		if err != nil {
 			log.Error(err, "error patching instaslice object with Boot ID of the Node ", "nodeName", r.NodeName, "bootId", node.Status.NodeInfo.BootID)
 			return ctrl.Result{RequeueAfter: controller.Requeue1sDelay}, nil
@@ -175,7 +187,7 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 			// update the instaslice object with the node's boot id
 			originalInstaSliceObj := instaslice.DeepCopy()
 			instaslice.Status.NodeResources.BootID = node.Status.NodeInfo.BootID
-			err := r.Status().Patch(ctx, &instaslice, client.MergeFrom(originalInstaSliceObj))
+			err := r.Status().Patch(ctx, &instaslice, client.MergeFrom(originalInstaSliceObj), client.FieldOwner(string(utils.DaemonsetFieldManager)))
 			if err != nil {
 				log.Error(err, "error patching instaslice object with Boot ID of the Node ", "nodeName", r.NodeName, "bootId", node.Status.NodeInfo.BootID)
 				return ctrl.Result{RequeueAfter: controller.Requeue1sDelay}, err
@@ -188,9 +200,10 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 
 		podRef := instaslice.Spec.PodAllocationRequests[podUID].PodRef
 
-		// 1) Handle "deleting"
+		// 1) Handle "deleting", allocations can be released before their slices were reported created
 		if allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting &&
-			allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated &&
+			(allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated ||
+				allocResult.AllocationStatus.AllocationStatusDaemonset == "") &&
 			allocResult.Nodename == types.NodeName(r.NodeName) {
 
 			log.Info("Performing cleanup for pod", "podRef", podRef)
@@ -200,30 +213,46 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 					log.Error(err, "error checking configmap existence", "podRef", podRef)
 					return ctrl.Result{RequeueAfter: controller.Requeue2sDelay}, err
 				}
-				if exists {
+				// slices of an allocation that was never reported created may exist without a ConfigMap
+				if exists || allocResult.AllocationStatus.AllocationStatusDaemonset == "" {
 					err := r.cleanUpCiAndGi(ctx, &allocResult, podRef)
 					if err != nil {
 						// NVML shutdowm took time or NVML init may have failed.
 						log.Error(err, "error cleaning up ci and gi retrying", podRef)
+						r.recordAllocationFailure(ctx, &instaslice, podUID, "SliceDeletionFailed", err)
 						return ctrl.Result{RequeueAfter: controller.Requeue2sDelay}, err
 					}
 				}
 			}
-			err := r.deleteConfigMap(ctx,
-				string(allocResult.ConfigMapResourceIdentifier),
-				podRef.Namespace)
-			if err != nil && !errors.IsNotFound(err) {
-				log.Error(err, "error deleting config map for pod", "pod", podRef.Name)
-				return ctrl.Result{Requeue: true}, err
+			// the ConfigMap of the allocation guards the MIG cleanup above, delete it last
+			configMapNames := make([]string, 0, len(allocResult.AllContainers()))
+			for _, container := range allocResult.AllContainers() {
+				if container.ConfigMapResourceIdentifier != "" && container.ConfigMapResourceIdentifier != allocResult.ConfigMapResourceIdentifier {
+					configMapNames = append(configMapNames, string(container.ConfigMapResourceIdentifier))
+				}
+			}
+			configMapNames = append(configMapNames, string(allocResult.ConfigMapResourceIdentifier))
+			for _, configMapName := range configMapNames {
+				err := r.deleteConfigMap(ctx, configMapName, podRef.Namespace)
+				if err != nil && !errors.IsNotFound(err) {
+					log.Error(err, "error deleting config map for pod", "pod", podRef.Name)
+					r.recordAllocationFailure(ctx, &instaslice, podUID, "ConfigMapDeletionFailed", err)
+					return ctrl.Result{Requeue: true}, err
+				}
 			}
 
-			newAlloc := allocResult
+			newAlloc := *allocResult.DeepCopy()
 			newAlloc.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
-			instaslice.Status.PodAllocationResults[podUID] = newAlloc
-			if err := r.Status().Update(ctx, &instaslice); err != nil {
+			newAlloc.SetCondition(inferencev1alpha1.AllocationConditionReleased, metav1.ConditionTrue, "SlicesDeleted",
+				fmt.Sprintf("the MIG slices and ConfigMaps of the allocation were deleted from node %s", r.NodeName))
+			clearAllocationFailure(&newAlloc)
+			allocRequest := instaslice.Spec.PodAllocationRequests[podUID]
+			if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.DaemonsetFieldManager, instaslice.Name, &newAlloc, &allocRequest); err != nil {
 				log.Error(err, "error updating Instaslice status for pod cleanup", "podRef", podRef)
 				return ctrl.Result{Requeue: true}, err
 			}
+			controller.RecordAllocationEvent(r.Recorder, instaslice.Name, &allocRequest, v1.EventTypeNormal, controller.EventReasonSlicesReleased,
+				fmt.Sprintf("the MIG slices and ConfigMaps of the pod were deleted from node %s", r.NodeName))
 			return ctrl.Result{}, nil
 		}
 
@@ -231,7 +260,7 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 		if allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusCreating &&
 			allocResult.AllocationStatus.AllocationStatusDaemonset == "" &&
 			allocResult.Nodename == types.NodeName(r.NodeName) {
-			exists, err := r.checkConfigMapExists(ctx, string(allocResult.ConfigMapResourceIdentifier), podRef.Namespace)
+			exists, err := r.containerConfigMapsExist(ctx, &allocResult, podRef.Namespace)
 			if err != nil {
 				log.Error(err, "error obtianing configmap", string(allocResult.ConfigMapResourceIdentifier))
 				return ctrl.Result{RequeueAfter: controller.Requeue2sDelay}, err
@@ -244,70 +273,54 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 				log.Info("No matching PodAllocationRequest for this result; skipping", podRef)
 				continue
 			}
+			// slices of an allocation whose ConfigMaps exist were created before
+			sliceMessage := "the MIG slices of the allocation exist"
 			if !exists {
 				if r.Config.EmulatorModeEnable {
-					// configmap with fake MIG uuid
-					err := r.createConfigMap(ctx,
-						string(allocResult.ConfigMapResourceIdentifier),
-						podRef.Namespace,
-						string(allocResult.ConfigMapResourceIdentifier))
+					// configmaps with fake MIG uuids
+					migUUIDs := emulatedMigUUIDs(&allocResult)
+					err := r.createContainerConfigMaps(ctx, &allocResult, migUUIDs, podRef.Namespace)
 					if err != nil {
 						log.Error(err, "failed to create config map (emulator mode)")
+						r.recordAllocationFailure(ctx, &instaslice, podUID, "ConfigMapCreationFailed", err)
 						return ctrl.Result{RequeueAfter: controller.Requeue1sDelay}, err
 					}
 					// Emulating cost to create CI and GI on a GPU
 					time.Sleep(controller.Requeue1sDelay)
+					sliceMessage = "emulated MIG slices created: " + strings.Join(migUUIDs, ",")
 				} else {
-					device, retCode := nvml.DeviceGetHandleByUUID(allocResult.GPUUUID)
-					if retCode != nvml.SUCCESS {
-						log.Error(retCode, "error getting GPU device handle", "gpuUUID", allocResult.GPUUUID)
-						return ctrl.Result{}, goerror.New("error fetching GPU device handle")
-					}
-
-					selectedMig, ok := instaslice.Status.NodeResources.MigPlacement[allocationRequest.Profile]
-					if !ok {
+					if _, ok := instaslice.Status.NodeResources.MigPlacement[allocationRequest.Profile]; !ok {
 						log.Info("No suitable MIG profile in NodeResources; skipping creation", podRef, allocResult)
+						r.recordAllocationFailure(ctx, &instaslice, podUID, "ProfileNotDiscovered",
+							fmt.Errorf("profile %s was not discovered on node %s", allocationRequest.Profile, r.NodeName))
 						continue
 					}
 
-					placement := nvml.GpuInstancePlacement{
-						Start: uint32(allocResult.MigPlacement.Start),
-						Size:  uint32(allocResult.MigPlacement.Size),
-					}
-
-					giProfileInfo, retGI := device.GetGpuInstanceProfileInfo(int(selectedMig.GIProfileID))
-					if retGI != nvml.SUCCESS {
-						log.Error(retGI, "error getting GPU instance profile info", "GIProfileID", selectedMig.GIProfileID)
-						return ctrl.Result{}, goerror.New("cannot get GI profile info")
-					}
-
-					ciProfileID := selectedMig.CIProfileID
-
-					createdMigInfos, err := r.createSliceAndPopulateMigInfos(
-						ctx, device, giProfileInfo, placement, ciProfileID, podRef.Name)
+					migUUIDs, err := r.createSlices(ctx, instaslice, allocationRequest.Profile, &allocResult, podRef.Name)
 					if err != nil {
 						log.Error(err, "MIG creation not successful", "podRef", podRef)
+						r.recordAllocationFailure(ctx, &instaslice, podUID, "SliceCreationFailed", err)
 						return ctrl.Result{RequeueAfter: controller.Requeue2sDelay}, err
 					}
-
-					for migUuid, migDevice := range createdMigInfos {
-						if migDevice.start == allocResult.MigPlacement.Start && migDevice.uuid == allocResult.GPUUUID && giProfileInfo.Id == migDevice.giInfo.ProfileId {
-							if err := r.createConfigMap(ctx, migUuid, podRef.Namespace, string(allocResult.ConfigMapResourceIdentifier)); err != nil {
-								return ctrl.Result{RequeueAfter: controller.Requeue1sDelay}, err
-							}
-							log.Info("done creating mig slice for ", "pod", podRef.Name, "parentgpu", allocResult.GPUUUID, "miguuid", migUuid)
-							break
-						}
+					if err := r.createContainerConfigMaps(ctx, &allocResult, migUUIDs, podRef.Namespace); err != nil {
+						r.recordAllocationFailure(ctx, &instaslice, podUID, "ConfigMapCreationFailed", err)
+						return ctrl.Result{RequeueAfter: controller.Requeue1sDelay}, err
 					}
+					sliceMessage = "MIG slices created: " + strings.Join(migUUIDs, ",")
 				}
 			}
 
 			newAllocationRequest := instaslice.Spec.PodAllocationRequests[podUID]
-			newAllocationResult := instaslice.Status.PodAllocationResults[podUID]
+			newAllocationResult := *allocResult.DeepCopy()
 			newAllocationResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
-			if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &newAllocationResult, &newAllocationRequest); err != nil {
+			newAllocationResult.SetCondition(inferencev1alpha1.AllocationConditionSliceCreated, metav1.ConditionTrue, "SlicesCreated", sliceMessage)
+			newAllocationResult.SetCondition(inferencev1alpha1.AllocationConditionConfigMapReady, metav1.ConditionTrue, "ConfigMapsCreated",
+				fmt.Sprintf("the ConfigMaps of the GPU containers exist in namespace %s", podRef.Namespace))
+			clearAllocationFailure(&newAllocationResult)
+			if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.DaemonsetFieldManager, instaslice.Name, &newAllocationResult, &newAllocationRequest); err != nil {
 				return ctrl.Result{Requeue: true}, err
 			}
+			controller.RecordAllocationEvent(r.Recorder, instaslice.Name, &newAllocationRequest, v1.EventTypeNormal, controller.EventReasonSlicesCreated, sliceMessage)
 
 			return ctrl.Result{}, nil
 		}
@@ -316,6 +329,28 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 	return ctrl.Result{}, nil
 }
 
+// recordAllocationFailure sets the Failed condition of an allocation whose step failed, the step
+// is retried by the caller
+func (r *InstaSliceDaemonsetReconciler) recordAllocationFailure(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, podUID types.UID, reason string, failure error) {
+	result := instaslice.Status.PodAllocationResults[podUID]
+	allocResult := *result.DeepCopy()
+	if !allocResult.SetCondition(inferencev1alpha1.AllocationConditionFailed, metav1.ConditionTrue, reason, failure.Error()) {
+		return
+	}
+	allocRequest := instaslice.Spec.PodAllocationRequests[podUID]
+	controller.RecordAllocationEvent(r.Recorder, instaslice.Name, &allocRequest, v1.EventTypeWarning, reason, failure.Error())
+	if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.DaemonsetFieldManager, instaslice.Name, &allocResult, &allocRequest); err != nil {
+		logr.FromContext(ctx).Error(err, "failed to record the failure of the allocation", "pod", podUID)
+	}
+}
+
+// clearAllocationFailure marks the failure of an earlier attempt as over once the step succeeded
+func clearAllocationFailure(allocResult *inferencev1alpha1.AllocationResult) {
+	if meta.IsStatusConditionTrue(allocResult.Conditions, inferencev1alpha1.AllocationConditionFailed) {
+		allocResult.SetCondition(inferencev1alpha1.AllocationConditionFailed, metav1.ConditionFalse, "Recovered", "a later attempt succeeded")
+	}
+}
+
 func (r *InstaSliceDaemonsetReconciler) createCiAndGiProfiles(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, podUID types.UID) error {
 	log := logr.FromContext(ctx)
 	podRef := instaslice.Spec.PodAllocationRequests[podUID].PodRef
@@ -328,97 +363,274 @@ func (r *InstaSliceDaemonsetReconciler) createCiAndGiProfiles(ctx context.Contex
 		log.Info("No matching PodAllocationRequest for this result; skipping")
 		return nil
 	}
-	device, retCode := nvml.DeviceGetHandleByUUID(allocResult.GPUUUID)
-	if retCode != nvml.SUCCESS {
-		log.Error(retCode, "error getting GPU device handle", "gpuUUID", allocResult.GPUUUID)
-		return goerror.New("error fetching GPU device handle, GPUUUID: " + allocResult.GPUUUID)
-	}
-	selectedMig, ok := instaslice.Status.NodeResources.MigPlacement[allocationRequest.Profile]
-	if !ok {
+	if _, ok := instaslice.Status.NodeResources.MigPlacement[allocationRequest.Profile]; !ok {
 		log.Info("No suitable MIG profile in NodeResources; skipping creation")
 		return goerror.New("Requested MIG profile not found on the node, node:  " + instaslice.Name + " profile: " + allocationRequest.Profile)
 	}
 
-	placement := nvml.GpuInstancePlacement{
-		Start: uint32(allocResult.MigPlacement.Start),
-		Size:  uint32(allocResult.MigPlacement.Size),
+	migUUIDs, err := r.createSlices(ctx, *instaslice, allocationRequest.Profile, &allocResult, podRef.Name)
+	if err != nil {
+		log.Error(err, "MIG creation not successful")
+		return err
 	}
 
-	giProfileInfo, retGI := device.GetGpuInstanceProfileInfo(int(selectedMig.GIProfileID))
-	if retGI != nvml.SUCCESS {
-		log.Error(retGI, "error getting GPU instance profile info", "GIProfileID", selectedMig.GIProfileID)
-		return goerror.New("cannot get GI profile info, GIProfileID: " + strconv.Itoa(int(selectedMig.GIProfileID)))
+	exists, _ := r.containerConfigMapsExist(ctx, &allocResult, podRef.Namespace)
+	if exists {
+		log.Info("Skipping updating pod", "podRef", podRef)
+		return nil
 	}
+	return r.createContainerConfigMaps(ctx, &allocResult, migUUIDs, podRef.Namespace)
+}
 
-	ciProfileID := selectedMig.CIProfileID
+// createContainerConfigMaps creates the ConfigMap of every GPU container of an allocation listing
+// the MIG devices of its slices, migUUIDs are in slice order.
+func (r *InstaSliceDaemonsetReconciler) createContainerConfigMaps(ctx context.Context, allocResult *inferencev1alpha1.AllocationResult, migUUIDs []string, namespace string) error {
+	for _, container := range allocResult.AllContainers() {
+		if container.ConfigMapResourceIdentifier == "" {
+			continue
+		}
+		containerMigUUIDs := make([]string, 0, len(container.Slices))
+		for _, index := range container.Slices {
+			if int(index) >= len(migUUIDs) {
+				return fmt.Errorf("slice %d of container %s not found in allocation", index, container.Name)
+			}
+			containerMigUUIDs = append(containerMigUUIDs, migUUIDs[index])
+		}
+		if err := r.createConfigMap(ctx, strings.Join(containerMigUUIDs, ","), namespace, string(container.ConfigMapResourceIdentifier)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	createdMigInfos, err := r.createSliceAndPopulateMigInfos(
-		ctx, device, giProfileInfo, placement, ciProfileID, podRef.Name)
-	if err != nil {
-		log.Error(err, "MIG creation not successful")
-		return err
+// containerConfigMapsExist reports whether the ConfigMaps of all GPU containers of an allocation exist
+func (r *InstaSliceDaemonsetReconciler) containerConfigMapsExist(ctx context.Context, allocResult *inferencev1alpha1.AllocationResult, namespace string) (bool, error) {
+	for _, container := range allocResult.AllContainers() {
+		if container.ConfigMapResourceIdentifier == "" {
+			continue
+		}
+		exists, err := r.checkConfigMapExists(ctx, string(container.ConfigMapResourceIdentifier), namespace)
+		if err != nil || !exists {
+			return false, err
+		}
 	}
+	return true, nil
+}
 
-	for migUuid, migDevice := range createdMigInfos {
-		if migDevice.start == allocResult.MigPlacement.Start && migDevice.uuid == allocResult.GPUUUID && giProfileInfo.Id == migDevice.giInfo.ProfileId {
-			exists, _ := r.checkConfigMapExists(ctx, string(allocResult.ConfigMapResourceIdentifier), podRef.Namespace)
-			if exists {
-				log.Info("Skipping updating pod", "podRef", podRef)
-				continue
-			} else if err := r.createConfigMap(ctx, migUuid, podRef.Namespace, string(allocResult.ConfigMapResourceIdentifier)); err != nil {
-				return err
+// createSlices creates a GPU and compute instance for every slice of an allocation and returns
+// the MIG UUIDs in slice order. Slices without a profile of their own use defaultProfile,
+// slices left behind by an earlier attempt are reused. Slices sharing a GPU instance only
+// create their compute instance once the GPU instance exists.
+func (r *InstaSliceDaemonsetReconciler) createSlices(ctx context.Context, instaslice inferencev1alpha1.Instaslice, defaultProfile string, allocResult *inferencev1alpha1.AllocationResult, podName string) ([]string, error) {
+	log := logr.FromContext(ctx)
+	var migUUIDs []string
+	for _, slice := range allocResult.AllSlices() {
+		profileName := slice.Profile
+		if profileName == "" {
+			profileName = defaultProfile
+		}
+		selectedMig, ok := instaslice.Status.NodeResources.MigPlacement[profileName]
+		if !ok {
+			return nil, goerror.New("Requested MIG profile not found on the node, node:  " + instaslice.Name + " profile: " + profileName)
+		}
+		device, retCode := nvml.DeviceGetHandleByUUID(slice.GPUUUID)
+		if retCode != nvml.SUCCESS {
+			log.Error(retCode, "error getting GPU device handle", "gpuUUID", slice.GPUUUID)
+			return nil, goerror.New("error fetching GPU device handle, GPUUUID: " + slice.GPUUUID)
+		}
+
+		giProfileInfo, retGI := device.GetGpuInstanceProfileInfo(int(selectedMig.GIProfileID))
+		if retGI != nvml.SUCCESS {
+			log.Error(retGI, "error getting GPU instance profile info", "GIProfileID", selectedMig.GIProfileID)
+			return nil, goerror.New("cannot get GI profile info, GIProfileID: " + strconv.Itoa(int(selectedMig.GIProfileID)))
+		}
+
+		existingMigInfos, err := populateMigDeviceInfos(device)
+		if err != nil {
+			return nil, fmt.Errorf("unable to walk MIGs: %v", err)
+		}
+		migUUID, ok := findMigDevice(existingMigInfos, slice, giProfileInfo.Id)
+		if !ok && slice.ComputePlacement != nil {
+			if migUUID, err = r.createComputeSlice(ctx, device, giProfileInfo, selectedMig, slice, podName); err != nil {
+				return nil, err
+			}
+		} else if !ok {
+			placement := nvml.GpuInstancePlacement{
+				Start: uint32(slice.MigPlacement.Start),
+				Size:  uint32(slice.MigPlacement.Size),
+			}
+			createdMigInfos, err := r.createSliceAndPopulateMigInfos(
+				ctx, device, giProfileInfo, placement, selectedMig.CIProfileID, podName)
+			if err != nil {
+				return nil, err
+			}
+			if migUUID, ok = findMigDevice(createdMigInfos, slice, giProfileInfo.Id); !ok {
+				return nil, fmt.Errorf("created MIG slice not found, gpuUUID: %s, start: %d", slice.GPUUUID, slice.MigPlacement.Start)
 			}
-			log.Info("done creating mig slice for ", "pod", podRef.Name, "parentgpu", allocResult.GPUUUID, "miguuid", migUuid)
-			break
 		}
+		log.Info("done creating mig slice for ", "pod", podName, "parentgpu", slice.GPUUUID, "miguuid", migUUID)
+		migUUIDs = append(migUUIDs, migUUID)
 	}
-	return nil
+	return migUUIDs, nil
+}
+
+// findMigDevice returns the UUID of the MIG device of a given GI profile placed at the slice
+func findMigDevice(migInfos map[string]*MigDeviceInfo, slice inferencev1alpha1.SliceResult, giProfileID uint32) (string, bool) {
+	for migUuid, migDevice := range migInfos {
+		if migDevice.start == slice.MigPlacement.Start && migDevice.uuid == slice.GPUUUID && migDevice.giInfo.ProfileId == giProfileID &&
+			hasComputePlacement(migDevice, slice) {
+			return migUuid, true
+		}
+	}
+	return "", false
+}
+
+// hasComputePlacement reports whether the compute instance of a MIG device is the one of the
+// slice, which for slices sharing their GPU instance depends on its placement
+func hasComputePlacement(migDevice *MigDeviceInfo, slice inferencev1alpha1.SliceResult) bool {
+	return slice.ComputePlacement == nil || (migDevice.ciInfo != nil && migDevice.ciInfo.Placement.Start == uint32(slice.ComputePlacement.Start))
+}
+
+// emulatedMigUUIDs returns fake MIG UUIDs for the slices of an allocation in emulator mode
+func emulatedMigUUIDs(allocResult *inferencev1alpha1.AllocationResult) []string {
+	slices := allocResult.AllSlices()
+	if len(slices) == 1 {
+		return []string{string(allocResult.ConfigMapResourceIdentifier)}
+	}
+	migUUIDs := make([]string, 0, len(slices))
+	for i := range slices {
+		migUUIDs = append(migUUIDs, fmt.Sprintf("%s-%d", allocResult.ConfigMapResourceIdentifier, i))
+	}
+	return migUUIDs
 }
 
-// cleanUpCiAndGi tears down the MIG compute instance and GPU instance.
+// cleanUpCiAndGi tears down the MIG compute instance and GPU instance of every slice.
 func (r *InstaSliceDaemonsetReconciler) cleanUpCiAndGi(ctx context.Context, allocationResult *inferencev1alpha1.AllocationResult, podRef v1.ObjectReference) error {
 	log := logr.FromContext(ctx)
 
-	parent, ret := nvml.DeviceGetHandleByUUID(allocationResult.GPUUUID)
-	if ret != nvml.SUCCESS {
-		log.Error(ret, "error obtaining GPU handle for cleanup")
-		return fmt.Errorf("unable to get device handle: %v", ret)
+	for _, slice := range allocationResult.AllSlices() {
+		parent, ret := nvml.DeviceGetHandleByUUID(slice.GPUUUID)
+		if ret != nvml.SUCCESS {
+			log.Error(ret, "error obtaining GPU handle for cleanup")
+			return fmt.Errorf("unable to get device handle: %v", ret)
+		}
+
+		migInfos, err := populateMigDeviceInfos(parent)
+		if err != nil {
+			return fmt.Errorf("unable to walk MIGs: %v", err)
+		}
+
+		for miguuid, migdevice := range migInfos {
+			if migdevice.uuid == slice.GPUUUID && migdevice.start == slice.MigPlacement.Start &&
+				migdevice.size == slice.MigPlacement.Size && hasComputePlacement(migdevice, slice) {
+				gi, ret := parent.GetGpuInstanceById(int(migdevice.giInfo.Id))
+				if ret != nvml.SUCCESS {
+					log.Error(ret, "error obtaining gpu instance")
+					return fmt.Errorf("unable to find GI: %v", ret)
+				}
+				ci, ret := gi.GetComputeInstanceById(int(migdevice.ciInfo.Id))
+				if ret != nvml.SUCCESS {
+					log.Error(ret, "error obtaining compute instance")
+					return fmt.Errorf("unable to find CI: %v", ret)
+				}
+				// Destroy CI
+				ret = ci.Destroy()
+				if ret != nvml.SUCCESS {
+					return fmt.Errorf("unable to destroy CI: %v", ret)
+				}
+				// Destroy GI, unless other slices still use compute instances of it
+				if sharedComputeInstances(migInfos, miguuid) == 0 {
+					ret = gi.Destroy()
+					if ret != nvml.SUCCESS {
+						return fmt.Errorf("unable to destroy GI: %v", ret)
+					}
+				}
+
+				log.Info("Successfully destroyed MIG resources", "slice", slice, "podRef", podRef, "MIGuuid", miguuid)
+				break
+			}
+		}
 	}
+	return nil
+}
+
+// sharedComputeInstances returns the number of other MIG devices with a compute instance in the
+// GPU instance of the MIG device migUUID
+func sharedComputeInstances(migInfos map[string]*MigDeviceInfo, migUUID string) int {
+	migDevice, ok := migInfos[migUUID]
+	if !ok {
+		return 0
+	}
+	count := 0
+	for otherUUID, other := range migInfos {
+		if otherUUID != migUUID && other.uuid == migDevice.uuid && other.giInfo.Id == migDevice.giInfo.Id {
+			count++
+		}
+	}
+	return count
+}
 
-	migInfos, err := populateMigDeviceInfos(parent)
+// createComputeSlice creates the compute instance of a slice sharing its GPU instance and returns
+// the UUID of its MIG device. The GPU instance is created by the first of its slices.
+func (r *InstaSliceDaemonsetReconciler) createComputeSlice(ctx context.Context, device nvml.Device, giProfileInfo nvml.GpuInstanceProfileInfo, selectedMig inferencev1alpha1.Mig, slice inferencev1alpha1.SliceResult, podName string) (string, error) {
+	log := logr.FromContext(ctx)
+	gi, ok, err := gpuInstanceAt(device, giProfileInfo, slice.MigPlacement.Start)
 	if err != nil {
-		return fmt.Errorf("unable to walk MIGs: %v", err)
+		return "", err
+	}
+	if !ok {
+		log.Info("creating shared gpu instance for", "pod", podName, "parentgpu", slice.GPUUUID, "start", slice.MigPlacement.Start)
+		placement := nvml.GpuInstancePlacement{
+			Start: uint32(slice.MigPlacement.Start),
+			Size:  uint32(slice.MigPlacement.Size),
+		}
+		var ret nvml.Return
+		if gi, ret = device.CreateGpuInstanceWithPlacement(&giProfileInfo, &placement); ret != nvml.SUCCESS {
+			return "", fmt.Errorf("error creating gpu instance profile with: %v", ret)
+		}
 	}
 
-	for miguuid, migdevice := range migInfos {
-		if migdevice.uuid == allocationResult.GPUUUID && migdevice.start == allocationResult.MigPlacement.Start &&
-			migdevice.size == allocationResult.MigPlacement.Size {
-			gi, ret := parent.GetGpuInstanceById(int(migdevice.giInfo.Id))
-			if ret != nvml.SUCCESS {
-				log.Error(ret, "error obtaining gpu instance")
-				return fmt.Errorf("unable to find GI: %v", ret)
-			}
-			ci, ret := gi.GetComputeInstanceById(int(migdevice.ciInfo.Id))
-			if ret != nvml.SUCCESS {
-				log.Error(ret, "error obtaining compute instance")
-				return fmt.Errorf("unable to find CI: %v", ret)
-			}
-			// Destroy CI
-			ret = ci.Destroy()
-			if ret != nvml.SUCCESS {
-				return fmt.Errorf("unable to destroy CI: %v", ret)
-			}
-			// Destroy GI
-			ret = gi.Destroy()
-			if ret != nvml.SUCCESS {
-				return fmt.Errorf("unable to destroy GI: %v", ret)
-			}
+	ciProfileInfo, ret := gi.GetComputeInstanceProfileInfo(int(selectedMig.CIProfileID), int(selectedMig.CIEngProfileID))
+	if ret != nvml.SUCCESS {
+		log.Error(ret, "error getting compute instance profile info", "pod", podName)
+		return "", fmt.Errorf("error getting compute instance profile info: %v", ret)
+	}
+	ciPlacement := nvml.ComputeInstancePlacement{
+		Start: uint32(slice.ComputePlacement.Start),
+		Size:  uint32(slice.ComputePlacement.Size),
+	}
+	if _, ret := gi.CreateComputeInstanceWithPlacement(&ciProfileInfo, &ciPlacement); ret != nvml.SUCCESS {
+		return "", fmt.Errorf("error creating compute instance at %d: %v", ciPlacement.Start, ret)
+	}
 
-			log.Info("Successfully destroyed MIG resources", "allocationResult", allocationResult, "podRef", podRef, "MIGuuid", miguuid)
-			return nil
+	migInfos, err := populateMigDeviceInfos(device)
+	if err != nil {
+		return "", fmt.Errorf("failed to populate MIG device infos: %v", err)
+	}
+	migUUID, ok := findMigDevice(migInfos, slice, giProfileInfo.Id)
+	if !ok {
+		return "", fmt.Errorf("created compute instance not found, gpuUUID: %s, start: %d, compute start: %d", slice.GPUUUID, slice.MigPlacement.Start, slice.ComputePlacement.Start)
+	}
+	return migUUID, nil
+}
+
+// gpuInstanceAt returns the GPU instance of a profile placed at start, which may have no compute
+// instance yet and therefore no MIG device
+func gpuInstanceAt(device nvml.Device, giProfileInfo nvml.GpuInstanceProfileInfo, start int32) (nvml.GpuInstance, bool, error) {
+	gpuInstances, ret := device.GetGpuInstances(&giProfileInfo)
+	if ret != nvml.SUCCESS {
+		return nil, false, fmt.Errorf("gpu instances cannot be listed: %v", ret)
+	}
+	for _, gpuInstance := range gpuInstances {
+		info, ret := gpuInstance.GetInfo()
+		if ret != nvml.SUCCESS {
+			return nil, false, fmt.Errorf("unable to obtain gpu instance info: %v", ret)
+		}
+		if info.Placement.Start == uint32(start) {
+			return gpuInstance, true, nil
 		}
 	}
-	return nil
+	return nil, false, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -486,11 +698,17 @@ func (r *InstaSliceDaemonsetReconciler) SetupWithManager(mgr ctrl.Manager) error
 			if err != nil {
 				log.Error(err, "Failed to fetch fake capacity after retries", "node_name", r.NodeName)
 			}
+			// only the node resources are written, the allocations of a restarted daemonset are kept
 			fakeCapacity = utils.GenerateFakeCapacity(r.NodeName)
-			instaslice.Name = fakeCapacity.Name
-			instaslice.Namespace = fakeCapacity.Namespace
-			instaslice.Status = fakeCapacity.Status
-			err = r.Status().Update(ctx, &instaslice)
+			err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				if err := r.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+					return err
+				}
+				original := instaslice.DeepCopy()
+				instaslice.Status.NodeResources = fakeCapacity.Status.NodeResources
+				return r.Status().Patch(ctx, &instaslice, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}),
+					client.FieldOwner(string(utils.DaemonsetFieldManager)))
+			})
 			if err != nil {
 				log.Error(err, "could not update fake capacity", "node_name", r.NodeName)
 				return err
@@ -548,6 +766,13 @@ func (r *InstaSliceDaemonsetReconciler) SetupWithManager(mgr ctrl.Manager) error
 		return mgrAddErr
 	}
 
+	// there are no MIG instances to collect in emulator mode
+	if r.Config.OrphanGCEnable && !r.Config.EmulatorModeEnable {
+		if err := mgr.Add(manager.RunnableFunc(r.runOrphanSliceCollector)); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -619,6 +844,10 @@ func (r *InstaSliceDaemonsetReconciler) addMigCapacityToNode(ctx context.Context
 				profilePlacements[profile]++
 			}
 		}
+		// every GPU instance holds several compute instances of a profile sharing it
+		if placement.SharesGPUInstance() {
+			profilePlacements[profile] *= int(placement.GISliceCount / placement.CISliceCount)
+		}
 	}
 	numGPUs := len(instaslice.Status.NodeResources.NodeGPUs)
 	for profile, sum := range profilePlacements {
@@ -736,8 +965,6 @@ func (r *InstaSliceDaemonsetReconciler) discoverAvailableProfilesOnGpus(instasli
 					return nil, ret, false, ret
 				}
 
-				profile := NewMigProfile(j, j, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, giProfileInfo.SliceCount, giProfileInfo.SliceCount, giProfileInfo.MemorySizeMB, memory.Total)
-
 				giPossiblePlacements, ret := device.GetGpuInstancePossiblePlacements(&giProfileInfo)
 				if ret == nvml.ERROR_NOT_SUPPORTED {
 					continue
@@ -757,16 +984,24 @@ func (r *InstaSliceDaemonsetReconciler) discoverAvailableProfilesOnGpus(instasli
 					placementsForProfile = append(placementsForProfile, placement)
 				}
 
-				aggregatedPlacementsForProfile := inferencev1alpha1.Mig{
-					Placements:     placementsForProfile,
-					GIProfileID:    int32(j),
-					CIProfileID:    int32(profile.CIProfileID),
-					CIEngProfileID: int32(profile.CIEngProfileID),
-				}
 				if instaslice.Status.NodeResources.MigPlacement == nil {
 					instaslice.Status.NodeResources.MigPlacement = make(map[string]inferencev1alpha1.Mig)
 				}
-				instaslice.Status.NodeResources.MigPlacement[profile.String()] = aggregatedPlacementsForProfile
+				ciProfiles, err := computeInstanceProfiles(device, giProfileInfo)
+				if err != nil {
+					return nil, 0, true, err
+				}
+				for _, ciProfileInfo := range ciProfiles {
+					profile := NewMigProfile(j, int(ciProfileInfo.Id), nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, giProfileInfo.SliceCount, ciProfileInfo.SliceCount, giProfileInfo.MemorySizeMB, memory.Total)
+					instaslice.Status.NodeResources.MigPlacement[profile.String()] = inferencev1alpha1.Mig{
+						Placements:     placementsForProfile,
+						GIProfileID:    int32(j),
+						CIProfileID:    int32(profile.CIProfileID),
+						CIEngProfileID: int32(profile.CIEngProfileID),
+						CISliceCount:   int32(ciProfileInfo.SliceCount),
+						GISliceCount:   int32(giProfileInfo.SliceCount),
+					}
+				}
 			}
 			discoverProfilePerNode = false
 		}
@@ -775,6 +1010,55 @@ func (r *InstaSliceDaemonsetReconciler) discoverAvailableProfilesOnGpus(instasli
 	return instaslice, ret, false, nil
 }
 
+// computeInstanceProfiles returns the compute instance profiles of a GPU instance profile, one per
+// number of compute slices. NVML only reports them for an existing GPU instance, so a GPU instance
+// of the profile is created for the query and destroyed after, unless one exists already. When
+// none can be created, only the compute instance taking the whole GPU instance is returned.
+func computeInstanceProfiles(device nvml.Device, giProfileInfo nvml.GpuInstanceProfileInfo) ([]nvml.ComputeInstanceProfileInfo, error) {
+	gpuInstances, ret := device.GetGpuInstances(&giProfileInfo)
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("gpu instances cannot be listed: %v", ret)
+	}
+	var gpuInstance nvml.GpuInstance
+	if len(gpuInstances) > 0 {
+		gpuInstance = gpuInstances[0]
+	} else {
+		gpuInstance, ret = device.CreateGpuInstance(&giProfileInfo)
+		if ret == nvml.ERROR_INSUFFICIENT_RESOURCES {
+			// the instances on the GPU leave no room, the profiles have always used the compute
+			// instance profile matching the GPU instance profile
+			return []nvml.ComputeInstanceProfileInfo{{Id: giProfileInfo.Id, SliceCount: giProfileInfo.SliceCount}}, nil
+		}
+		if ret != nvml.SUCCESS {
+			return nil, fmt.Errorf("error creating gpu instance to discover its compute instance profiles: %v", ret)
+		}
+		defer func() {
+			if ret := gpuInstance.Destroy(); ret != nvml.SUCCESS {
+				logr.FromContext(context.TODO()).Error(ret, "unable to destroy the gpu instance created to discover its compute instance profiles", "giProfileID", giProfileInfo.Id)
+			}
+		}()
+	}
+
+	var profiles []nvml.ComputeInstanceProfileInfo
+	sliceCounts := make(map[uint32]bool)
+	for ciProfileID := 0; ciProfileID < nvml.COMPUTE_INSTANCE_PROFILE_COUNT; ciProfileID++ {
+		ciProfileInfo, ret := gpuInstance.GetComputeInstanceProfileInfo(ciProfileID, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return nil, fmt.Errorf("error getting compute instance profile %d of gpu instance profile %d: %v", ciProfileID, giProfileInfo.Id, ret)
+		}
+		// revisions of a profile come after it, the first profile of a size is kept
+		if sliceCounts[ciProfileInfo.SliceCount] {
+			continue
+		}
+		sliceCounts[ciProfileInfo.SliceCount] = true
+		profiles = append(profiles, ciProfileInfo)
+	}
+	return profiles, nil
+}
+
 // NewMigProfile constructs a new MigProfile struct using info from the giProfiles and ciProfiles used to create it.
 func NewMigProfile(giProfileID, ciProfileID, ciEngProfileID int, giSliceCount, ciSliceCount uint32, migMemorySizeMB, totalDeviceMemoryBytes uint64) *MigProfile {
 	return &MigProfile{
@@ -820,7 +1104,8 @@ func (m MigProfile) Attributes() []string {
 	return attr
 }
 
-// Create configmap which is used by Pods to consume MIG device
+// Create configmap which is used by Pods to consume MIG devices, migGPUUUID is a comma
+// separated list when the pod has more than one slice
 func (r *InstaSliceDaemonsetReconciler) createConfigMap(ctx context.Context, migGPUUUID string, namespace string, resourceIdentifier string) error {
 	log := logr.FromContext(ctx)
 	var configMap v1.ConfigMap
@@ -831,6 +1116,7 @@ func (r *InstaSliceDaemonsetReconciler) createConfigMap(ctx context.Context, mig
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      resourceIdentifier,
 				Namespace: namespace,
+				Labels:    map[string]string{controller.AllocationConfigMapLabel: controller.AllocationConfigMapTrue},
 			},
 			Data: map[string]string{
 				"NVIDIA_VISIBLE_DEVICES": migGPUUUID,
@@ -21,14 +21,17 @@ import (
 	"os"
 	"testing"
 
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
 	"github.com/stretchr/testify/assert"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
@@ -191,6 +194,192 @@ func TestInstaSliceDaemonsetReconciler_Reconcile_Creating_Alloc_Status(t *testin
 	assert.Equal(t, result, ctrl.Result{})
 }
 
+func TestInstaSliceDaemonsetReconciler_Reconcile_Creating_Multiple_Slices(t *testing.T) {
+	s := scheme.Scheme
+	_ = v1.AddToScheme(s)
+	_ = inferencev1alpha1.AddToScheme(s)
+	const (
+		nodeName = "test-node"
+		podUUID  = "test-pod-uuid"
+	)
+	client := fake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).Build()
+	recorder := record.NewFakeRecorder(10)
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:   client,
+		NodeName: nodeName,
+		Config:   &config.Config{EmulatorModeEnable: true},
+		Recorder: recorder,
+	}
+	ctx := context.Background()
+
+	instaslice := newInstaslice(nodeName, podUUID, inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating})
+	instaslice.Spec.PodAllocationRequests = map[types.UID]inferencev1alpha1.AllocationRequest{
+		podUUID: {
+			Profile:  "1g.5gb",
+			Quantity: 2,
+			PodRef:   v1.ObjectReference{Name: "test-pod", Namespace: "default", UID: podUUID},
+		},
+	}
+	allocResult := instaslice.Status.PodAllocationResults[podUUID]
+	allocResult.Nodename = nodeName
+	allocResult.ConfigMapResourceIdentifier = "test-configmap"
+	allocResult.Slices = []inferencev1alpha1.SliceResult{
+		{GPUUUID: "GPU-1", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}},
+		{GPUUUID: "GPU-2", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}},
+	}
+	instaslice.Status.PodAllocationResults[podUUID] = allocResult
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+		Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{BootID: instaslice.Status.NodeResources.BootID}},
+	}
+	assert.NoError(t, client.Create(ctx, node))
+	assert.NoError(t, client.Create(ctx, instaslice))
+	status := instaslice.Status
+	assert.NoError(t, client.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}, instaslice))
+	instaslice.Status = status
+	assert.NoError(t, client.Status().Update(ctx, instaslice))
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}})
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, result)
+
+	cm := &v1.ConfigMap{}
+	assert.NoError(t, client.Get(ctx, types.NamespacedName{Name: "test-configmap", Namespace: "default"}, cm))
+	assert.Equal(t, "test-configmap-0,test-configmap-1", cm.Data["NVIDIA_VISIBLE_DEVICES"])
+	// the orphan collector of the controller selects the ConfigMaps of the daemonset by label
+	assert.Equal(t, controller.AllocationConfigMapTrue, cm.Labels[controller.AllocationConfigMapLabel])
+
+	updated := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, client.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}, updated))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, updated.Status.PodAllocationResults[podUUID].AllocationStatus.AllocationStatusDaemonset)
+	conditions := updated.Status.PodAllocationResults[podUUID].Conditions
+	assert.True(t, meta.IsStatusConditionTrue(conditions, inferencev1alpha1.AllocationConditionSliceCreated))
+	assert.True(t, meta.IsStatusConditionTrue(conditions, inferencev1alpha1.AllocationConditionConfigMapReady))
+	// the pod and the instaslice both get the event
+	if assert.Len(t, recorder.Events, 2) {
+		assert.Contains(t, <-recorder.Events, controller.EventReasonSlicesCreated)
+		assert.Contains(t, <-recorder.Events, "pod default/test-pod")
+	}
+}
+
+func TestInstaSliceDaemonsetReconciler_Reconcile_Deleting_Conditions(t *testing.T) {
+	s := scheme.Scheme
+	_ = v1.AddToScheme(s)
+	_ = inferencev1alpha1.AddToScheme(s)
+	const (
+		nodeName = "test-node"
+		podUUID  = "test-pod-uuid"
+	)
+	client := fake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:   client,
+		NodeName: nodeName,
+		Config:   &config.Config{EmulatorModeEnable: true},
+	}
+	ctx := context.Background()
+
+	instaslice := newInstaslice(nodeName, podUUID, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting,
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+	})
+	instaslice.Spec.PodAllocationRequests = map[types.UID]inferencev1alpha1.AllocationRequest{
+		podUUID: {Profile: "1g.5gb", PodRef: v1.ObjectReference{Name: "test-pod", Namespace: "default", UID: podUUID}},
+	}
+	allocResult := instaslice.Status.PodAllocationResults[podUUID]
+	allocResult.Nodename = nodeName
+	allocResult.ConfigMapResourceIdentifier = "test-configmap"
+	allocResult.SetCondition(inferencev1alpha1.AllocationConditionReleasing, metav1.ConditionTrue, "PodSucceeded", "the pod completed")
+	allocResult.SetCondition(inferencev1alpha1.AllocationConditionFailed, metav1.ConditionTrue, "ConfigMapDeletionFailed", "timeout")
+	instaslice.Status.PodAllocationResults[podUUID] = allocResult
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+		Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{BootID: instaslice.Status.NodeResources.BootID}},
+	}
+	assert.NoError(t, client.Create(ctx, node))
+	assert.NoError(t, client.Create(ctx, instaslice))
+	status := instaslice.Status
+	assert.NoError(t, client.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}, instaslice))
+	instaslice.Status = status
+	assert.NoError(t, client.Status().Update(ctx, instaslice))
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}})
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, result)
+
+	updated := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, client.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}, updated))
+	released := updated.Status.PodAllocationResults[podUUID]
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleted, released.AllocationStatus.AllocationStatusDaemonset)
+	assert.True(t, meta.IsStatusConditionTrue(released.Conditions, inferencev1alpha1.AllocationConditionReleased))
+	// Releasing is owned by the controller
+	assert.True(t, meta.IsStatusConditionTrue(released.Conditions, inferencev1alpha1.AllocationConditionReleasing))
+	assert.True(t, meta.IsStatusConditionFalse(released.Conditions, inferencev1alpha1.AllocationConditionFailed))
+}
+
+func TestInstaSliceDaemonsetReconciler_Reconcile_Creating_Container_ConfigMaps(t *testing.T) {
+	s := scheme.Scheme
+	_ = v1.AddToScheme(s)
+	_ = inferencev1alpha1.AddToScheme(s)
+	const (
+		nodeName = "test-node"
+		podUUID  = "test-pod-uuid"
+	)
+	client := fake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:   client,
+		NodeName: nodeName,
+		Config:   &config.Config{EmulatorModeEnable: true},
+	}
+	ctx := context.Background()
+
+	instaslice := newInstaslice(nodeName, podUUID, inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating})
+	instaslice.Spec.PodAllocationRequests = map[types.UID]inferencev1alpha1.AllocationRequest{
+		podUUID: {
+			Profile: "1g.5gb",
+			PodRef:  v1.ObjectReference{Name: "test-pod", Namespace: "default", UID: podUUID},
+		},
+	}
+	allocResult := instaslice.Status.PodAllocationResults[podUUID]
+	allocResult.Nodename = nodeName
+	allocResult.ConfigMapResourceIdentifier = "model-configmap"
+	allocResult.Slices = []inferencev1alpha1.SliceResult{
+		{GPUUUID: "GPU-1", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}},
+		{GPUUUID: "GPU-1", MigPlacement: inferencev1alpha1.Placement{Start: 1, Size: 1}},
+		{GPUUUID: "GPU-2", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 2}, Profile: "2g.10gb"},
+	}
+	// the init container reuses the first slice of the model container
+	allocResult.Containers = []inferencev1alpha1.ContainerResult{
+		{Name: "warmup", ConfigMapResourceIdentifier: "warmup-configmap", Slices: []int32{0}},
+		{Name: "model", ConfigMapResourceIdentifier: "model-configmap", Slices: []int32{0, 1}},
+		{Name: "embedder", ConfigMapResourceIdentifier: "embedder-configmap", Slices: []int32{2}},
+	}
+	instaslice.Status.PodAllocationResults[podUUID] = allocResult
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+		Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{BootID: instaslice.Status.NodeResources.BootID}},
+	}
+	assert.NoError(t, client.Create(ctx, node))
+	assert.NoError(t, client.Create(ctx, instaslice))
+	status := instaslice.Status
+	assert.NoError(t, client.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}, instaslice))
+	instaslice.Status = status
+	assert.NoError(t, client.Status().Update(ctx, instaslice))
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}})
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, result)
+
+	for name, want := range map[string]string{
+		"warmup-configmap":   "model-configmap-0",
+		"model-configmap":    "model-configmap-0,model-configmap-1",
+		"embedder-configmap": "model-configmap-2",
+	} {
+		cm := &v1.ConfigMap{}
+		assert.NoError(t, client.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, cm))
+		assert.Equal(t, want, cm.Data["NVIDIA_VISIBLE_DEVICES"], name)
+	}
+}
+
 func newInstaslice(name, podUUID string, status inferencev1alpha1.AllocationStatus) *inferencev1alpha1.Instaslice {
 	// Create an instaslice object
 
@@ -456,3 +645,91 @@ func TestCalculateTotalMemoryGB(t *testing.T) {
 		})
 	}
 }
+
+func TestInstaSliceDaemonsetReconciler_addMigCapacityToNode_SharedGPUInstance(t *testing.T) {
+	s := scheme.Scheme
+	_ = v1.AddToScheme(s)
+	_ = inferencev1alpha1.AddToScheme(s)
+
+	const nodeName = "test-node"
+	ctx := context.Background()
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}
+	node.Status.Capacity = v1.ResourceList{}
+	for _, profile := range []string{"4g.20gb", "1c.4g.20gb", "2c.4g.20gb"} {
+		node.Status.Capacity[v1.ResourceName(controller.OrgInstaslicePrefix+"mig-"+profile)] = resource.MustParse("0")
+	}
+	client := fake.NewClientBuilder().WithScheme(s).WithObjects(node).WithStatusSubresource(node).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: client, NodeName: nodeName}
+
+	instaslice := &inferencev1alpha1.Instaslice{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}
+	instaslice.Status.NodeResources.NodeGPUs = []inferencev1alpha1.DiscoveredGPU{{GPUUUID: "gpu-1"}, {GPUUUID: "gpu-2"}}
+	instaslice.Status.NodeResources.MigPlacement = map[string]inferencev1alpha1.Mig{
+		"4g.20gb":    {Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 4}}, GIProfileID: 3, CIProfileID: 3, CISliceCount: 4, GISliceCount: 4},
+		"1c.4g.20gb": {Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 4}}, GIProfileID: 3, CIProfileID: 0, CISliceCount: 1, GISliceCount: 4},
+		"2c.4g.20gb": {Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 4}}, GIProfileID: 3, CIProfileID: 1, CISliceCount: 2, GISliceCount: 4},
+	}
+	assert.NoError(t, reconciler.addMigCapacityToNode(ctx, instaslice))
+
+	var patched v1.Node
+	assert.NoError(t, client.Get(ctx, types.NamespacedName{Name: nodeName}, &patched))
+	capacity := func(profile string) string {
+		quantity := patched.Status.Capacity[v1.ResourceName(controller.OrgInstaslicePrefix+"mig-"+profile)]
+		return quantity.String()
+	}
+	assert.Equal(t, "2", capacity("4g.20gb"))
+	assert.Equal(t, "8", capacity("1c.4g.20gb"))
+	assert.Equal(t, "4", capacity("2c.4g.20gb"))
+}
+
+func TestFindMigDevice_SharedGPUInstance(t *testing.T) {
+	gi := &nvml.GpuInstanceInfo{Id: 1, ProfileId: 3}
+	migInfos := map[string]*MigDeviceInfo{
+		"MIG-a": {uuid: "gpu-1", giInfo: gi, ciInfo: &nvml.ComputeInstanceInfo{Placement: nvml.ComputeInstancePlacement{Start: 0, Size: 1}}, start: 0, size: 4},
+		"MIG-b": {uuid: "gpu-1", giInfo: gi, ciInfo: &nvml.ComputeInstanceInfo{Placement: nvml.ComputeInstancePlacement{Start: 1, Size: 1}}, start: 0, size: 4},
+		"MIG-c": {uuid: "gpu-1", giInfo: &nvml.GpuInstanceInfo{Id: 2, ProfileId: 0}, ciInfo: &nvml.ComputeInstanceInfo{}, start: 4, size: 1},
+	}
+	slice := func(computeStart int32) inferencev1alpha1.SliceResult {
+		return inferencev1alpha1.SliceResult{
+			GPUUUID:          "gpu-1",
+			MigPlacement:     inferencev1alpha1.Placement{Start: 0, Size: 4},
+			ComputePlacement: &inferencev1alpha1.Placement{Start: computeStart, Size: 1},
+		}
+	}
+
+	migUUID, ok := findMigDevice(migInfos, slice(1), 3)
+	assert.True(t, ok)
+	assert.Equal(t, "MIG-b", migUUID)
+	_, ok = findMigDevice(migInfos, slice(2), 3)
+	assert.False(t, ok)
+	migUUID, ok = findMigDevice(migInfos, inferencev1alpha1.SliceResult{GPUUUID: "gpu-1", MigPlacement: inferencev1alpha1.Placement{Start: 4, Size: 1}}, 0)
+	assert.True(t, ok)
+	assert.Equal(t, "MIG-c", migUUID)
+
+	// the GPU instance is kept until its last compute instance is destroyed
+	assert.Equal(t, 1, sharedComputeInstances(migInfos, "MIG-a"))
+	delete(migInfos, "MIG-b")
+	assert.Equal(t, 0, sharedComputeInstances(migInfos, "MIG-a"))
+	assert.Equal(t, 0, sharedComputeInstances(migInfos, "MIG-c"))
+}
+
+func TestOrphanedMigDevices(t *testing.T) {
+	gi := &nvml.GpuInstanceInfo{Id: 1, ProfileId: 3}
+	migInfos := map[string]*MigDeviceInfo{
+		"MIG-a": {uuid: "gpu-1", giInfo: gi, ciInfo: &nvml.ComputeInstanceInfo{Placement: nvml.ComputeInstancePlacement{Start: 0, Size: 1}}, start: 0, size: 4},
+		"MIG-b": {uuid: "gpu-1", giInfo: gi, ciInfo: &nvml.ComputeInstanceInfo{Placement: nvml.ComputeInstancePlacement{Start: 1, Size: 1}}, start: 0, size: 4},
+		"MIG-c": {uuid: "gpu-1", giInfo: &nvml.GpuInstanceInfo{Id: 2}, ciInfo: &nvml.ComputeInstanceInfo{}, start: 4, size: 1},
+		"MIG-d": {uuid: "gpu-2", giInfo: &nvml.GpuInstanceInfo{Id: 1}, ciInfo: &nvml.ComputeInstanceInfo{}, start: 0, size: 1},
+	}
+	held := []inferencev1alpha1.SliceResult{
+		{GPUUUID: "gpu-1", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 4}, ComputePlacement: &inferencev1alpha1.Placement{Start: 1, Size: 1}},
+		{GPUUUID: "gpu-1", MigPlacement: inferencev1alpha1.Placement{Start: 4, Size: 1}},
+		// the same placement on another GPU does not hold the MIG device
+		{GPUUUID: "gpu-3", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}},
+	}
+
+	assert.Equal(t, []string{"MIG-a", "MIG-d"}, orphanedMigDevices(migInfos, held))
+	assert.Empty(t, orphanedMigDevices(migInfos, append(held,
+		inferencev1alpha1.SliceResult{GPUUUID: "gpu-1", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 4}, ComputePlacement: &inferencev1alpha1.Placement{Start: 0, Size: 1}},
+		inferencev1alpha1.SliceResult{GPUUUID: "gpu-2", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}})))
+	assert.Len(t, orphanedMigDevices(migInfos, nil), 4)
+}
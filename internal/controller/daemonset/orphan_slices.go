@@ -0,0 +1,167 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemonset
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller"
+)
+
+// runOrphanSliceCollector destroys the MIG instances of the node that no allocation holds every
+// OrphanGCInterval until ctx is done. They are left by allocations removed from the Instaslice
+// before the daemonset released their slices.
+func (r *InstaSliceDaemonsetReconciler) runOrphanSliceCollector(ctx context.Context) error {
+	log := logr.FromContext(ctx)
+	ticker := time.NewTicker(r.Config.OrphanGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.collectOrphanSlices(ctx, time.Now()); err != nil {
+				log.Error(err, "orphaned MIG instance collection failed")
+			}
+		}
+	}
+}
+
+// collectOrphanSlices destroys the MIG instances no allocation of the node held for the grace
+// period. It runs between two reconciles, which create and delete the slices of the allocations.
+func (r *InstaSliceDaemonsetReconciler) collectOrphanSlices(ctx context.Context, now time.Time) error {
+	log := logr.FromContext(ctx)
+	r.migMu.Lock()
+	defer r.migMu.Unlock()
+	var instaslice inferencev1alpha1.Instaslice
+	if err := r.Get(ctx, types.NamespacedName{Name: r.NodeName, Namespace: controller.InstaSliceOperatorNamespace}, &instaslice); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	var held []inferencev1alpha1.SliceResult
+	for _, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.Nodename == types.NodeName(r.NodeName) {
+			held = append(held, allocResult.AllSlices()...)
+		}
+	}
+
+	if r.orphanSlices == nil {
+		r.orphanSlices = make(map[string]time.Time)
+	}
+	seen := make(map[string]bool)
+	detected := 0
+	for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+		device, ret := nvml.DeviceGetHandleByUUID(gpu.GPUUUID)
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("unable to get device handle of GPU %s: %v", gpu.GPUUUID, ret)
+		}
+		migInfos, err := populateMigDeviceInfos(device)
+		if err != nil {
+			return fmt.Errorf("unable to walk MIGs of GPU %s: %v", gpu.GPUUUID, err)
+		}
+		destroyed := make(map[string]bool)
+		for _, migUUID := range orphanedMigDevices(migInfos, held) {
+			seen[migUUID] = true
+			since, ok := r.orphanSlices[migUUID]
+			if !ok {
+				since = now
+				r.orphanSlices[migUUID] = now
+			}
+			if now.Sub(since) < r.Config.OrphanGCGracePeriod {
+				continue
+			}
+			detected++
+			migDevice := migInfos[migUUID]
+			if r.Config.OrphanGCDryRun {
+				log.Info("orphaned MIG instance would be destroyed", "gpu", gpu.GPUUUID, "MIGuuid", migUUID, "start", migDevice.start, "size", migDevice.size)
+				continue
+			}
+			log.Info("destroying orphaned MIG instance", "gpu", gpu.GPUUUID, "MIGuuid", migUUID, "start", migDevice.start, "size", migDevice.size)
+			if err := destroyMigDevice(device, migInfos, migUUID, destroyed); err != nil {
+				return err
+			}
+			controller.IncrementOrphansReclaimedMetrics(controller.OrphanKindMigInstance, r.NodeName)
+			delete(r.orphanSlices, migUUID)
+		}
+	}
+	for migUUID := range r.orphanSlices {
+		if !seen[migUUID] {
+			delete(r.orphanSlices, migUUID)
+		}
+	}
+	controller.SetOrphansDetectedMetrics(controller.OrphanKindMigInstance, r.NodeName, detected)
+	return nil
+}
+
+// orphanedMigDevices returns the UUIDs of the MIG devices that are not the slice of an allocation,
+// in the order of their UUIDs
+func orphanedMigDevices(migInfos map[string]*MigDeviceInfo, held []inferencev1alpha1.SliceResult) []string {
+	var orphaned []string
+	for migUUID, migDevice := range migInfos {
+		found := false
+		for _, slice := range held {
+			if migDevice.uuid == slice.GPUUUID && migDevice.start == slice.MigPlacement.Start &&
+				migDevice.size == slice.MigPlacement.Size && hasComputePlacement(migDevice, slice) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			orphaned = append(orphaned, migUUID)
+		}
+	}
+	sort.Strings(orphaned)
+	return orphaned
+}
+
+// destroyMigDevice destroys the compute instance of a MIG device, and its GPU instance with its
+// last compute instance. destroyed records the MIG devices destroyed on the GPU so far.
+func destroyMigDevice(device nvml.Device, migInfos map[string]*MigDeviceInfo, migUUID string, destroyed map[string]bool) error {
+	migDevice := migInfos[migUUID]
+	gi, ret := device.GetGpuInstanceById(int(migDevice.giInfo.Id))
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to find GI of %s: %v", migUUID, ret)
+	}
+	ci, ret := gi.GetComputeInstanceById(int(migDevice.ciInfo.Id))
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to find CI of %s: %v", migUUID, ret)
+	}
+	if ret := ci.Destroy(); ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to destroy CI of %s: %v", migUUID, ret)
+	}
+	destroyed[migUUID] = true
+	for otherUUID, other := range migInfos {
+		if !destroyed[otherUUID] && other.uuid == migDevice.uuid && other.giInfo.Id == migDevice.giInfo.Id {
+			return nil
+		}
+	}
+	if ret := gi.Destroy(); ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to destroy GI of %s: %v", migUUID, ret)
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// The defragmenter periodically looks for GPUs whose free slots are scattered so that a profile
+// fitting in their free memory has no free placement. It frees such a placement by evicting the
+// pods in its way, as long as they belong to a Deployment or to a StatefulSet annotated with
+// DefragAllowedAnnotation and fit elsewhere on the node. The freed placement is held for the pods
+// requesting its profile until one of them is placed in it, the replacement pods are allocated
+// again like any other pod. At most one node is repacked at a time and every pass evicts at most
+// config.Config.DefragMaxEvictions pods.
+
+// DefragMove is a pod evicted by the defragmenter with the slices it holds and the slices its
+// replacement is expected to get
+type DefragMove struct {
+	Pod  string                          `json:"pod"`
+	From []inferencev1alpha1.SliceResult `json:"from"`
+	To   []inferencev1alpha1.SliceResult `json:"to"`
+}
+
+// DefragPlan frees the placement of a profile on a GPU by moving the pods in its way
+type DefragPlan struct {
+	Node      string                      `json:"node"`
+	GPUUUID   string                      `json:"gpuUuid"`
+	Profile   string                      `json:"profile"`
+	Placement inferencev1alpha1.Placement `json:"placement"`
+	Moves     []DefragMove                `json:"moves"`
+	Executed  bool                        `json:"executed"`
+	// Skipped is the reason a plan was not carried out
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// DefragReport lists the plans of a defragmentation pass, it is stored in the
+// DefragReportConfigMapName ConfigMap
+type DefragReport struct {
+	Time   metav1.Time  `json:"time"`
+	DryRun bool         `json:"dryRun"`
+	Plans  []DefragPlan `json:"plans"`
+}
+
+// defragVictim is a pod whose slices the defragmenter may move
+type defragVictim struct {
+	pod          *v1.Pod
+	allocRequest inferencev1alpha1.AllocationRequest
+	allocResult  inferencev1alpha1.AllocationResult
+}
+
+// defragHoldKey is the key of the nomination holding the placement freed on a node
+func defragHoldKey(nodeName string) types.UID {
+	return types.UID("defrag/" + nodeName)
+}
+
+// runDefragmenter runs a defragmentation pass every DefragInterval until ctx is done
+func (r *InstasliceReconciler) runDefragmenter(ctx context.Context) error {
+	log := logr.FromContext(ctx)
+	ticker := time.NewTicker(r.Config.DefragInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if !r.isRecovered() {
+				continue
+			}
+			if _, err := r.defragment(ctx); err != nil {
+				log.Error(err, "defragmentation pass failed")
+			}
+		}
+	}
+}
+
+// defragment plans a repacking for every node and carries out the plans within the eviction
+// limit, unless running dry. The report of the pass is logged and stored in a ConfigMap. The API
+// is read and the pods evicted without r.mu, which is only taken to plan and hold the placements.
+func (r *InstasliceReconciler) defragment(ctx context.Context) (*DefragReport, error) {
+	log := logr.FromContext(ctx)
+	if err := r.ensureAllocationCache(ctx); err != nil {
+		return nil, err
+	}
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := r.List(ctx, &instasliceList, client.InNamespace(InstaSliceOperatorNamespace)); err != nil {
+		return nil, err
+	}
+	policy := r.allocationPolicy(ctx)
+
+	report := &DefragReport{Time: metav1.Now(), DryRun: r.Config.DefragDryRun, Plans: []DefragPlan{}}
+	evictions := r.Config.DefragMaxEvictions
+	for i := range instasliceList.Items {
+		instaslice := &instasliceList.Items[i]
+		r.mu.Lock()
+		held := r.defragHeld(instaslice.Name)
+		r.mu.Unlock()
+		if held {
+			// the pods moved by the previous plan are still being allocated again
+			continue
+		}
+		movable, budgets, err := r.readDefragCandidates(ctx, instaslice)
+		if err != nil {
+			return nil, err
+		}
+		if len(movable) == 0 {
+			continue
+		}
+
+		r.mu.Lock()
+		plan, victims := r.planDefrag(instaslice, policy, movable, budgets)
+		if plan == nil {
+			r.mu.Unlock()
+			continue
+		}
+		execute := false
+		switch {
+		case r.Config.DefragDryRun:
+			plan.Skipped = "dry run"
+		case int32(len(plan.Moves)) > evictions:
+			plan.Skipped = "eviction limit reached"
+		default:
+			// the placement is held before the pods are evicted, so the pods recreated meanwhile
+			// do not take it
+			r.holdDefragPlacement(plan)
+			execute = true
+		}
+		r.mu.Unlock()
+		if execute {
+			if err := r.evictDefragVictims(ctx, plan, victims); err != nil {
+				plan.Skipped = err.Error()
+			} else {
+				plan.Executed = true
+				evictions -= int32(len(plan.Moves))
+			}
+		}
+		log.Info("defragmentation plan", "node", plan.Node, "gpu", plan.GPUUUID, "profile", plan.Profile, "start", plan.Placement.Start,
+			"moves", len(plan.Moves), "executed", plan.Executed, "skipped", plan.Skipped)
+		report.Plans = append(report.Plans, *plan)
+	}
+	if err := r.writeDefragReport(ctx, report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// defragHeld reports whether the placement freed by an earlier plan on the node is still held,
+// under r.mu
+func (r *InstasliceReconciler) defragHeld(nodeName string) bool {
+	hold, ok := r.nominations[defragHoldKey(nodeName)]
+	return ok && time.Now().Before(hold.expires)
+}
+
+// readDefragCandidates reads the pods of the node that may be moved and the disruption budgets
+// covering them. It reads the API and must not run under r.mu.
+func (r *InstasliceReconciler) readDefragCandidates(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) ([]defragVictim, map[types.UID]*disruptionBudget, error) {
+	movable, err := r.movableAllocations(ctx, instaslice)
+	if err != nil || len(movable) == 0 {
+		return nil, nil, err
+	}
+	pods := make([]*v1.Pod, 0, len(movable))
+	for _, victim := range movable {
+		pods = append(pods, victim.pod)
+	}
+	budgets, err := r.disruptionBudgets(ctx, pods)
+	if err != nil {
+		return nil, nil, err
+	}
+	return movable, budgets, nil
+}
+
+// planDefrag returns the plan freeing the largest profile on a GPU of the node with the fewest
+// moves, nil when no GPU of the node is fragmented or the pods in the way cannot be moved. It
+// runs under r.mu.
+func (r *InstasliceReconciler) planDefrag(instaslice *inferencev1alpha1.Instaslice, policy AllocationPolicy, movable []defragVictim,
+	budgets map[types.UID]*disruptionBudget) (*DefragPlan, []defragVictim) {
+	var (
+		best        *DefragPlan
+		bestVictims []defragVictim
+		bestSize    int32
+	)
+	gpus := r.gpuCandidates(instaslice)
+	r.reserveNominatedSlices(instaslice.Name, nil, nil, gpus)
+	for _, gpu := range gpus {
+		profileName, size := defragTarget(instaslice, gpu.Allocated)
+		if profileName == "" || size < bestSize {
+			continue
+		}
+		for _, placement := range instaslice.Status.NodeResources.MigPlacement[profileName].Placements {
+			plan, victims, ok := r.planPlacement(instaslice, policy, gpu, profileName, placement, movable, budgets)
+			if !ok {
+				continue
+			}
+			if best == nil || size > bestSize || len(plan.Moves) < len(best.Moves) {
+				best, bestVictims, bestSize = plan, victims, size
+			}
+		}
+	}
+	return best, bestVictims
+}
+
+// planPlacement moves the pods holding slots of placement on the GPU elsewhere on the node,
+// ok is false when a pod in the way cannot be moved or does not fit anymore
+func (r *InstasliceReconciler) planPlacement(instaslice *inferencev1alpha1.Instaslice, policy AllocationPolicy, gpu GPUCandidate, profileName string,
+	placement inferencev1alpha1.Placement, movable []defragVictim, budgets map[types.UID]*disruptionBudget) (*DefragPlan, []defragVictim, bool) {
+	blocked := NewGPUSlots(gpu.Allocated.Len())
+	excluded := make(map[types.UID]bool)
+	var victims []defragVictim
+	for _, victim := range movable {
+		inTheWay := false
+		for _, slice := range victim.allocResult.AllSlices() {
+			if slice.GPUUUID == gpu.GPUUUID && slice.MigPlacement.Start < placement.Start+placement.Size &&
+				placement.Start < slice.MigPlacement.Start+slice.MigPlacement.Size {
+				inTheWay = true
+				blocked.Allocate(slice.MigPlacement.Start, slice.MigPlacement.Size)
+			}
+		}
+		if inTheWay {
+			excluded[victim.pod.UID] = true
+			victims = append(victims, victim)
+		}
+	}
+	if len(victims) == 0 {
+		return nil, nil, false
+	}
+	// slots taken by pods that cannot be moved stay taken
+	for i := placement.Start; i < placement.Start+placement.Size; i++ {
+		if gpu.Allocated[i] && !blocked[i] {
+			return nil, nil, false
+		}
+	}
+	taken := make([]*v1.Pod, 0, len(victims))
+	defer func() {
+		for _, pod := range taken {
+			takeDisruptionBudget(budgets, pod, 1)
+		}
+	}()
+	for _, victim := range victims {
+		if !takeDisruptionBudget(budgets, victim.pod, -1) {
+			return nil, nil, false
+		}
+		taken = append(taken, victim.pod)
+	}
+
+	candidates := r.gpuCandidatesExcluding(instaslice, excluded)
+	r.reserveNominatedSlices(instaslice.Name, nil, nil, candidates)
+	for _, candidate := range candidates {
+		if candidate.GPUUUID == gpu.GPUUUID {
+			candidate.Allocated.Allocate(placement.Start, placement.Size)
+		}
+	}
+	plan := &DefragPlan{Node: instaslice.Name, GPUUUID: gpu.GPUUUID, Profile: profileName, Placement: placement}
+	for _, victim := range victims {
+		slices, _, ok := r.placeContainerSlices(instaslice, victim.allocRequest.AllContainers(), policy, podGPUCandidates(instaslice, victim.pod, candidates))
+		if !ok {
+			return nil, nil, false
+		}
+		plan.Moves = append(plan.Moves, DefragMove{Pod: victim.pod.Namespace + "/" + victim.pod.Name, From: victim.allocResult.AllSlices(), To: slices})
+	}
+	return plan, victims, true
+}
+
+// defragTarget returns the largest profile that fits in the free slots of a GPU but has no free
+// placement, and that is larger than every profile with a free placement
+func defragTarget(instaslice *inferencev1alpha1.Instaslice, allocated GPUSlots) (string, int32) {
+	var largestFit int32
+	profileNames := make([]string, 0, len(instaslice.Status.NodeResources.MigPlacement))
+	for profileName, mig := range instaslice.Status.NodeResources.MigPlacement {
+		if mig.SharesGPUInstance() {
+			continue
+		}
+		profileNames = append(profileNames, profileName)
+		if size := profileSize(instaslice, profileName); size > largestFit && len(freePlacementStarts(instaslice, profileName, allocated)) > 0 {
+			largestFit = size
+		}
+	}
+	sort.Strings(profileNames)
+	var (
+		target     string
+		targetSize int32
+	)
+	for _, profileName := range profileNames {
+		if size := profileSize(instaslice, profileName); size > largestFit && size > targetSize && size <= allocated.FreeCount() {
+			target, targetSize = profileName, size
+		}
+	}
+	return target, targetSize
+}
+
+// movableAllocations returns the pods with created slices on the node whose owner recreates them
+// after an eviction, in the order of their names
+func (r *InstasliceReconciler) movableAllocations(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) ([]defragVictim, error) {
+	var movable []defragVictim
+	for podUID, allocResult := range r.allocationCache.Snapshot() {
+		if allocResult.Nodename != types.NodeName(instaslice.Name) ||
+			allocResult.AllocationStatus.AllocationStatusController != inferencev1alpha1.AllocationStatusUngated ||
+			allocResult.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusCreated {
+			continue
+		}
+		// moving one of the pods sharing a GPU instance does not free its slots
+		if hasSharedSlice(&allocResult) {
+			continue
+		}
+		allocRequest, ok := instaslice.Spec.PodAllocationRequests[podUID]
+		if !ok {
+			continue
+		}
+		pod := &v1.Pod{}
+		if err := r.Get(ctx, types.NamespacedName{Name: allocRequest.PodRef.Name, Namespace: allocRequest.PodRef.Namespace}, pod); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if pod.UID != podUID || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		_, ok, err := r.restartableOwner(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			movable = append(movable, defragVictim{pod: pod, allocRequest: allocRequest, allocResult: allocResult})
+		}
+	}
+	sort.Slice(movable, func(i, j int) bool {
+		return movable[i].pod.Namespace+"/"+movable[i].pod.Name < movable[j].pod.Namespace+"/"+movable[j].pod.Name
+	})
+	return movable, nil
+}
+
+// restartableOwner returns the controller that recreates a pod after an eviction, which is the
+// ReplicaSet of a Deployment or a StatefulSet that opted in with DefragAllowedAnnotation
+func (r *InstasliceReconciler) restartableOwner(ctx context.Context, pod *v1.Pod) (types.UID, bool, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", false, nil
+	}
+	key := types.NamespacedName{Name: owner.Name, Namespace: pod.Namespace}
+	switch owner.Kind {
+	case "ReplicaSet":
+		replicaSet := &appsv1.ReplicaSet{}
+		if err := r.Get(ctx, key, replicaSet); err != nil {
+			return "", false, client.IgnoreNotFound(err)
+		}
+		if deployment := metav1.GetControllerOf(replicaSet); deployment != nil && deployment.Kind == "Deployment" {
+			return replicaSet.UID, true, nil
+		}
+	case "StatefulSet":
+		statefulSet := &appsv1.StatefulSet{}
+		if err := r.Get(ctx, key, statefulSet); err != nil {
+			return "", false, client.IgnoreNotFound(err)
+		}
+		if statefulSet.Annotations[DefragAllowedAnnotation] == "true" {
+			return statefulSet.UID, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// holdDefragPlacement holds the placement freed by a plan for the pods requesting its profile,
+// under r.mu
+func (r *InstasliceReconciler) holdDefragPlacement(plan *DefragPlan) {
+	if r.nominations == nil {
+		r.nominations = make(map[types.UID]nomination)
+	}
+	r.nominations[defragHoldKey(plan.Node)] = nomination{
+		nodeName: plan.Node,
+		slices:   []inferencev1alpha1.SliceResult{{GPUUUID: plan.GPUUUID, MigPlacement: plan.Placement}},
+		expires:  time.Now().Add(nominationTimeout),
+		profile:  plan.Profile,
+	}
+}
+
+// evictDefragVictims evicts the pods of a plan, whose placement is held already. It must not run
+// under r.mu.
+func (r *InstasliceReconciler) evictDefragVictims(ctx context.Context, plan *DefragPlan, victims []defragVictim) error {
+	for _, victim := range victims {
+		eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: victim.pod.Name, Namespace: victim.pod.Namespace}}
+		if err := r.SubResource("eviction").Create(ctx, victim.pod, eviction); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to evict pod %s/%s: %w", victim.pod.Namespace, victim.pod.Name, err)
+		}
+		r.recordEvent(victim.pod, v1.EventTypeNormal, EventReasonDefragmenting,
+			fmt.Sprintf("Evicted to free a placement of profile %s on GPU %s of node %s", plan.Profile, plan.GPUUUID, plan.Node))
+	}
+	return nil
+}
+
+// releaseDefragHold drops the hold of the defragmenter on the placement an allocation was placed in
+func (r *InstasliceReconciler) releaseDefragHold(allocResult *inferencev1alpha1.AllocationResult) {
+	key := defragHoldKey(string(allocResult.Nodename))
+	hold, ok := r.nominations[key]
+	if ok && allocationsOverlap(inferencev1alpha1.AllocationResult{Slices: hold.slices}, *allocResult) {
+		delete(r.nominations, key)
+	}
+}
+
+// writeDefragReport stores the report of the last pass in the DefragReportConfigMapName ConfigMap
+func (r *InstasliceReconciler) writeDefragReport(ctx context.Context, report *DefragReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: DefragReportConfigMapName, Namespace: InstaSliceOperatorNamespace}}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		configMap.Data = map[string]string{"report.json": string(data)}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func controllerRef(kind, name string, uid types.UID) []metav1.OwnerReference {
+	isController := true
+	return []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: name, UID: uid, Controller: &isController}}
+}
+
+// newDefragFixture returns a node whose first GPU holds 1g slices at slots 1, 3 and 5 and whose
+// second GPU is full. The pods at slots 1 and 5 belong to a Deployment, the others to no controller.
+func newDefragFixture(t *testing.T, cfg *config.Config, extra ...client.Object) (*InstasliceReconciler, client.Client) {
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	instaslice.Namespace = InstaSliceOperatorNamespace
+	gpus := sortGPUs(instaslice)
+	instaslice.Spec.PodAllocationRequests = map[types.UID]inferencev1alpha1.AllocationRequest{}
+	instaslice.Status.PodAllocationResults = map[types.UID]inferencev1alpha1.AllocationResult{}
+	cache := map[types.UID]inferencev1alpha1.AllocationResult{}
+
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-abc", Namespace: "default", UID: "rs-uid", OwnerReferences: controllerRef("Deployment", "web", "deploy-uid"),
+	}}
+	objects := append([]client.Object{replicaSet}, extra...)
+	holders := []struct {
+		name    string
+		owned   bool
+		gpu     int
+		profile string
+		start   int32
+		size    int32
+	}{
+		{name: "web-1", owned: true, gpu: 0, profile: "1g.5gb", start: 1, size: 1},
+		{name: "bare", gpu: 0, profile: "1g.5gb", start: 3, size: 1},
+		{name: "web-2", owned: true, gpu: 0, profile: "1g.5gb", start: 5, size: 1},
+		{name: "full", gpu: 1, profile: "7g.40gb", start: 0, size: 8},
+	}
+	for _, holder := range holders {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: holder.name, Namespace: "default", UID: types.UID(holder.name), Labels: map[string]string{"app": holder.name}}}
+		if holder.owned {
+			pod.Labels["app"] = "web"
+			pod.OwnerReferences = controllerRef("ReplicaSet", replicaSet.Name, replicaSet.UID)
+		}
+		objects = append(objects, pod)
+		allocResult := inferencev1alpha1.AllocationResult{
+			GPUUUID:          gpus[holder.gpu],
+			Nodename:         "node-1",
+			MigPlacement:     inferencev1alpha1.Placement{Start: holder.start, Size: holder.size},
+			AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusUngated, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated},
+		}
+		instaslice.Spec.PodAllocationRequests[pod.UID] = inferencev1alpha1.AllocationRequest{
+			Profile: holder.profile,
+			PodRef:  v1.ObjectReference{Name: pod.Name, Namespace: pod.Namespace, UID: pod.UID},
+		}
+		instaslice.Status.PodAllocationResults[pod.UID] = allocResult
+		cache[pod.UID] = allocResult
+	}
+	objects = append(objects, instaslice)
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(scheme))
+	assert.NoError(t, appsv1.AddToScheme(scheme))
+	assert.NoError(t, policyv1.AddToScheme(scheme))
+	assert.NoError(t, inferencev1alpha1.AddToScheme(scheme))
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	r := &InstasliceReconciler{Client: fakeClient, Config: cfg, allocationCache: newAllocationStore(cache), isCacheInitialized: true}
+	return r, fakeClient
+}
+
+func TestDefragTarget(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	tests := []struct {
+		name        string
+		allocated   []int32
+		wantProfile string
+		wantSize    int32
+	}{
+		{name: "empty GPU", wantProfile: "", wantSize: 0},
+		{name: "scattered slices", allocated: []int32{1, 3, 5}, wantProfile: "3g.20gb", wantSize: 4},
+		{name: "packed slices", allocated: []int32{0, 1, 2}, wantProfile: "", wantSize: 0},
+		{name: "full GPU", allocated: []int32{0, 1, 2, 3, 4, 5, 6, 7}, wantProfile: "", wantSize: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slots := NewGPUSlots(gpuSlotCount(instaslice))
+			for _, slot := range tt.allocated {
+				slots.Allocate(slot, 1)
+			}
+			profileName, size := defragTarget(instaslice, slots)
+			assert.Equal(t, tt.wantProfile, profileName)
+			assert.Equal(t, tt.wantSize, size)
+		})
+	}
+}
+
+func TestRestartableOwner(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(scheme))
+	assert.NoError(t, appsv1.AddToScheme(scheme))
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "deployed", Namespace: "default", UID: "rs-1", OwnerReferences: controllerRef("Deployment", "web", "d-1")}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "standalone", Namespace: "default", UID: "rs-2"}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "allowed", Namespace: "default", UID: "sts-1", Annotations: map[string]string{DefragAllowedAnnotation: "true"}}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "pinned", Namespace: "default", UID: "sts-2"}},
+	).Build()
+	r := &InstasliceReconciler{Client: fakeClient}
+
+	tests := []struct {
+		name      string
+		owners    []metav1.OwnerReference
+		wantOwner types.UID
+		wantOk    bool
+	}{
+		{name: "pod without a controller"},
+		{name: "pod of a Deployment", owners: controllerRef("ReplicaSet", "deployed", "rs-1"), wantOwner: "rs-1", wantOk: true},
+		{name: "pod of a bare ReplicaSet", owners: controllerRef("ReplicaSet", "standalone", "rs-2")},
+		{name: "pod of an opted in StatefulSet", owners: controllerRef("StatefulSet", "allowed", "sts-1"), wantOwner: "sts-1", wantOk: true},
+		{name: "pod of a StatefulSet", owners: controllerRef("StatefulSet", "pinned", "sts-2")},
+		{name: "pod of a Job", owners: controllerRef("Job", "batch", "job-1")},
+		{name: "owner gone", owners: controllerRef("ReplicaSet", "gone", "rs-3")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default", OwnerReferences: tt.owners}}
+			owner, ok, err := r.restartableOwner(context.Background(), pod)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantOwner, owner)
+		})
+	}
+}
+
+func TestDefragment(t *testing.T) {
+	ctx := context.Background()
+	readReport := func(t *testing.T, c client.Client) DefragReport {
+		configMap := &v1.ConfigMap{}
+		assert.NoError(t, c.Get(ctx, types.NamespacedName{Name: DefragReportConfigMapName, Namespace: InstaSliceOperatorNamespace}, configMap))
+		var report DefragReport
+		assert.NoError(t, json.Unmarshal([]byte(configMap.Data["report.json"]), &report))
+		return report
+	}
+	podExists := func(t *testing.T, c client.Client, name string) bool {
+		err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, &v1.Pod{})
+		assert.True(t, err == nil || apierrors.IsNotFound(err))
+		return err == nil
+	}
+
+	t.Run("dry run only reports the plan", func(t *testing.T) {
+		cfg := config.NewConfig()
+		cfg.DefragDryRun = true
+		r, c := newDefragFixture(t, cfg)
+		report, err := r.defragment(ctx)
+		assert.NoError(t, err)
+		assert.True(t, report.DryRun)
+		assert.Len(t, report.Plans, 1)
+		plan := report.Plans[0]
+		// the pod at slot 3 cannot be moved, the one at slot 5 goes to the first free slot
+		assert.Equal(t, "3g.20gb", plan.Profile)
+		assert.Equal(t, inferencev1alpha1.Placement{Start: 4, Size: 4}, plan.Placement)
+		assert.Len(t, plan.Moves, 1)
+		assert.Equal(t, "default/web-2", plan.Moves[0].Pod)
+		assert.Equal(t, int32(0), plan.Moves[0].To[0].MigPlacement.Start)
+		assert.False(t, plan.Executed)
+		assert.Equal(t, "dry run", plan.Skipped)
+		assert.True(t, podExists(t, c, "web-2"))
+		assert.Equal(t, report.Plans, readReport(t, c).Plans)
+	})
+
+	t.Run("eviction limit", func(t *testing.T) {
+		cfg := config.NewConfig()
+		cfg.DefragMaxEvictions = 0
+		r, c := newDefragFixture(t, cfg)
+		report, err := r.defragment(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, report.Plans, 1)
+		assert.Equal(t, "eviction limit reached", report.Plans[0].Skipped)
+		assert.True(t, podExists(t, c, "web-2"))
+	})
+
+	t.Run("disruption budget", func(t *testing.T) {
+		pdb := &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "pdb-web"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+		}
+		r, c := newDefragFixture(t, config.NewConfig(), pdb)
+		report, err := r.defragment(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, report.Plans)
+		assert.True(t, podExists(t, c, "web-2"))
+	})
+
+	t.Run("evicts and holds the freed placement", func(t *testing.T) {
+		r, c := newDefragFixture(t, config.NewConfig())
+		report, err := r.defragment(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, report.Plans, 1)
+		assert.True(t, report.Plans[0].Executed)
+		assert.False(t, podExists(t, c, "web-2"))
+		assert.True(t, podExists(t, c, "web-1"))
+		assert.True(t, podExists(t, c, "bare"))
+
+		// the freed placement is kept from the pods not requesting its profile, replacements included
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, c.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+		r.allocationCache.Delete("web-2")
+		requesting := func(uid types.UID, profile string, owners []metav1.OwnerReference) *v1.Pod {
+			return &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{UID: uid, OwnerReferences: owners},
+				Spec: v1.PodSpec{Containers: []v1.Container{{Name: "main", Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{v1.ResourceName(OrgInstaslicePrefix + "mig-" + profile): resource.MustParse("1")},
+				}}}},
+			}
+		}
+		replacement := requesting("web-3", "1g.5gb", controllerRef("ReplicaSet", "web-abc", "rs-uid"))
+		gpus := r.gpuCandidates(instaslice)
+		r.reserveNominatedSlices(instaslice.Name, replacement, r.extractContainerRequests(replacement), gpus)
+		assert.False(t, gpus[0].Allocated.IsFree(4, 4))
+		gpus = r.gpuCandidates(instaslice)
+		large := requesting("large", "3g.20gb", nil)
+		r.reserveNominatedSlices(instaslice.Name, large, r.extractContainerRequests(large), gpus)
+		assert.True(t, gpus[0].Allocated.IsFree(4, 4))
+
+		// the node is left alone while the placement is held
+		report, err = r.defragment(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, report.Plans)
+
+		// and the hold is dropped once a pod is placed in it
+		r.releaseDefragHold(&inferencev1alpha1.AllocationResult{Nodename: "node-1", GPUUUID: sortGPUs(instaslice)[0], MigPlacement: inferencev1alpha1.Placement{Start: 4, Size: 4}})
+		assert.NotContains(t, r.nominations, defragHoldKey("node-1"))
+		gpus = r.gpuCandidates(instaslice)
+		r.reserveNominatedSlices(instaslice.Name, replacement, r.extractContainerRequests(replacement), gpus)
+		assert.True(t, gpus[0].Allocated.IsFree(4, 4))
+	})
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// Reasons of the events emitted along the lifecycle of an allocation
+const (
+	EventReasonSlicesPlaced          = "SlicesPlaced"
+	EventReasonNoFit                 = "NoFit"
+	EventReasonGPUModelUnsatisfiable = "GPUModelUnsatisfiable"
+	EventReasonSlicesCreated         = "SlicesCreated"
+	EventReasonPodUngated            = "PodUngated"
+	EventReasonAllocationTimedOut    = "AllocationTimedOut"
+	EventReasonReleaseStarted        = "ReleaseStarted"
+	EventReasonSlicesReleased        = "SlicesReleased"
+	EventReasonQuotaNotAdjusted      = "QuotaNotAdjusted"
+)
+
+// Reasons of the events emitted by preemptions, on the victims and on the preemptor, and on
+// the pods the defragmenter evicts
+const (
+	EventReasonPreempted     = "Preempted"
+	EventReasonPreempting    = "Preempting"
+	EventReasonDefragmenting = "Defragmenting"
+)
+
+// RecordAllocationEvent emits an event on the pod of an allocation and on the Instaslice of its
+// node, so that it shows up in kubectl describe for both
+func RecordAllocationEvent(recorder record.EventRecorder, instasliceName string, allocRequest *inferencev1alpha1.AllocationRequest, eventType, reason, message string) {
+	if recorder == nil || allocRequest == nil {
+		return
+	}
+	pod := allocRequest.PodRef
+	if pod.APIVersion == "" {
+		pod.APIVersion = "v1"
+	}
+	if pod.Kind == "" {
+		pod.Kind = "Pod"
+	}
+	recorder.Event(&pod, eventType, reason, message)
+	recorder.Event(&v1.ObjectReference{
+		APIVersion: inferencev1alpha1.GroupVersion.String(),
+		Kind:       "Instaslice",
+		Namespace:  InstaSliceOperatorNamespace,
+		Name:       instasliceName,
+	}, eventType, reason, fmt.Sprintf("pod %s/%s: %s", pod.Namespace, pod.Name, message))
+}
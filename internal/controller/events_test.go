@@ -0,0 +1,43 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+func TestRecordAllocationEvent(t *testing.T) {
+	allocRequest := &inferencev1alpha1.AllocationRequest{
+		Profile: "3g.20gb",
+		PodRef:  v1.ObjectReference{Name: "model", Namespace: "default", UID: "model"},
+	}
+	// without a recorder nothing is emitted
+	RecordAllocationEvent(nil, "node-1", allocRequest, v1.EventTypeNormal, EventReasonSlicesPlaced, "placed")
+
+	recorder := record.NewFakeRecorder(10)
+	RecordAllocationEvent(recorder, "node-1", allocRequest, v1.EventTypeNormal, EventReasonSlicesPlaced, "placed on node node-1")
+	if assert.Len(t, recorder.Events, 2) {
+		assert.Equal(t, "Normal SlicesPlaced placed on node node-1", <-recorder.Events)
+		assert.Equal(t, "Normal SlicesPlaced pod default/model: placed on node node-1", <-recorder.Events)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	rcache "github.com/openshift/instaslice-operator/internal/controller/cache"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// testAllocation is the allocation of the slots of a GPU of node-1 to a pod
+type testAllocation struct {
+	pod     string
+	gpu     int
+	profile string
+	start   int32
+	size    int32
+	// status is the status of the allocation, the slices are created and the pod ungated when empty
+	status inferencev1alpha1.AllocationStatus
+}
+
+// newAllocationFixture returns a reconciler whose fake client holds node-1, its Instaslice with
+// the allocations and the objects, and whose allocation cache knows about the allocations
+func newAllocationFixture(t *testing.T, allocations []testAllocation, objects ...client.Object) (*InstasliceReconciler, *inferencev1alpha1.Instaslice) {
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpus := sortGPUs(instaslice)
+	allocationCache := make(map[types.UID]inferencev1alpha1.AllocationResult)
+	for _, allocation := range allocations {
+		podUID := types.UID(allocation.pod)
+		status := allocation.status
+		if status == (inferencev1alpha1.AllocationStatus{}) {
+			status = inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusUngated, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}
+		}
+		instaslice.Spec.PodAllocationRequests[podUID] = inferencev1alpha1.AllocationRequest{
+			Profile: allocation.profile,
+			PodRef:  v1.ObjectReference{Name: allocation.pod, Namespace: "default", UID: podUID},
+		}
+		allocResult := inferencev1alpha1.AllocationResult{
+			Nodename:         "node-1",
+			GPUUUID:          gpus[allocation.gpu],
+			MigPlacement:     inferencev1alpha1.Placement{Start: allocation.start, Size: allocation.size},
+			AllocationStatus: status,
+		}
+		instaslice.Status.PodAllocationResults[podUID] = allocResult
+		allocationCache[podUID] = allocResult
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("4"),
+			v1.ResourceMemory: resource.MustParse("8Gi"),
+		}},
+	}
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(scheme))
+	assert.NoError(t, appsv1.AddToScheme(scheme))
+	assert.NoError(t, policyv1.AddToScheme(scheme))
+	assert.NoError(t, inferencev1alpha1.AddToScheme(scheme))
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objects, instaslice, node)...).
+		WithStatusSubresource(instaslice).Build()
+	resourceCache := rcache.NewResourceCache()
+	resourceCache.ResourceEventHandlerForNode().AddFunc(node)
+	return &InstasliceReconciler{
+		Client:          fakeClient,
+		Config:          config.NewConfig(),
+		ResourceCache:   resourceCache,
+		allocationCache: newAllocationStore(allocationCache),
+	}, instaslice
+}
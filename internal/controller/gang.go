@@ -0,0 +1,359 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// Pods of a group are placed all together: once at least min member pods of the group are
+// created, the slices of every gated member are reserved in the allocation cache, and the ones
+// reserved are rolled back when a member fits nowhere. Workers place the members of a group under
+// the lock of the group, one worker at a time. The members are ungated once the slices of all of
+// them are created. Slices of a group that does not get there within config.Config.PodGroupTimeout
+// are released and the group starts over.
+
+// podGroup identifies the gang a pod belongs to
+type podGroup struct {
+	namespace string
+	name      string
+	minMember int32
+}
+
+func (g podGroup) key() string {
+	return g.namespace + "/" + g.name
+}
+
+// podGroupOf returns the group of a pod, ok is false for pods without the group annotation
+func podGroupOf(pod *v1.Pod) (group podGroup, ok bool, err error) {
+	name, ok := pod.Annotations[PodGroupAnnotation]
+	if !ok || name == "" {
+		return podGroup{}, false, nil
+	}
+	minMember, err := strconv.ParseInt(pod.Annotations[PodGroupMinMemberAnnotation], 10, 32)
+	if err != nil || minMember < 1 {
+		return podGroup{}, true, fmt.Errorf("invalid %s annotation %q, pod: %s, a positive number is required",
+			PodGroupMinMemberAnnotation, pod.Annotations[PodGroupMinMemberAnnotation], pod.Name)
+	}
+	return podGroup{namespace: pod.Namespace, name: name, minMember: int32(minMember)}, true, nil
+}
+
+// podGroupMember is a pod of a group with its allocation, if any
+type podGroupMember struct {
+	pod            v1.Pod
+	instasliceName string
+	allocRequest   *inferencev1alpha1.AllocationRequest
+	allocResult    *inferencev1alpha1.AllocationResult
+}
+
+// podGroupMembers returns the pods of a group that are still running or waiting for slices
+func (r *InstasliceReconciler) podGroupMembers(ctx context.Context, group podGroup, instasliceList *inferencev1alpha1.InstasliceList) ([]podGroupMember, error) {
+	var podList v1.PodList
+	if err := r.List(ctx, &podList, client.InNamespace(group.namespace)); err != nil {
+		return nil, err
+	}
+	var members []podGroupMember
+	for _, pod := range podList.Items {
+		if pod.Annotations[PodGroupAnnotation] != group.name || !pod.DeletionTimestamp.IsZero() ||
+			pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		member := podGroupMember{pod: pod}
+		for _, instaslice := range instasliceList.Items {
+			if allocResult, ok := instaslice.Status.PodAllocationResults[pod.UID]; ok {
+				allocRequest := instaslice.Spec.PodAllocationRequests[pod.UID]
+				member.instasliceName = instaslice.Name
+				member.allocRequest, member.allocResult = &allocRequest, &allocResult
+				break
+			}
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// releasingAllocation reports whether the slices of an allocation are being or were released
+func releasingAllocation(allocResult inferencev1alpha1.AllocationResult) bool {
+	return allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting ||
+		allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted
+}
+
+// groupPlacementMember is a gated member of a group waiting for slices, with the nodes read for it
+type groupPlacementMember struct {
+	pod        *v1.Pod
+	containers []inferencev1alpha1.ContainerRequest
+	nodes      map[string]*placementNode
+	rejections map[string]error
+}
+
+// placePodGroup places a pod of a group along with the other gated members of the group. The
+// slices of all of them are reserved in the allocation cache, or none when a member fits
+// nowhere. Members of a group that was admitted before, or that holds slices for min member pods
+// already, are placed alone. Groups are not placed while the slices of a member are released or
+// until min member pods are created, and do not preempt other pods.
+func (r *InstasliceReconciler) placePodGroup(ctx context.Context, pod *v1.Pod, containers []inferencev1alpha1.ContainerRequest, group podGroup,
+	instasliceList *inferencev1alpha1.InstasliceList, availableInstaslices []inferencev1alpha1.Instaslice, unavailableNodes map[string]error, policy AllocationPolicy) (*podPlacement, error) {
+	log := logr.FromContext(ctx)
+	members, err := r.podGroupMembers(ctx, group, instasliceList)
+	if err != nil {
+		return nil, err
+	}
+	pending := []*groupPlacementMember{{pod: pod, containers: containers}}
+	var allocated int32
+	var admitted bool
+	for i := range members {
+		member := &members[i]
+		if member.pod.UID == pod.UID {
+			continue
+		}
+		// allocations written since the Instaslices were listed are in the allocation cache, which
+		// does not follow the statuses set by the daemonset
+		cached, inCache := r.allocationCache.Get(member.pod.UID)
+		allocResult, ok := cached, inCache
+		if member.allocResult != nil {
+			allocResult, ok = *member.allocResult, true
+		}
+		switch {
+		case ok && releasingAllocation(allocResult), inCache && releasingAllocation(cached):
+			log.Info("waiting for the slices of a member of the pod group to be released", "group", group.key(), "pod", member.pod.Name)
+			return &podPlacement{waitingForGroup: true}, nil
+		case ok:
+			allocated++
+			admitted = admitted || allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusUngated
+		case checkIfPodGatedByInstaSlice(&member.pod) && controllerutil.ContainsFinalizer(&member.pod, FinalizerName):
+			if memberContainers := r.extractContainerRequests(&member.pod); len(memberContainers) > 0 {
+				pending = append(pending, &groupPlacementMember{pod: &member.pod, containers: memberContainers})
+			}
+		}
+	}
+	if admitted || allocated >= group.minMember {
+		// the group got its slices, late members are placed on their own
+		pending = pending[:1]
+	} else if allocated+int32(len(pending)) < group.minMember {
+		log.Info("waiting for the members of the pod group to be created", "group", group.key(),
+			"members", allocated+int32(len(pending)), "minMember", group.minMember)
+		return &podPlacement{waitingForGroup: true}, nil
+	}
+	for _, member := range pending {
+		member.rejections = make(map[string]error)
+		member.nodes = r.readPlacementNodes(ctx, member.pod, instasliceList.Items, unavailableNodes, member.rejections)
+	}
+	queueNodes := r.readNodes(ctx, availableInstaslices)
+
+	unlock := r.groupLocks.Lock(group.key())
+	defer unlock()
+	r.mu.Lock()
+	allowed := r.mayAllocate(ctx, pod, containers, availableInstaslices, queueNodes, policy)
+	r.mu.Unlock()
+	if !allowed {
+		return &podPlacement{queued: true}, nil
+	}
+	// members placed by another worker meanwhile hold their slices already
+	if _, ok := r.allocationCache.Get(pod.UID); ok {
+		return &podPlacement{waitingForGroup: true}, nil
+	}
+	pending = slices.DeleteFunc(pending, func(member *groupPlacementMember) bool {
+		_, ok := r.allocationCache.Get(member.pod.UID)
+		return ok
+	})
+	var placements []*podPlacement
+	for _, member := range pending {
+		placement := r.reservePlacement(ctx, member.pod, member.containers, policy, instasliceList.Items, member.nodes, member.rejections)
+		if placement != nil {
+			placements = append(placements, placement)
+			continue
+		}
+		// the group is placed all together or not at all
+		r.mu.Lock()
+		for _, placement := range placements {
+			r.allocationCache.Rollback(placement.pod.UID)
+		}
+		r.syncReservedSlices()
+		r.mu.Unlock()
+		log.Info("a member of the pod group fits nowhere, releasing the slices reserved for the group", "group", group.key(), "pod", member.pod.Name)
+		return &podPlacement{rejections: member.rejections}, nil
+	}
+	placement := placements[0]
+	placement.members = placements[1:]
+	return placement, nil
+}
+
+// releaseGroupPlacements releases the allocations written for members of a group placed along
+// with a member whose allocation could not be written, so that the group is placed again all
+// together
+func (r *InstasliceReconciler) releaseGroupPlacements(ctx context.Context, placements []*podPlacement) {
+	log := logr.FromContext(ctx)
+	for _, placement := range placements {
+		allocResult := *placement.allocResult
+		r.markReleasing(placement.instasliceName, placement.allocRequest, &allocResult, "PodGroupPlacementFailed", "the allocation of another member of the pod group could not be written")
+		if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, placement.instasliceName, &allocResult, placement.allocRequest); err != nil {
+			// the allocation times out if the daemonset does not create it
+			log.Error(err, "failed to release the allocation of a member of the pod group", "pod", placement.pod.Name, "node", placement.instasliceName)
+			continue
+		}
+		r.mu.Lock()
+		r.updateCacheWithNewAllocation(placement.pod.UID, allocResult)
+		r.mu.Unlock()
+	}
+}
+
+// admitPodGroup reports whether a pod whose slices are created can be ungated. Pods outside of
+// a group are always admitted, pods of a group once the slices of all its placed members are
+// created, for at least min member pods. The other members are then woken up so that the whole
+// group is ungated. When the group times out the slices of all its members are released. The
+// members are listed, woken up and released without r.mu, which is only taken to decide. It must
+// not run under r.mu.
+func (r *InstasliceReconciler) admitPodGroup(ctx context.Context, pod *v1.Pod, instasliceList *inferencev1alpha1.InstasliceList) (bool, ctrl.Result, error) {
+	log := logr.FromContext(ctx)
+	group, ok, err := podGroupOf(pod)
+	if !ok || err != nil {
+		return !ok, ctrl.Result{}, err
+	}
+	members, err := r.podGroupMembers(ctx, group, instasliceList)
+	if err != nil {
+		return false, ctrl.Result{}, err
+	}
+
+	r.mu.Lock()
+	admission := r.podGroupAdmission(group, members)
+	r.mu.Unlock()
+	switch {
+	case admission.lateMember:
+		return true, ctrl.Result{}, nil
+	case admission.admitted:
+		log.Info("pod group admitted", "group", group.key(), "reserved", admission.reserved, "minMember", group.minMember)
+		for _, member := range members {
+			if member.pod.UID != pod.UID && member.allocRequest != nil {
+				r.wakePod(ctx, member.allocRequest.PodRef)
+			}
+		}
+		return true, ctrl.Result{}, nil
+	case admission.remaining > 0:
+		log.Info("waiting for pod group", "group", group.key(), "reserved", admission.reserved, "creating", admission.creating,
+			"minMember", group.minMember, "remaining", admission.remaining)
+		return false, ctrl.Result{RequeueAfter: min(admission.remaining, Requeue5sDelay)}, nil
+	}
+
+	log.Info("pod group timed out, releasing its slices", "group", group.key(), "reserved", admission.reserved, "creating", admission.creating, "minMember", group.minMember)
+	if err := r.releasePodGroup(ctx, members); err != nil {
+		return false, ctrl.Result{}, err
+	}
+	r.mu.Lock()
+	if r.podGroupReleases == nil {
+		r.podGroupReleases = make(map[string]time.Time)
+	}
+	r.podGroupReleases[group.key()] = time.Now()
+	r.mu.Unlock()
+	return false, ctrl.Result{RequeueAfter: Requeue5sDelay}, nil
+}
+
+// podGroupAdmission is the state of a group whose member asks to be ungated
+type podGroupAdmission struct {
+	// lateMember is set when the group was admitted before, admitted when it is admitted now
+	lateMember bool
+	admitted   bool
+	// reserved and creating are the members whose slices are created and being created
+	reserved, creating int32
+	// remaining is the time left before the group times out
+	remaining time.Duration
+}
+
+// podGroupAdmission decides whether a group is admitted from its members, under r.mu
+func (r *InstasliceReconciler) podGroupAdmission(group podGroup, members []podGroupMember) podGroupAdmission {
+	var admission podGroupAdmission
+	start := time.Now()
+	for _, member := range members {
+		if member.pod.CreationTimestamp.Time.Before(start) {
+			start = member.pod.CreationTimestamp.Time
+		}
+		allocResult := member.allocResult
+		if cached, ok := r.allocationCache.Get(member.pod.UID); ok && allocResult == nil {
+			// allocations written since the Instaslices were listed are in the allocation cache
+			allocResult = &cached
+		}
+		if allocResult == nil {
+			continue
+		}
+		switch {
+		case allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusUngated:
+			// the group was admitted before, admit late members too
+			return podGroupAdmission{lateMember: true}
+		case allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated:
+			admission.reserved++
+		case !releasingAllocation(*allocResult):
+			admission.creating++
+		}
+	}
+	if admission.reserved >= group.minMember && admission.creating == 0 {
+		admission.admitted = true
+		return admission
+	}
+	// the timeout restarts after the group released its slices
+	if released, ok := r.podGroupReleases[group.key()]; ok && released.After(start) {
+		start = released
+	}
+	admission.remaining = r.Config.PodGroupTimeout - time.Since(start)
+	return admission
+}
+
+// releasePodGroup moves the allocations of the gated members of a group to deleting so that
+// the daemonset tears their slices down. It must not run under r.mu, which is only taken to
+// update the allocation cache.
+func (r *InstasliceReconciler) releasePodGroup(ctx context.Context, members []podGroupMember) error {
+	for _, member := range members {
+		if member.allocResult == nil || member.allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting {
+			continue
+		}
+		r.markReleasing(member.instasliceName, member.allocRequest, member.allocResult, "PodGroupTimedOut", "the pod group did not get enough members allocated in time")
+		if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, member.instasliceName, member.allocResult, member.allocRequest); err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.updateCacheWithNewAllocation(member.pod.UID, *member.allocResult)
+		r.mu.Unlock()
+		r.ResetDeployedPodTotalMetrics(member.allocResult, member.allocRequest)
+	}
+	return nil
+}
+
+// removeReleasedAllocation drops the allocation of a gated pod once the daemonset deleted its
+// slices, or a request left without a result, so that the pod is allocated again. It must not
+// run under r.mu, which is only taken to update the allocation cache.
+func (r *InstasliceReconciler) removeReleasedAllocation(ctx context.Context, instasliceName string, podUID types.UID) error {
+	if err := utils.RemoveInstasliceAllocation(ctx, r.Client, utils.ControllerFieldManager, instasliceName, podUID); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.allocationCache.Delete(podUID)
+	r.syncReservedSlices()
+	return nil
+}
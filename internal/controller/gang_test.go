@@ -0,0 +1,375 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	rcache "github.com/openshift/instaslice-operator/internal/controller/cache"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestPodGroupOf(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default"}}
+	_, ok, err := podGroupOf(pod)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	pod.Annotations = map[string]string{PodGroupAnnotation: "train", PodGroupMinMemberAnnotation: "3"}
+	group, ok, err := podGroupOf(pod)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, podGroup{namespace: "default", name: "train", minMember: 3}, group)
+
+	for _, minMember := range []string{"", "0", "three"} {
+		pod.Annotations[PodGroupMinMemberAnnotation] = minMember
+		_, _, err = podGroupOf(pod)
+		assert.ErrorContains(t, err, "invalid "+PodGroupMinMemberAnnotation)
+	}
+}
+
+func TestAdmitPodGroup(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = v1.AddToScheme(scheme)
+	_ = inferencev1alpha1.AddToScheme(scheme)
+
+	created := inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}
+	creating := inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}
+	ungated := inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusUngated, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}
+
+	tests := []struct {
+		name         string
+		minMember    int32
+		age          time.Duration
+		statuses     []*inferencev1alpha1.AllocationStatus
+		wantAdmitted bool
+		wantRequeue  bool
+		wantReleased bool
+	}{
+		{name: "waits for the remaining members", minMember: 3, statuses: []*inferencev1alpha1.AllocationStatus{&created, &created, nil}, wantRequeue: true},
+		{name: "waits for slices being created", minMember: 3, statuses: []*inferencev1alpha1.AllocationStatus{&created, &created, &creating}, wantRequeue: true},
+		{name: "admitted with min members reserved", minMember: 2, statuses: []*inferencev1alpha1.AllocationStatus{&created, &created, nil}, wantAdmitted: true},
+		{name: "waits for the slices of every placed member", minMember: 2, statuses: []*inferencev1alpha1.AllocationStatus{&created, &created, &creating}, wantRequeue: true},
+		{name: "late member of an admitted group", minMember: 3, statuses: []*inferencev1alpha1.AllocationStatus{&created, &ungated, nil}, wantAdmitted: true},
+		{name: "released after the timeout", minMember: 3, age: 10 * time.Minute, statuses: []*inferencev1alpha1.AllocationStatus{&created, &creating, nil}, wantRequeue: true, wantReleased: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instaslice := &inferencev1alpha1.Instaslice{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: InstaSliceOperatorNamespace},
+				Spec:       inferencev1alpha1.InstasliceSpec{PodAllocationRequests: map[types.UID]inferencev1alpha1.AllocationRequest{}},
+				Status:     inferencev1alpha1.InstasliceStatus{PodAllocationResults: map[types.UID]inferencev1alpha1.AllocationResult{}},
+			}
+			var objects []client.Object
+			var pods []*v1.Pod
+			for i, status := range tt.statuses {
+				pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+					Name:              "worker-" + strconv.Itoa(i),
+					Namespace:         "default",
+					UID:               types.UID("uid-" + strconv.Itoa(i)),
+					CreationTimestamp: metav1.NewTime(time.Now().Add(-tt.age)),
+					Annotations:       map[string]string{PodGroupAnnotation: "train", PodGroupMinMemberAnnotation: strconv.Itoa(int(tt.minMember))},
+				}}
+				pods = append(pods, pod)
+				objects = append(objects, pod)
+				if status != nil {
+					instaslice.Spec.PodAllocationRequests[pod.UID] = inferencev1alpha1.AllocationRequest{
+						Profile: "1g.5gb",
+						PodRef:  v1.ObjectReference{Name: pod.Name, Namespace: pod.Namespace, UID: pod.UID},
+					}
+					instaslice.Status.PodAllocationResults[pod.UID] = inferencev1alpha1.AllocationResult{Nodename: "node-1", AllocationStatus: *status}
+				}
+			}
+			// a pod outside of the group is not counted
+			objects = append(objects, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}, instaslice)
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).WithStatusSubresource(instaslice).Build()
+
+			cfg := config.NewConfig()
+			r := &InstasliceReconciler{Client: fakeClient, Config: cfg, allocationCache: newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{})}
+			var instasliceList inferencev1alpha1.InstasliceList
+			assert.NoError(t, fakeClient.List(ctx, &instasliceList))
+
+			admitted, result, err := r.admitPodGroup(ctx, pods[0], &instasliceList)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantAdmitted, admitted)
+			assert.Equal(t, tt.wantRequeue, result.RequeueAfter > 0)
+
+			updated := &inferencev1alpha1.Instaslice{}
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, updated))
+			for uid, allocResult := range updated.Status.PodAllocationResults {
+				released := allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting
+				assert.Equal(t, tt.wantReleased, released, uid)
+			}
+			_, recorded := r.podGroupReleases["default/train"]
+			assert.Equal(t, tt.wantReleased, recorded)
+		})
+	}
+
+	t.Run("pods outside of a group are admitted", func(t *testing.T) {
+		r := &InstasliceReconciler{}
+		admitted, _, err := r.admitPodGroup(context.Background(), &v1.Pod{}, &inferencev1alpha1.InstasliceList{})
+		assert.NoError(t, err)
+		assert.True(t, admitted)
+	})
+}
+
+// groupPod returns a gated pod of the train group requesting a slice of the profile
+func groupPod(name, profile string, minMember int) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			UID:         types.UID(name),
+			Finalizers:  []string{FinalizerName},
+			Annotations: map[string]string{PodGroupAnnotation: "train", PodGroupMinMemberAnnotation: strconv.Itoa(minMember)},
+		},
+		Spec: v1.PodSpec{
+			SchedulingGates: []v1.PodSchedulingGate{{Name: GateName}},
+			Containers: []v1.Container{{Name: "main", Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{v1.ResourceName("nvidia.com/mig-" + profile): resource.MustParse("1")},
+			}}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodPending, Conditions: []v1.PodCondition{{
+			Type: v1.PodScheduled, Status: v1.ConditionFalse, Message: "Scheduling is blocked due to non-empty scheduling gates",
+		}}},
+	}
+}
+
+// groupFixture returns a reconciler on a node without allocations whose client holds the pods
+func groupFixture(t *testing.T, pods ...client.Object) (*InstasliceReconciler, *inferencev1alpha1.Instaslice) {
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("4"),
+			v1.ResourceMemory: resource.MustParse("8Gi"),
+		}},
+	}
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(scheme))
+	assert.NoError(t, inferencev1alpha1.AddToScheme(scheme))
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(pods, instaslice, node)...).
+		WithStatusSubresource(instaslice).Build()
+	resourceCache := rcache.NewResourceCache()
+	resourceCache.ResourceEventHandlerForNode().AddFunc(node)
+	return &InstasliceReconciler{Client: fakeClient, Config: config.NewConfig(), ResourceCache: resourceCache}, instaslice
+}
+
+func TestPlacePodGroup(t *testing.T) {
+	tests := []struct {
+		name        string
+		members     int
+		minMember   int
+		wantPlaced  int
+		wantWaiting bool
+	}{
+		{name: "placed all together", members: 3, minMember: 3, wantPlaced: 3},
+		{name: "none placed when a member fits nowhere", members: 5, minMember: 5},
+		{name: "waits for the members to be created", members: 2, minMember: 3, wantWaiting: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var objects []client.Object
+			for i := 0; i < tt.members; i++ {
+				objects = append(objects, groupPod("worker-"+strconv.Itoa(i), "3g.20gb", tt.minMember))
+			}
+			r, instaslice := groupFixture(t, objects...)
+			pod := objects[0].(*v1.Pod)
+			var instasliceList inferencev1alpha1.InstasliceList
+			assert.NoError(t, r.List(ctx, &instasliceList))
+
+			placement, err := r.placePod(ctx, pod, r.extractContainerRequests(pod), &instasliceList, instasliceList.Items, nil, &FirstFitPolicy{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantWaiting, placement.waitingForGroup)
+			// the reservations of the members are rolled back when one of them does not fit
+			assert.Equal(t, tt.wantPlaced, r.allocationCache.Len())
+			if tt.wantPlaced == 0 {
+				assert.Nil(t, placement.allocResult)
+				return
+			}
+			assert.Len(t, placement.members, tt.wantPlaced-1)
+
+			// the allocations of all the members are written together
+			_, err = r.commitPlacement(ctx, pod, placement, instasliceList.Items, &FirstFitPolicy{})
+			assert.NoError(t, err)
+			assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+			assert.Len(t, instaslice.Status.PodAllocationResults, tt.wantPlaced)
+			for _, object := range objects {
+				assert.False(t, r.allocationCache.Reserved(object.GetUID()))
+			}
+		})
+	}
+}
+
+func TestCommitPodGroupWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	objects := []client.Object{groupPod("worker-0", "3g.20gb", 2), groupPod("worker-1", "3g.20gb", 2)}
+	r, instaslice := groupFixture(t, objects...)
+	var patches int
+	r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			// the request of the second member is not written
+			if patches++; patches == 2 {
+				return errors.NewServiceUnavailable("unavailable")
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	})
+	pod := objects[0].(*v1.Pod)
+	var instasliceList inferencev1alpha1.InstasliceList
+	assert.NoError(t, r.List(ctx, &instasliceList))
+	placement, err := r.placePod(ctx, pod, r.extractContainerRequests(pod), &instasliceList, instasliceList.Items, nil, &FirstFitPolicy{})
+	assert.NoError(t, err)
+	assert.Len(t, placement.members, 1)
+
+	result, err := r.commitPlacement(ctx, pod, placement, instasliceList.Items, &FirstFitPolicy{})
+	assert.NoError(t, err)
+	assert.True(t, result.Requeue)
+
+	// the member written is released and the other one is not placed
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	assert.NotContains(t, instaslice.Status.PodAllocationResults, types.UID("worker-1"))
+	released := instaslice.Status.PodAllocationResults["worker-0"]
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, released.AllocationStatus.AllocationStatusController)
+	releasing := meta.FindStatusCondition(released.Conditions, inferencev1alpha1.AllocationConditionReleasing)
+	if assert.NotNil(t, releasing) {
+		assert.Equal(t, "PodGroupPlacementFailed", releasing.Reason)
+	}
+	cached, ok := r.allocationCache.Get("worker-0")
+	assert.True(t, ok)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, cached.AllocationStatus.AllocationStatusController)
+	_, ok = r.allocationCache.Get("worker-1")
+	assert.False(t, ok)
+	assert.False(t, r.allocationCache.Reserved("worker-0"))
+}
+
+func TestPlacePodWhilePodGroupReleases(t *testing.T) {
+	ctx := context.Background()
+	created := inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}
+	// the group never gets its third member and times out
+	workers := []*v1.Pod{groupPod("worker-0", "3g.20gb", 3), groupPod("worker-1", "3g.20gb", 3)}
+	for _, worker := range workers {
+		worker.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	}
+	other := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "model", Namespace: "default", UID: "model"}}
+	r, instaslice := newAllocationFixture(t, []testAllocation{
+		{pod: "worker-0", gpu: 0, profile: "3g.20gb", start: 0, size: 4, status: created},
+		{pod: "worker-1", gpu: 0, profile: "3g.20gb", start: 4, size: 4, status: created},
+	}, workers[0], workers[1], other)
+	node := &v1.Node{}
+	assert.NoError(t, r.Get(ctx, types.NamespacedName{Name: "node-1"}, node))
+	node.Status.NodeInfo.BootID = instaslice.Status.NodeResources.BootID
+	assert.NoError(t, r.Status().Update(ctx, node))
+	// the release of the group is held up in its first write
+	writing, release := make(chan struct{}), make(chan struct{})
+	var held atomic.Bool
+	r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+		SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			if held.CompareAndSwap(false, true) {
+				close(writing)
+				<-release
+			}
+			return c.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+		},
+	})
+
+	var instasliceList inferencev1alpha1.InstasliceList
+	assert.NoError(t, r.List(ctx, &instasliceList))
+	admitted := make(chan error, 1)
+	go func() {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(workers[0])})
+		admitted <- err
+	}()
+	select {
+	case <-writing:
+	case err := <-admitted:
+		t.Fatalf("the pod group was not released: %v", err)
+	}
+
+	// another pod is placed while the slices of the group are released
+	placed := make(chan *podPlacement, 1)
+	go func() {
+		var instasliceList inferencev1alpha1.InstasliceList
+		assert.NoError(t, r.List(ctx, &instasliceList))
+		placement, err := r.placePod(ctx, other, []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "1g.5gb"}}, &instasliceList, instasliceList.Items, nil, &FirstFitPolicy{})
+		assert.NoError(t, err)
+		placed <- placement
+	}()
+	select {
+	case placement := <-placed:
+		if assert.NotNil(t, placement.allocResult) {
+			assert.Equal(t, types.NodeName("node-1"), placement.allocResult.Nodename)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("no pod was placed while the pod group released its slices")
+	}
+	close(release)
+	assert.NoError(t, <-admitted)
+
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	for _, name := range []types.UID{"worker-0", "worker-1"} {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, instaslice.Status.PodAllocationResults[name].AllocationStatus.AllocationStatusController)
+	}
+}
+
+func TestRemoveReleasedAllocation(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = inferencev1alpha1.AddToScheme(scheme)
+	ctx := context.Background()
+	instaslice := &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: InstaSliceOperatorNamespace},
+		Spec: inferencev1alpha1.InstasliceSpec{PodAllocationRequests: map[types.UID]inferencev1alpha1.AllocationRequest{
+			"released": {Profile: "1g.5gb"},
+			"other":    {Profile: "1g.5gb"},
+		}},
+		Status: inferencev1alpha1.InstasliceStatus{PodAllocationResults: map[types.UID]inferencev1alpha1.AllocationResult{
+			"released": {AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusDeleted}},
+			"other":    {AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}},
+		}},
+	}
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instaslice).WithStatusSubresource(instaslice).Build()
+	r := &InstasliceReconciler{Client: fakeClient, allocationCache: newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{"released": {}})}
+
+	assert.NoError(t, r.removeReleasedAllocation(ctx, "node-1", "released"))
+	updated := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, updated))
+	assert.NotContains(t, updated.Spec.PodAllocationRequests, types.UID("released"))
+	assert.NotContains(t, updated.Status.PodAllocationResults, types.UID("released"))
+	assert.Contains(t, updated.Status.PodAllocationResults, types.UID("other"))
+	assert.NotContains(t, r.allocationCache.Snapshot(), types.UID("released"))
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// A pod can restrict its slices to GPU products with the gpu-model annotation and prefer some
+// products with the preferred-gpu-model annotation. Both take a comma separated list of regular
+// expressions matched against the whole GPU name discovered on the node, e.g.
+// "NVIDIA A100-SXM4-80GB,NVIDIA H100.*".
+
+// parseGPUModels parses the comma separated GPU model expressions of an annotation
+func parseGPUModels(annotation, value string) ([]*regexp.Regexp, error) {
+	var models []*regexp.Regexp
+	for _, expr := range strings.Split(value, ",") {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			continue
+		}
+		model, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %v", annotation, value, err)
+		}
+		models = append(models, model)
+	}
+	return models, nil
+}
+
+// podGPUModels returns the GPU models a pod requires and prefers, nil when it has no constraint
+func podGPUModels(pod *v1.Pod) (required, preferred []*regexp.Regexp, err error) {
+	if required, err = parseGPUModels(GPUModelAnnotation, pod.Annotations[GPUModelAnnotation]); err != nil {
+		return nil, nil, fmt.Errorf("%v, pod: %s", err, pod.Name)
+	}
+	if preferred, err = parseGPUModels(PreferredGPUModelAnnotation, pod.Annotations[PreferredGPUModelAnnotation]); err != nil {
+		return nil, nil, fmt.Errorf("%v, pod: %s", err, pod.Name)
+	}
+	return required, preferred, nil
+}
+
+// matchesGPUModel reports whether the GPU name matches one of the models
+func matchesGPUModel(models []*regexp.Regexp, gpuName string) bool {
+	for _, model := range models {
+		if model.MatchString(gpuName) {
+			return true
+		}
+	}
+	return false
+}
+
+// gpuModelCandidates returns the candidates whose GPU matches one of the models, all of them
+// when there are no models. The slots of the returned candidates are shared with candidates.
+func gpuModelCandidates(instaslice *inferencev1alpha1.Instaslice, candidates []GPUCandidate, models []*regexp.Regexp) []GPUCandidate {
+	if len(models) == 0 {
+		return candidates
+	}
+	gpuNames := make(map[string]string)
+	for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+		gpuNames[gpu.GPUUUID] = gpu.GPUName
+	}
+	var matching []GPUCandidate
+	for _, candidate := range candidates {
+		if name, ok := gpuNames[candidate.GPUUUID]; ok && matchesGPUModel(models, name) {
+			matching = append(matching, candidate)
+		}
+	}
+	return matching
+}
+
+// podGPUCandidates returns the candidates a pod may place its slices on, none when its
+// gpu-model annotation is invalid
+func podGPUCandidates(instaslice *inferencev1alpha1.Instaslice, pod *v1.Pod, candidates []GPUCandidate) []GPUCandidate {
+	required, _, err := podGPUModels(pod)
+	if err != nil {
+		return nil
+	}
+	return gpuModelCandidates(instaslice, candidates, required)
+}
+
+// hasGPUModel reports whether one of the GPUs of the node matches one of the models
+func hasGPUModel(instaslice *inferencev1alpha1.Instaslice, models []*regexp.Regexp) bool {
+	for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+		if matchesGPUModel(models, gpu.GPUName) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsatisfiableGPUModel returns why no node has a GPU of the model the pod requires, ok is false
+// when at least one node has one
+func unsatisfiableGPUModel(pod *v1.Pod, instaslices []inferencev1alpha1.Instaslice) (reason string, ok bool) {
+	required, _, err := podGPUModels(pod)
+	if err != nil {
+		return err.Error(), true
+	}
+	if len(required) == 0 {
+		return "", false
+	}
+	names := make(map[string]bool)
+	for i := range instaslices {
+		if hasGPUModel(&instaslices[i], required) {
+			return "", false
+		}
+		for _, gpu := range instaslices[i].Status.NodeResources.NodeGPUs {
+			names[gpu.GPUName] = true
+		}
+	}
+	available := make([]string, 0, len(names))
+	for name := range names {
+		available = append(available, name)
+	}
+	sort.Strings(available)
+	return fmt.Sprintf("no node has a GPU matching %s %q, available GPU models: %q",
+		GPUModelAnnotation, pod.Annotations[GPUModelAnnotation], available), true
+}
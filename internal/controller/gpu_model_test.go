@@ -0,0 +1,191 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	rcache "github.com/openshift/instaslice-operator/internal/controller/cache"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+const h100Name = "NVIDIA H100 80GB HBM3"
+
+// mixedGPUCapacity returns a node whose second GPU is an H100
+func mixedGPUCapacity(nodeName string) *inferencev1alpha1.Instaslice {
+	instaslice := utils.GenerateFakeCapacity(nodeName)
+	h100 := sortGPUs(instaslice)[1]
+	for i := range instaslice.Status.NodeResources.NodeGPUs {
+		if instaslice.Status.NodeResources.NodeGPUs[i].GPUUUID == h100 {
+			instaslice.Status.NodeResources.NodeGPUs[i].GPUName = h100Name
+		}
+	}
+	return instaslice
+}
+
+func TestPodGPUModels(t *testing.T) {
+	tests := []struct {
+		name          string
+		annotation    string
+		matches       []string
+		doesNotMatch  []string
+		expectedError bool
+	}{
+		{name: "no annotation", matches: nil},
+		{name: "exact name", annotation: h100Name, matches: []string{h100Name}, doesNotMatch: []string{"NVIDIA A100-PCIE-40GB", h100Name + " NVL"}},
+		{name: "list", annotation: "NVIDIA A100-PCIE-40GB, " + h100Name, matches: []string{h100Name, "NVIDIA A100-PCIE-40GB"}},
+		{name: "regex", annotation: "NVIDIA A100.*80GB", matches: []string{"NVIDIA A100-SXM4-80GB"}, doesNotMatch: []string{"NVIDIA A100-PCIE-40GB"}},
+		{name: "invalid regex", annotation: "NVIDIA (A100", expectedError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Annotations: map[string]string{GPUModelAnnotation: tt.annotation}}}
+			required, preferred, err := podGPUModels(pod)
+			if tt.expectedError {
+				assert.ErrorContains(t, err, "invalid "+GPUModelAnnotation)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Empty(t, preferred)
+			if tt.annotation == "" {
+				assert.Empty(t, required)
+			}
+			for _, name := range tt.matches {
+				assert.True(t, matchesGPUModel(required, name), name)
+			}
+			for _, name := range tt.doesNotMatch {
+				assert.False(t, matchesGPUModel(required, name), name)
+			}
+		})
+	}
+}
+
+func TestFindNodeAndDeviceForASliceGPUModel(t *testing.T) {
+	ctx := context.Background()
+	instaslice := mixedGPUCapacity("node-1")
+	gpus := sortGPUs(instaslice)
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(scheme))
+	assert.NoError(t, inferencev1alpha1.AddToScheme(scheme))
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("4"),
+			v1.ResourceMemory: resource.MustParse("8Gi"),
+		}},
+	}
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instaslice, node).Build()
+	resourceCache := rcache.NewResourceCache()
+	resourceCache.ResourceEventHandlerForNode().AddFunc(node)
+	// the H100 is full
+	fullH100 := map[types.UID]inferencev1alpha1.AllocationResult{
+		"running": {GPUUUID: gpus[1], Nodename: "node-1", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 8}},
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		allocations map[types.UID]inferencev1alpha1.AllocationResult
+		expectedGPU string
+	}{
+		{name: "no constraint uses the first GPU", expectedGPU: gpus[0]},
+		{name: "required model", annotations: map[string]string{GPUModelAnnotation: "NVIDIA H100.*"}, expectedGPU: gpus[1]},
+		{name: "preferred model", annotations: map[string]string{PreferredGPUModelAnnotation: "NVIDIA H100.*"}, expectedGPU: gpus[1]},
+		{name: "preferred model is full", annotations: map[string]string{PreferredGPUModelAnnotation: "NVIDIA H100.*"}, allocations: fullH100, expectedGPU: gpus[0]},
+		{name: "required model is full", annotations: map[string]string{GPUModelAnnotation: "NVIDIA H100.*"}, allocations: fullH100},
+		{name: "invalid required model", annotations: map[string]string{GPUModelAnnotation: "NVIDIA (H100"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocations := tt.allocations
+			if allocations == nil {
+				allocations = map[types.UID]inferencev1alpha1.AllocationResult{}
+			}
+			r := &InstasliceReconciler{Client: fakeClient, Config: config.NewConfig(), ResourceCache: resourceCache, allocationCache: newAllocationStore(allocations)}
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default", UID: "p", Annotations: tt.annotations}}
+			containers := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "1g.5gb"}}
+			_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, containers, &FirstFitPolicy{}, pod)
+			if tt.expectedGPU == "" {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedGPU, allocResult.GPUUUID)
+			placed := meta.FindStatusCondition(allocResult.Conditions, inferencev1alpha1.AllocationConditionPlaced)
+			if assert.NotNil(t, placed) {
+				assert.Equal(t, metav1.ConditionTrue, placed.Status)
+				assert.Contains(t, placed.Message, tt.expectedGPU)
+			}
+		})
+	}
+}
+
+func TestSortInstaslicesPreferredGPUModel(t *testing.T) {
+	nodeA := utils.GenerateFakeCapacity("node-a")
+	nodeB := mixedGPUCapacity("node-b")
+	for i := range nodeB.Status.NodeResources.NodeGPUs {
+		nodeB.Status.NodeResources.NodeGPUs[i].GPUUUID += "-node-b"
+	}
+	r := &InstasliceReconciler{Config: config.NewConfig(), allocationCache: newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{})}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Annotations: map[string]string{PreferredGPUModelAnnotation: h100Name}}}
+	names := func(instaslices []inferencev1alpha1.Instaslice) []string {
+		var names []string
+		for _, instaslice := range instaslices {
+			names = append(names, instaslice.Name)
+		}
+		return names
+	}
+
+	instaslices := []inferencev1alpha1.Instaslice{*nodeA, *nodeB}
+	r.sortInstaslicesForPod(context.Background(), pod, instaslices)
+	assert.Equal(t, []string{"node-b", "node-a"}, names(instaslices))
+
+	// a full H100 is not worth trying first
+	r.allocationCache.Set("running", inferencev1alpha1.AllocationResult{GPUUUID: sortGPUs(nodeB)[1], Nodename: "node-b", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 8}})
+	r.sortInstaslicesForPod(context.Background(), pod, instaslices)
+	assert.Equal(t, []string{"node-a", "node-b"}, names(instaslices))
+}
+
+func TestUnsatisfiableGPUModel(t *testing.T) {
+	instaslices := []inferencev1alpha1.Instaslice{*utils.GenerateFakeCapacity("node-a"), *mixedGPUCapacity("node-b")}
+	pod := func(annotation string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Annotations: map[string]string{GPUModelAnnotation: annotation}}}
+	}
+
+	_, unsatisfiable := unsatisfiableGPUModel(pod(""), instaslices)
+	assert.False(t, unsatisfiable)
+	_, unsatisfiable = unsatisfiableGPUModel(pod("NVIDIA H100.*"), instaslices)
+	assert.False(t, unsatisfiable)
+	reason, unsatisfiable := unsatisfiableGPUModel(pod("NVIDIA B200"), instaslices)
+	assert.True(t, unsatisfiable)
+	assert.Equal(t, `no node has a GPU matching instaslice.redhat.com/gpu-model "NVIDIA B200", available GPU models: ["NVIDIA A100-PCIE-40GB" "NVIDIA H100 80GB HBM3"]`, reason)
+	reason, unsatisfiable = unsatisfiableGPUModel(pod("NVIDIA (B200"), instaslices)
+	assert.True(t, unsatisfiable)
+	assert.Contains(t, reason, "invalid "+GPUModelAnnotation)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// GPUSlots is a bitmap of the memory slots of a single GPU, true marks an allocated slot.
+// The number of slots depends on the GPU model (4 on A30, 8 on A100 and H100) and is
+// derived from the discovered MigPlacement, see gpuSlotCount.
+type GPUSlots []bool
+
+// NewGPUSlots returns a bitmap of n free slots
+func NewGPUSlots(n int32) GPUSlots {
+	if n < 0 {
+		n = 0
+	}
+	return make(GPUSlots, n)
+}
+
+// Len returns the number of slots on the GPU
+func (s GPUSlots) Len() int32 {
+	return int32(len(s))
+}
+
+// FreeCount returns the number of unallocated slots
+func (s GPUSlots) FreeCount() int32 {
+	var free int32
+	for _, allocated := range s {
+		if !allocated {
+			free++
+		}
+	}
+	return free
+}
+
+// IsFree reports whether the size slots beginning at start exist and are all unallocated
+func (s GPUSlots) IsFree(start, size int32) bool {
+	if start < 0 || size <= 0 || start+size > s.Len() {
+		return false
+	}
+	for i := start; i < start+size; i++ {
+		if s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Allocate marks the size slots beginning at start as allocated, slots outside of
+// the GPU are ignored.
+func (s GPUSlots) Allocate(start, size int32) {
+	for i := start; i < start+size; i++ {
+		if i >= 0 && i < s.Len() {
+			s[i] = true
+		}
+	}
+}
+
+// Release marks the size slots beginning at start as free, slots outside of the GPU are ignored.
+func (s GPUSlots) Release(start, size int32) {
+	for i := start; i < start+size; i++ {
+		if i >= 0 && i < s.Len() {
+			s[i] = false
+		}
+	}
+}
+
+// Clone returns a copy that can be modified without touching s
+func (s GPUSlots) Clone() GPUSlots {
+	clone := make(GPUSlots, len(s))
+	copy(clone, s)
+	return clone
+}
+
+// FreeBlockLength returns the length of the run of free slots that contains start,
+// 0 if start is allocated or out of range.
+func (s GPUSlots) FreeBlockLength(start int32) int32 {
+	if !s.IsFree(start, 1) {
+		return 0
+	}
+	first, last := start, start
+	for first > 0 && !s[first-1] {
+		first--
+	}
+	for last < s.Len()-1 && !s[last+1] {
+		last++
+	}
+	return last - first + 1
+}
+
+// gpuSlotCount returns the number of memory slots of the GPUs on a node, which is the
+// end of the furthest placement discovered for any profile.
+func gpuSlotCount(instaslice *inferencev1alpha1.Instaslice) int32 {
+	var count int32
+	for _, mig := range instaslice.Status.NodeResources.MigPlacement {
+		for _, p := range mig.Placements {
+			if end := p.Start + p.Size; end > count {
+				count = end
+			}
+		}
+	}
+	return count
+}
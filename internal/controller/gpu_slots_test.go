@@ -0,0 +1,126 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// parseGPUSlots builds a bitmap of n slots from a string where 'x' marks an allocated slot
+func parseGPUSlots(allocated string, n int32) GPUSlots {
+	slots := NewGPUSlots(n)
+	for i, c := range allocated {
+		slots[i] = c == 'x'
+	}
+	return slots
+}
+
+// fakeA30Instaslice returns a node with a single A30, which has 4 memory slots
+func fakeA30Instaslice() *inferencev1alpha1.Instaslice {
+	return &inferencev1alpha1.Instaslice{
+		Status: inferencev1alpha1.InstasliceStatus{
+			NodeResources: inferencev1alpha1.DiscoveredNodeResources{
+				NodeGPUs: []inferencev1alpha1.DiscoveredGPU{
+					{GPUUUID: "GPU-a30", GPUName: "NVIDIA A30"},
+				},
+				MigPlacement: map[string]inferencev1alpha1.Mig{
+					"1g.6gb": {Placements: []inferencev1alpha1.Placement{
+						{Size: 1, Start: 0}, {Size: 1, Start: 1}, {Size: 1, Start: 2}, {Size: 1, Start: 3},
+					}},
+					"2g.12gb": {Placements: []inferencev1alpha1.Placement{{Size: 2, Start: 0}, {Size: 2, Start: 2}}},
+					"4g.24gb": {Placements: []inferencev1alpha1.Placement{{Size: 4, Start: 0}}},
+				},
+			},
+		},
+	}
+}
+
+func TestGPUSlotCount(t *testing.T) {
+	assert.Equal(t, int32(8), gpuSlotCount(utils.GenerateFakeCapacity("fake-node")))
+	assert.Equal(t, int32(4), gpuSlotCount(fakeA30Instaslice()))
+	assert.Equal(t, int32(0), gpuSlotCount(&inferencev1alpha1.Instaslice{}))
+}
+
+func TestGPUSlots(t *testing.T) {
+	slots := parseGPUSlots("x..x....", 8)
+	assert.True(t, slots.IsFree(1, 2))
+	assert.False(t, slots.IsFree(1, 3))
+	assert.False(t, slots.IsFree(6, 4), "placement past the last slot")
+	assert.False(t, slots.IsFree(-1, 1))
+	assert.False(t, slots.IsFree(4, 0))
+	assert.Equal(t, int32(2), slots.FreeBlockLength(2))
+	assert.Equal(t, int32(4), slots.FreeBlockLength(7))
+	assert.Equal(t, int32(0), slots.FreeBlockLength(0))
+
+	clone := slots.Clone()
+	clone.Allocate(6, 4)
+	assert.Equal(t, parseGPUSlots("x..x..xx", 8), clone)
+	assert.Equal(t, parseGPUSlots("x..x....", 8), slots)
+}
+
+func TestGetStartIndexFromAllocationResults_A30(t *testing.T) {
+	instaslice := fakeA30Instaslice()
+	tests := []struct {
+		name      string
+		profile   string
+		allocated string
+		wantStart int32
+		wantOk    bool
+	}{
+		{name: "empty GPU", profile: "4g.24gb", allocated: "....", wantStart: 0, wantOk: true},
+		{name: "second half free", profile: "2g.12gb", allocated: "x...", wantStart: 2, wantOk: true},
+		{name: "last slot free", profile: "1g.6gb", allocated: "xxx.", wantStart: 3, wantOk: true},
+		{name: "no contiguous block", profile: "2g.12gb", allocated: ".x.x", wantOk: false},
+		{name: "full GPU", profile: "1g.6gb", allocated: "xxxx", wantOk: false},
+		{name: "profile of another GPU model", profile: "7g.40gb", allocated: "....", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &InstasliceReconciler{}
+			start, ok := r.getStartIndexFromAllocationResults(instaslice, tt.profile, parseGPUSlots(tt.allocated, 4), nil, true)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantStart, start)
+		})
+	}
+}
+
+func TestCalculateProfileFitOnGPU_A30(t *testing.T) {
+	instaslice := fakeA30Instaslice()
+	r := &InstasliceReconciler{
+		allocationCache: newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{
+			"pod-1": {GPUUUID: "GPU-a30", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}},
+			"pod-2": {
+				GPUUUID:          "GPU-a30",
+				MigPlacement:     inferencev1alpha1.Placement{Start: 2, Size: 2},
+				AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusDeleted},
+			},
+		}),
+	}
+	assert.Equal(t, parseGPUSlots("x...", 4), r.gpuAllocatedSlices(instaslice, "GPU-a30"))
+
+	for profile, want := range map[string]int32{"1g.6gb": 3, "2g.12gb": 1, "4g.24gb": 0} {
+		fit, err := r.calculateProfileFitOnGPU(instaslice, profile, "GPU-a30", true, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, want, fit, profile)
+	}
+}
@@ -20,10 +20,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"math/rand"
 	"regexp"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/manifestival/manifestival"
@@ -39,14 +38,17 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -55,37 +57,55 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // InstasliceReconciler reconciles a Instaslice object
 type InstasliceReconciler struct {
 	client.Client
+	// mu guards the in-memory state of the placements but the allocation cache. Placements hold
+	// it to copy the placementState and to record their outcome, the slices are placed and
+	// reserved under the slot locks of the allocation cache. It is not held while the API is read
+	// or Instaslices are written.
+	mu sync.Mutex
+	placementState
 	Scheme             *runtime.Scheme
 	kubeClient         *kubernetes.Clientset
 	Config             *config.Config
 	RunningOnOpenShift bool
-	allocationCache    map[types.UID]inferencev1alpha1.AllocationResult
+	allocationCache    *allocationStore
 	isCacheInitialized bool
+	// podGroupReleases records when a pod group last released its slices after a timeout
+	podGroupReleases map[string]time.Time
+	// groupLocks keep two workers from placing the members of the same pod group at once
+	groupLocks keyedLocks[string]
+	// pending orders the gated pods waiting for slices
+	pending *pendingQueue
+	// wakeups reconciles pending pods once slices they fit in are released
+	wakeups chan event.GenericEvent
+	// recovered is closed once the leader recovered its state, elected once it is the leader
+	recovered chan struct{}
+	elected   <-chan struct{}
+	// reservationUpdates reconciles the reservations whose slices were bound or released
+	reservationUpdates chan event.GenericEvent
+	// allocationStates records when allocations entered their status, to time out the ones
+	// the daemonset does not move
+	allocationStates map[types.UID]allocationState
+	// nodeProgress is the last time the daemonset of a node moved one of its allocations
+	nodeProgress map[types.NodeName]time.Time
+	// timedOutConditions are carried over to the next allocation of pods whose allocation was withdrawn
+	timedOutConditions map[types.UID]metav1.Condition
+	// orphans records when the orphan collector first saw each orphan, by kind and key
+	orphans map[string]time.Time
+	// configMapNamespaces are the namespaces the orphan collector last found ConfigMaps of the
+	// daemonset in, which are swept again after their pods and allocations are gone
+	configMapNamespaces map[string]bool
 	// Optional override for testing
 	createDSFn    func(namespace string) *appsv1.DaemonSet
 	ResourceCache *rcache.ResourceCache
+	Recorder      record.EventRecorder
 }
 
-// AllocationPolicy interface with a single method
-type AllocationPolicy interface {
-	SetAllocationDetails(profileName string, newStart, size int32, podUUID types.UID, nodename types.NodeName, allocationStatus inferencev1alpha1.AllocationStatus,
-		discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32, namespace string, podName string, gpuUuid string, resourceIndetifier types.UID) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult)
-}
-
-// not implemented
-type RightToLeftPolicy struct{}
-
-// not implemented
-type LeftToRightPolicy struct{}
-
-// first fit policy is implemented at the moment
-type FirstFitPolicy struct{}
-
 var daemonSetlabel = map[string]string{"app": "controller-daemonset"}
 
 type NodeReconciler struct {
@@ -96,9 +116,14 @@ type NodeReconciler struct {
 //+kubebuilder:rbac:groups=inference.redhat.com,resources=instaslices/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=inference.redhat.com,resources=instaslices/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
+//+kubebuilder:rbac:groups="",resources=pods/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;update;patch;watch
 //+kubebuilder:rbac:groups="",resources=nodes/status,verbs=get;list;update;patch;watch
 //+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=apps,resources=replicasets;statefulsets,verbs=get;list;watch
 //+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;delete
 //+kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=list
 //+kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,verbs=create;update;get;watch
@@ -197,6 +222,10 @@ func (r *NodeReconciler) cleanUpNodeResources(ctx context.Context, node *v1.Node
 // instalice reconciler
 func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := logr.FromContext(ctx)
+	// no slice is placed before the leader recovered its state
+	if !r.isRecovered() {
+		return ctrl.Result{RequeueAfter: Requeue1sDelay}, nil
+	}
 	if r.RunningOnOpenShift {
 		err := r.ReconcileSCC(ctx)
 		if err != nil {
@@ -216,7 +245,7 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		for _, condition := range node.Status.Conditions {
 			if condition.Type == v1.NodeReady && condition.Status != v1.ConditionTrue {
 				log.Info("Detected a node going down", "node", node.Name)
-				if err := r.rebuildAllocationCache(ctx); err != nil {
+				if err := r.reloadAllocationCache(ctx); err != nil {
 					return ctrl.Result{}, err
 				}
 				break
@@ -224,14 +253,21 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 	// Continue with the rest of the reconciliation logic
-	policy := &FirstFitPolicy{}
+	policy := r.allocationPolicy(ctx)
 	pod := &v1.Pod{}
 	var instasliceList inferencev1alpha1.InstasliceList
 	if err = r.List(ctx, &instasliceList, &client.ListOptions{}); err != nil {
 		log.Error(err, "Error getting Instaslice object")
 		return ctrl.Result{}, err
 	}
-
+	r.mu.Lock()
+	r.observeAllocationStates(instasliceList.Items, time.Now())
+	r.mu.Unlock()
+
+	// no slice is placed on unavailable nodes, the pods allocated on a node out of sync with its
+	// instaslice wait for the daemonset to discover the node again
+	unavailableNodes := make(map[string]error)
+	var availableInstaslices []inferencev1alpha1.Instaslice
 	for _, instaslice := range instasliceList.Items {
 		// Get the node object on which the instaslice object is present
 		node := &v1.Node{}
@@ -239,28 +275,38 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			log.Error(err, "error getting the node object", "name", instaslice.Name)
 			return ctrl.Result{RequeueAfter: Requeue1sDelay}, err
 		}
-
-		if instaslice.Status.NodeResources.BootID == "" {
-			log.Info("Instaslice boot ID not yet populated, requeuing", "node", node.Name)
-			return ctrl.Result{RequeueAfter: Requeue5sDelay}, nil
-		}
-
-		if instaslice.Status.NodeResources.BootID != node.Status.NodeInfo.BootID {
-			err := fmt.Errorf("instaslice not in sync with the node as the boot id doesn't match")
-			log.Error(err, "instaslice's boot id not matching node's boot id", "node's boot id", node.Status.NodeInfo.BootID, "instaslice's boot id", instaslice.Status.NodeResources.BootID)
-			return ctrl.Result{RequeueAfter: Requeue10sDelay}, err
+		if err := instasliceNodeUnavailable(&instaslice, node); err != nil {
+			log.Info("Instaslice node is unavailable", "node", node.Name, "reason", err.Error())
+			unavailableNodes[instaslice.Name] = err
+			continue
 		}
+		availableInstaslices = append(availableInstaslices, instaslice)
 	}
 
 	err = r.Get(ctx, req.NamespacedName, pod)
 	if err != nil {
 		// Error fetching the Pod
 		if errors.IsNotFound(err) {
+			// pods behind a deleted pod may go now
+			nodes := r.readNodes(ctx, instasliceList.Items)
+			r.mu.Lock()
+			if r.pendingQueue().remove(req.NamespacedName) {
+				r.wakePendingPods(ctx, instasliceList.Items, nodes, policy)
+			}
+			r.mu.Unlock()
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "unable to fetch pod")
 		return ctrl.Result{}, nil
 	}
+	for _, instaslice := range instasliceList.Items {
+		if err, unavailable := unavailableNodes[instaslice.Name]; unavailable && rejectionReason(err) == RejectionBootIDMismatch {
+			if _, allocated := instaslice.Status.PodAllocationResults[pod.UID]; allocated {
+				log.Error(err, "the node of the allocation of the pod is not in sync, requeuing", "pod", pod.Name, "node", instaslice.Name)
+				return ctrl.Result{RequeueAfter: Requeue10sDelay}, nil
+			}
+		}
+	}
 	// Pods with scheduling gates other than the InstaSlice gate are not ready to be scheduled and should be ignored
 	if isPodGatedByOthers(pod) {
 		return ctrl.Result{}, nil
@@ -293,6 +339,7 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 						return ctrl.Result{RequeueAfter: Requeue2sDelay}, nil
 					}
 					if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated || allocation.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusUngated {
+						r.markReleasing(instaslice.Name, &allocRequest, &allocation, "PodFailed", "the pod failed")
 						resultDeleting, err := r.setInstasliceAllocationToDeleting(ctx, instaslice.Name, &allocation, &allocRequest)
 						if err != nil {
 							return resultDeleting, nil
@@ -306,9 +353,10 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 						if err != nil {
 							return ctrl.Result{}, err
 						}
-						r.CleanupOrphanedAllocations(ctx, &instasliceList)
+						// slices were released, wake up the pending pods that fit now
+						r.releaseAllocations(ctx, &instasliceList, policy)
 						// update DeployedPodTotal Metrics by setting value to 0 as pod allocation is deleted and pod is no loger consuming slices
-						r.UpdateDeployedPodTotalMetrics(string(allocation.Nodename), allocation.GPUUUID, allocRequest.PodRef.Namespace, allocRequest.PodRef.Name, allocRequest.Profile, 0)
+						r.ResetDeployedPodTotalMetrics(&allocation, &allocRequest)
 						// update compatible profiles metrics
 						r.UpdateCompatibleProfilesMetrics(instaslice, instaslice.Name)
 						// requeue for the finalizer to be removed
@@ -338,6 +386,7 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 					allocRequest := instaslice.Spec.PodAllocationRequests[uuid]
 					if allocation.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted {
 						log.Info("setting status to deleting", "pod", pod.Name)
+						r.markReleasing(instaslice.Name, &allocRequest, &allocation, "PodSucceeded", "the pod completed")
 						result, err := r.setInstasliceAllocationToDeleting(ctx, instaslice.Name, &allocation, &allocRequest)
 						if err != nil {
 							return result, err
@@ -352,9 +401,10 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 						if err != nil {
 							return ctrl.Result{}, err
 						}
-						r.CleanupOrphanedAllocations(ctx, &instasliceList)
+						// slices were released, wake up the pending pods that fit now
+						r.releaseAllocations(ctx, &instasliceList, policy)
 						// update DeployedPodTotal Metrics by setting value to 0 as pod allocation is deleted and pod is no loger consuming slices
-						r.UpdateDeployedPodTotalMetrics(string(allocation.Nodename), allocation.GPUUUID, allocRequest.PodRef.Namespace, allocRequest.PodRef.Name, allocRequest.Profile, 0)
+						r.ResetDeployedPodTotalMetrics(&allocation, &allocRequest)
 						// update compatible profiles metrics
 						r.UpdateCompatibleProfilesMetrics(instaslice, instaslice.Name)
 						// requeue for the finalizer to be removed
@@ -379,13 +429,16 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	// handle deleted pod that never gets ungated
 	// set allocation status to deleting to cleanup resources if any
 	if !pod.DeletionTimestamp.IsZero() && isPodGated {
+		r.mu.Lock()
+		r.pendingQueue().remove(req.NamespacedName)
+		r.mu.Unlock()
 		// allocation can be in creating or created while the user deletes the pod.
 		for _, instaslice := range instasliceList.Items {
 			for podUuid, allocation := range instaslice.Status.PodAllocationResults {
 				allocRequest := instaslice.Spec.PodAllocationRequests[podUuid]
 				if podUuid == pod.UID && (allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated) {
-					allocation.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
-					if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &allocation, &allocRequest); err != nil {
+					r.markReleasing(instaslice.Name, &allocRequest, &allocation, "PodDeleted", "the pod was deleted before it was ungated")
+					if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, instaslice.Name, &allocation, &allocRequest); err != nil {
 						log.Info("unable to set instaslice to state deleted for ungated", "pod", pod.Name)
 						return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
 					}
@@ -396,9 +449,10 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 					if err != nil {
 						return ctrl.Result{}, err
 					}
-					r.CleanupOrphanedAllocations(ctx, &instasliceList)
+					// slices were released, wake up the pending pods that fit now
+					r.releaseAllocations(ctx, &instasliceList, policy)
 					// update DeployedPodTotal Metrics by setting value to 0 as pod allocation is deleted and pod is no loger consuming slices
-					r.UpdateDeployedPodTotalMetrics(string(allocation.Nodename), allocation.GPUUUID, allocRequest.PodRef.Namespace, allocRequest.PodRef.Name, allocRequest.Profile, 0)
+					r.ResetDeployedPodTotalMetrics(&allocation, &allocRequest)
 					// update compatible profiles metrics
 					r.UpdateCompatibleProfilesMetrics(instaslice, instaslice.Name)
 					if controllerutil.RemoveFinalizer(pod, FinalizerName) {
@@ -423,7 +477,7 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 					if podUuid == pod.UID {
 						if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
 							allocRequest := instaslice.Spec.PodAllocationRequests[podUuid]
-							err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &allocation, &allocRequest)
+							err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, instaslice.Name, &allocation, &allocRequest)
 							if err != nil {
 								return ctrl.Result{}, err
 							}
@@ -431,17 +485,18 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 							if err != nil {
 								return resultRemove, err
 							}
-							r.CleanupOrphanedAllocations(ctx, &instasliceList)
+							// slices were released, wake up the pending pods that fit now
+							r.releaseAllocations(ctx, &instasliceList, policy)
 							// update DeployedPodTotal Metrics by setting value to 0 as pod allocation is deleted and pod is no loger consuming slices
-							r.UpdateDeployedPodTotalMetrics(string(allocation.Nodename), allocation.GPUUUID, allocRequest.PodRef.Namespace, allocRequest.PodRef.Name, allocRequest.Profile, 0)
+							r.ResetDeployedPodTotalMetrics(&allocation, &allocRequest)
 							// update compatible profiles metrics
 							r.UpdateCompatibleProfilesMetrics(instaslice, instaslice.Name)
 						}
 						elapsed := time.Since(pod.DeletionTimestamp.Time)
 						if elapsed > 30*time.Second {
-							allocation.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
 							allocRequest := instaslice.Spec.PodAllocationRequests[podUuid]
-							if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &allocation, &allocRequest); err != nil {
+							r.markReleasing(instaslice.Name, &allocRequest, &allocation, "PodDeleted", "the pod was deleted")
+							if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, instaslice.Name, &allocation, &allocRequest); err != nil {
 								log.Info("unable to set instaslice to state deleted for ", "pod", pod.Name)
 								return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
 							}
@@ -466,42 +521,78 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		if len(pod.Spec.Containers) == 0 {
 			return ctrl.Result{}, fmt.Errorf(noContainerInsidePodErr+", pod: %v", pod.Name)
 		}
-		// Assume pod only has one container with one GPU requests
-		if len(pod.Spec.Containers) != 1 {
-			return ctrl.Result{}, fmt.Errorf(multipleContainersUnsupportedErr+", pod: %v", pod.Name)
+		containers := r.extractContainerRequests(pod)
+		if len(containers) == 0 {
+			return ctrl.Result{}, fmt.Errorf(noGPUContainerInsidePodErr+", pod: %v", pod.Name)
+		}
+		if _, _, err := podGroupOf(pod); err != nil {
+			return ctrl.Result{}, err
+		}
+		if _, _, err := podGPUModels(pod); err != nil {
+			return ctrl.Result{}, err
 		}
-		limits := pod.Spec.Containers[0].Resources.Limits
-		profileName := r.extractProfileName(limits)
 		var podHasNodeAllocation bool
 		// search if pod has allocation in any of the instaslice object in the cluster
 		// TODO: allocations may get slower as the cluster size increases
 		for _, instaslice := range instasliceList.Items {
 			for uuid := range instaslice.Spec.PodAllocationRequests {
-				// no matter the state if allocations exists for a pod skip such a pod
-				if uuid == pod.UID {
-					podHasNodeAllocation = true
+				if uuid != pod.UID {
+					continue
+				}
+				// a write that failed after the request left it without a result, it holds no
+				// slots and is removed before the pod is placed again. Results written since are
+				// in the allocation cache before they reach the informer.
+				_, hasResult := instaslice.Status.PodAllocationResults[uuid]
+				if _, cached := r.allocationCache.Get(uuid); !hasResult && !cached {
+					log.Info("removing the allocation request without result", "pod", pod.Name, "instaslice", instaslice.Name)
+					if err := r.removeReleasedAllocation(ctx, instaslice.Name, uuid); err != nil {
+						return ctrl.Result{}, err
+					}
+					return ctrl.Result{Requeue: true}, nil
 				}
+				// no matter the state if allocations exists for a pod skip such a pod
+				podHasNodeAllocation = true
 			}
 		}
 
 		for _, instaslice := range instasliceList.Items {
 			for uuid, allocations := range instaslice.Status.PodAllocationResults {
+				// slices released by a pod group or an allocation that timed out are gone, allocate the pod again
+				if allocations.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted && uuid == pod.UID {
+					if err := r.removeReleasedAllocation(ctx, instaslice.Name, uuid); err != nil {
+						return ctrl.Result{}, err
+					}
+					r.mu.Lock()
+					r.keepTimedOutCondition(uuid, allocations)
+					r.mu.Unlock()
+					return ctrl.Result{Requeue: true}, nil
+				}
 				if allocations.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated && uuid == pod.UID {
+					// pods of a group wait until the slices of the whole group are created
+					admitted, result, err := r.admitPodGroup(ctx, pod, &instasliceList)
+					if err != nil || !admitted {
+						return result, err
+					}
 					allocations.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusUngated
+					allocations.SetCondition(inferencev1alpha1.AllocationConditionUngated, metav1.ConditionTrue, "PodUngated",
+						fmt.Sprintf("the pod was ungated onto node %s", allocations.Nodename))
 					allocRequest := instaslice.Spec.PodAllocationRequests[uuid]
-					if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &allocations, &allocRequest); err != nil {
+					if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, instaslice.Name, &allocations, &allocRequest); err != nil {
 						return ctrl.Result{Requeue: true}, err
 					}
-					result, err := r.addNodeSelectorAndUngatePod(ctx, pod, &allocations)
+					result, err = r.addNodeSelectorAndUngatePod(ctx, pod, &allocRequest, &allocations)
 					if err != nil {
 						return result, err
 					}
+					RecordAllocationEvent(r.Recorder, instaslice.Name, &allocRequest, v1.EventTypeNormal, EventReasonPodUngated,
+						fmt.Sprintf("the pod was ungated onto node %s", allocations.Nodename))
 					break
 				}
 				// InstaSlice object got updated with ungated status but the controller failed
 				// ungating the pod.
 				if allocations.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusUngated && uuid == pod.UID {
-					result, err := r.addNodeSelectorAndUngatePod(ctx, pod, &allocations)
+					allocRequest := instaslice.Spec.PodAllocationRequests[uuid]
+					result, err := r.addNodeSelectorAndUngatePod(ctx, pod, &allocRequest, &allocations)
 					if err != nil {
 						return result, err
 					}
@@ -519,55 +610,246 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		// pod does not have an allocation yet, make allocation
 		// find the node
 		if !podHasNodeAllocation {
-			sort.Slice(instasliceList.Items, func(i, j int) bool {
-				// Sort by Name in ascending order
-				return instasliceList.Items[i].Name < instasliceList.Items[j].Name
-			})
-			err := r.rebuildAllocationCache(ctx)
+			placement, err := r.placePod(ctx, pod, containers, &instasliceList, availableInstaslices, unavailableNodes, policy)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-
-			r.CleanupOrphanedAllocations(ctx, &instasliceList)
-			for _, instaslice := range instasliceList.Items {
-				// find the GPU on the node and the GPU index where the slice can be created
-				allocRequest, allocResult, err := r.findNodeAndDeviceForASlice(ctx, &instaslice, profileName, policy, pod)
-				if err != nil {
-					continue
-				}
-				podHasNodeAllocation = true
-				if podHasNodeAllocation {
-					err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, allocResult, allocRequest)
-					if err != nil {
-						return ctrl.Result{Requeue: true}, nil
-					}
-					// allocation was successful and hence update the cache with new allocation
-					r.updateCacheWithNewAllocation(allocRequest.PodRef.UID, *allocResult)
-					processedSlices, err := r.calculateProfileFitOnGPU(&instaslice, allocRequest.Profile, allocResult.GPUUUID, false, pod)
-					if err != nil {
-						log.Error(err, "failed to calculate processed GPU slices for profile %s: %w", allocRequest.Profile, err)
-					}
-					// update deployed pod total metrics
-					r.UpdateDeployedPodTotalMetrics(string(allocResult.Nodename), allocResult.GPUUUID, allocRequest.PodRef.Namespace, allocRequest.PodRef.Name, allocRequest.Profile, processedSlices)
-					// update total processed GPU slices metrics
-					r.IncrementTotalProcessedGpuSliceMetrics(string(allocResult.Nodename), allocResult.GPUUUID, profileName, processedSlices)
-					return ctrl.Result{}, nil
+			switch {
+			case placement.unsatisfiable != "":
+				// a pod requiring a GPU model no node has waits for such a node to join
+				log.Info("no node has the required GPU model", "pod", pod.Name, "reason", placement.unsatisfiable)
+				r.recordEvent(pod, v1.EventTypeWarning, EventReasonGPUModelUnsatisfiable, placement.unsatisfiable)
+				if err := r.setSlicesPlacedCondition(ctx, pod, v1.ConditionFalse, EventReasonGPUModelUnsatisfiable, placement.unsatisfiable); err != nil {
+					log.Error(err, "failed to set the condition of the pending pod", "pod", pod.Name)
 				}
+				return ctrl.Result{RequeueAfter: Requeue10sDelay}, nil
+			case placement.queued:
+				log.Info("waiting for pods ahead in the pending queue", "pod", pod.Name)
+				return ctrl.Result{RequeueAfter: Requeue10sDelay}, nil
+			case placement.waitingForGroup:
+				return ctrl.Result{RequeueAfter: Requeue5sDelay}, nil
+			case placement.allocResult != nil:
+				return r.commitPlacement(ctx, pod, placement, instasliceList.Items, policy)
 			}
-		}
 
-		// if the cluster does not have suitable node, requeue request
-		if !podHasNodeAllocation {
+			// if the cluster does not have suitable node, requeue request
 			log.Info("no suitable node found in cluster for ", "pod", pod.Name)
-			// Generate a random duration between 1 and 10 seconds
-			randomDuration := time.Duration(rand.Intn(10)+1) * time.Second
-			return ctrl.Result{RequeueAfter: randomDuration}, nil
+			if placement.preempting {
+				return ctrl.Result{RequeueAfter: Requeue2sDelay}, nil
+			}
+			message := noFitMessage(len(instasliceList.Items), placement.rejections)
+			r.recordEvent(pod, v1.EventTypeWarning, EventReasonNoFit, message)
+			if err := r.setSlicesPlacedCondition(ctx, pod, v1.ConditionFalse, EventReasonNoFit, message); err != nil {
+				log.Error(err, "failed to set the condition of the pending pod", "pod", pod.Name)
+			}
+			// the pod is woken up once slices it fits in are released
+			return ctrl.Result{RequeueAfter: Requeue10sDelay}, nil
 		}
 		return ctrl.Result{Requeue: true}, nil
 	}
 	return ctrl.Result{}, nil
 }
 
+// podPlacement is the outcome of placing the slices of a pod
+type podPlacement struct {
+	// instasliceName, allocRequest and allocResult are the allocation reserved for the pod
+	instasliceName string
+	allocRequest   *inferencev1alpha1.AllocationRequest
+	allocResult    *inferencev1alpha1.AllocationResult
+	// members are the allocations reserved along for the other members of the group of the pod,
+	// with pod set
+	members []*podPlacement
+	pod     *v1.Pod
+	// unsatisfiable tells why no node can take the pod until a node with its GPU model joins
+	unsatisfiable string
+	// queued is set when the pod waits for the pods ahead in the pending queue
+	queued bool
+	// waitingForGroup is set when the pod waits for more members of its group to be created
+	waitingForGroup bool
+	// preempting is set when pods are evicted to make room for the pod
+	preempting bool
+	// rejections tells why each node was rejected, for the event and the condition of a pod that fits nowhere
+	rejections map[string]error
+}
+
+// placePod finds the first node the slices of a pod fit on and reserves them in the allocation
+// cache. The Instaslices and nodes are read before r.mu is taken to check the pending queue, the
+// slices are placed from them by reservePlacement under the slot lock of each node tried, so that
+// pods are placed on different nodes in parallel. The reserved allocation is written by
+// commitPlacement. Pods of a group are placed with the other members of the group, pods that
+// fit nowhere may preempt pods of a lower priority.
+func (r *InstasliceReconciler) placePod(ctx context.Context, pod *v1.Pod, containers []inferencev1alpha1.ContainerRequest, instasliceList *inferencev1alpha1.InstasliceList,
+	availableInstaslices []inferencev1alpha1.Instaslice, unavailableNodes map[string]error, policy AllocationPolicy) (*podPlacement, error) {
+	log := logr.FromContext(ctx)
+	if err := r.ensureAllocationCache(ctx); err != nil {
+		return nil, err
+	}
+	r.CleanupOrphanedAllocations(ctx, instasliceList)
+	if reason, unsatisfiable := unsatisfiableGPUModel(pod, instasliceList.Items); unsatisfiable {
+		return &podPlacement{unsatisfiable: reason}, nil
+	}
+	if group, ok, err := podGroupOf(pod); ok {
+		if err != nil {
+			return nil, err
+		}
+		return r.placePodGroup(ctx, pod, containers, group, instasliceList, availableInstaslices, unavailableNodes, policy)
+	}
+	placement := &podPlacement{rejections: make(map[string]error)}
+	nodes := r.readPlacementNodes(ctx, pod, instasliceList.Items, unavailableNodes, placement.rejections)
+	queueNodes := r.readNodes(ctx, availableInstaslices)
+
+	r.mu.Lock()
+	allowed := r.mayAllocate(ctx, pod, containers, availableInstaslices, queueNodes, policy)
+	r.mu.Unlock()
+	if !allowed {
+		return &podPlacement{queued: true}, nil
+	}
+	if reserved := r.reservePlacement(ctx, pod, containers, policy, instasliceList.Items, nodes, placement.rejections); reserved != nil {
+		return reserved, nil
+	}
+
+	// evict lower priority pods to make room
+	preempting, err := r.preemptForPod(ctx, pod, containers, policy, availableInstaslices)
+	if err != nil {
+		log.Error(err, "preemption failed for ", "pod", pod.Name)
+	}
+	placement.preempting = preempting
+	return placement, nil
+}
+
+// readPlacementNodes reads the nodes of the available instaslices for the placement of a pod, the
+// others are rejected. It reads the API and must not run under r.mu.
+func (r *InstasliceReconciler) readPlacementNodes(ctx context.Context, pod *v1.Pod, instaslices []inferencev1alpha1.Instaslice, unavailableNodes map[string]error,
+	rejections map[string]error) map[string]*placementNode {
+	nodes := make(map[string]*placementNode, len(instaslices))
+	for i := range instaslices {
+		instaslice := &instaslices[i]
+		if err, unavailable := unavailableNodes[instaslice.Name]; unavailable {
+			rejections[instaslice.Name] = err
+			continue
+		}
+		node, err := r.readPlacementNode(ctx, instaslice, pod)
+		if err != nil {
+			rejections[instaslice.Name] = err
+			continue
+		}
+		nodes[instaslice.Name] = node
+	}
+	return nodes
+}
+
+// reservePlacement places the slices of a pod on the first of the nodes they fit on, in the order
+// the pod tries the instaslices, and reserves them in the allocation cache. The nodes are sorted
+// and the placementState copied under r.mu, the slices are placed and reserved under the slot
+// lock of each node. It returns nil when they fit nowhere, with the reason of every node in
+// rejections. It must not run under r.mu.
+func (r *InstasliceReconciler) reservePlacement(ctx context.Context, pod *v1.Pod, containers []inferencev1alpha1.ContainerRequest, policy AllocationPolicy,
+	instaslices []inferencev1alpha1.Instaslice, nodes map[string]*placementNode, rejections map[string]error) *podPlacement {
+	instaslices = append([]inferencev1alpha1.Instaslice(nil), instaslices...)
+	r.mu.Lock()
+	r.sortInstaslicesForPod(ctx, pod, instaslices)
+	state := r.copyPlacementState()
+	r.mu.Unlock()
+	for _, instaslice := range instaslices {
+		node, ok := nodes[instaslice.Name]
+		if !ok {
+			continue
+		}
+		// find the GPU on the node and the GPU index where the slice can be created
+		unlock := r.allocationCache.LockNodeSlots(types.NodeName(instaslice.Name))
+		allocRequest, allocResult, err := r.placeOnNode(node, state, containers, policy, pod)
+		if err == nil {
+			err = r.allocationCache.Reserve(pod.UID, *allocResult)
+		}
+		unlock()
+		if err != nil {
+			rejections[instaslice.Name] = err
+			continue
+		}
+		r.mu.Lock()
+		// reserved slices taken by the allocation are bound to the pod
+		r.syncReservedSlices()
+		r.carryTimedOutCondition(pod.UID, allocResult)
+		r.mu.Unlock()
+		return &podPlacement{instasliceName: instaslice.Name, allocRequest: allocRequest, allocResult: allocResult, pod: pod, rejections: rejections}
+	}
+	return nil
+}
+
+// commitPlacement writes the allocations reserved by placePod, for the pod and the members of its
+// group placed along, to the Instaslices of their nodes under the lock of each node, and commits
+// them to the allocation cache. The reservations are rolled back when a write fails, and the
+// allocations of the members written before are released.
+func (r *InstasliceReconciler) commitPlacement(ctx context.Context, pod *v1.Pod, placement *podPlacement, instaslices []inferencev1alpha1.Instaslice, policy AllocationPolicy) (ctrl.Result, error) {
+	log := logr.FromContext(ctx)
+	placement.pod = pod
+	placements := append([]*podPlacement{placement}, placement.members...)
+	var written int
+	var err error
+	for _, placement := range placements {
+		unlock := r.allocationCache.LockNode(placement.allocResult.Nodename)
+		err = utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, placement.instasliceName, placement.allocResult, placement.allocRequest)
+		unlock()
+		if err != nil {
+			break
+		}
+		written++
+	}
+
+	// the pending pods are woken up next to the new allocations
+	nodes := r.readNodes(ctx, instaslices)
+	r.mu.Lock()
+	if err != nil {
+		for _, placement := range placements[written:] {
+			r.allocationCache.Rollback(placement.pod.UID)
+		}
+		for _, placement := range placements[:written] {
+			r.allocationCache.Commit(placement.pod.UID)
+		}
+		r.syncReservedSlices()
+		r.mu.Unlock()
+		failed := placements[written]
+		log.Error(err, "failed to write the allocation, releasing its slices", "pod", failed.pod.Name, "node", failed.instasliceName)
+		r.releaseGroupPlacements(ctx, placements[:written])
+		return ctrl.Result{Requeue: true}, nil
+	}
+	// allocation was successful and hence update the cache with new allocation
+	for _, placement := range placements {
+		r.allocationCache.Commit(placement.pod.UID)
+		delete(r.nominations, placement.pod.UID)
+		r.releaseDefragHold(placement.allocResult)
+		r.pendingQueue().remove(types.NamespacedName{Namespace: placement.pod.Namespace, Name: placement.pod.Name})
+	}
+	r.wakePendingPods(ctx, instaslices, nodes, policy)
+	r.mu.Unlock()
+
+	for _, placement := range placements {
+		allocRequest, allocResult := placement.allocRequest, placement.allocResult
+		RecordAllocationEvent(r.Recorder, placement.instasliceName, allocRequest, v1.EventTypeNormal, EventReasonSlicesPlaced, placementMessage(allocRequest, allocResult))
+		if err := r.setSlicesPlacedCondition(ctx, placement.pod, v1.ConditionTrue, EventReasonSlicesPlaced, placementMessage(allocRequest, allocResult)); err != nil {
+			log.Error(err, "failed to set the condition of the placed pod", "pod", placement.pod.Name)
+		}
+		// slices of a pod may be spread over several GPUs of the node
+		for gpuUUID, processedSlices := range slotsPerGPU(allocResult) {
+			// update deployed pod total metrics
+			r.UpdateDeployedPodTotalMetrics(string(allocResult.Nodename), gpuUUID, allocRequest.PodRef.Namespace, allocRequest.PodRef.Name, allocRequest.Profile, processedSlices)
+			// update total processed GPU slices metrics
+			r.IncrementTotalProcessedGpuSliceMetrics(string(allocResult.Nodename), gpuUUID, allocRequest.Profile, processedSlices)
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// releaseAllocations drops the released allocations from the allocation cache and wakes up the
+// pending pods that fit in their slices
+func (r *InstasliceReconciler) releaseAllocations(ctx context.Context, instasliceList *inferencev1alpha1.InstasliceList, policy AllocationPolicy) {
+	r.CleanupOrphanedAllocations(ctx, instasliceList)
+	nodes := r.readNodes(ctx, instasliceList.Items)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.wakePendingPods(ctx, instasliceList.Items, nodes, policy)
+}
+
 // Initialize Prometheus-compatible profiles metrics when the controller starts
 // Adds a background goroutine that waits for Instaslice objects.
 // Proceeds to setupWithManager(mgr) to start the reconciler
@@ -646,6 +928,22 @@ func (r *InstasliceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return mgrAddErr
 	}
 
+	if err := mgr.Add(manager.RunnableFunc(r.runAllocationDeadlines)); err != nil {
+		return err
+	}
+
+	if r.Config.DefragEnable {
+		if err := mgr.Add(manager.RunnableFunc(r.runDefragmenter)); err != nil {
+			return err
+		}
+	}
+
+	if r.Config.OrphanGCEnable {
+		if err := mgr.Add(manager.RunnableFunc(r.runOrphanCollector)); err != nil {
+			return err
+		}
+	}
+
 	// Continue with setting up the controller
 	return r.setupWithManager(mgr) // Return error directly for readability
 }
@@ -658,9 +956,20 @@ func (r *InstasliceReconciler) setupWithManager(mgr ctrl.Manager) error {
 	if err != nil {
 		return err
 	}
+	r.wakeups = make(chan event.GenericEvent, wakeupBufferSize)
+	r.recovered = make(chan struct{})
+	r.elected = mgr.Elected()
+	if err := mgr.Add(manager.RunnableFunc(r.recoverState)); err != nil {
+		return err
+	}
+	if r.allocationCache == nil {
+		r.allocationCache = newAllocationStore(nil)
+	}
 	err = ctrl.NewControllerManagedBy(mgr).
 		For(&v1.Pod{}).Named("InstaSlice-controller").
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.Config.MaxConcurrentReconciles}).
 		Watches(&inferencev1alpha1.Instaslice{}, handler.EnqueueRequestsFromMapFunc(r.podMapFunc)).
+		WatchesRawSource(source.Channel(r.wakeups, &handler.EnqueueRequestForObject{})).
 		Complete(r)
 	if err != nil {
 		log := mgr.GetLogger() // Get logger from the manager
@@ -684,6 +993,14 @@ func (r *InstasliceReconciler) setupWithManager(mgr ctrl.Manager) error {
 			return true // cleanup on node delete
 		},
 	}
+	r.reservationUpdates = make(chan event.GenericEvent, wakeupBufferSize)
+	if err := ctrl.NewControllerManagedBy(mgr).
+		For(&inferencev1alpha1.SliceReservation{}).Named("SliceReservation-controller").
+		WatchesRawSource(source.Channel(r.reservationUpdates, &handler.EnqueueRequestForObject{})).
+		Complete(&SliceReservationReconciler{InstasliceReconciler: r}); err != nil {
+		return err
+	}
+
 	nodeReconciler := &NodeReconciler{Client: mgr.GetClient()}
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&v1.Node{}).Named("Node-controller").
@@ -807,6 +1124,22 @@ func (r *InstasliceReconciler) createInstaSliceDaemonSet(namespace string) *apps
 									Name:  "EMULATOR_MODE",
 									Value: fmt.Sprintf("%v", emulatorMode),
 								},
+								{
+									Name:  "ORPHAN_GC_ENABLE",
+									Value: fmt.Sprintf("%v", r.Config.OrphanGCEnable),
+								},
+								{
+									Name:  "ORPHAN_GC_DRY_RUN",
+									Value: fmt.Sprintf("%v", r.Config.OrphanGCDryRun),
+								},
+								{
+									Name:  "ORPHAN_GC_INTERVAL",
+									Value: r.Config.OrphanGCInterval.String(),
+								},
+								{
+									Name:  "ORPHAN_GC_GRACE_PERIOD",
+									Value: r.Config.OrphanGCGracePeriod.String(),
+								},
 							},
 						},
 					},
@@ -821,16 +1154,78 @@ func (r *InstasliceReconciler) createInstaSliceDaemonSet(namespace string) *apps
 func (*InstasliceReconciler) extractProfileName(limits v1.ResourceList) string {
 	profileName := ""
 	for k := range limits {
-		if strings.Contains(k.String(), "mig-") {
+		if profile, ok := resourceProfile(k); ok {
+			profileName = profile
+		}
+	}
+	return profileName
+}
+
+// Extract the number of slices requested for a profile from the container limits spec, at least 1
+func (*InstasliceReconciler) extractProfileQuantity(limits v1.ResourceList, profileName string) int32 {
+	for k, quantity := range limits {
+		if profile, ok := resourceProfile(k); ok && profile == profileName && quantity.Value() > 1 {
+			return int32(quantity.Value())
+		}
+	}
+	return 1
+}
 
-			re := regexp.MustCompile(`(\d+g\.\d+gb)`)
-			match := re.FindStringSubmatch(k.String())
-			if len(match) > 1 {
-				profileName = match[1]
+// resourceProfile returns the MIG profile a resource such as instaslice.redhat.com/mig-1c.4g.20gb
+// stands for, ok is false for other resources. Extensions such as the media engines of 1g.5gb+me
+// are part of the profile.
+func resourceProfile(resourceName v1.ResourceName) (string, bool) {
+	_, profile, found := strings.Cut(string(resourceName), "mig-")
+	base, _, _ := strings.Cut(profile, "+")
+	if !found || !profileNamePattern.MatchString(base) {
+		return "", false
+	}
+	return profile, true
+}
+
+// Extract the slice requests of the init and regular containers of a pod, containers without
+// a MIG profile in their limits (sidecars) are skipped
+func (r *InstasliceReconciler) extractContainerRequests(pod *v1.Pod) []inferencev1alpha1.ContainerRequest {
+	var requests []inferencev1alpha1.ContainerRequest
+	acceptable := containerAcceptableProfiles(pod)
+	add := func(containers []v1.Container, init bool) {
+		for _, container := range containers {
+			profileName := r.extractProfileName(container.Resources.Limits)
+			profiles, ok := acceptable[container.Name]
+			if profileName == "" || ok {
+				// the webhook recorded the profiles of containers accepting several profiles, and
+				// replaced the slice size resources of containers requesting a size, which is
+				// resolved to a profile on every node tried
+				switch {
+				case ok && (profiles.Memory != nil || profiles.ComputePercent > 0):
+					requests = append(requests, inferencev1alpha1.ContainerRequest{
+						Name:              container.Name,
+						AcceleratorMemory: profiles.Memory,
+						ComputePercent:    profiles.ComputePercent,
+						Init:              init,
+					})
+				case ok && len(profiles.Profiles) > 0:
+					requests = append(requests, inferencev1alpha1.ContainerRequest{
+						Name:               container.Name,
+						Profile:            profiles.Profiles[0],
+						AcceptableProfiles: profiles.Profiles,
+						Quantity:           profiles.Quantity,
+						Init:               init,
+					})
+				}
+				continue
 			}
+			requests = append(requests, inferencev1alpha1.ContainerRequest{
+				Name:     container.Name,
+				Profile:  profileName,
+				Quantity: r.extractProfileQuantity(container.Resources.Limits, profileName),
+				Init:     init,
+			})
 		}
 	}
-	return profileName
+	add(pod.Spec.InitContainers, true)
+	add(pod.Spec.Containers, false)
+	return requests
 }
 
 // Extract NVML specific attributes for GPUs, this will change for different generations of the GPU.
@@ -932,50 +1327,9 @@ func (r *InstasliceReconciler) removeInstaSliceFinalizer(ctx context.Context, re
 	return ctrl.Result{}, nil
 }
 
-// Policy based allocation - FirstFit
-func (r *FirstFitPolicy) SetAllocationDetails(profileName string, newStart, size int32, podUUID types.UID, nodename types.NodeName,
-	allocationStatus inferencev1alpha1.AllocationStatus, discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32,
-	namespace string, podName string, gpuUuid string, resourceIdentifier types.UID) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult) {
-	return &inferencev1alpha1.AllocationRequest{
-			Profile: profileName,
-			PodRef: v1.ObjectReference{
-				Kind:      "Pod",
-				Namespace: namespace,
-				Name:      podName,
-				UID:       podUUID,
-			},
-		}, &inferencev1alpha1.AllocationResult{
-			MigPlacement: inferencev1alpha1.Placement{
-				Size:  size,
-				Start: newStart,
-			},
-			GPUUUID:                     gpuUuid,
-			Nodename:                    nodename,
-			AllocationStatus:            allocationStatus,
-			ConfigMapResourceIdentifier: resourceIdentifier,
-			Conditions:                  []metav1.Condition{},
-		}
-}
-
-// Policy based allocation - LeftToRIght
-func (l *LeftToRightPolicy) SetAllocationDetails(profileName string, newStart, size int32, podUUID types.UID, nodename types.NodeName,
-	allocationStatus inferencev1alpha1.AllocationStatus, discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32,
-	namespace string, podName string, gpuUuid string, resourceIdentifier types.UID) *inferencev1alpha1.AllocationRequest {
-	// Implement the left-to-right policy here
-	return &inferencev1alpha1.AllocationRequest{}
-}
-
-// Policy based allocation - RigghToLeft
-func (l *RightToLeftPolicy) SetAllocationDetails(profileName string, newStart, size int32, podUUID types.UID, nodename types.NodeName,
-	allocationStatus inferencev1alpha1.AllocationStatus, discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32,
-	namespace string, podName string, gpuUuid string, resourceIdentifier types.UID) *inferencev1alpha1.AllocationRequest {
-	// Implement the left-to-right policy here
-	return &inferencev1alpha1.AllocationRequest{}
-}
-
 func (r *InstasliceReconciler) removeInstasliceAllocation(ctx context.Context, instasliceName string, allocation *inferencev1alpha1.AllocationResult) error {
 	if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
-		err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instasliceName, nil, nil)
+		err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, instasliceName, nil, nil)
 		if err != nil {
 			return err
 		}
@@ -983,10 +1337,19 @@ func (r *InstasliceReconciler) removeInstasliceAllocation(ctx context.Context, i
 	return nil
 }
 
+// markReleasing moves an allocation to deleting so that the daemonset deletes its slices, reason
+// tells why the slices are released
+func (r *InstasliceReconciler) markReleasing(instasliceName string, allocRequest *inferencev1alpha1.AllocationRequest, allocResult *inferencev1alpha1.AllocationResult, reason, message string) {
+	allocResult.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
+	if allocResult.SetCondition(inferencev1alpha1.AllocationConditionReleasing, metav1.ConditionTrue, reason, message) {
+		RecordAllocationEvent(r.Recorder, instasliceName, allocRequest, v1.EventTypeNormal, EventReasonReleaseStarted, message)
+	}
+}
+
 func (r *InstasliceReconciler) setInstasliceAllocationToDeleting(ctx context.Context, instasliceName string, allocResult *inferencev1alpha1.AllocationResult, allocRequest *inferencev1alpha1.AllocationRequest) (ctrl.Result, error) {
 	log := logr.FromContext(ctx)
 	allocResult.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
-	if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instasliceName, allocResult, allocRequest); err != nil {
+	if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, instasliceName, allocResult, allocRequest); err != nil {
 		log.Info("unable to set instaslice to state ", "state", allocResult.AllocationStatus.AllocationStatusController, "pod", allocRequest.PodRef.Name)
 		return ctrl.Result{Requeue: true}, err
 	}
@@ -994,14 +1357,31 @@ func (r *InstasliceReconciler) setInstasliceAllocationToDeleting(ctx context.Con
 	return ctrl.Result{}, nil
 }
 
-func (r *InstasliceReconciler) addNodeSelectorAndUngatePod(ctx context.Context, pod *v1.Pod, allocResult *inferencev1alpha1.AllocationResult) (ctrl.Result, error) {
+// addNodeSelectorAndUngatePod pins the pod to the node of its slices, records the profiles chosen
+// for its containers and ungates it
+func (r *InstasliceReconciler) addNodeSelectorAndUngatePod(ctx context.Context, pod *v1.Pod, allocRequest *inferencev1alpha1.AllocationRequest, allocResult *inferencev1alpha1.AllocationResult) (ctrl.Result, error) {
+	pod, err := r.chargeAllocatedProfiles(ctx, pod, allocRequest)
+	if err != nil {
+		return ctrl.Result{Requeue: true}, err
+	}
 	if pod.Spec.NodeSelector == nil {
 		pod.Spec.NodeSelector = make(map[string]string)
 	}
 	pod.Spec.NodeSelector[NodeLabel] = string(allocResult.Nodename)
+	// record the profiles the controller chose for the containers accepting several profiles
+	if profiles := allocatedProfiles(allocRequest); len(profiles) > 0 {
+		profilesJSON, err := json.Marshal(profiles)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[AllocatedProfilesAnnotation] = string(profilesJSON)
+	}
 
 	ungatedPod := r.unGatePod(pod)
-	err := r.Update(ctx, ungatedPod)
+	err = r.Update(ctx, ungatedPod)
 	if err != nil {
 		logr.FromContext(ctx).Error(err, "error ungating pod")
 		return ctrl.Result{Requeue: true}, err
@@ -1010,6 +1390,79 @@ func (r *InstasliceReconciler) addNodeSelectorAndUngatePod(ctx context.Context,
 	return ctrl.Result{}, nil
 }
 
+// allocatedProfiles returns by container name the profiles the controller chose for the containers
+// accepting several profiles or requesting a slice size
+func allocatedProfiles(allocRequest *inferencev1alpha1.AllocationRequest) map[string]string {
+	profiles := make(map[string]string)
+	for _, container := range allocRequest.Containers {
+		if len(container.AcceptableProfiles) > 0 || container.AcceleratorMemory != nil || container.ComputePercent > 0 {
+			profiles[container.Name] = container.Profile
+		}
+	}
+	return profiles
+}
+
+// chargeAllocatedProfiles rewrites the accelerator memory quota of the containers accepting
+// several profiles, charged by the webhook for the largest of them, to the profile they got. It
+// returns the updated pod. API servers that do not let the limits of a pod change reject the
+// update, the pod then stays charged for the largest profile and is ungated all the same.
+func (r *InstasliceReconciler) chargeAllocatedProfiles(ctx context.Context, pod *v1.Pod, allocRequest *inferencev1alpha1.AllocationRequest) (*v1.Pod, error) {
+	charged := pod.DeepCopy()
+	if !setAllocatedProfilesQuota(charged, allocRequest) {
+		return pod, nil
+	}
+	if err := r.Update(ctx, charged); err != nil {
+		if !apierrors.IsInvalid(err) {
+			logr.FromContext(ctx).Error(err, "error charging the quota of the allocated profiles", "pod", pod.Name)
+			return nil, err
+		}
+		r.recordEvent(pod, v1.EventTypeWarning, EventReasonQuotaNotAdjusted,
+			fmt.Sprintf("the %s limits were not adjusted to the allocated profiles: %v", QuotaResourceName, err))
+		return pod, nil
+	}
+	return charged, nil
+}
+
+// setAllocatedProfilesQuota sets the accelerator memory quota of the containers accepting several
+// profiles to the memory of the profile allocated to them, it reports whether a quota changed
+func setAllocatedProfilesQuota(pod *v1.Pod, allocRequest *inferencev1alpha1.AllocationRequest) bool {
+	changed := false
+	for _, request := range allocRequest.Containers {
+		memory, ok := profileMemory(request.Profile)
+		if len(request.AcceptableProfiles) == 0 || !ok {
+			continue
+		}
+		quota := resource.MustParse(fmt.Sprintf("%dGi", memory*int(request.SliceCount())))
+		for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+			for i := range containers {
+				if containers[i].Name != request.Name {
+					continue
+				}
+				for _, resources := range []v1.ResourceList{containers[i].Resources.Limits, containers[i].Resources.Requests} {
+					if current, ok := resources[v1.ResourceName(QuotaResourceName)]; ok && !current.Equal(quota) {
+						resources[v1.ResourceName(QuotaResourceName)] = quota
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// allocationPolicy returns the configured allocation policy, falling back to first fit
+func (r *InstasliceReconciler) allocationPolicy(ctx context.Context) AllocationPolicy {
+	if r.Config == nil || r.Config.AllocationPolicy == "" {
+		return &FirstFitPolicy{}
+	}
+	policy, err := NewAllocationPolicy(r.Config.AllocationPolicy)
+	if err != nil {
+		logr.FromContext(ctx).Error(err, "invalid allocation policy, falling back to first fit")
+		return &FirstFitPolicy{}
+	}
+	return policy
+}
+
 // TODO move this to utils and refer to common function
 func (r *InstasliceReconciler) getInstasliceObject(ctx context.Context, instasliceName string, namespace string) (*inferencev1alpha1.Instaslice, error) {
 	log := logr.FromContext(ctx)
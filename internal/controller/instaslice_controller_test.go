@@ -35,6 +35,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
@@ -363,7 +364,7 @@ func TestChangesAllocationDeletionAndFinalizer(t *testing.T) {
 
 			allocationResult := instaslice.Status.PodAllocationResults[pod.GetUID()]
 			allocationRequest := instaslice.Spec.PodAllocationRequests[pod.GetUID()]
-			err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &allocationResult, &allocationRequest)
+			err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, instaslice.Name, &allocationResult, &allocationRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			updatedInstaSlice := &inferencev1alpha1.Instaslice{}
@@ -693,8 +694,8 @@ func TestInstasliceReconciler_Reconcile(t *testing.T) {
 			Expect(newPod.Finalizers).ToNot(ContainElement(FinalizerName))
 		})
 
-		It("should return from reconcile when more than 1 container is present in a pod", func() {
-			// Define a pod with more than a container
+		It("should return from reconcile when no container of a pod requests a slice", func() {
+			// Define a pod with containers without GPU limits
 			pod = &v1.Pod{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "test-pod-1",
@@ -717,7 +718,7 @@ func TestInstasliceReconciler_Reconcile(t *testing.T) {
 			req.Name = pod.Name
 			result, err := r.Reconcile(ctx, req)
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring(multipleContainersUnsupportedErr))
+			Expect(err.Error()).To(ContainSubstring(noGPUContainerInsidePodErr))
 			Expect(result).To(Equal(ctrl.Result{}))
 		})
 
@@ -1123,6 +1124,66 @@ func TestInstasliceReconciler_podMapFunc(t *testing.T) {
 	}
 }
 
+func TestInstasliceReconciler_addNodeSelectorAndUngatePod(t *testing.T) {
+	ctx := context.TODO()
+	scheme := runtime.NewScheme()
+	_ = v1.AddToScheme(scheme)
+	newPod := func() *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "model", Namespace: "default", UID: "model"},
+			Spec: v1.PodSpec{
+				SchedulingGates: []v1.PodSchedulingGate{{Name: GateName}},
+				Containers: []v1.Container{
+					{Name: "model", Resources: v1.ResourceRequirements{Limits: v1.ResourceList{QuotaResourceName: resource.MustParse("10Gi")}}},
+					{Name: "embedder", Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+						"instaslice.redhat.com/mig-1g.5gb": resource.MustParse("1"), QuotaResourceName: resource.MustParse("5Gi"),
+					}}},
+				},
+			},
+		}
+	}
+	allocRequest := &inferencev1alpha1.AllocationRequest{Containers: []inferencev1alpha1.ContainerRequest{
+		{Name: "model", Profile: "1g.5gb", AcceptableProfiles: []string{"1g.5gb", "1g.10gb"}},
+		{Name: "embedder", Profile: "1g.5gb"},
+	}}
+	allocResult := &inferencev1alpha1.AllocationResult{Nodename: "node-1"}
+
+	t.Run("the quota of the container accepting several profiles follows the profile it got", func(t *testing.T) {
+		pod := newPod()
+		r := &InstasliceReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build()}
+		_, err := r.addNodeSelectorAndUngatePod(ctx, pod, allocRequest, allocResult)
+		assert.NoError(t, err)
+		ungated := &v1.Pod{}
+		assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(pod), ungated))
+		assert.Empty(t, ungated.Spec.SchedulingGates)
+		assert.Equal(t, "node-1", ungated.Spec.NodeSelector[NodeLabel])
+		assert.JSONEq(t, `{"model":"1g.5gb"}`, ungated.Annotations[AllocatedProfilesAnnotation])
+		quota := ungated.Spec.Containers[0].Resources.Limits[QuotaResourceName]
+		assert.Equal(t, "5Gi", quota.String())
+		quota = ungated.Spec.Containers[1].Resources.Limits[QuotaResourceName]
+		assert.Equal(t, "5Gi", quota.String())
+	})
+
+	t.Run("the pod is ungated when the API server keeps its limits", func(t *testing.T) {
+		pod := newPod()
+		r := &InstasliceReconciler{Client: interceptor.NewClient(fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build(), interceptor.Funcs{
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if quota := obj.(*v1.Pod).Spec.Containers[0].Resources.Limits[QuotaResourceName]; quota.String() != "10Gi" {
+					return apierrors.NewInvalid(v1.SchemeGroupVersion.WithKind("Pod").GroupKind(), obj.GetName(), nil)
+				}
+				return c.Update(ctx, obj, opts...)
+			},
+		})}
+		_, err := r.addNodeSelectorAndUngatePod(ctx, pod, allocRequest, allocResult)
+		assert.NoError(t, err)
+		ungated := &v1.Pod{}
+		assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(pod), ungated))
+		assert.Empty(t, ungated.Spec.SchedulingGates)
+		quota := ungated.Spec.Containers[0].Resources.Limits[QuotaResourceName]
+		assert.Equal(t, "10Gi", quota.String())
+	})
+}
+
 func TestFirstFitPolicy_SetAllocationDetails(t *testing.T) {
 	type args struct {
 		profileName                 string
@@ -1253,8 +1314,10 @@ func TestGetStartIndexFromPreparedState(t *testing.T) {
 					},
 				},
 			}
-			gpuAllocatedIndex := [8]int32{1, 0, 0, 0, 0, 0, 0, 0}
-			Expect((&InstasliceReconciler{}).getStartIndexFromAllocationResults(instaslice, "2g.10gb", gpuAllocatedIndex, nil, true)).To(Equal(int32(1)))
+			gpuAllocatedIndex := GPUSlots{true, false, false, false, false, false, false, false}
+			start, ok := (&InstasliceReconciler{}).getStartIndexFromAllocationResults(instaslice, "2g.10gb", gpuAllocatedIndex, nil, true)
+			Expect(ok).To(BeTrue())
+			Expect(start).To(Equal(int32(1)))
 		})
 	})
 }
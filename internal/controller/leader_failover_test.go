@@ -0,0 +1,187 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlconfig "sigs.k8s.io/controller-runtime/pkg/config"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	rcache "github.com/openshift/instaslice-operator/internal/controller/cache"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+var _ = Describe("Leader failover", func() {
+	const nodeName = "failover-node"
+
+	var (
+		ctx  context.Context
+		node *v1.Node
+		pod  *v1.Pod
+	)
+
+	// startLeader runs a manager campaigning for the leadership with the Instaslice controller
+	startLeader := func() (*InstasliceReconciler, context.CancelFunc) {
+		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+			Scheme:                  scheme.Scheme,
+			Metrics:                 metricsserver.Options{BindAddress: "0"},
+			HealthProbeBindAddress:  "0",
+			LeaderElection:          true,
+			LeaderElectionID:        "instaslice-failover-test",
+			LeaderElectionNamespace: InstaSliceOperatorNamespace,
+			LeaseDuration:           ptr.To(2 * time.Second),
+			RenewDeadline:           ptr.To(time.Second),
+			RetryPeriod:             ptr.To(200 * time.Millisecond),
+			Controller:              ctrlconfig.Controller{SkipNameValidation: ptr.To(true)},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		resourceCache := rcache.NewResourceCache()
+		resourceCache.ResourceEventHandlerForNode().AddFunc(node)
+		r := &InstasliceReconciler{
+			Client:        mgr.GetClient(),
+			Scheme:        mgr.GetScheme(),
+			Config:        config.NewConfig(),
+			ResourceCache: resourceCache,
+			Recorder:      mgr.GetEventRecorderFor("instaslice-controller"),
+		}
+		Expect(r.setupWithManager(mgr)).To(Succeed())
+		mgrCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			defer GinkgoRecover()
+			Expect(mgr.Start(mgrCtx)).To(Succeed())
+		}()
+		Eventually(mgr.Elected()).WithTimeout(30 * time.Second).Should(BeClosed())
+		return r, cancel
+	}
+
+	getAllocation := func(g Gomega) (inferencev1alpha1.AllocationResult, bool) {
+		var instaslice inferencev1alpha1.Instaslice
+		g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: InstaSliceOperatorNamespace}, &instaslice)).To(Succeed())
+		allocResult, ok := instaslice.Status.PodAllocationResults[pod.UID]
+		return allocResult, ok
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: InstaSliceOperatorNamespace}}
+		Expect(client.IgnoreAlreadyExists(k8sClient.Create(ctx, namespace))).To(Succeed())
+
+		node = &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName, Labels: map[string]string{ManagedLabel: "true"}}}
+		Expect(k8sClient.Create(ctx, node)).To(Succeed())
+		node.Status = v1.NodeStatus{
+			NodeInfo:    v1.NodeSystemInfo{BootID: "fake-boot-id"},
+			Conditions:  []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+			Capacity:    v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("8Gi")},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("8Gi")},
+		}
+		Expect(k8sClient.Status().Update(ctx, node)).To(Succeed())
+
+		instaslice := utils.GenerateFakeCapacity(nodeName)
+		status := instaslice.Status
+		Expect(k8sClient.Create(ctx, instaslice)).To(Succeed())
+		instaslice.Status = status
+		Expect(k8sClient.Status().Update(ctx, instaslice)).To(Succeed())
+
+		pod = &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "failover-model", Namespace: "default"},
+			Spec: v1.PodSpec{
+				SchedulingGates: []v1.PodSchedulingGate{{Name: GateName}},
+				Containers: []v1.Container{{
+					Name:  "model",
+					Image: "model",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{"nvidia.com/mig-1g.5gb": resource.MustParse("1")},
+					},
+				}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		// the scheduler reports the gated pod, there is none in the test environment
+		pod.Status = v1.PodStatus{Phase: v1.PodPending, Conditions: []v1.PodCondition{{
+			Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: "SchedulingGated",
+			Message: "Scheduling is blocked due to non-empty scheduling gates",
+		}}}
+		Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, pod, client.GracePeriodSeconds(0)))).To(Succeed())
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, utils.GenerateFakeCapacity(nodeName)))).To(Succeed())
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, node))).To(Succeed())
+	})
+
+	It("resumes the allocation of the previous leader once its state is recovered", func() {
+		By("placing the pod on the first leader")
+		_, stopFirst := startLeader()
+		Eventually(func(g Gomega) {
+			allocResult, ok := getAllocation(g)
+			g.Expect(ok).To(BeTrue())
+			g.Expect(allocResult.AllocationStatus.AllocationStatusController).To(Equal(inferencev1alpha1.AllocationStatusCreating))
+		}).WithTimeout(30 * time.Second).Should(Succeed())
+
+		By("killing the first leader while the slices are being created")
+		// the lease is not released, the next leader is elected once it expires
+		stopFirst()
+		var instaslice inferencev1alpha1.Instaslice
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: InstaSliceOperatorNamespace}, &instaslice)).To(Succeed())
+		instaslice.Spec.PodAllocationRequests["interrupted"] = inferencev1alpha1.AllocationRequest{
+			Profile: "1g.5gb",
+			PodRef:  v1.ObjectReference{Name: "interrupted", Namespace: "default", UID: "interrupted"},
+		}
+		Expect(k8sClient.Update(ctx, &instaslice)).To(Succeed())
+
+		By("electing the next leader")
+		next, stopNext := startLeader()
+		defer stopNext()
+		Eventually(next.isRecovered).WithTimeout(30 * time.Second).Should(BeTrue())
+		Expect(next.ReadyzCheck(nil)).To(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&instaslice), &instaslice)).To(Succeed())
+			g.Expect(instaslice.Spec.PodAllocationRequests).NotTo(HaveKey(types.UID("interrupted")))
+			g.Expect(instaslice.Spec.PodAllocationRequests).To(HaveKey(pod.UID))
+		}).Should(Succeed())
+
+		By("ungating the pod once the daemonset created its slices")
+		allocResult, ok := getAllocation(Default)
+		Expect(ok).To(BeTrue())
+		allocRequest := instaslice.Spec.PodAllocationRequests[pod.UID]
+		allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+		Expect(utils.UpdateOrDeleteInstasliceAllocations(ctx, k8sClient, utils.DaemonsetFieldManager, nodeName, &allocResult, &allocRequest)).To(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pod), pod)).To(Succeed())
+			g.Expect(pod.Spec.SchedulingGates).To(BeEmpty())
+			g.Expect(pod.Spec.NodeSelector).To(HaveKeyWithValue(NodeLabel, nodeName))
+			allocResult, _ := getAllocation(g)
+			g.Expect(allocResult.AllocationStatus.AllocationStatusController).To(Equal(inferencev1alpha1.AllocationStatusUngated))
+		}).WithTimeout(30 * time.Second).Should(Succeed())
+	})
+})
@@ -25,43 +25,38 @@ import (
 // simulate - `true` → simulate fits | `false` → check actual allocation
 func (r *InstasliceReconciler) calculateProfileFitOnGPU(instaslice *inferencev1alpha1.Instaslice, profileName, gpuUUID string, simulate bool, pod *v1.Pod) (int32, error) {
 	// Get the GPU allocation state (already allocated slices)
-	originalAllocatedIndex := r.gpuAllocatedSlices(gpuUUID)
-	// Create a copy of the allocated slots (fixed-size array)
-	var gpuAllocatedIndex [8]int32
-	copy(gpuAllocatedIndex[:], originalAllocatedIndex[:]) // Ensure we don’t modify real allocations
+	gpuAllocatedIndex := r.gpuAllocatedSlices(instaslice, gpuUUID)
 	// Determine the required slice size for this profile
-	var neededContinuousSlot int32
 	placement, exists := instaslice.Status.NodeResources.MigPlacement[profileName]
 	if !exists || len(placement.Placements) == 0 {
 		return 0, fmt.Errorf("profile %s not found in MigPlacement", profileName)
 	}
-	neededContinuousSlot = placement.Placements[0].Size
-	// If we're checking actual allocation, count and return immediately
+	neededContinuousSlot := placement.Placements[0].Size
+	// If we're checking actual allocation, return the slots the profile occupies
 	if !simulate {
-		actualSliceSize := int32(0)
-		startIdx := r.getStartIndexFromAllocationResults(instaslice, profileName, gpuAllocatedIndex, &pod.UID, false)
-		for i := int32(0); i < neededContinuousSlot; i++ {
-			if startIdx+i < int32(len(originalAllocatedIndex)-1) {
-				actualSliceSize++
-			}
+		if _, ok := r.getStartIndexFromAllocationResults(instaslice, profileName, gpuAllocatedIndex, &pod.UID, false); !ok {
+			return 0, nil
 		}
-		return actualSliceSize, nil // Return the **actual** allocated slice count
+		return neededContinuousSlot, nil
 	}
 	// If we are simulating, count how many times the profile **could fit**
+	return countProfileFits(instaslice, profileName, gpuAllocatedIndex), nil
+}
+
+// countProfileFits returns how many more slices of a profile fit next to the allocated slots,
+// placing them in discovered order. gpuAllocatedIndex is left untouched.
+func countProfileFits(instaslice *inferencev1alpha1.Instaslice, profileName string, gpuAllocatedIndex GPUSlots) int32 {
+	slots := gpuAllocatedIndex.Clone()
 	fitCount := int32(0)
-	for i := 0; i < len(originalAllocatedIndex); i++ {
-		startIdx := r.getStartIndexFromAllocationResults(instaslice, profileName, gpuAllocatedIndex, nil, true)
-		// If no valid placement found, break the loop
-		if startIdx == 9 {
+	for {
+		starts := freePlacementStarts(instaslice, profileName, slots)
+		// If no valid placement found, stop
+		if len(starts) == 0 {
 			break
 		}
 		// Simulate allocation by marking the slots
-		for i := int32(0); i < neededContinuousSlot; i++ {
-			if startIdx+i < int32(len(gpuAllocatedIndex)) {
-				gpuAllocatedIndex[startIdx+i] = 1
-			}
-		}
+		slots.Allocate(starts[0], profileSize(instaslice, profileName))
 		fitCount++ // one successful fit
 	}
-	return fitCount, nil // total hypothetical fits
+	return fitCount // total hypothetical fits
 }
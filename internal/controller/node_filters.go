@@ -0,0 +1,88 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	v1helper "k8s.io/component-helpers/scheduling/corev1"
+	"k8s.io/component-helpers/scheduling/corev1/nodeaffinity"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// The controller pins a pod to the node of its slices, so the node must pass the filters the
+// kube-scheduler would run for the pod. Otherwise the pod stays pending while holding slices.
+
+const (
+	nodeNameMismatchReason     = "node didn't match the requested node name"
+	nodeUnschedulableReason    = "node was unschedulable"
+	nodeAffinityMismatchReason = "node didn't match Pod's node affinity/selector"
+)
+
+// podFitsNode runs the NodeName, NodeUnschedulable, NodeAffinity and TaintToleration filters of the
+// kube-scheduler, reason tells why the node was filtered out
+func podFitsNode(pod *v1.Pod, node *v1.Node) (bool, string) {
+	if pod.Spec.NodeName != "" && pod.Spec.NodeName != node.Name {
+		return false, nodeNameMismatchReason
+	}
+	if node.Spec.Unschedulable && !v1helper.TolerationsTolerateTaint(pod.Spec.Tolerations, &v1.Taint{
+		Key:    v1.TaintNodeUnschedulable,
+		Effect: v1.TaintEffectNoSchedule,
+	}) {
+		return false, nodeUnschedulableReason
+	}
+	// nodeSelector and required node affinity, errors in the affinity match no node
+	if match, err := nodeaffinity.GetRequiredNodeAffinity(pod).Match(node); err != nil || !match {
+		return false, nodeAffinityMismatchReason
+	}
+	taint, untolerated := v1helper.FindMatchingUntoleratedTaint(node.Spec.Taints, pod.Spec.Tolerations, func(t *v1.Taint) bool {
+		return t.Effect == v1.TaintEffectNoSchedule || t.Effect == v1.TaintEffectNoExecute
+	})
+	if untolerated {
+		return false, fmt.Sprintf("node had untolerated taint {%s: %s}", taint.Key, taint.Value)
+	}
+	return true, ""
+}
+
+// podFitsInstasliceNode runs podFitsNode against the node of an instaslice
+func (r *InstasliceReconciler) podFitsInstasliceNode(ctx context.Context, nodeName string, pod *v1.Pod) (bool, string, error) {
+	node := &v1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: nodeName}, node); err != nil {
+		return false, "", err
+	}
+	ok, reason := podFitsNode(pod, node)
+	return ok, reason, nil
+}
+
+// readNodes reads the nodes of the instaslices, the ones that cannot be read are left out. It
+// reads the API and must not run under r.mu.
+func (r *InstasliceReconciler) readNodes(ctx context.Context, instaslices []inferencev1alpha1.Instaslice) map[string]*v1.Node {
+	nodes := make(map[string]*v1.Node, len(instaslices))
+	for _, instaslice := range instaslices {
+		node := &v1.Node{}
+		if err := r.Get(ctx, client.ObjectKey{Name: instaslice.Name}, node); err != nil {
+			logr.FromContext(ctx).Error(err, "error getting the node object", "name", instaslice.Name)
+			continue
+		}
+		nodes[instaslice.Name] = node
+	}
+	return nodes
+}
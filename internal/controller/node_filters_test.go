@@ -0,0 +1,117 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodFitsNode(t *testing.T) {
+	gpuTaint := v1.Taint{Key: "nvidia.com/gpu", Value: "true", Effect: v1.TaintEffectNoSchedule}
+	gpuToleration := v1.Toleration{Key: "nvidia.com/gpu", Operator: v1.TolerationOpExists}
+	zoneAffinity := &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+			MatchExpressions: []v1.NodeSelectorRequirement{{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"a"}}},
+		}}},
+	}}
+
+	tests := []struct {
+		name           string
+		podSpec        v1.PodSpec
+		nodeLabels     map[string]string
+		nodeSpec       v1.NodeSpec
+		expectedFits   bool
+		expectedReason string
+	}{
+		{
+			name:         "no constraints",
+			expectedFits: true,
+		},
+		{
+			name:           "node name mismatch",
+			podSpec:        v1.PodSpec{NodeName: "node-2"},
+			expectedReason: nodeNameMismatchReason,
+		},
+		{
+			name:           "unschedulable node",
+			nodeSpec:       v1.NodeSpec{Unschedulable: true},
+			expectedReason: nodeUnschedulableReason,
+		},
+		{
+			name: "unschedulable node tolerated",
+			podSpec: v1.PodSpec{Tolerations: []v1.Toleration{{
+				Key: v1.TaintNodeUnschedulable, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule,
+			}}},
+			nodeSpec:     v1.NodeSpec{Unschedulable: true},
+			expectedFits: true,
+		},
+		{
+			name:           "node selector mismatch",
+			podSpec:        v1.PodSpec{NodeSelector: map[string]string{"zone": "a"}},
+			nodeLabels:     map[string]string{"zone": "b"},
+			expectedReason: nodeAffinityMismatchReason,
+		},
+		{
+			name:         "node selector match",
+			podSpec:      v1.PodSpec{NodeSelector: map[string]string{"zone": "a"}},
+			nodeLabels:   map[string]string{"zone": "a"},
+			expectedFits: true,
+		},
+		{
+			name:           "required affinity mismatch",
+			podSpec:        v1.PodSpec{Affinity: zoneAffinity},
+			nodeLabels:     map[string]string{"zone": "b"},
+			expectedReason: nodeAffinityMismatchReason,
+		},
+		{
+			name:         "required affinity match",
+			podSpec:      v1.PodSpec{Affinity: zoneAffinity},
+			nodeLabels:   map[string]string{"zone": "a"},
+			expectedFits: true,
+		},
+		{
+			name:           "untolerated taint",
+			nodeSpec:       v1.NodeSpec{Taints: []v1.Taint{gpuTaint}},
+			expectedReason: "node had untolerated taint {nvidia.com/gpu: true}",
+		},
+		{
+			name:         "tolerated taint",
+			podSpec:      v1.PodSpec{Tolerations: []v1.Toleration{gpuToleration}},
+			nodeSpec:     v1.NodeSpec{Taints: []v1.Taint{gpuTaint}},
+			expectedFits: true,
+		},
+		{
+			name:         "prefer no schedule taint ignored",
+			nodeSpec:     v1.NodeSpec{Taints: []v1.Taint{{Key: "busy", Effect: v1.TaintEffectPreferNoSchedule}}},
+			expectedFits: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}, Spec: tt.podSpec}
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: tt.nodeLabels}, Spec: tt.nodeSpec}
+			fits, reason := podFitsNode(pod, node)
+			assert.Equal(t, tt.expectedFits, fits)
+			assert.Equal(t, tt.expectedReason, reason)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// Node selection strategies decide the order in which Instaslice objects, and therefore
+// nodes, are tried when placing a slice.
+const (
+	// NodeSelectionOrdered tries nodes by name
+	NodeSelectionOrdered = "ordered"
+	// NodeSelectionPack tries the nodes with the fewest free GPU slots first
+	NodeSelectionPack = "pack"
+	// NodeSelectionSpread tries the nodes with the most free GPU slots first
+	NodeSelectionSpread = "spread"
+	// NodeSelectionBalanced tries the nodes with the most unrequested CPU and memory first
+	NodeSelectionBalanced = "balanced"
+)
+
+var nodeSelectionStrategies = []string{NodeSelectionOrdered, NodeSelectionPack, NodeSelectionSpread, NodeSelectionBalanced}
+
+// ValidateNodeSelectionStrategy returns an error if name is not a known node selection strategy
+func ValidateNodeSelectionStrategy(name string) error {
+	for _, strategy := range nodeSelectionStrategies {
+		if name == strategy {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown node selection strategy %q, valid strategies are %v", name, nodeSelectionStrategies)
+}
+
+// nodeSelectionStrategy returns the strategy for a pod, the pod annotation takes precedence
+// over the cluster wide configuration.
+func (r *InstasliceReconciler) nodeSelectionStrategy(ctx context.Context, pod *v1.Pod) string {
+	log := logr.FromContext(ctx)
+	if strategy, ok := pod.Annotations[NodeSelectionStrategyAnnotation]; ok {
+		err := ValidateNodeSelectionStrategy(strategy)
+		if err == nil {
+			return strategy
+		}
+		log.Error(err, "ignoring node selection strategy annotation", "pod", pod.Name)
+	}
+	if r.Config == nil || r.Config.NodeSelectionStrategy == "" {
+		return NodeSelectionOrdered
+	}
+	if err := ValidateNodeSelectionStrategy(r.Config.NodeSelectionStrategy); err != nil {
+		log.Error(err, "invalid node selection strategy, falling back to ordered")
+		return NodeSelectionOrdered
+	}
+	return r.Config.NodeSelectionStrategy
+}
+
+// sortInstaslicesForPod orders the instaslices in the order they should be tried for the pod.
+// The allocation cache must be up to date since free slots are derived from it.
+func (r *InstasliceReconciler) sortInstaslicesForPod(ctx context.Context, pod *v1.Pod, instaslices []inferencev1alpha1.Instaslice) {
+	// Sort by Name in ascending order, the other strategies keep it as tie breaker
+	sort.Slice(instaslices, func(i, j int) bool {
+		return instaslices[i].Name < instaslices[j].Name
+	})
+
+	switch r.nodeSelectionStrategy(ctx, pod) {
+	case NodeSelectionPack:
+		free := r.freeSlotsByNode(instaslices)
+		sort.SliceStable(instaslices, func(i, j int) bool {
+			return free[instaslices[i].Name] < free[instaslices[j].Name]
+		})
+	case NodeSelectionSpread:
+		free := r.freeSlotsByNode(instaslices)
+		sort.SliceStable(instaslices, func(i, j int) bool {
+			return free[instaslices[i].Name] > free[instaslices[j].Name]
+		})
+	case NodeSelectionBalanced:
+		if r.ResourceCache == nil {
+			break
+		}
+		headroom := make(map[string]float64, len(instaslices))
+		for _, instaslice := range instaslices {
+			// nodes missing from the cache are tried last, Fits rejects them anyway
+			headroom[instaslice.Name], _ = r.ResourceCache.Headroom(instaslice.Name)
+		}
+		sort.SliceStable(instaslices, func(i, j int) bool {
+			return headroom[instaslices[i].Name] > headroom[instaslices[j].Name]
+		})
+	}
+
+	// nodes with free slots on a GPU of a preferred model are tried first
+	if _, preferred, err := podGPUModels(pod); err == nil && len(preferred) > 0 {
+		hasPreferred := make(map[string]bool, len(instaslices))
+		for i := range instaslices {
+			for _, candidate := range gpuModelCandidates(&instaslices[i], r.gpuCandidates(&instaslices[i]), preferred) {
+				if candidate.Allocated.FreeCount() > 0 {
+					hasPreferred[instaslices[i].Name] = true
+				}
+			}
+		}
+		sort.SliceStable(instaslices, func(i, j int) bool {
+			return hasPreferred[instaslices[i].Name] && !hasPreferred[instaslices[j].Name]
+		})
+	}
+
+	// nodes holding slices reserved for the namespace of the pod come before any other
+	hasReserved := make(map[string]bool, len(instaslices))
+	for i := range instaslices {
+		hasReserved[instaslices[i].Name] = r.hasFreeReservedSlices(instaslices[i].Name, pod.Namespace)
+	}
+	sort.SliceStable(instaslices, func(i, j int) bool {
+		return hasReserved[instaslices[i].Name] && !hasReserved[instaslices[j].Name]
+	})
+}
+
+// freeSlotsByNode returns the number of unallocated GPU slots of every instaslice
+func (r *InstasliceReconciler) freeSlotsByNode(instaslices []inferencev1alpha1.Instaslice) map[string]int32 {
+	free := make(map[string]int32, len(instaslices))
+	for i := range instaslices {
+		for _, gpuUUID := range sortGPUs(&instaslices[i]) {
+			free[instaslices[i].Name] += r.gpuAllocatedSlices(&instaslices[i], gpuUUID).FreeCount()
+		}
+	}
+	return free
+}
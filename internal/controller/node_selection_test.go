@@ -0,0 +1,106 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	rcache "github.com/openshift/instaslice-operator/internal/controller/cache"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestSortInstaslicesForPod(t *testing.T) {
+	// node-a has a 7g slice, node-b a 1g slice and node-c no slices, while on the classical
+	// resources node-b is the least and node-a the most loaded
+	nodeA, nodeB, nodeC := utils.GenerateFakeCapacity("node-a"), utils.GenerateFakeCapacity("node-b"), utils.GenerateFakeCapacity("node-c")
+	// GenerateFakeCapacity uses the same GPU UUIDs on every node, keep allocations per node apart
+	for _, node := range []*inferencev1alpha1.Instaslice{nodeB, nodeC} {
+		for i := range node.Status.NodeResources.NodeGPUs {
+			node.Status.NodeResources.NodeGPUs[i].GPUUUID += "-" + node.Name
+		}
+	}
+	allocationCache := map[types.UID]inferencev1alpha1.AllocationResult{
+		"pod-a": {GPUUUID: sortGPUs(nodeA)[0], Nodename: "node-a", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 8}},
+		"pod-b": {GPUUUID: sortGPUs(nodeB)[0], Nodename: "node-b", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}},
+	}
+
+	resourceCache := rcache.NewResourceCache()
+	for name, load := range map[string]string{"node-a": "3", "node-b": "1", "node-c": "2"} {
+		resourceCache.ResourceEventHandlerForNode().AddFunc(&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("4"),
+				v1.ResourceMemory: resource.MustParse("8Gi"),
+			}},
+		})
+		resourceCache.ResourceEventHandlerForPod().AddFunc(&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "load-" + name},
+			Spec: v1.PodSpec{NodeName: name, Containers: []v1.Container{{
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(load)}},
+			}}},
+		})
+	}
+
+	tests := []struct {
+		name       string
+		configured string
+		annotation string
+		want       []string
+	}{
+		{name: "default orders by name", want: []string{"node-a", "node-b", "node-c"}},
+		{name: "pack", configured: NodeSelectionPack, want: []string{"node-a", "node-b", "node-c"}},
+		{name: "spread", configured: NodeSelectionSpread, want: []string{"node-c", "node-b", "node-a"}},
+		{name: "balanced", configured: NodeSelectionBalanced, want: []string{"node-b", "node-c", "node-a"}},
+		{name: "annotation overrides config", configured: NodeSelectionPack, annotation: NodeSelectionSpread, want: []string{"node-c", "node-b", "node-a"}},
+		{name: "invalid annotation falls back to config", configured: NodeSelectionSpread, annotation: "random", want: []string{"node-c", "node-b", "node-a"}},
+		{name: "invalid config falls back to name", configured: "random", want: []string{"node-a", "node-b", "node-c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			if tt.configured != "" {
+				cfg.NodeSelectionStrategy = tt.configured
+			}
+			r := &InstasliceReconciler{Config: cfg, ResourceCache: resourceCache, allocationCache: newAllocationStore(allocationCache)}
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p"}}
+			if tt.annotation != "" {
+				pod.Annotations = map[string]string{NodeSelectionStrategyAnnotation: tt.annotation}
+			}
+			instaslices := []inferencev1alpha1.Instaslice{*nodeC, *nodeA, *nodeB}
+			r.sortInstaslicesForPod(context.Background(), pod, instaslices)
+			var got []string
+			for _, instaslice := range instaslices {
+				got = append(got, instaslice.Name)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateNodeSelectionStrategy(t *testing.T) {
+	assert.NoError(t, ValidateNodeSelectionStrategy(config.DefaultNodeSelectionStrategy))
+	assert.ErrorContains(t, ValidateNodeSelectionStrategy("random"), "unknown node selection strategy")
+}
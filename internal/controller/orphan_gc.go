@@ -0,0 +1,368 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// Pods that are force deleted or lose their finalizer are never reconciled again, their
+// allocations, ConfigMaps and MIG instances would stay forever. The orphan collector of the
+// controller sweeps the Instaslices every config.Config.OrphanGCInterval: allocations whose pod
+// is gone, was recreated under the same name or terminated without the finalizer are moved to
+// deleting so that the daemonset releases their slices, and removed once it reports them deleted.
+// The ConfigMaps labeled by the daemonset that no allocation nor pod refers to are deleted. The
+// daemonset destroys the MIG instances of its node that no allocation holds. Orphans are only
+// reclaimed once consecutive sweeps saw them for config.Config.OrphanGCGracePeriod, and only
+// reported when running dry.
+
+// Kinds of the orphans reclaimed by the collectors
+const (
+	OrphanKindAllocation  = "allocation"
+	OrphanKindConfigMap   = "configmap"
+	OrphanKindMigInstance = "mig_instance"
+)
+
+// orphanedReason is the reason of the Releasing condition of orphaned allocations
+const orphanedReason = "PodOrphaned"
+
+// runOrphanCollector sweeps the orphans every OrphanGCInterval until ctx is done
+func (r *InstasliceReconciler) runOrphanCollector(ctx context.Context) error {
+	log := logr.FromContext(ctx)
+	ticker := time.NewTicker(r.Config.OrphanGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if !r.isRecovered() {
+				continue
+			}
+			if err := r.collectOrphans(ctx, time.Now()); err != nil {
+				log.Error(err, "orphan collection failed")
+			}
+		}
+	}
+}
+
+// orphanCandidate is an allocation or a ConfigMap found by a sweep, which is reclaimed once it was
+// orphaned for the grace period
+type orphanCandidate struct {
+	kind string
+	key  string
+	// instasliceName, podUID, allocRequest and allocResult are set for allocations, hasRequest and
+	// hasResult tell which of the request and the result the Instaslice holds and podGone whether
+	// the pod of the allocation is gone
+	instasliceName string
+	podUID         types.UID
+	allocRequest   inferencev1alpha1.AllocationRequest
+	allocResult    inferencev1alpha1.AllocationResult
+	hasRequest     bool
+	hasResult      bool
+	podGone        bool
+	// configMap is set for ConfigMaps
+	configMap *v1.ConfigMap
+}
+
+// collectOrphans reclaims the allocations and ConfigMaps left by pods that no longer exist. The
+// API is read and written without r.mu, which is only taken to decide which orphans are past
+// their grace period and to update the allocation cache.
+func (r *InstasliceReconciler) collectOrphans(ctx context.Context, now time.Time) error {
+	if err := r.ensureAllocationCache(ctx); err != nil {
+		return err
+	}
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := r.List(ctx, &instasliceList, client.InNamespace(InstaSliceOperatorNamespace)); err != nil {
+		return err
+	}
+	allocations, err := r.readAllocationOrphans(ctx, instasliceList.Items)
+	if err != nil {
+		return err
+	}
+	configMaps, err := r.readConfigMapOrphans(ctx, instasliceList.Items)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	seen := make(map[string]bool)
+	reclaimed := r.allocationOrphansPastGrace(ctx, instasliceList.Items, allocations, now, seen)
+	reclaimed = append(reclaimed, r.configMapOrphansPastGrace(ctx, configMaps, now, seen)...)
+	// orphans that were adopted or reclaimed otherwise start their grace period again
+	for key := range r.orphans {
+		if !seen[key] {
+			delete(r.orphans, key)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, candidate := range reclaimed {
+		if err := r.reclaimOrphan(ctx, candidate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orphanPastGrace records that a sweep saw an orphan and reports whether it was seen for the
+// grace period, under r.mu
+func (r *InstasliceReconciler) orphanPastGrace(key string, now time.Time, seen map[string]bool) bool {
+	if r.orphans == nil {
+		r.orphans = make(map[string]time.Time)
+	}
+	seen[key] = true
+	since, ok := r.orphans[key]
+	if !ok {
+		since = now
+		r.orphans[key] = now
+	}
+	return now.Sub(since) >= r.Config.OrphanGCGracePeriod
+}
+
+// readAllocationOrphans returns the allocations whose pod is gone, was recreated under the same
+// name or terminated without the finalizer, and the requests without a result, which a failed
+// write may have left. It reads the API and must not run under r.mu.
+func (r *InstasliceReconciler) readAllocationOrphans(ctx context.Context, instaslices []inferencev1alpha1.Instaslice) ([]orphanCandidate, error) {
+	var orphans []orphanCandidate
+	for _, instaslice := range instaslices {
+		podUIDs := make(map[types.UID]bool)
+		for podUID := range instaslice.Spec.PodAllocationRequests {
+			podUIDs[podUID] = true
+		}
+		for podUID := range instaslice.Status.PodAllocationResults {
+			podUIDs[podUID] = true
+		}
+		for podUID := range podUIDs {
+			allocRequest, hasRequest := instaslice.Spec.PodAllocationRequests[podUID]
+			allocResult, hasResult := instaslice.Status.PodAllocationResults[podUID]
+			podGone, err := r.allocationOrphaned(ctx, podUID, allocRequest, hasRequest)
+			if err != nil {
+				return nil, err
+			}
+			if !podGone && hasResult {
+				continue
+			}
+			orphans = append(orphans, orphanCandidate{
+				kind:           OrphanKindAllocation,
+				key:            OrphanKindAllocation + "/" + string(podUID),
+				instasliceName: instaslice.Name,
+				podUID:         podUID,
+				allocRequest:   allocRequest,
+				allocResult:    *allocResult.DeepCopy(),
+				hasRequest:     hasRequest,
+				hasResult:      hasResult,
+				podGone:        podGone,
+			})
+		}
+	}
+	return orphans, nil
+}
+
+// allocationOrphaned reports whether the pod of an allocation is gone, was recreated under the
+// same name or terminated without the finalizer, none of which is reconciled again
+func (r *InstasliceReconciler) allocationOrphaned(ctx context.Context, podUID types.UID, allocRequest inferencev1alpha1.AllocationRequest, hasRequest bool) (bool, error) {
+	if !hasRequest {
+		return true, nil
+	}
+	pod := &v1.Pod{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: allocRequest.PodRef.Namespace, Name: allocRequest.PodRef.Name}, pod)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if pod.UID != podUID {
+		return true, nil
+	}
+	terminated := pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+	return terminated && !controllerutil.ContainsFinalizer(pod, FinalizerName), nil
+}
+
+// allocationOrphansPastGrace returns the orphaned allocations seen for the grace period, under
+// r.mu. Requests without a result are only orphaned when the allocation cache holds no result
+// written since either. Nothing is returned when running dry.
+func (r *InstasliceReconciler) allocationOrphansPastGrace(ctx context.Context, instaslices []inferencev1alpha1.Instaslice, orphans []orphanCandidate, now time.Time, seen map[string]bool) []orphanCandidate {
+	log := logr.FromContext(ctx)
+	detected := make(map[string]int, len(instaslices))
+	for _, instaslice := range instaslices {
+		detected[instaslice.Name] = 0
+	}
+	var reclaimed []orphanCandidate
+	for _, candidate := range orphans {
+		// placements being written have no result yet
+		if r.allocationCache.Reserved(candidate.podUID) {
+			continue
+		}
+		// results written since are in the allocation cache before they reach the informer
+		_, cached := r.allocationCache.Get(candidate.podUID)
+		if !candidate.podGone && (candidate.hasResult || cached) {
+			continue
+		}
+		if !r.orphanPastGrace(candidate.key, now, seen) {
+			continue
+		}
+		detected[candidate.instasliceName]++
+		if r.Config.OrphanGCDryRun {
+			log.Info("orphaned allocation would be reclaimed", "instaslice", candidate.instasliceName, "pod", candidate.podUID, "podRef", candidate.allocRequest.PodRef)
+			continue
+		}
+		if candidate.hasRequest && candidate.hasResult && candidate.allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting &&
+			candidate.allocResult.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted {
+			// the daemonset is releasing the slices
+			continue
+		}
+		reclaimed = append(reclaimed, candidate)
+	}
+	for instasliceName, count := range detected {
+		SetOrphansDetectedMetrics(OrphanKindAllocation, instasliceName, count)
+	}
+	return reclaimed
+}
+
+// readConfigMapOrphans returns the ConfigMaps labeled by the daemonset that neither an allocation
+// nor a pod refers to. The daemonset creates them in the namespace of their pod, so they are only
+// looked up in the namespaces of the allocations, of the pods of GPU containers and of the
+// ConfigMaps found by the previous sweep. It reads the API and must not run under r.mu.
+func (r *InstasliceReconciler) readConfigMapOrphans(ctx context.Context, instaslices []inferencev1alpha1.Instaslice) ([]orphanCandidate, error) {
+	referenced := make(map[string]bool)
+	namespaces := make(map[string]bool)
+	r.mu.Lock()
+	for namespace := range r.configMapNamespaces {
+		namespaces[namespace] = true
+	}
+	r.mu.Unlock()
+	for _, instaslice := range instaslices {
+		for _, allocRequest := range instaslice.Spec.PodAllocationRequests {
+			namespaces[allocRequest.PodRef.Namespace] = true
+		}
+		for _, allocResult := range instaslice.Status.PodAllocationResults {
+			referenced[string(allocResult.ConfigMapResourceIdentifier)] = true
+			for _, container := range allocResult.AllContainers() {
+				referenced[string(container.ConfigMapResourceIdentifier)] = true
+			}
+		}
+	}
+	// pods whose allocation was withdrawn get the same ConfigMaps with their next allocation
+	var podList v1.PodList
+	if err := r.List(ctx, &podList); err != nil {
+		return nil, err
+	}
+	for i := range podList.Items {
+		for _, configMapName := range containerConfigMaps(&podList.Items[i]) {
+			referenced[configMapName] = true
+			namespaces[podList.Items[i].Namespace] = true
+		}
+	}
+
+	var orphans []orphanCandidate
+	found := make(map[string]bool)
+	for namespace := range namespaces {
+		var configMapList v1.ConfigMapList
+		if err := r.List(ctx, &configMapList, client.InNamespace(namespace),
+			client.MatchingLabels{AllocationConfigMapLabel: AllocationConfigMapTrue}); err != nil {
+			return nil, err
+		}
+		if len(configMapList.Items) > 0 {
+			found[namespace] = true
+		}
+		for i := range configMapList.Items {
+			configMap := &configMapList.Items[i]
+			if referenced[configMap.Name] {
+				continue
+			}
+			orphans = append(orphans, orphanCandidate{kind: OrphanKindConfigMap, key: OrphanKindConfigMap + "/" + configMap.Namespace + "/" + configMap.Name, configMap: configMap})
+		}
+	}
+	r.mu.Lock()
+	r.configMapNamespaces = found
+	r.mu.Unlock()
+	return orphans, nil
+}
+
+// configMapOrphansPastGrace returns the orphaned ConfigMaps seen for the grace period, under r.mu.
+// Nothing is returned when running dry.
+func (r *InstasliceReconciler) configMapOrphansPastGrace(ctx context.Context, orphans []orphanCandidate, now time.Time, seen map[string]bool) []orphanCandidate {
+	log := logr.FromContext(ctx)
+	detected := 0
+	var reclaimed []orphanCandidate
+	for _, candidate := range orphans {
+		if !r.orphanPastGrace(candidate.key, now, seen) {
+			continue
+		}
+		detected++
+		if r.Config.OrphanGCDryRun {
+			log.Info("orphaned ConfigMap would be deleted", "name", candidate.configMap.Name, "namespace", candidate.configMap.Namespace)
+			continue
+		}
+		reclaimed = append(reclaimed, candidate)
+	}
+	SetOrphansDetectedMetrics(OrphanKindConfigMap, "", detected)
+	return reclaimed
+}
+
+// reclaimOrphan deletes an orphaned ConfigMap, or moves an orphaned allocation to deleting so
+// that the daemonset releases its slices and removes it once they are released or were never
+// placed. It must not run under r.mu.
+func (r *InstasliceReconciler) reclaimOrphan(ctx context.Context, candidate orphanCandidate) error {
+	log := logr.FromContext(ctx)
+	switch {
+	case candidate.kind == OrphanKindConfigMap:
+		log.Info("deleting orphaned ConfigMap", "name", candidate.configMap.Name, "namespace", candidate.configMap.Namespace)
+		if err := r.Delete(ctx, candidate.configMap); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		IncrementOrphansReclaimedMetrics(OrphanKindConfigMap, "")
+	case candidate.hasRequest && candidate.hasResult && candidate.allocResult.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted:
+		log.Info("releasing orphaned allocation", "instaslice", candidate.instasliceName, "pod", candidate.podUID, "podRef", candidate.allocRequest.PodRef)
+		releasing := candidate.allocResult
+		r.markReleasing(candidate.instasliceName, &candidate.allocRequest, &releasing, orphanedReason, "the pod of the allocation no longer exists")
+		if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.ControllerFieldManager, candidate.instasliceName, &releasing, &candidate.allocRequest); err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.updateCacheWithNewAllocation(candidate.podUID, releasing)
+		r.mu.Unlock()
+		return nil
+	default:
+		// the slices were released by the daemonset, or never placed
+		log.Info("removing orphaned allocation", "instaslice", candidate.instasliceName, "pod", candidate.podUID, "podRef", candidate.allocRequest.PodRef)
+		if err := r.removeReleasedAllocation(ctx, candidate.instasliceName, candidate.podUID); err != nil {
+			return err
+		}
+		if candidate.hasResult {
+			r.ResetDeployedPodTotalMetrics(&candidate.allocResult, &candidate.allocRequest)
+		}
+		IncrementOrphansReclaimedMetrics(OrphanKindAllocation, candidate.instasliceName)
+	}
+	r.mu.Lock()
+	delete(r.orphans, candidate.key)
+	r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestOrphanCollector(t *testing.T) {
+	ctx := context.Background()
+	instasliceMetrics.orphansDetected.Reset()
+	instasliceMetrics.orphansReclaimed.Reset()
+	const (
+		liveConfigMap    = "6f1c1f5e-8a59-4a4e-9c55-0f1d6c2b7a01"
+		waitingConfigMap = "6f1c1f5e-8a59-4a4e-9c55-0f1d6c2b7a02"
+		orphanConfigMap  = "6f1c1f5e-8a59-4a4e-9c55-0f1d6c2b7a03"
+		otherConfigMap   = "6f1c1f5e-8a59-4a4e-9c55-0f1d6c2b7a04"
+	)
+	created := inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusUngated, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}
+	configMap := func(name, namespace string, labels map[string]string) *v1.ConfigMap {
+		return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Data: map[string]string{"NVIDIA_VISIBLE_DEVICES": "MIG-1", "CUDA_VISIBLE_DEVICES": "MIG-1"}}
+	}
+	labeled := map[string]string{AllocationConfigMapLabel: AllocationConfigMapTrue}
+	r, instaslice := newAllocationFixture(t, []testAllocation{
+		{pod: "live", profile: "1g.5gb", start: 0, size: 1},
+		// force deleted
+		{pod: "gone", profile: "1g.5gb", start: 1, size: 1},
+		// released by the daemonset after the pod lost its finalizer
+		{pod: "released", profile: "1g.5gb", start: 2, size: 1, status: inferencev1alpha1.AllocationStatus{
+			AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusDeleted}},
+		// a pod of the same name replaced the pod of the allocation
+		{pod: "recreated", profile: "1g.5gb", start: 3, size: 1, status: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}},
+		// completed without the finalizer
+		{pod: "completed", profile: "1g.5gb", start: 4, size: 1},
+	},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "live", Namespace: "default", UID: "live", Finalizers: []string{FinalizerName}}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "recreated", Namespace: "default", UID: "replacement"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "completed", Namespace: "default", UID: "completed"}, Status: v1.PodStatus{Phase: v1.PodSucceeded}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "waiting", Namespace: "default", UID: "waiting",
+			Annotations: map[string]string{ContainerConfigMapsAnnotation: `{"main":"` + waitingConfigMap + `"}`}}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "leftover", Namespace: "default", UID: "leftover"}},
+		configMap(liveConfigMap, "default", labeled), configMap(waitingConfigMap, "default", labeled),
+		configMap(orphanConfigMap, "default", labeled),
+		// not created by the daemonset
+		configMap(otherConfigMap, "default", nil),
+		// in a namespace without allocations nor pods of GPU containers
+		configMap(orphanConfigMap, "elsewhere", labeled),
+	)
+	instaslice.Spec.PodAllocationRequests["dangling"] = inferencev1alpha1.AllocationRequest{
+		Profile: "1g.5gb",
+		PodRef:  v1.ObjectReference{Name: "dangling", Namespace: "default", UID: "dangling"},
+	}
+	// the result of the allocation of a live pod failed to be written
+	instaslice.Spec.PodAllocationRequests["leftover"] = inferencev1alpha1.AllocationRequest{
+		Profile: "1g.5gb",
+		PodRef:  v1.ObjectReference{Name: "leftover", Namespace: "default", UID: "leftover"},
+	}
+	assert.NoError(t, r.Update(ctx, instaslice))
+	live := instaslice.Status.PodAllocationResults["live"]
+	live.ConfigMapResourceIdentifier = liveConfigMap
+	instaslice.Status.PodAllocationResults["live"] = live
+	assert.NoError(t, r.Status().Update(ctx, instaslice))
+	r.Config.OrphanGCGracePeriod = time.Minute
+	configMapExists := func(name, namespace string) bool {
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &v1.ConfigMap{})
+		assert.True(t, err == nil || apierrors.IsNotFound(err))
+		return err == nil
+	}
+
+	// orphans are left alone for the grace period, and only reported when running dry
+	now := time.Now()
+	assert.NoError(t, r.collectOrphans(ctx, now))
+	r.Config.OrphanGCDryRun = true
+	assert.NoError(t, r.collectOrphans(ctx, now.Add(2*time.Minute)))
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	assert.Len(t, instaslice.Spec.PodAllocationRequests, 7)
+	assert.Equal(t, created, instaslice.Status.PodAllocationResults["gone"].AllocationStatus)
+	assert.True(t, configMapExists(orphanConfigMap, "default"))
+	assert.Equal(t, 6.0, testutil.ToFloat64(instasliceMetrics.orphansDetected.WithLabelValues(OrphanKindAllocation, "node-1")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(instasliceMetrics.orphansDetected.WithLabelValues(OrphanKindConfigMap, "")))
+	assert.Equal(t, 0, testutil.CollectAndCount(instasliceMetrics.orphansReclaimed))
+
+	// allocations holding slices are released by the daemonset, the others are removed
+	r.Config.OrphanGCDryRun = false
+	assert.NoError(t, r.collectOrphans(ctx, now.Add(3*time.Minute)))
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	assert.NotContains(t, instaslice.Spec.PodAllocationRequests, types.UID("released"))
+	assert.NotContains(t, instaslice.Status.PodAllocationResults, types.UID("released"))
+	assert.NotContains(t, instaslice.Spec.PodAllocationRequests, types.UID("dangling"))
+	assert.NotContains(t, instaslice.Spec.PodAllocationRequests, types.UID("leftover"))
+	for _, podUID := range []types.UID{"gone", "recreated", "completed"} {
+		allocResult := instaslice.Status.PodAllocationResults[podUID]
+		assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, allocResult.AllocationStatus.AllocationStatusController, "pod %s", podUID)
+		releasing := meta.FindStatusCondition(allocResult.Conditions, inferencev1alpha1.AllocationConditionReleasing)
+		if assert.NotNil(t, releasing) {
+			assert.Equal(t, orphanedReason, releasing.Reason)
+		}
+	}
+	assert.Equal(t, created, instaslice.Status.PodAllocationResults["live"].AllocationStatus)
+	assert.False(t, configMapExists(orphanConfigMap, "default"))
+	assert.True(t, configMapExists(liveConfigMap, "default"))
+	assert.True(t, configMapExists(waitingConfigMap, "default"))
+	assert.True(t, configMapExists(otherConfigMap, "default"))
+	assert.True(t, configMapExists(orphanConfigMap, "elsewhere"))
+	assert.Equal(t, map[string]bool{"default": true}, r.configMapNamespaces)
+	assert.Equal(t, 3.0, testutil.ToFloat64(instasliceMetrics.orphansReclaimed.WithLabelValues(OrphanKindAllocation, "node-1")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(instasliceMetrics.orphansReclaimed.WithLabelValues(OrphanKindConfigMap, "")))
+
+	// and removed once the daemonset deleted their slices
+	deleted := instaslice.Status.PodAllocationResults["gone"]
+	deleted.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+	allocRequest := instaslice.Spec.PodAllocationRequests["gone"]
+	assert.NoError(t, utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, utils.DaemonsetFieldManager, "node-1", &deleted, &allocRequest))
+	assert.NoError(t, r.collectOrphans(ctx, now.Add(4*time.Minute)))
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	assert.NotContains(t, instaslice.Status.PodAllocationResults, types.UID("gone"))
+	assert.Contains(t, instaslice.Status.PodAllocationResults, types.UID("recreated"))
+	assert.Equal(t, 4.0, testutil.ToFloat64(instasliceMetrics.orphansReclaimed.WithLabelValues(OrphanKindAllocation, "node-1")))
+	_, cached := r.allocationCache.Get("gone")
+	assert.False(t, cached)
+}
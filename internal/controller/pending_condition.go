@@ -0,0 +1,198 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// A gated pod whose slices fit no node carries the PodConditionSlicesPlaced condition set to
+// False, the way the kube-scheduler sets PodScheduled for unschedulable pods. Its message tells
+// why every Instaslice node was rejected, so that users can find out without the logs of the
+// controller. The condition turns True once the slices of the pod are placed.
+
+// PodConditionSlicesPlaced tells whether the slices of a gated pod are placed on a node
+const PodConditionSlicesPlaced v1.PodConditionType = OrgInstaslicePrefix + "SlicesPlaced"
+
+// Reasons a node is rejected for the slices of a pod
+const (
+	RejectionProfileNotOffered     = "ProfileNotOffered"
+	RejectionNoContiguousSlots     = "NoContiguousSlots"
+	RejectionInsufficientResources = "InsufficientResources"
+	RejectionBootIDMismatch        = "BootIDMismatch"
+	RejectionNodeUnmanaged         = "NodeUnmanaged"
+	RejectionNodeFiltered          = "NodeFiltered"
+	RejectionNodeBackingOff        = "NodeBackingOff"
+	// RejectionError is the reason of the nodes that could not be checked
+	RejectionError = "Error"
+)
+
+// nodeRejection is the error returned when the slices of a pod do not fit a node
+type nodeRejection struct {
+	reason  string
+	message string
+}
+
+func (e *nodeRejection) Error() string {
+	return e.message
+}
+
+// rejectNode returns the nodeRejection of reason with a formatted message
+func rejectNode(reason, format string, args ...any) error {
+	return &nodeRejection{reason: reason, message: fmt.Sprintf(format, args...)}
+}
+
+// rejectionReason returns the reason of a nodeRejection, RejectionError for other errors
+func rejectionReason(err error) string {
+	var rejection *nodeRejection
+	if errors.As(err, &rejection) {
+		return rejection.reason
+	}
+	return RejectionError
+}
+
+// instasliceNodeUnavailable returns why no slice may be placed on the node of an instaslice, nil
+// when it is available. The boot ID of the instaslice must be the one of the node, otherwise the
+// daemonset did not discover the GPUs since the node rebooted. A node whose managed label is set
+// to another value than true is being removed from InstaSlice.
+func instasliceNodeUnavailable(instaslice *inferencev1alpha1.Instaslice, node *v1.Node) error {
+	if value, ok := node.Labels[ManagedLabel]; ok && value != InstasliceManagedTrue {
+		return rejectNode(RejectionNodeUnmanaged, "the node is not managed by InstaSlice, label %s is %q", ManagedLabel, value)
+	}
+	if instaslice.Status.NodeResources.BootID == "" {
+		return rejectNode(RejectionBootIDMismatch, "the daemonset has not reported the boot ID of the node yet")
+	}
+	if instaslice.Status.NodeResources.BootID != node.Status.NodeInfo.BootID {
+		return rejectNode(RejectionBootIDMismatch, "instaslice not in sync with the node as the boot id %s doesn't match the boot id %s of the node",
+			instaslice.Status.NodeResources.BootID, node.Status.NodeInfo.BootID)
+	}
+	return nil
+}
+
+// unofferedProfile returns a profile requested by the containers that the node does not offer,
+// ok is false when the node offers a profile for every container
+func unofferedProfile(instaslice *inferencev1alpha1.Instaslice, containers []inferencev1alpha1.ContainerRequest) (string, bool) {
+	offered := instaslice.Status.NodeResources.MigPlacement
+	for _, container := range containers {
+		if isSizedRequest(container) {
+			if _, ok := smallestProfileForSize(instaslice, container.AcceleratorMemory, container.ComputePercent); !ok {
+				return fmt.Sprintf("large enough for container %s", container.Name), true
+			}
+			continue
+		}
+		profiles := container.AcceptableProfiles
+		if len(profiles) == 0 {
+			profiles = []string{container.Profile}
+		}
+		found := false
+		for _, profile := range profiles {
+			if _, ok := offered[profile]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return strings.Join(profiles, " or "), true
+		}
+	}
+	return "", false
+}
+
+// placementRejection tells why the slices of the containers were not placed on the node of instaslice
+func (r *InstasliceReconciler) placementRejection(instaslice *inferencev1alpha1.Instaslice, containers []inferencev1alpha1.ContainerRequest, pod *v1.Pod) error {
+	if profile, unoffered := unofferedProfile(instaslice, containers); unoffered {
+		return rejectNode(RejectionProfileNotOffered, "the GPUs of the node offer no profile %s", profile)
+	}
+	if insufficient := r.ResourceCache.Insufficient(instaslice.Name, pod); len(insufficient) > 0 {
+		return rejectNode(RejectionInsufficientResources, "%s", strings.Join(insufficient, ", "))
+	}
+	return rejectNode(RejectionNoContiguousSlots, "no GPU of the node has enough free contiguous slots for the slices of the pod")
+}
+
+// noFitMessage summarizes why no node fits the slices of a pod, the way the kube-scheduler does:
+// the number of nodes rejected for each reason followed by the rejection of every node
+func noFitMessage(nodes int, rejections map[string]error) string {
+	if len(rejections) == 0 {
+		return fmt.Sprintf("0/%d nodes fit the slices of the pod: no Instaslice node is available", nodes)
+	}
+	counts := make(map[string]int)
+	names := make([]string, 0, len(rejections))
+	for name, err := range rejections {
+		counts[rejectionReason(err)]++
+		names = append(names, name)
+	}
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	summary := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		summary = append(summary, fmt.Sprintf("%d %s", counts[reason], reason))
+	}
+	sort.Strings(names)
+	details := make([]string, 0, len(names))
+	for _, name := range names {
+		details = append(details, fmt.Sprintf("%s: %v", name, rejections[name]))
+	}
+	return fmt.Sprintf("0/%d nodes fit the slices of the pod: %s. %s", nodes, strings.Join(summary, ", "), strings.Join(details, "; "))
+}
+
+// setSlicesPlacedCondition records on the pod whether its slices are placed. The pod is only
+// written when the condition changes, and the condition is only added to pods that waited.
+func (r *InstasliceReconciler) setSlicesPlacedCondition(ctx context.Context, pod *v1.Pod, status v1.ConditionStatus, reason, message string) error {
+	condition := v1.PodCondition{
+		Type:               PodConditionSlicesPlaced,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+	updated := pod.DeepCopy()
+	found := false
+	for i, existing := range updated.Status.Conditions {
+		if existing.Type != PodConditionSlicesPlaced {
+			continue
+		}
+		if existing.Status == status && existing.Reason == reason && existing.Message == message {
+			return nil
+		}
+		if existing.Status == status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		updated.Status.Conditions[i] = condition
+		found = true
+		break
+	}
+	if !found {
+		if status == v1.ConditionTrue {
+			return nil
+		}
+		updated.Status.Conditions = append(updated.Status.Conditions, condition)
+	}
+	return r.Status().Patch(ctx, updated, client.StrategicMergeFrom(pod))
+}
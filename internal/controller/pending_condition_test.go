@@ -0,0 +1,131 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestPlacementRejection(t *testing.T) {
+	ctx := context.Background()
+	r, instaslice := newAllocationFixture(t, nil)
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "model", Namespace: "default", UID: "model"}}
+
+	tests := []struct {
+		name       string
+		containers []inferencev1alpha1.ContainerRequest
+		pod        *v1.Pod
+		reason     string
+	}{
+		{"profile not offered", []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "9g.90gb"}}, pod, RejectionProfileNotOffered},
+		{"no acceptable profile offered", []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "9g.90gb", AcceptableProfiles: []string{"9g.90gb", "8g.80gb"}}}, pod, RejectionProfileNotOffered},
+		{"no contiguous slots", []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "7g.40gb", Quantity: 3}}, pod, RejectionNoContiguousSlots},
+		{"cpu does not fit", []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "3g.20gb"}}, &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "big", Namespace: "default", UID: "big"},
+			Spec: v1.PodSpec{Containers: []v1.Container{{Name: "main", Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")},
+			}}}},
+		}, RejectionInsufficientResources},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := r.findNodeAndDeviceForASlice(ctx, instaslice, tt.containers, &FirstFitPolicy{}, tt.pod)
+			assert.Error(t, err)
+			assert.Equal(t, tt.reason, rejectionReason(err))
+		})
+	}
+}
+
+func TestInstasliceNodeUnavailable(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{BootID: instaslice.Status.NodeResources.BootID}},
+	}
+	assert.NoError(t, instasliceNodeUnavailable(instaslice, node))
+
+	rebooted := node.DeepCopy()
+	rebooted.Status.NodeInfo.BootID = "rebooted"
+	assert.Equal(t, RejectionBootIDMismatch, rejectionReason(instasliceNodeUnavailable(instaslice, rebooted)))
+
+	unmanaged := node.DeepCopy()
+	unmanaged.Labels = map[string]string{ManagedLabel: "false"}
+	assert.Equal(t, RejectionNodeUnmanaged, rejectionReason(instasliceNodeUnavailable(instaslice, unmanaged)))
+}
+
+func TestNoFitMessage(t *testing.T) {
+	assert.Equal(t, "0/0 nodes fit the slices of the pod: no Instaslice node is available", noFitMessage(0, nil))
+	message := noFitMessage(3, map[string]error{
+		"node-b": rejectNode(RejectionNoContiguousSlots, "no room"),
+		"node-a": rejectNode(RejectionNoContiguousSlots, "no room"),
+		"node-c": fmt.Errorf("timeout"),
+	})
+	assert.Equal(t, "0/3 nodes fit the slices of the pod: 1 Error, 2 NoContiguousSlots. node-a: no room; node-b: no room; node-c: timeout", message)
+}
+
+func TestSetSlicesPlacedCondition(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(scheme))
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "model", Namespace: "default", UID: "model"}}
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).WithStatusSubresource(pod).Build()
+	r := &InstasliceReconciler{Client: fakeClient}
+	condition := func() *v1.PodCondition {
+		assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), pod))
+		for i := range pod.Status.Conditions {
+			if pod.Status.Conditions[i].Type == PodConditionSlicesPlaced {
+				return &pod.Status.Conditions[i]
+			}
+		}
+		return nil
+	}
+
+	// pods placed right away do not get the condition
+	assert.NoError(t, r.setSlicesPlacedCondition(ctx, pod, v1.ConditionTrue, EventReasonSlicesPlaced, "placed"))
+	assert.Nil(t, condition())
+
+	assert.NoError(t, r.setSlicesPlacedCondition(ctx, pod, v1.ConditionFalse, EventReasonNoFit, "0/1 nodes fit"))
+	if got := condition(); assert.NotNil(t, got) {
+		assert.Equal(t, v1.ConditionFalse, got.Status)
+		assert.Equal(t, "0/1 nodes fit", got.Message)
+	}
+
+	// the same condition is not written again
+	resourceVersion := pod.ResourceVersion
+	assert.NoError(t, r.setSlicesPlacedCondition(ctx, pod, v1.ConditionFalse, EventReasonNoFit, "0/1 nodes fit"))
+	condition()
+	assert.Equal(t, resourceVersion, pod.ResourceVersion)
+
+	assert.NoError(t, r.setSlicesPlacedCondition(ctx, pod, v1.ConditionTrue, EventReasonSlicesPlaced, "placed"))
+	if got := condition(); assert.NotNil(t, got) {
+		assert.Equal(t, v1.ConditionTrue, got.Status)
+		assert.Equal(t, EventReasonSlicesPlaced, got.Reason)
+	}
+}
@@ -0,0 +1,312 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// Gated pods waiting for slices are kept in a queue ordered by priority, then creation time.
+// With fair share enabled, pods of namespaces holding fewer slots go first among pods of the
+// same priority. Pods are allocated in queue order: the first pod that has to wait for slices
+// to be released blocks the pods behind it, so that a stream of small pods cannot starve a
+// large one. Pods that fit on no node even when all its slices are free, or that no node
+// accepts for their CPU and memory or scheduling constraints, do not block anyone. The queue is
+// simulated when slices are placed or released, and again when a pod joins it ahead of the first
+// pod that has to wait, the pods reconciled in between read the outcome of the last simulation.
+
+const (
+	// wakeupBufferSize is the number of wakeups that can be pending before new ones are dropped,
+	// dropped pods are still retried after Requeue10sDelay
+	wakeupBufferSize = 1024
+	// queueOutcomeLifetime is how long the outcome of a simulation of the queue is used, nodes
+	// and exclusions change without the queue being told
+	queueOutcomeLifetime = Requeue10sDelay
+)
+
+// pendingPod is a gated pod waiting in the queue
+type pendingPod struct {
+	pod        *v1.Pod
+	containers []inferencev1alpha1.ContainerRequest
+}
+
+// pendingQueue holds the gated pods without an allocation
+type pendingQueue struct {
+	pods map[types.NamespacedName]*pendingPod
+	// outcome is the last simulation of the queue, nil once pods joined or left it since
+	outcome *queueOutcome
+}
+
+// queueOutcome is the outcome of a simulation of the queue: the pods that fit next to the pods
+// ahead of them, in queue order, and the first pod that has to wait, which every pod ordered
+// behind it waits for
+type queueOutcome struct {
+	fit       []*pendingPod
+	blocker   *pendingPod
+	usage     map[string]int32
+	fairShare bool
+	simulated time.Time
+}
+
+// allows reports whether it is the turn of the pod to be allocated
+func (o *queueOutcome) allows(podUID types.UID) bool {
+	if o.blocker != nil && o.blocker.pod.UID == podUID {
+		return true
+	}
+	for _, pending := range o.fit {
+		if pending.pod.UID == podUID {
+			return true
+		}
+	}
+	return false
+}
+
+func newPendingQueue() *pendingQueue {
+	return &pendingQueue{pods: make(map[types.NamespacedName]*pendingPod)}
+}
+
+// add queues pod, replacing an earlier pod of the same name. The outcome of the last simulation
+// is dropped when the pod is new to the queue and nothing keeps it from being allocated, pods
+// ordered behind the first pod that has to wait wait as well.
+func (q *pendingQueue) add(pod *v1.Pod, containers []inferencev1alpha1.ContainerRequest) {
+	name := types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}
+	if queued, ok := q.pods[name]; (!ok || queued.pod.UID != pod.UID) && q.outcome != nil {
+		if q.outcome.blocker == nil || queuedBefore(pod, q.outcome.blocker.pod, q.outcome.usage, q.outcome.fairShare) {
+			q.outcome = nil
+		}
+	}
+	q.pods[name] = &pendingPod{pod: pod.DeepCopy(), containers: containers}
+}
+
+// remove drops the pod from the queue and reports whether it was queued
+func (q *pendingQueue) remove(name types.NamespacedName) bool {
+	_, ok := q.pods[name]
+	if ok {
+		q.outcome = nil
+	}
+	delete(q.pods, name)
+	return ok
+}
+
+// queuedBefore reports whether pod a is allocated before pod b, usage holds the slots allocated
+// to each namespace and is only used with fair share
+func queuedBefore(a, b *v1.Pod, usage map[string]int32, fairShare bool) bool {
+	if podPriority(a) != podPriority(b) {
+		return podPriority(a) > podPriority(b)
+	}
+	if fairShare && usage[a.Namespace] != usage[b.Namespace] {
+		return usage[a.Namespace] < usage[b.Namespace]
+	}
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+// ordered returns the queued pods in allocation order, usage holds the slots allocated to
+// each namespace and is only used with fair share
+func (q *pendingQueue) ordered(usage map[string]int32, fairShare bool) []*pendingPod {
+	pods := make([]*pendingPod, 0, len(q.pods))
+	for _, pending := range q.pods {
+		pods = append(pods, pending)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return queuedBefore(pods[i].pod, pods[j].pod, usage, fairShare)
+	})
+	return pods
+}
+
+// pendingQueue returns the queue of the reconciler, creating it on first use
+func (r *InstasliceReconciler) pendingQueue() *pendingQueue {
+	if r.pending == nil {
+		r.pending = newPendingQueue()
+	}
+	return r.pending
+}
+
+// namespaceUsage returns the slots allocated to the pods of every namespace
+func namespaceUsage(instaslices []inferencev1alpha1.Instaslice) map[string]int32 {
+	usage := make(map[string]int32)
+	for _, instaslice := range instaslices {
+		for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+			if allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				continue
+			}
+			namespace := instaslice.Spec.PodAllocationRequests[podUID].PodRef.Namespace
+			for _, slots := range slotsPerGPU(&allocResult) {
+				usage[namespace] += slots
+			}
+		}
+	}
+	return usage
+}
+
+// pendingPodsThatFit simulates the allocation of the queued pods in order. It returns the pods
+// that fit on the nodes next to the pods ahead of them, and the first pod that has to wait for
+// slices to be released, if any, at which the simulation stops. The nodes of the instaslices are
+// read by the caller before it takes r.mu, instaslices without a node are skipped.
+func (r *InstasliceReconciler) pendingPodsThatFit(ctx context.Context, instaslices []inferencev1alpha1.Instaslice, nodes map[string]*v1.Node, policy AllocationPolicy) ([]*pendingPod, *pendingPod) {
+	allocated := make(map[string][]GPUCandidate)
+	for i := range instaslices {
+		allocated[instaslices[i].Name] = r.gpuCandidates(&instaslices[i])
+	}
+	var fit []*pendingPod
+	// reserved slices taken by the pods ahead
+	taken := make(map[types.UID]bool)
+	for _, pending := range r.pendingQueue().ordered(namespaceUsage(instaslices), r.pendingQueueFairShare()) {
+		ordered := append([]inferencev1alpha1.Instaslice(nil), instaslices...)
+		r.sortInstaslicesForPod(ctx, pending.pod, ordered)
+		placed, blocking := false, false
+		for i := range ordered {
+			instaslice := &ordered[i]
+			if r.isNodeExcluded(instaslice.Name) || r.ResourceCache != nil && !r.ResourceCache.Fits(instaslice.Name, pending.pod) {
+				continue
+			}
+			if node, ok := nodes[instaslice.Name]; !ok {
+				continue
+			} else if fits, _ := podFitsNode(pending.pod, node); !fits {
+				continue
+			}
+			candidates := r.withoutExcludedGPUs(podGPUCandidates(instaslice, pending.pod, cloneGPUCandidates(allocated[instaslice.Name])))
+			r.reserveNominatedSlices(instaslice.Name, pending.pod, pending.containers, candidates)
+			reserved := r.freeReservedSlices(instaslice.Name, pending.pod.Namespace, taken)
+			if _, _, keys, ok := r.placeReservedSlices(instaslice, pending.containers, policy, candidates, reserved); ok {
+				for _, key := range keys {
+					taken[key] = true
+				}
+				placed = true
+				break
+			}
+			if slices, _, ok := r.placeContainerSlices(instaslice, pending.containers, policy, candidates); ok {
+				// the slices are taken for the pods behind this one
+				for _, slice := range slices {
+					for _, gpu := range allocated[instaslice.Name] {
+						if gpu.GPUUUID == slice.GPUUUID {
+							gpu.Allocated.Allocate(slice.MigPlacement.Start, slice.MigPlacement.Size)
+						}
+					}
+				}
+				placed = true
+				break
+			}
+			// a pod that does not even fit on the empty node waits for nothing that could be released
+			empty := podGPUCandidates(instaslice, pending.pod, r.gpuCandidatesExcluding(instaslice, allPods(r.allocationCache.Snapshot())))
+			if _, _, ok := r.placeContainerSlices(instaslice, pending.containers, policy, empty); ok {
+				blocking = true
+			}
+		}
+		if placed {
+			fit = append(fit, pending)
+			continue
+		}
+		if blocking {
+			return fit, pending
+		}
+	}
+	return fit, nil
+}
+
+// allPods returns the pods of the allocations
+func allPods(allocations map[types.UID]inferencev1alpha1.AllocationResult) map[types.UID]bool {
+	pods := make(map[types.UID]bool, len(allocations))
+	for podUID := range allocations {
+		pods[podUID] = true
+	}
+	return pods
+}
+
+// cloneGPUCandidates returns a copy of candidates whose slots can be modified
+func cloneGPUCandidates(candidates []GPUCandidate) []GPUCandidate {
+	clones := make([]GPUCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		clones = append(clones, GPUCandidate{GPUUUID: candidate.GPUUUID, Allocated: candidate.Allocated.Clone(), Shared: cloneSharedGPUInstances(candidate.Shared)})
+	}
+	return clones
+}
+
+// pendingQueueFairShare reports whether pods of namespaces holding fewer slots go first
+func (r *InstasliceReconciler) pendingQueueFairShare() bool {
+	return r.Config != nil && r.Config.PendingQueueFairShare
+}
+
+// simulatePendingQueue simulates the allocation of the queued pods and keeps the outcome for the
+// pods reconciled until the next simulation
+func (r *InstasliceReconciler) simulatePendingQueue(ctx context.Context, instaslices []inferencev1alpha1.Instaslice, nodes map[string]*v1.Node, policy AllocationPolicy) *queueOutcome {
+	fit, blocker := r.pendingPodsThatFit(ctx, instaslices, nodes, policy)
+	outcome := &queueOutcome{fit: fit, blocker: blocker, usage: namespaceUsage(instaslices), fairShare: r.pendingQueueFairShare(), simulated: time.Now()}
+	r.pendingQueue().outcome = outcome
+	return outcome
+}
+
+// mayAllocate queues a gated pod and reports whether it is its turn to be allocated, which is
+// when it fits next to the pods ahead of it or when it is the first pod that has to wait. The
+// outcome of the last simulation of the queue is used while it is recent enough.
+func (r *InstasliceReconciler) mayAllocate(ctx context.Context, pod *v1.Pod, containers []inferencev1alpha1.ContainerRequest, instaslices []inferencev1alpha1.Instaslice,
+	nodes map[string]*v1.Node, policy AllocationPolicy) bool {
+	queue := r.pendingQueue()
+	queue.add(pod, containers)
+	outcome := queue.outcome
+	if outcome == nil || time.Since(outcome.simulated) >= queueOutcomeLifetime {
+		outcome = r.simulatePendingQueue(ctx, instaslices, nodes, policy)
+	}
+	return outcome.allows(pod.UID)
+}
+
+// wakePendingPods simulates the queue once slices were placed or released and reconciles the
+// queued pods that fit now, without waiting for their next retry
+func (r *InstasliceReconciler) wakePendingPods(ctx context.Context, instaslices []inferencev1alpha1.Instaslice, nodes map[string]*v1.Node, policy AllocationPolicy) {
+	if len(r.pendingQueue().pods) == 0 {
+		r.pendingQueue().outcome = nil
+		return
+	}
+	outcome := r.simulatePendingQueue(ctx, instaslices, nodes, policy)
+	if r.wakeups == nil {
+		return
+	}
+	for _, pending := range outcome.fit {
+		select {
+		case r.wakeups <- event.GenericEvent{Object: pending.pod}:
+		default:
+		}
+	}
+}
+
+// wakePod reconciles the pod of an allocation. Pods whose allocation moved or went away may not
+// be reconciled otherwise, unlike the pending pods the wakeup is not dropped when the buffer is
+// full, it waits until there is room or ctx is done.
+func (r *InstasliceReconciler) wakePod(ctx context.Context, podRef v1.ObjectReference) {
+	if r.wakeups == nil {
+		return
+	}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: podRef.Namespace, Name: podRef.Name, UID: podRef.UID}}
+	select {
+	case r.wakeups <- event.GenericEvent{Object: pod}:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func pendingTestPod(namespace, name string, priority int32, age time.Duration) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(name), CreationTimestamp: metav1.NewTime(time.Now().Add(-age))},
+		Spec:       v1.PodSpec{Priority: &priority},
+	}
+}
+
+func TestPendingQueueOrder(t *testing.T) {
+	q := newPendingQueue()
+	q.add(pendingTestPod("team-a", "old-low", 0, 3*time.Minute), nil)
+	q.add(pendingTestPod("team-a", "new-high", 10, time.Minute), nil)
+	q.add(pendingTestPod("team-a", "new-low", 0, time.Minute), nil)
+	q.add(pendingTestPod("team-b", "newer-low", 0, 30*time.Second), nil)
+	names := func(pods []*pendingPod) []string {
+		var names []string
+		for _, pending := range pods {
+			names = append(names, pending.pod.Name)
+		}
+		return names
+	}
+	usage := map[string]int32{"team-a": 8}
+
+	assert.Equal(t, []string{"new-high", "old-low", "new-low", "newer-low"}, names(q.ordered(usage, false)))
+	// team-b holds no slots, its pods go first among pods of the same priority
+	assert.Equal(t, []string{"new-high", "newer-low", "old-low", "new-low"}, names(q.ordered(usage, true)))
+
+	// a pod recreated with the same name replaces the earlier one
+	q.add(pendingTestPod("team-a", "old-low", 0, 0), nil)
+	assert.Len(t, q.pods, 4)
+	assert.True(t, q.remove(types.NamespacedName{Namespace: "team-a", Name: "new-high"}))
+	assert.False(t, q.remove(types.NamespacedName{Namespace: "team-a", Name: "new-high"}))
+}
+
+func TestPendingPodsThatFit(t *testing.T) {
+	ctx := context.Background()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpus := sortGPUs(instaslice)
+	// a 1g slice on both GPUs leaves room for small slices only
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(scheme))
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}).Build()
+	r := &InstasliceReconciler{Client: fakeClient, Config: config.NewConfig(), allocationCache: newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{
+		"running-0": {GPUUUID: gpus[0], Nodename: "node-1", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}},
+		"running-1": {GPUUUID: gpus[1], Nodename: "node-1", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}},
+	})}
+	instaslices := []inferencev1alpha1.Instaslice{*instaslice}
+	nodes := r.readNodes(ctx, instaslices)
+	policy := &FirstFitPolicy{}
+	large := pendingTestPod("default", "large", 0, 2*time.Minute)
+	small := pendingTestPod("default", "small", 0, time.Minute)
+	unknown := pendingTestPod("default", "unknown", 0, 3*time.Minute)
+	largeRequest := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "7g.40gb"}}
+	smallRequest := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "1g.5gb"}}
+
+	// a profile no node offers does not block the queue
+	assert.True(t, r.mayAllocate(ctx, small, smallRequest, instaslices, nodes, policy))
+	assert.False(t, r.mayAllocate(ctx, unknown, []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "9g.99gb"}}, instaslices, nodes, policy))
+	assert.True(t, r.mayAllocate(ctx, small, smallRequest, instaslices, nodes, policy))
+
+	// the older large pod has to wait for a GPU to be released, the small pod waits behind it
+	assert.True(t, r.mayAllocate(ctx, large, largeRequest, instaslices, nodes, policy))
+	assert.False(t, r.mayAllocate(ctx, small, smallRequest, instaslices, nodes, policy))
+	fit, blocked := r.pendingPodsThatFit(ctx, instaslices, nodes, policy)
+	assert.Empty(t, fit)
+	assert.Equal(t, "large", blocked.pod.Name)
+
+	// once a GPU is released both pods fit and only they are woken up
+	r.wakeups = make(chan event.GenericEvent, 10)
+	r.allocationCache.Delete("running-1")
+	r.wakePendingPods(ctx, instaslices, nodes, policy)
+	assert.Len(t, r.wakeups, 2)
+	assert.Equal(t, "large", (<-r.wakeups).Object.GetName())
+	assert.Equal(t, "small", (<-r.wakeups).Object.GetName())
+	assert.True(t, r.mayAllocate(ctx, small, smallRequest, instaslices, nodes, policy))
+}
+
+func TestMayAllocateQueueOutcome(t *testing.T) {
+	ctx := context.Background()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpus := sortGPUs(instaslice)
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(scheme))
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}).Build()
+	running := inferencev1alpha1.AllocationResult{GPUUUID: gpus[1], Nodename: "node-1", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}}
+	r := &InstasliceReconciler{Client: fakeClient, Config: config.NewConfig(), allocationCache: newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{
+		"running-0": {GPUUUID: gpus[0], Nodename: "node-1", MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1}},
+		"running-1": running,
+	})}
+	instaslices := []inferencev1alpha1.Instaslice{*instaslice}
+	nodes := r.readNodes(ctx, instaslices)
+	policy := &FirstFitPolicy{}
+	large := pendingTestPod("default", "large", 0, 2*time.Minute)
+	small := pendingTestPod("default", "small", 0, time.Minute)
+	largeRequest := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "7g.40gb"}}
+	smallRequest := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "1g.5gb"}}
+	assert.True(t, r.mayAllocate(ctx, large, largeRequest, instaslices, nodes, policy))
+	assert.False(t, r.mayAllocate(ctx, small, smallRequest, instaslices, nodes, policy))
+
+	// a slice released without the queue being told does not let the small pod go
+	r.allocationCache.Delete("running-1")
+	assert.False(t, r.mayAllocate(ctx, small, smallRequest, instaslices, nodes, policy))
+
+	// a pod joining behind the large pod waits without a new simulation
+	outcome := r.pendingQueue().outcome
+	late := pendingTestPod("default", "late", 0, 0)
+	assert.False(t, r.mayAllocate(ctx, late, smallRequest, instaslices, nodes, policy))
+	assert.Same(t, outcome, r.pendingQueue().outcome)
+
+	// a pod joining ahead of it simulates the queue again
+	urgent := pendingTestPod("default", "urgent", 10, 0)
+	assert.True(t, r.mayAllocate(ctx, urgent, smallRequest, instaslices, nodes, policy))
+	assert.NotSame(t, outcome, r.pendingQueue().outcome)
+	assert.True(t, r.mayAllocate(ctx, small, smallRequest, instaslices, nodes, policy))
+
+	// an outcome past its lifetime is simulated again
+	r.allocationCache.Set("running-1", running)
+	assert.True(t, r.mayAllocate(ctx, small, smallRequest, instaslices, nodes, policy))
+	r.pendingQueue().outcome.simulated = time.Now().Add(-queueOutcomeLifetime)
+	assert.False(t, r.mayAllocate(ctx, small, smallRequest, instaslices, nodes, policy))
+}
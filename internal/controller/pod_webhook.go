@@ -21,6 +21,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -29,10 +31,26 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
 )
 
 //+kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=fail,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=instaslice.redhat.com,admissionReviewVersions=v1
 
+// profileNamePattern matches MIG profile names such as 1g.5gb and 1c.4g.20gb, capturing the
+// compute slices of the compute instance, the compute slices of the GPU instance and the memory
+var profileNamePattern = regexp.MustCompile(`^(?:(\d+)c\.)?(\d+)g\.(\d+)gb$`)
+
+// containerProfiles are the profiles a container accepts, in order of preference, and the number
+// of slices it requests, or the size of the slice it requests. The webhook records them for the
+// controller in the container-profiles annotation.
+type containerProfiles struct {
+	Profiles       []string           `json:"profiles,omitempty"`
+	Quantity       int32              `json:"quantity,omitempty"`
+	Memory         *resource.Quantity `json:"memory,omitempty"`
+	ComputePercent int32              `json:"computePercent,omitempty"`
+}
+
 type PodAnnotator struct {
 	Client  client.Client
 	Decoder admission.Decoder
@@ -49,11 +67,96 @@ func (a *PodAnnotator) Handle(ctx context.Context, req admission.Request) admiss
 	if !hasMIGResource(pod) {
 		return admission.Allowed("No nvidia.com/mig-* resource found, skipping mutation.")
 	}
+	if response := a.mutatePod(ctx, req, pod); response != nil {
+		return *response
+	}
 
-	performQuotaArithmetic(pod, req)
+	// Marshal the updated pod object back to JSON
+	marshaledPod, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Errored(500, fmt.Errorf("could not marshal pod: %v", err))
+	}
 
-	// Transform resource requests from nvidia.com/mig-* to instaslice.redhat.com/mig-*
-	transformResources(&pod.Spec.Containers[0].Resources)
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+}
+
+// mutatePod gates a pod requesting MIG slices and rewrites its GPU containers for the controller,
+// the returned response is nil unless the pod is denied or cannot be mutated
+func (a *PodAnnotator) mutatePod(ctx context.Context, req admission.Request, pod *v1.Pod) *admission.Response {
+	respond := func(response admission.Response) *admission.Response {
+		return &response
+	}
+	profiles, err := acceptableProfiles(pod)
+	if err != nil {
+		return respond(admission.Denied(err.Error()))
+	}
+
+	performQuotaArithmetic(pod, req, profiles)
+
+	// Each GPU container, init containers included, gets its own ConfigMap listing the
+	// MIG devices of its slices. Sidecars without MIG resources are left untouched.
+	containerConfigMaps := make(map[string]string)
+	acceptable := make(map[string]containerProfiles)
+	for _, container := range migContainers(pod) {
+		memory, computePercent, sized, err := containerSliceSize(container)
+		if err != nil {
+			return respond(admission.Denied(err.Error()))
+		}
+		if sized {
+			// nodes do not offer slice sizes, the controller reads the size from the annotation
+			// and resolves it to the smallest profile large enough on every node it tries
+			quota, response := a.sliceSizeQuota(ctx, container.Name, memory, computePercent)
+			if response != nil {
+				return response
+			}
+			acceptable[container.Name] = containerProfiles{Memory: memory, ComputePercent: computePercent}
+			removeSliceSizeResources(&container.Resources)
+			if container.Resources.Limits == nil {
+				container.Resources.Limits = make(v1.ResourceList)
+			}
+			container.Resources.Limits[v1.ResourceName(QuotaResourceName)] = quota
+		} else if len(profiles) > 0 {
+			// a MIG resource names a single profile, the controller reads the profiles the
+			// container accepts from the annotation. The quota covers the largest of them until
+			// the controller lowers it to the profile it chose, and every node advertises it,
+			// so it replaces the MIG limit for the kube-scheduler whichever node is chosen.
+			quantity, ok := acceptsProfiles(container, profiles)
+			if !ok {
+				return respond(admission.Denied(fmt.Sprintf("container %s must request a single MIG profile listed in the %s annotation %q",
+					container.Name, AcceptableProfilesAnnotation, pod.Annotations[AcceptableProfilesAnnotation])))
+			}
+			acceptable[container.Name] = containerProfiles{Profiles: profiles, Quantity: quantity}
+			removeMIGResources(&container.Resources)
+		} else {
+			// Transform resource requests from nvidia.com/mig-* to instaslice.redhat.com/mig-*
+			transformResources(&container.Resources)
+		}
+
+		// Add envFrom with a unique ConfigMap name
+		configMapName := uuid.New().String()
+		container.EnvFrom = append(container.EnvFrom, v1.EnvFromSource{
+			ConfigMapRef: &v1.ConfigMapEnvSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: configMapName},
+			},
+		})
+		containerConfigMaps[container.Name] = configMapName
+	}
+	// record which ConfigMap belongs to which container for the controller
+	configMapsJSON, err := json.Marshal(containerConfigMaps)
+	if err != nil {
+		return respond(admission.Errored(500, fmt.Errorf("could not marshal container configmaps: %v", err)))
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[ContainerConfigMapsAnnotation] = string(configMapsJSON)
+	if len(acceptable) > 0 {
+		acceptableJSON, err := json.Marshal(acceptable)
+		if err != nil {
+			return respond(admission.Errored(500, fmt.Errorf("could not marshal container profiles: %v", err)))
+		}
+		pod.Annotations[ContainerProfilesAnnotation] = string(acceptableJSON)
+	}
 
 	// Add scheduling
 	schedulingGateName := GateName
@@ -67,58 +170,63 @@ func (a *PodAnnotator) Handle(ctx context.Context, req admission.Request) admiss
 	if !found {
 		pod.Spec.SchedulingGates = append(pod.Spec.SchedulingGates, v1.PodSchedulingGate{Name: schedulingGateName})
 	}
-
-	// Generate an extended resource name based on the pod name
-	uuidStr := uuid.New().String()
-
-	// Add envFrom with a unique ConfigMap name derived from the pod name
-	configMapName := uuidStr
-	// Support for only one pod workloads
-	pod.Spec.Containers[0].EnvFrom = append(pod.Spec.Containers[0].EnvFrom, v1.EnvFromSource{
-		ConfigMapRef: &v1.ConfigMapEnvSource{
-			LocalObjectReference: v1.LocalObjectReference{Name: configMapName},
-		},
-	})
-
-	// Marshal the updated pod object back to JSON
-	marshaledPod, err := json.Marshal(pod)
-	if err != nil {
-		return admission.Errored(500, fmt.Errorf("could not marshal pod: %v", err))
-	}
-
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	return nil
 }
 
 // hasMIGResource checks if a pod has resource requests or limits with a key that matches `nvidia.com/mig-*`
 func hasMIGResource(pod *v1.Pod) bool {
-	for _, container := range pod.Spec.Containers {
-		// Check resource limits
-		for resourceName := range container.Resources.Limits {
-			if strings.HasPrefix(string(resourceName), NvidiaMIGPrefix) {
+	return len(migContainers(pod)) > 0
+}
+
+// migContainers returns the init and regular containers of a pod whose resource requests or
+// limits have a key that matches `nvidia.com/mig-*` or a slice size resource
+func migContainers(pod *v1.Pod) []*v1.Container {
+	var containers []*v1.Container
+	hasMIG := func(resources v1.ResourceList) bool {
+		for resourceName := range resources {
+			if strings.HasPrefix(string(resourceName), NvidiaMIGPrefix) ||
+				resourceName == AcceleratorMemoryResourceName || resourceName == AcceleratorComputeResourceName {
 				return true
 			}
 		}
-		// Check resource requests
-		for resourceName := range container.Resources.Requests {
-			if strings.HasPrefix(string(resourceName), NvidiaMIGPrefix) {
-				return true
+		return false
+	}
+	for _, podContainers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range podContainers {
+			if hasMIG(podContainers[i].Resources.Limits) || hasMIG(podContainers[i].Resources.Requests) {
+				containers = append(containers, &podContainers[i])
 			}
 		}
 	}
-	return false
+	return containers
 }
 
-func performQuotaArithmetic(pod *v1.Pod, req admission.Request) admission.Response {
-	// assumption is that workloads will have 1 container where
-	// MIG is requested.
-	// TODO instead of only iterating over regular containers,
-	// we should also consider other types of containers (such as init containers) in future
-	for _, container := range pod.Spec.Containers {
+// performQuotaArithmetic sets the accelerator memory quota on every GPU container. Quota usage
+// is then computed by Kubernetes like for any other resource, the largest init container
+// or the sum of the regular containers. Containers accepting several profiles are charged the
+// largest of them, the controller charges the profile it chose once the pod is placed.
+func performQuotaArithmetic(pod *v1.Pod, req admission.Request, profiles []string) admission.Response {
+	for _, container := range migContainers(pod) {
 		// dont bother checking requests section. Nvidia supports only limits
 		// if requests is added by user, it should be equal to limits.
+		acceleratorMemory := 0
+		_, flexible := acceptsProfiles(container, profiles)
 		for resourceName, quantity := range container.Resources.Limits {
-			resourceParts := strings.Split(strings.TrimPrefix(string(resourceName), NvidiaMIGPrefix), ".")
+			if !strings.HasPrefix(string(resourceName), NvidiaMIGPrefix) {
+				continue
+			}
+			profile := strings.TrimPrefix(string(resourceName), NvidiaMIGPrefix)
+			resourceParts := strings.Split(profile, ".")
 
+			// compute instances sharing a GPU instance, such as 1c.4g.20gb
+			if len(resourceParts) == 3 {
+				if memoryValue, ok := profileMemory(profile); ok {
+					if flexible {
+						memoryValue = largestProfileMemory(profiles)
+					}
+					acceleratorMemory += memoryValue * int(quantity.Value())
+				}
+			}
 			if len(resourceParts) == 2 {
 				// gpuPart := resourceParts[0]
 				memoryPart := resourceParts[1]
@@ -126,13 +234,15 @@ func performQuotaArithmetic(pod *v1.Pod, req admission.Request) admission.Respon
 				if err != nil {
 					return admission.Errored(http.StatusInternalServerError, fmt.Errorf("failed to parse memory value: %v", err))
 				}
-				acceleratorMemory := memoryValue * int(quantity.Value())
-				// assume 1 container workload
-				// Convert the string to ResourceName
-				resourceName := v1.ResourceName(QuotaResourceName)
-				pod.Spec.Containers[0].Resources.Limits[resourceName] = resource.MustParse(fmt.Sprintf("%dGi", acceleratorMemory))
+				if flexible {
+					memoryValue = largestProfileMemory(profiles)
+				}
+				acceleratorMemory += memoryValue * int(quantity.Value())
 			}
 		}
+		if acceleratorMemory > 0 {
+			container.Resources.Limits[v1.ResourceName(QuotaResourceName)] = resource.MustParse(fmt.Sprintf("%dGi", acceleratorMemory))
+		}
 	}
 	// Return the modified pod spec
 	marshaledPod, err := json.Marshal(pod)
@@ -142,6 +252,107 @@ func performQuotaArithmetic(pod *v1.Pod, req admission.Request) admission.Respon
 	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
 }
 
+// sliceSizeQuota returns the accelerator memory quota of a container requesting a slice size, the
+// response is set when the pod has to be rejected because no node has a profile large enough
+func (a *PodAnnotator) sliceSizeQuota(ctx context.Context, containerName string, memory *resource.Quantity, computePercent int32) (resource.Quantity, *admission.Response) {
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := a.Client.List(ctx, &instasliceList); err != nil {
+		response := admission.Errored(http.StatusInternalServerError, fmt.Errorf("could not list instaslices: %v", err))
+		return resource.Quantity{}, &response
+	}
+	quota, ok := sliceSizeQuota(instasliceList.Items, memory, computePercent)
+	if !ok {
+		requested := fmt.Sprintf("%d%% of the compute", computePercent)
+		if memory != nil {
+			requested = fmt.Sprintf("%s of memory and %s", memory.String(), requested)
+		}
+		response := admission.Denied(fmt.Sprintf("no node has a MIG profile with %s of a GPU requested by container %s", requested, containerName))
+		return resource.Quantity{}, &response
+	}
+	return quota, nil
+}
+
+// acceptableProfiles returns the profiles listed in order of preference by the acceptable-profiles
+// annotation, nil when the pod has no such annotation
+func acceptableProfiles(pod *v1.Pod) ([]string, error) {
+	value := pod.Annotations[AcceptableProfilesAnnotation]
+	if value == "" {
+		return nil, nil
+	}
+	var profiles []string
+	for _, profile := range strings.Split(value, ",") {
+		profile = strings.TrimSpace(profile)
+		if !profileNamePattern.MatchString(profile) {
+			return nil, fmt.Errorf("invalid %s annotation %q: %q is not a MIG profile such as 1g.5gb", AcceptableProfilesAnnotation, value, profile)
+		}
+		if slices.Contains(profiles, profile) {
+			return nil, fmt.Errorf("invalid %s annotation %q: %s is listed twice", AcceptableProfilesAnnotation, value, profile)
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// acceptsProfiles reports whether the container requests a single MIG profile and that profile
+// is one of the acceptable profiles, quantity is the number of slices it requests
+func acceptsProfiles(container *v1.Container, profiles []string) (int32, bool) {
+	var requested []string
+	var quantity int32
+	for resourceName, value := range container.Resources.Limits {
+		if strings.HasPrefix(string(resourceName), NvidiaMIGPrefix) {
+			requested = append(requested, strings.TrimPrefix(string(resourceName), NvidiaMIGPrefix))
+			quantity = int32(value.Value())
+		}
+	}
+	if len(requested) != 1 || !slices.Contains(profiles, requested[0]) {
+		return 0, false
+	}
+	return quantity, true
+}
+
+// profileMemory returns the memory in GB a slice of the profile is charged for. A compute instance
+// sharing its GPU instance is charged its share of the memory, rounded up.
+func profileMemory(profile string) (int, bool) {
+	match := profileNamePattern.FindStringSubmatch(profile)
+	if match == nil {
+		return 0, false
+	}
+	memory, _ := strconv.Atoi(match[3])
+	if match[1] == "" {
+		return memory, true
+	}
+	ciSlices, _ := strconv.Atoi(match[1])
+	giSlices, _ := strconv.Atoi(match[2])
+	if giSlices == 0 || ciSlices >= giSlices {
+		return memory, true
+	}
+	return (memory*ciSlices + giSlices - 1) / giSlices, true
+}
+
+// largestProfileMemory returns the memory in GB of the largest profile
+func largestProfileMemory(profiles []string) int {
+	largest := 0
+	for _, profile := range profiles {
+		if memory, ok := profileMemory(profile); ok && memory > largest {
+			largest = memory
+		}
+	}
+	return largest
+}
+
+// removeMIGResources drops the nvidia.com/mig-* resources of a container. The extended resource
+// of a single profile would hold the container to the nodes advertising that profile, the
+// accelerator memory quota set on the container stands for its slices instead.
+func removeMIGResources(resources *v1.ResourceRequirements) {
+	for _, resourceList := range []v1.ResourceList{resources.Limits, resources.Requests} {
+		for resourceName := range resourceList {
+			if strings.HasPrefix(string(resourceName), NvidiaMIGPrefix) {
+				delete(resourceList, resourceName)
+			}
+		}
+	}
+}
+
 func transformResources(resources *v1.ResourceRequirements) {
 	func(resourceLists ...*v1.ResourceList) {
 		for _, resourceList := range resourceLists {
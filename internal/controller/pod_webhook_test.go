@@ -31,6 +31,9 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
 )
 
 func TestHandle(t *testing.T) {
@@ -215,3 +218,235 @@ func TestTransformResources(t *testing.T) {
 		})
 	}
 }
+
+func TestHandle_MultipleContainers(t *testing.T) {
+	g := NewWithT(t)
+	scheme := runtime.NewScheme()
+	_ = v1.AddToScheme(scheme)
+	annotator := &PodAnnotator{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Decoder: admission.NewDecoder(scheme),
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-with-sidecar-and-init-container"},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{
+				Name: "warmup",
+				Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+					"nvidia.com/mig-1g.5gb": resource.MustParse("1"),
+				}},
+			}},
+			Containers: []v1.Container{
+				{
+					Name: "model",
+					Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+						"nvidia.com/mig-3g.20gb": resource.MustParse("2"),
+					}},
+				},
+				{
+					Name: "proxy",
+					Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+						"cpu": resource.MustParse("100m"),
+					}},
+				},
+			},
+		},
+	}
+	rawPod, _ := json.Marshal(pod)
+	resp := annotator.Handle(context.TODO(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: rawPod}},
+	})
+	g.Expect(resp.Allowed).To(BeTrue())
+
+	patchBytes, err := json.Marshal(resp.Patches)
+	g.Expect(err).NotTo(HaveOccurred())
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	g.Expect(err).NotTo(HaveOccurred())
+	patchedPodBytes, err := patch.Apply(rawPod)
+	g.Expect(err).NotTo(HaveOccurred())
+	modifiedPod := &v1.Pod{}
+	g.Expect(json.Unmarshal(patchedPodBytes, modifiedPod)).To(Succeed())
+
+	warmup, model, proxy := modifiedPod.Spec.InitContainers[0], modifiedPod.Spec.Containers[0], modifiedPod.Spec.Containers[1]
+	quota := v1.ResourceName(QuotaResourceName)
+	g.Expect(warmup.Resources.Limits[quota]).To(Equal(resource.MustParse("5Gi")))
+	g.Expect(model.Resources.Limits[quota]).To(Equal(resource.MustParse("40Gi")))
+	g.Expect(warmup.Resources.Limits).To(HaveKey(v1.ResourceName(OrgInstaslicePrefix + "mig-1g.5gb")))
+	g.Expect(model.Resources.Limits).To(HaveKey(v1.ResourceName(OrgInstaslicePrefix + "mig-3g.20gb")))
+
+	// every GPU container gets its own ConfigMap, the sidecar is left untouched
+	g.Expect(warmup.EnvFrom).To(HaveLen(1))
+	g.Expect(model.EnvFrom).To(HaveLen(1))
+	g.Expect(warmup.EnvFrom[0].ConfigMapRef.Name).NotTo(Equal(model.EnvFrom[0].ConfigMapRef.Name))
+	g.Expect(proxy.EnvFrom).To(BeEmpty())
+	g.Expect(proxy.Resources.Limits).NotTo(HaveKey(quota))
+
+	g.Expect(containerConfigMaps(modifiedPod)).To(Equal(map[string]string{
+		"warmup": warmup.EnvFrom[0].ConfigMapRef.Name,
+		"model":  model.EnvFrom[0].ConfigMapRef.Name,
+	}))
+}
+
+func TestHandle_AcceptableProfiles(t *testing.T) {
+	g := NewWithT(t)
+	scheme := runtime.NewScheme()
+	_ = v1.AddToScheme(scheme)
+	annotator := &PodAnnotator{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Decoder: admission.NewDecoder(scheme),
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pod-with-acceptable-profiles",
+			Annotations: map[string]string{AcceptableProfilesAnnotation: "1g.5gb, 1g.10gb"},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "model",
+					Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+						"nvidia.com/mig-1g.5gb": resource.MustParse("2"),
+					}},
+				},
+			},
+		},
+	}
+	rawPod, _ := json.Marshal(pod)
+	resp := annotator.Handle(context.TODO(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: rawPod}},
+	})
+	g.Expect(resp.Allowed).To(BeTrue())
+
+	patchBytes, err := json.Marshal(resp.Patches)
+	g.Expect(err).NotTo(HaveOccurred())
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	g.Expect(err).NotTo(HaveOccurred())
+	patchedPodBytes, err := patch.Apply(rawPod)
+	g.Expect(err).NotTo(HaveOccurred())
+	modifiedPod := &v1.Pod{}
+	g.Expect(json.Unmarshal(patchedPodBytes, modifiedPod)).To(Succeed())
+
+	// the quota covers two slices of the largest acceptable profile and replaces the MIG limit,
+	// which would hold the pod to the nodes advertising the named profile
+	model := modifiedPod.Spec.Containers[0]
+	g.Expect(model.Resources.Limits[v1.ResourceName(QuotaResourceName)]).To(Equal(resource.MustParse("20Gi")))
+	g.Expect(model.Resources.Limits).To(HaveLen(1))
+	g.Expect(model.EnvFrom).To(HaveLen(1))
+
+	r := &InstasliceReconciler{}
+	g.Expect(r.extractContainerRequests(modifiedPod)).To(Equal([]inferencev1alpha1.ContainerRequest{
+		{Name: "model", Profile: "1g.5gb", AcceptableProfiles: []string{"1g.5gb", "1g.10gb"}, Quantity: 2},
+	}))
+
+	// a container requesting a profile that is not listed is denied
+	pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{
+		Name: "embedder",
+		Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+			"nvidia.com/mig-3g.20gb": resource.MustParse("1"),
+		}},
+	})
+	rawPod, _ = json.Marshal(pod)
+	resp = annotator.Handle(context.TODO(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: rawPod}},
+	})
+	g.Expect(resp.Allowed).To(BeFalse())
+	g.Expect(resp.Result.Message).To(ContainSubstring("container embedder must request a single MIG profile"))
+
+	for _, annotation := range []string{"1g.5gb,small", "1g.5gb,1g.5gb"} {
+		pod.Annotations[AcceptableProfilesAnnotation] = annotation
+		rawPod, _ = json.Marshal(pod)
+		resp = annotator.Handle(context.TODO(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: rawPod}},
+		})
+		g.Expect(resp.Allowed).To(BeFalse(), annotation)
+		g.Expect(resp.Result.Message).To(ContainSubstring("invalid " + AcceptableProfilesAnnotation))
+	}
+}
+
+func TestHandle_SliceSize(t *testing.T) {
+	g := NewWithT(t)
+	scheme := runtime.NewScheme()
+	_ = v1.AddToScheme(scheme)
+	_ = inferencev1alpha1.AddToScheme(scheme)
+	annotator := &PodAnnotator{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).WithObjects(utils.GenerateFakeCapacity("node-1")).Build(),
+		Decoder: admission.NewDecoder(scheme),
+	}
+	handle := func(pod *v1.Pod) (admission.Response, *v1.Pod) {
+		rawPod, _ := json.Marshal(pod)
+		resp := annotator.Handle(context.TODO(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: rawPod}},
+		})
+		if !resp.Allowed {
+			return resp, nil
+		}
+		patchBytes, err := json.Marshal(resp.Patches)
+		g.Expect(err).NotTo(HaveOccurred())
+		patch, err := jsonpatch.DecodePatch(patchBytes)
+		g.Expect(err).NotTo(HaveOccurred())
+		patchedPodBytes, err := patch.Apply(rawPod)
+		g.Expect(err).NotTo(HaveOccurred())
+		modifiedPod := &v1.Pod{}
+		g.Expect(json.Unmarshal(patchedPodBytes, modifiedPod)).To(Succeed())
+		return resp, modifiedPod
+	}
+	sizedPod := func(limits v1.ResourceList) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-with-slice-size"},
+			Spec: v1.PodSpec{Containers: []v1.Container{{
+				Name:      "model",
+				Resources: v1.ResourceRequirements{Limits: limits},
+			}}},
+		}
+	}
+
+	// 12Gi is served by a 3g.20gb slice on the A100 nodes, the quota covers it
+	resp, modifiedPod := handle(sizedPod(v1.ResourceList{AcceleratorMemoryResourceName: resource.MustParse("12Gi")}))
+	g.Expect(resp.Allowed).To(BeTrue())
+	model := modifiedPod.Spec.Containers[0]
+	g.Expect(model.Resources.Limits).To(Equal(v1.ResourceList{v1.ResourceName(QuotaResourceName): resource.MustParse("20Gi")}))
+	g.Expect(model.EnvFrom).To(HaveLen(1))
+	g.Expect(modifiedPod.Spec.SchedulingGates).To(ContainElement(v1.PodSchedulingGate{Name: GateName}))
+	memory := resource.MustParse("12Gi")
+	r := &InstasliceReconciler{}
+	g.Expect(r.extractContainerRequests(modifiedPod)).To(Equal([]inferencev1alpha1.ContainerRequest{
+		{Name: "model", AcceleratorMemory: &memory},
+	}))
+
+	resp, modifiedPod = handle(sizedPod(v1.ResourceList{AcceleratorComputeResourceName: resource.MustParse("50")}))
+	g.Expect(resp.Allowed).To(BeTrue())
+	g.Expect(modifiedPod.Spec.Containers[0].Resources.Limits[v1.ResourceName(QuotaResourceName)]).To(Equal(resource.MustParse("20Gi")))
+
+	// sizes no node can serve and invalid sizes are rejected
+	resp, _ = handle(sizedPod(v1.ResourceList{AcceleratorMemoryResourceName: resource.MustParse("80Gi")}))
+	g.Expect(resp.Allowed).To(BeFalse())
+	g.Expect(resp.Result.Message).To(ContainSubstring("no node has a MIG profile with 80Gi of memory"))
+	resp, _ = handle(sizedPod(v1.ResourceList{AcceleratorComputeResourceName: resource.MustParse("0")}))
+	g.Expect(resp.Allowed).To(BeFalse())
+	g.Expect(resp.Result.Message).To(ContainSubstring("must be between 1 and 100"))
+}
+
+func TestProfileMemory(t *testing.T) {
+	g := NewWithT(t)
+	tests := []struct {
+		profile  string
+		expected int
+		ok       bool
+	}{
+		{profile: "1g.5gb", expected: 5, ok: true},
+		{profile: "4g.20gb", expected: 20, ok: true},
+		// compute instances sharing a GPU instance are charged their share of its memory
+		{profile: "1c.4g.20gb", expected: 5, ok: true},
+		{profile: "2c.4g.20gb", expected: 10, ok: true},
+		{profile: "1c.3g.20gb", expected: 7, ok: true},
+		{profile: "1g.5gb+me"},
+		{profile: "g.5gb"},
+	}
+	for _, tt := range tests {
+		memory, ok := profileMemory(tt.profile)
+		g.Expect(ok).To(Equal(tt.ok), tt.profile)
+		g.Expect(memory).To(Equal(tt.expected), tt.profile)
+	}
+}
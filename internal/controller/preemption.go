@@ -0,0 +1,380 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// A gated pod that fits on no node may evict lower priority pods holding slices. The
+// controller looks for the node where the fewest such pods have to go, evicts them through
+// the Eviction API, which enforces PodDisruptionBudgets, and nominates the freed slots to
+// the preemptor so that no other pod takes them while the victims terminate.
+
+// nominationTimeout bounds how long slots stay reserved for a preemptor that did not get them
+const nominationTimeout = 2 * time.Minute
+
+// nomination reserves the slices freed by a preemption for the preemptor
+type nomination struct {
+	nodeName string
+	slices   []inferencev1alpha1.SliceResult
+	expires  time.Time
+	// profile is the profile the slices are held for by the defragmenter, see holds
+	profile string
+}
+
+// holds reports whether the nominated slices are kept from a pod whose containers request
+// containers. Slices nominated to a preemptor are kept from every other pod, slices held by the
+// defragmenter from the pods that do not request their profile.
+func (n nomination) holds(containers []inferencev1alpha1.ContainerRequest) bool {
+	if n.profile == "" {
+		return true
+	}
+	for _, container := range containers {
+		if container.Profile == n.profile || slices.Contains(container.AcceptableProfiles, n.profile) {
+			return false
+		}
+	}
+	return true
+}
+
+// preemptionVictim is a lower priority pod holding slices on the node of a preemption
+type preemptionVictim struct {
+	pod      *v1.Pod
+	priority int32
+	slots    int32
+}
+
+// podPriority returns the priority of a pod, pods without one have priority 0
+func podPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
+
+// preemptForPod evicts lower priority pods so that the slices of pod fit on a node. It returns
+// true when the pod has to wait for victims to terminate, either from this or an earlier call.
+// The API is read and the victims evicted without r.mu, which is only taken to select them.
+func (r *InstasliceReconciler) preemptForPod(ctx context.Context, pod *v1.Pod, containers []inferencev1alpha1.ContainerRequest, policy AllocationPolicy, instaslices []inferencev1alpha1.Instaslice) (bool, error) {
+	log := logr.FromContext(ctx)
+	if pod.Spec.PreemptionPolicy != nil && *pod.Spec.PreemptionPolicy == v1.PreemptNever {
+		return false, nil
+	}
+	r.mu.Lock()
+	if nominated, ok := r.nominations[pod.UID]; ok {
+		if time.Now().Before(nominated.expires) {
+			r.mu.Unlock()
+			return true, nil
+		}
+		delete(r.nominations, pod.UID)
+	}
+	r.mu.Unlock()
+
+	var (
+		bestNode    *inferencev1alpha1.Instaslice
+		bestVictims []preemptionVictim
+		bestSlices  []inferencev1alpha1.SliceResult
+	)
+	for i := range instaslices {
+		instaslice := &instaslices[i]
+		// nodes backing off from a timed out allocation get no slices, not even by preemption
+		r.mu.Lock()
+		excluded := r.isNodeExcluded(instaslice.Name)
+		r.mu.Unlock()
+		if excluded {
+			continue
+		}
+		if r.ResourceCache != nil && !r.ResourceCache.Fits(instaslice.Name, pod) {
+			continue
+		}
+		if fits, _, err := r.podFitsInstasliceNode(ctx, instaslice.Name, pod); err != nil || !fits {
+			continue
+		}
+		candidates, err := r.preemptionCandidates(ctx, pod, instaslice)
+		if err != nil {
+			return false, err
+		}
+		budgets, err := r.disruptionBudgets(ctx, victimPods(candidates))
+		if err != nil {
+			return false, err
+		}
+		r.mu.Lock()
+		victims, slices, ok := r.selectVictims(instaslice, pod, containers, policy, candidates, budgets)
+		r.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if bestNode == nil || fewerVictims(victims, bestVictims) {
+			bestNode, bestVictims, bestSlices = instaslice, victims, slices
+		}
+	}
+	if bestNode == nil {
+		return false, nil
+	}
+
+	var victimNames []string
+	for _, victim := range bestVictims {
+		victimNames = append(victimNames, victim.pod.Namespace+"/"+victim.pod.Name)
+	}
+	// the whole victim set is checked against the budgets as they are now, so that no victim is
+	// evicted for a preemption a budget blocks midway
+	budgets, err := r.disruptionBudgets(ctx, victimPods(bestVictims))
+	if err != nil {
+		return false, err
+	}
+	for _, victim := range bestVictims {
+		if !takeDisruptionBudget(budgets, victim.pod, -1) {
+			log.Info("a PodDisruptionBudget blocks the preemption for", "pod", pod.Name, "node", bestNode.Name, "victim", victim.pod.Name)
+			return false, nil
+		}
+	}
+
+	// the freed slots are nominated before the first eviction, the slots of the victims evicted
+	// before an eviction fails are kept for the pod
+	r.mu.Lock()
+	if r.nominations == nil {
+		r.nominations = make(map[types.UID]nomination)
+	}
+	r.nominations[pod.UID] = nomination{nodeName: bestNode.Name, slices: bestSlices, expires: time.Now().Add(nominationTimeout)}
+	r.mu.Unlock()
+
+	log.Info("preempting pods for", "pod", pod.Name, "priority", podPriority(pod), "node", bestNode.Name, "victims", victimNames)
+	for i, victim := range bestVictims {
+		eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: victim.pod.Name, Namespace: victim.pod.Namespace}}
+		if err := r.SubResource("eviction").Create(ctx, victim.pod, eviction); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			// no slot is freed for the pod when the first eviction fails
+			if i == 0 {
+				r.mu.Lock()
+				delete(r.nominations, pod.UID)
+				r.mu.Unlock()
+			}
+			return i > 0, fmt.Errorf("failed to evict pod %s/%s for pod %s: %w", victim.pod.Namespace, victim.pod.Name, pod.Name, err)
+		}
+		r.recordEvent(victim.pod, v1.EventTypeNormal, EventReasonPreempted,
+			fmt.Sprintf("Preempted by %s/%s with priority %d to free GPU slices on node %s", pod.Namespace, pod.Name, podPriority(pod), bestNode.Name))
+	}
+	r.recordEvent(pod, v1.EventTypeNormal, EventReasonPreempting,
+		fmt.Sprintf("Preempting %s on node %s to free GPU slices", strings.Join(victimNames, ", "), bestNode.Name))
+	return true, nil
+}
+
+// fewerVictims reports whether preempting a disrupts less than preempting b, by the number of
+// victims and then by the highest victim priority
+func fewerVictims(a, b []preemptionVictim) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	maxPriority := func(victims []preemptionVictim) int32 {
+		var priority int32
+		for i, victim := range victims {
+			if i == 0 || victim.priority > priority {
+				priority = victim.priority
+			}
+		}
+		return priority
+	}
+	return maxPriority(a) < maxPriority(b)
+}
+
+// preemptionCandidates returns the pods with slices on the node whose priority is lower than the
+// priority of the preemptor, lowest priority and then fewest slots first
+func (r *InstasliceReconciler) preemptionCandidates(ctx context.Context, preemptor *v1.Pod, instaslice *inferencev1alpha1.Instaslice) ([]preemptionVictim, error) {
+	var candidates []preemptionVictim
+	for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+		if podUID == preemptor.UID || allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting ||
+			allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		podRef := instaslice.Spec.PodAllocationRequests[podUID].PodRef
+		pod := &v1.Pod{}
+		if err := r.Get(ctx, types.NamespacedName{Name: podRef.Name, Namespace: podRef.Namespace}, pod); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if pod.UID != podUID || !pod.DeletionTimestamp.IsZero() || podPriority(pod) >= podPriority(preemptor) {
+			continue
+		}
+		var slots int32
+		for _, slice := range allocResult.AllSlices() {
+			slots += slice.MigPlacement.Size
+		}
+		candidates = append(candidates, preemptionVictim{pod: pod, priority: podPriority(pod), slots: slots})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority < candidates[j].priority
+		}
+		if candidates[i].slots != candidates[j].slots {
+			return candidates[i].slots < candidates[j].slots
+		}
+		return candidates[i].pod.Name < candidates[j].pod.Name
+	})
+	return candidates, nil
+}
+
+// victimPods returns the pods of the victims
+func victimPods(victims []preemptionVictim) []*v1.Pod {
+	pods := make([]*v1.Pod, 0, len(victims))
+	for _, victim := range victims {
+		pods = append(pods, victim.pod)
+	}
+	return pods
+}
+
+// disruptionBudgets returns the disruptions allowed by every PodDisruptionBudget in the namespaces of the pods
+func (r *InstasliceReconciler) disruptionBudgets(ctx context.Context, pods []*v1.Pod) (map[types.UID]*disruptionBudget, error) {
+	budgets := make(map[types.UID]*disruptionBudget)
+	listed := make(map[string]bool)
+	for _, pod := range pods {
+		if listed[pod.Namespace] {
+			continue
+		}
+		listed[pod.Namespace] = true
+		var pdbList policyv1.PodDisruptionBudgetList
+		if err := r.List(ctx, &pdbList, client.InNamespace(pod.Namespace)); err != nil {
+			return nil, err
+		}
+		for _, pdb := range pdbList.Items {
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil {
+				return nil, err
+			}
+			budgets[pdb.UID] = &disruptionBudget{namespace: pdb.Namespace, selector: selector, allowed: pdb.Status.DisruptionsAllowed}
+		}
+	}
+	return budgets, nil
+}
+
+// disruptionBudget tracks the disruptions a PodDisruptionBudget still allows during a preemption
+type disruptionBudget struct {
+	namespace string
+	selector  labels.Selector
+	allowed   int32
+}
+
+func (b *disruptionBudget) covers(pod *v1.Pod) bool {
+	return b.namespace == pod.Namespace && b.selector.Matches(labels.Set(pod.Labels))
+}
+
+// takeDisruptionBudget adds delta to the disruptions allowed by every budget covering pod. It
+// returns false and leaves the budgets untouched when one of them would go below zero.
+func takeDisruptionBudget(budgets map[types.UID]*disruptionBudget, pod *v1.Pod, delta int32) bool {
+	for _, budget := range budgets {
+		if budget.covers(pod) && budget.allowed+delta < 0 {
+			return false
+		}
+	}
+	for _, budget := range budgets {
+		if budget.covers(pod) {
+			budget.allowed += delta
+		}
+	}
+	return true
+}
+
+// selectVictims finds a minimal set of candidates whose removal lets the slices of the preemptor fit
+// on the node. Candidates are taken in order until the slices fit, then every victim that is not
+// needed after all is spared, starting from the last one taken. GPUs backing off from a timed out
+// allocation are left out.
+func (r *InstasliceReconciler) selectVictims(instaslice *inferencev1alpha1.Instaslice, preemptor *v1.Pod, containers []inferencev1alpha1.ContainerRequest, policy AllocationPolicy,
+	candidates []preemptionVictim, budgets map[types.UID]*disruptionBudget) ([]preemptionVictim, []inferencev1alpha1.SliceResult, bool) {
+	excluded := make(map[types.UID]bool)
+	fits := func() ([]inferencev1alpha1.SliceResult, bool) {
+		gpus := r.withoutExcludedGPUs(podGPUCandidates(instaslice, preemptor, r.gpuCandidatesExcluding(instaslice, excluded)))
+		r.reserveNominatedSlices(instaslice.Name, preemptor, containers, gpus)
+		slices, _, ok := r.placeContainerSlices(instaslice, containers, policy, gpus)
+		return slices, ok
+	}
+
+	var victims []preemptionVictim
+	slices, ok := fits()
+	for _, candidate := range candidates {
+		if ok {
+			break
+		}
+		if !takeDisruptionBudget(budgets, candidate.pod, -1) {
+			continue
+		}
+		excluded[candidate.pod.UID] = true
+		victims = append(victims, candidate)
+		slices, ok = fits()
+	}
+	if !ok || len(victims) == 0 {
+		return nil, nil, false
+	}
+	for i := len(victims) - 1; i >= 0; i-- {
+		delete(excluded, victims[i].pod.UID)
+		if spared, stillFits := fits(); stillFits {
+			takeDisruptionBudget(budgets, victims[i].pod, 1)
+			victims = append(victims[:i], victims[i+1:]...)
+			slices = spared
+			continue
+		}
+		excluded[victims[i].pod.UID] = true
+	}
+	return victims, slices, true
+}
+
+// reserveNominatedSlices marks the slices on the node that are nominated to other pods and kept from
+// pod, whose containers request containers, as allocated. Every nomination is reserved when pod is nil.
+func (s *placementState) reserveNominatedSlices(nodeName string, pod *v1.Pod, containers []inferencev1alpha1.ContainerRequest, candidates []GPUCandidate) {
+	for nominee, nominated := range s.nominations {
+		if nominated.nodeName != nodeName {
+			continue
+		}
+		if time.Now().After(nominated.expires) {
+			delete(s.nominations, nominee)
+			continue
+		}
+		if pod != nil && (nominee == pod.UID || !nominated.holds(containers)) {
+			continue
+		}
+		for _, slice := range nominated.slices {
+			// a GPU instance started by a nominated slice is kept whole, it is not shared before it exists
+			reserveSlice(candidates, slice, inferencev1alpha1.Mig{})
+		}
+	}
+}
+
+// recordEvent emits an event when the reconciler has a recorder
+func (r *InstasliceReconciler) recordEvent(object *v1.Pod, eventType, reason, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(object, eventType, reason, message)
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// slotHolder is a pod of a priority holding the slots of a GPU of node-1
+type slotHolder struct {
+	name     string
+	priority int32
+	gpu      int
+	start    int32
+	size     int32
+}
+
+// newPreemptionFixture returns a node with the slices of the holders allocated, the holder pods
+// and a reconciler whose cache knows about the allocations
+func newPreemptionFixture(holders []slotHolder, extra ...client.Object) (*InstasliceReconciler, *inferencev1alpha1.Instaslice, client.Client) {
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpus := sortGPUs(instaslice)
+	instaslice.Spec.PodAllocationRequests = map[types.UID]inferencev1alpha1.AllocationRequest{}
+	instaslice.Status.PodAllocationResults = map[types.UID]inferencev1alpha1.AllocationResult{}
+	cache := map[types.UID]inferencev1alpha1.AllocationResult{}
+	objects := append([]client.Object{&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}}, extra...)
+	for _, holder := range holders {
+		priority := holder.priority
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: holder.name, Namespace: "default", UID: types.UID(holder.name), Labels: map[string]string{"app": holder.name}},
+			Spec:       v1.PodSpec{Priority: &priority},
+		}
+		objects = append(objects, pod)
+		allocResult := inferencev1alpha1.AllocationResult{
+			GPUUUID:          gpus[holder.gpu],
+			Nodename:         "node-1",
+			MigPlacement:     inferencev1alpha1.Placement{Start: holder.start, Size: holder.size},
+			AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusUngated, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated},
+		}
+		instaslice.Spec.PodAllocationRequests[pod.UID] = inferencev1alpha1.AllocationRequest{PodRef: v1.ObjectReference{Name: pod.Name, Namespace: pod.Namespace, UID: pod.UID}}
+		instaslice.Status.PodAllocationResults[pod.UID] = allocResult
+		cache[pod.UID] = allocResult
+	}
+	scheme := runtime.NewScheme()
+	_ = v1.AddToScheme(scheme)
+	_ = policyv1.AddToScheme(scheme)
+	_ = inferencev1alpha1.AddToScheme(scheme)
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	return &InstasliceReconciler{Client: fakeClient, allocationCache: newAllocationStore(cache)}, instaslice, fakeClient
+}
+
+func preemptor(priority int32) *v1.Pod {
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "preemptor", Namespace: "default", UID: "preemptor"}, Spec: v1.PodSpec{Priority: &priority}}
+}
+
+func TestSelectVictims(t *testing.T) {
+	full := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "7g.40gb"}}
+	pdb := func(app string, allowed int32) *policyv1.PodDisruptionBudget {
+		return &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "pdb-" + app, Namespace: "default", UID: types.UID("pdb-" + app)},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}},
+			Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: allowed},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		holders     []slotHolder
+		pdbs        []client.Object
+		wantVictims []string
+		wantOk      bool
+	}{
+		{
+			name: "single low priority pod blocking a GPU",
+			holders: []slotHolder{
+				{name: "big", priority: 1, gpu: 0, start: 0, size: 8},
+				{name: "small", priority: 1, gpu: 1, start: 0, size: 1},
+			},
+			wantVictims: []string{"small"},
+			wantOk:      true,
+		},
+		{
+			name: "victims that are not needed after all are spared",
+			holders: []slotHolder{
+				{name: "tiny", priority: 1, gpu: 0, start: 0, size: 1},
+				{name: "rest", priority: 3, gpu: 0, start: 1, size: 7},
+				{name: "whole", priority: 2, gpu: 1, start: 0, size: 8},
+			},
+			wantVictims: []string{"whole"},
+			wantOk:      true,
+		},
+		{
+			name: "pods protected by a disruption budget are kept",
+			holders: []slotHolder{
+				{name: "tiny", priority: 1, gpu: 0, start: 0, size: 1},
+				{name: "rest", priority: 3, gpu: 0, start: 1, size: 7},
+				{name: "whole", priority: 2, gpu: 1, start: 0, size: 8},
+			},
+			pdbs:        []client.Object{pdb("whole", 0)},
+			wantVictims: []string{"tiny", "rest"},
+			wantOk:      true,
+		},
+		{
+			name: "pods of equal or higher priority are never victims",
+			holders: []slotHolder{
+				{name: "same", priority: 10, gpu: 0, start: 0, size: 8},
+				{name: "higher", priority: 20, gpu: 1, start: 0, size: 8},
+			},
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r, instaslice, _ := newPreemptionFixture(tt.holders, tt.pdbs...)
+			candidates, err := r.preemptionCandidates(ctx, preemptor(10), instaslice)
+			assert.NoError(t, err)
+			budgets, err := r.disruptionBudgets(ctx, victimPods(candidates))
+			assert.NoError(t, err)
+			victims, slices, ok := r.selectVictims(instaslice, preemptor(10), full, &FirstFitPolicy{}, candidates, budgets)
+			assert.Equal(t, tt.wantOk, ok)
+			var names []string
+			for _, victim := range victims {
+				names = append(names, victim.pod.Name)
+			}
+			assert.Equal(t, tt.wantVictims, names)
+			if tt.wantOk {
+				assert.Len(t, slices, 1)
+			}
+		})
+	}
+}
+
+func TestPreemptForPod(t *testing.T) {
+	ctx := context.Background()
+	r, instaslice, fakeClient := newPreemptionFixture([]slotHolder{
+		{name: "big", priority: 5, gpu: 0, start: 0, size: 8},
+		{name: "small", priority: 1, gpu: 1, start: 0, size: 1},
+	})
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+	containers := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "7g.40gb"}}
+
+	// a pod that must not preempt leaves everyone alone
+	never := preemptor(10)
+	preemptNever := v1.PreemptNever
+	never.Spec.PreemptionPolicy = &preemptNever
+	preempting, err := r.preemptForPod(ctx, never, containers, &FirstFitPolicy{}, []inferencev1alpha1.Instaslice{*instaslice})
+	assert.NoError(t, err)
+	assert.False(t, preempting)
+
+	pod := preemptor(10)
+	preempting, err = r.preemptForPod(ctx, pod, containers, &FirstFitPolicy{}, []inferencev1alpha1.Instaslice{*instaslice})
+	assert.NoError(t, err)
+	assert.True(t, preempting)
+
+	// the victim is evicted, the other pod keeps running
+	err = fakeClient.Get(ctx, types.NamespacedName{Name: "small", Namespace: "default"}, &v1.Pod{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "big", Namespace: "default"}, &v1.Pod{}))
+	assert.Len(t, recorder.Events, 2)
+	assert.Contains(t, <-recorder.Events, "Preempted")
+	assert.Contains(t, <-recorder.Events, "Preempting")
+
+	// the freed GPU is nominated to the preemptor and kept from other pods
+	assert.Contains(t, r.nominations, pod.UID)
+	gpus := r.gpuCandidatesExcluding(instaslice, map[types.UID]bool{"small": true})
+	r.reserveNominatedSlices(instaslice.Name, &v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "other-pod"}}, nil, gpus)
+	assert.Equal(t, int32(0), gpus[1].Allocated.FreeCount())
+	gpus = r.gpuCandidatesExcluding(instaslice, map[types.UID]bool{"small": true})
+	r.reserveNominatedSlices(instaslice.Name, pod, r.extractContainerRequests(pod), gpus)
+	assert.Equal(t, int32(8), gpus[1].Allocated.FreeCount())
+
+	// while the victims terminate the preemptor waits without evicting more pods
+	preempting, err = r.preemptForPod(ctx, pod, containers, &FirstFitPolicy{}, []inferencev1alpha1.Instaslice{*instaslice})
+	assert.NoError(t, err)
+	assert.True(t, preempting)
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "big", Namespace: "default"}, &v1.Pod{}))
+}
+
+func TestPreemptForPodExcludedPlacement(t *testing.T) {
+	ctx := context.Background()
+	gpus := sortGPUs(utils.GenerateFakeCapacity("node-1"))
+	containers := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "7g.40gb"}}
+	holders := []slotHolder{
+		{name: "big", priority: 5, gpu: 0, start: 0, size: 8},
+		{name: "small", priority: 1, gpu: 1, start: 0, size: 1},
+	}
+
+	// no pod is evicted on a node backing off from a timed out allocation
+	r, instaslice, _ := newPreemptionFixture(holders)
+	r.excludedNodes = map[types.NodeName]time.Time{"node-1": time.Now().Add(time.Hour)}
+	preempting, err := r.preemptForPod(ctx, preemptor(10), containers, &FirstFitPolicy{}, []inferencev1alpha1.Instaslice{*instaslice})
+	assert.NoError(t, err)
+	assert.False(t, preempting)
+	assert.NoError(t, r.Get(ctx, types.NamespacedName{Name: "small", Namespace: "default"}, &v1.Pod{}))
+	assert.Empty(t, r.nominations)
+
+	// the slices are not placed on a GPU backing off, the pod on the other GPU is evicted instead
+	r, instaslice, _ = newPreemptionFixture(holders)
+	r.excludedGPUs = map[string]time.Time{gpus[1]: time.Now().Add(time.Hour)}
+	preempting, err = r.preemptForPod(ctx, preemptor(10), containers, &FirstFitPolicy{}, []inferencev1alpha1.Instaslice{*instaslice})
+	assert.NoError(t, err)
+	assert.True(t, preempting)
+	assert.NoError(t, r.Get(ctx, types.NamespacedName{Name: "small", Namespace: "default"}, &v1.Pod{}))
+	assert.True(t, apierrors.IsNotFound(r.Get(ctx, types.NamespacedName{Name: "big", Namespace: "default"}, &v1.Pod{})))
+}
+
+func TestPreemptForPodEvictionFailure(t *testing.T) {
+	ctx := context.Background()
+	r, instaslice, fakeClient := newPreemptionFixture([]slotHolder{
+		{name: "big", priority: 5, gpu: 0, start: 0, size: 8},
+		{name: "first", priority: 1, gpu: 1, start: 0, size: 1},
+		{name: "second", priority: 1, gpu: 1, start: 4, size: 1},
+	})
+	// the eviction of the second victim is refused
+	r.Client = interceptor.NewClient(fakeClient.(client.WithWatch), interceptor.Funcs{
+		SubResourceCreate: func(ctx context.Context, kubeClient client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+			if obj.GetName() == "second" {
+				return apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10)
+			}
+			return kubeClient.SubResource(subResourceName).Create(ctx, obj, subResource, opts...)
+		},
+	})
+	containers := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "7g.40gb"}}
+
+	// the slots of the evicted victim stay nominated to the preemptor, which waits
+	pod := preemptor(10)
+	preempting, err := r.preemptForPod(ctx, pod, containers, &FirstFitPolicy{}, []inferencev1alpha1.Instaslice{*instaslice})
+	assert.Error(t, err)
+	assert.True(t, preempting)
+	assert.True(t, apierrors.IsNotFound(fakeClient.Get(ctx, types.NamespacedName{Name: "first", Namespace: "default"}, &v1.Pod{})))
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "second", Namespace: "default"}, &v1.Pod{}))
+	assert.Contains(t, r.nominations, pod.UID)
+	gpus := r.gpuCandidatesExcluding(instaslice, map[types.UID]bool{"first": true})
+	r.reserveNominatedSlices(instaslice.Name, &v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "other-pod"}}, nil, gpus)
+	assert.False(t, gpus[1].Allocated.IsFree(0, 1))
+}
+
+func TestPreemptForPodDisruptionBudget(t *testing.T) {
+	ctx := context.Background()
+	// a budget covers both pods holding the second GPU
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "pdb-small", Namespace: "default", UID: "pdb-small"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"first", "second"}}}}},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 2},
+	}
+	r, instaslice, fakeClient := newPreemptionFixture([]slotHolder{
+		{name: "big", priority: 5, gpu: 0, start: 0, size: 8},
+		{name: "first", priority: 1, gpu: 1, start: 0, size: 1},
+		{name: "second", priority: 1, gpu: 1, start: 4, size: 1},
+	}, pdb)
+	// a disruption takes from the budget once the victims are selected
+	lists := 0
+	r.Client = interceptor.NewClient(fakeClient.(client.WithWatch), interceptor.Funcs{
+		List: func(ctx context.Context, kubeClient client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if err := kubeClient.List(ctx, list, opts...); err != nil {
+				return err
+			}
+			if pdbs, ok := list.(*policyv1.PodDisruptionBudgetList); ok {
+				if lists++; lists > 1 {
+					pdbs.Items[0].Status.DisruptionsAllowed = 1
+				}
+			}
+			return nil
+		},
+	})
+	containers := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "7g.40gb"}}
+
+	// no pod is evicted when the budget no longer allows the whole victim set to go
+	pod := preemptor(10)
+	preempting, err := r.preemptForPod(ctx, pod, containers, &FirstFitPolicy{}, []inferencev1alpha1.Instaslice{*instaslice})
+	assert.NoError(t, err)
+	assert.False(t, preempting)
+	assert.Equal(t, 2, lists)
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "first", Namespace: "default"}, &v1.Pod{}))
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "second", Namespace: "default"}, &v1.Pod{}))
+	assert.NotContains(t, r.nominations, pod.UID)
+}
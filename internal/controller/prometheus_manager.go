@@ -26,6 +26,8 @@ type InstasliceMetrics struct {
 	compatibleProfiles *prometheus.GaugeVec
 	processedSlices    *prometheus.GaugeVec
 	deployedPodTotal   *prometheus.GaugeVec
+	orphansDetected    *prometheus.GaugeVec
+	orphansReclaimed   *prometheus.CounterVec
 }
 
 var (
@@ -48,6 +50,18 @@ var (
 			Help: "Number of total processed GPU slices since instaslice controller start time.",
 		},
 			[]string{"node", "gpu_id"}), // Labels: node, GPU ID
+		// orphans found by the last sweep of the orphan collector
+		orphansDetected: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "instaslice_orphans_detected",
+			Help: "Orphaned allocations, ConfigMaps and MIG instances found by the last sweep of the orphan collector, past their grace period.",
+		},
+			[]string{"kind", "node"}), // Labels: kind, node
+		// orphans reclaimed by the orphan collector
+		orphansReclaimed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "instaslice_orphans_reclaimed_total",
+			Help: "Orphaned allocations, ConfigMaps and MIG instances reclaimed by the orphan collector since start time.",
+		},
+			[]string{"kind", "node"}), // Labels: kind, node
 	}
 )
 
@@ -55,6 +69,22 @@ var (
 func RegisterMetrics() {
 	// Register custom metrics with the global prometheus registry
 	metrics.Registry.MustRegister(instasliceMetrics.compatibleProfiles, instasliceMetrics.processedSlices, instasliceMetrics.deployedPodTotal)
+	RegisterOrphanMetrics()
+}
+
+// RegisterOrphanMetrics registers the metrics of the orphan collector, the daemonset only exposes these
+func RegisterOrphanMetrics() {
+	metrics.Registry.MustRegister(instasliceMetrics.orphansDetected, instasliceMetrics.orphansReclaimed)
+}
+
+// SetOrphansDetectedMetrics records the orphans of a kind found by a sweep, node is empty for ConfigMaps
+func SetOrphansDetectedMetrics(kind, node string, count int) {
+	instasliceMetrics.orphansDetected.WithLabelValues(kind, node).Set(float64(count))
+}
+
+// IncrementOrphansReclaimedMetrics counts an orphan reclaimed by the collector
+func IncrementOrphansReclaimedMetrics(kind, node string) {
+	instasliceMetrics.orphansReclaimed.WithLabelValues(kind, node).Inc()
 }
 
 // UpdateGpuSliceMetrics updates GPU slice allocation metrics
@@ -70,6 +100,13 @@ func (r *InstasliceReconciler) UpdateDeployedPodTotalMetrics(nodeName, gpuID, na
 	instasliceMetrics.deployedPodTotal.WithLabelValues(nodeName, gpuID, namespace, podname, profile).Set(float64(size))
 }
 
+// ResetDeployedPodTotalMetrics sets the processed slices of a pod to 0 on every GPU it had slices on
+func (r *InstasliceReconciler) ResetDeployedPodTotalMetrics(allocResult *inferencev1alpha1.AllocationResult, allocRequest *inferencev1alpha1.AllocationRequest) {
+	for gpuUUID := range slotsPerGPU(allocResult) {
+		r.UpdateDeployedPodTotalMetrics(string(allocResult.Nodename), gpuUUID, allocRequest.PodRef.Namespace, allocRequest.PodRef.Name, allocRequest.Profile, 0)
+	}
+}
+
 // UpdateCompatibleProfilesMetrics updates metrics based on remaining GPU slices and calculates compatible profiles dynamically
 // TODO: store metrics per gpu and when there is an update, calculate a fit for only one GPU instead of all GPUs on the host
 func (r *InstasliceReconciler) UpdateCompatibleProfilesMetrics(instasliceObj inferencev1alpha1.Instaslice, nodeName string) {
@@ -142,7 +142,7 @@ instaslice_pod_processed_slices{gpu_id="GPU-1",namespace="default",node="node-1"
 	// for clean slate node with 2 gpus
 	It("should reflect reduced compatibility after 1g.5gb pod allocation", func() {
 		// simulate pod allocation in memory
-		r.allocationCache = map[types.UID]inferencev1alpha1.AllocationResult{
+		r.allocationCache = newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{
 			types.UID(podUUID): {
 				GPUUUID:      "gpu1",
 				Nodename:     "node-1",
@@ -152,7 +152,7 @@ instaslice_pod_processed_slices{gpu_id="GPU-1",namespace="default",node="node-1"
 					AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
 				},
 			},
-		}
+		})
 		r.UpdateCompatibleProfilesMetrics(*instaslice, "node-1")
 		expect := `
 # HELP instaslice_compatible_profiles Profiles compatible with remaining GPU slices in a node and their counts.
@@ -0,0 +1,156 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// A new leader knows nothing of the placements of the previous one but what is written in the
+// Instaslices. Before it places any slice, it rebuilds the allocation cache from the informers,
+// drops the requests an interrupted write left without a result and wakes up the pods of the
+// allocations left in flight, so that they are created, ungated or released as if the leader
+// never changed. Pods are requeued until the recovery is done, and the readiness probe of the
+// leader fails meanwhile.
+
+// errRecovering is reported by the readiness probe of a leader recovering its state
+var errRecovering = errors.New("the allocation state of the new leader is being recovered")
+
+// isRecovered reports whether the state of the leader is recovered. Reconcilers that are not
+// run by a manager have nothing to recover.
+func (r *InstasliceReconciler) isRecovered() bool {
+	if r.recovered == nil {
+		return true
+	}
+	select {
+	case <-r.recovered:
+		return true
+	default:
+		return false
+	}
+}
+
+// ReadyzCheck fails while the leader recovers its state. Replicas waiting for the leadership make
+// no placement and are ready, so that rolling updates of a single replica go through.
+func (r *InstasliceReconciler) ReadyzCheck(_ *http.Request) error {
+	select {
+	case <-r.elected:
+	default:
+		return nil
+	}
+	if !r.isRecovered() {
+		return errRecovering
+	}
+	return nil
+}
+
+// recoverState recovers the state of the leader once it is elected, the informers are synced by
+// then. It is retried until it succeeds or ctx is done.
+func (r *InstasliceReconciler) recoverState(ctx context.Context) error {
+	log := logr.FromContext(ctx)
+	err := wait.PollUntilContextCancel(ctx, Requeue1sDelay, true, func(ctx context.Context) (bool, error) {
+		if err := r.recoverAllocations(ctx, time.Now()); err != nil {
+			log.Error(err, "failed to recover the allocation state, retrying")
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		// the leadership was lost before the state was recovered
+		return nil
+	}
+	close(r.recovered)
+	log.Info("recovered the allocation state")
+	return nil
+}
+
+// recoverAllocations rebuilds the allocation cache from the Instaslices and resumes the
+// allocations left in flight
+func (r *InstasliceReconciler) recoverAllocations(ctx context.Context, now time.Time) error {
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := r.List(ctx, &instasliceList, client.InNamespace(InstaSliceOperatorNamespace)); err != nil {
+		return err
+	}
+	for i := range instasliceList.Items {
+		if err := r.dropDanglingRequests(ctx, &instasliceList.Items[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := r.reloadAllocationCache(ctx); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.observeAllocationStates(instasliceList.Items, now)
+	r.mu.Unlock()
+
+	for _, instaslice := range instasliceList.Items {
+		for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+			allocRequest, ok := instaslice.Spec.PodAllocationRequests[podUID]
+			if !ok {
+				continue
+			}
+			if !allocationInFlight(allocResult) {
+				// the previous leader may have failed between ungating the allocation and its pod
+				pod := &v1.Pod{}
+				err := r.Get(ctx, types.NamespacedName{Namespace: allocRequest.PodRef.Namespace, Name: allocRequest.PodRef.Name}, pod)
+				if err != nil || !checkIfPodGatedByInstaSlice(pod) {
+					continue
+				}
+			}
+			r.wakePod(ctx, allocRequest.PodRef)
+		}
+	}
+	return nil
+}
+
+// dropDanglingRequests drops the allocation requests without a result. They are left by a leader
+// that failed between writing the request and the result of an allocation, hold no slots and
+// their pods are placed again.
+func (r *InstasliceReconciler) dropDanglingRequests(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	original := instaslice.DeepCopy()
+	for podUID := range instaslice.Spec.PodAllocationRequests {
+		if _, ok := instaslice.Status.PodAllocationResults[podUID]; !ok {
+			logr.FromContext(ctx).Info("dropping the allocation request left without a result", "instaslice", instaslice.Name, "pod", podUID)
+			delete(instaslice.Spec.PodAllocationRequests, podUID)
+		}
+	}
+	if len(instaslice.Spec.PodAllocationRequests) == len(original.Spec.PodAllocationRequests) {
+		return nil
+	}
+	return r.Patch(ctx, instaslice, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}),
+		client.FieldOwner(string(utils.ControllerFieldManager)))
+}
+
+// allocationInFlight reports whether an allocation waits on the controller or the daemonset,
+// allocations whose pod was ungated onto created slices are settled
+func allocationInFlight(allocResult inferencev1alpha1.AllocationResult) bool {
+	return allocResult.AllocationStatus.AllocationStatusController != inferencev1alpha1.AllocationStatusUngated ||
+		allocResult.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusCreated
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+func TestRecoverState(t *testing.T) {
+	ctx := context.Background()
+	pod := func(name string, gated bool) *v1.Pod {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name)}}
+		if gated {
+			pod.Spec.SchedulingGates = []v1.PodSchedulingGate{{Name: GateName}}
+			pod.Status = v1.PodStatus{Phase: v1.PodPending, Conditions: []v1.PodCondition{{
+				Type: v1.PodScheduled, Status: v1.ConditionFalse, Message: "Scheduling is blocked due to non-empty scheduling gates",
+			}}}
+		}
+		return pod
+	}
+	r, instaslice := newAllocationFixture(t, []testAllocation{
+		{pod: "creating", profile: "1g.5gb", start: 0, size: 1, status: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}},
+		{pod: "running", profile: "1g.5gb", start: 1, size: 1},
+		{pod: "ungating", profile: "1g.5gb", start: 2, size: 1},
+		{pod: "deleting", profile: "1g.5gb", start: 3, size: 1, status: inferencev1alpha1.AllocationStatus{
+			AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}},
+	}, pod("creating", true), pod("running", false), pod("ungating", true), pod("deleting", false))
+	// the previous leader failed between writing the request and the result of an allocation
+	instaslice.Spec.PodAllocationRequests["interrupted"] = inferencev1alpha1.AllocationRequest{
+		Profile: "1g.5gb",
+		PodRef:  v1.ObjectReference{Name: "interrupted", Namespace: "default", UID: "interrupted"},
+	}
+	assert.NoError(t, r.Update(ctx, instaslice))
+
+	r.wakeups = make(chan event.GenericEvent, wakeupBufferSize)
+	r.recovered = make(chan struct{})
+	elected := make(chan struct{})
+	r.elected = elected
+
+	// pods are requeued until the state is recovered, replicas that do not lead are ready
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "creating", Namespace: "default"}})
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl.Result{RequeueAfter: Requeue1sDelay}, result)
+	assert.NoError(t, r.ReadyzCheck(nil))
+	close(elected)
+	assert.ErrorIs(t, r.ReadyzCheck(nil), errRecovering)
+
+	assert.NoError(t, r.recoverState(ctx))
+	assert.True(t, r.isRecovered())
+	assert.NoError(t, r.ReadyzCheck(nil))
+
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	assert.NotContains(t, instaslice.Spec.PodAllocationRequests, types.UID("interrupted"))
+	assert.Len(t, instaslice.Spec.PodAllocationRequests, 4)
+	assert.Equal(t, 4, r.allocationCache.Len())
+
+	// the pods of the allocations left in flight are reconciled
+	woken := make(map[string]bool)
+	for len(r.wakeups) > 0 {
+		woken[(<-r.wakeups).Object.GetName()] = true
+	}
+	assert.Equal(t, map[string]bool{"creating": true, "ungating": true, "deleting": true}, woken)
+}
+
+func TestAllocationInFlight(t *testing.T) {
+	assert.True(t, allocationInFlight(inferencev1alpha1.AllocationResult{AllocationStatus: inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusCreating, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}}))
+	assert.True(t, allocationInFlight(inferencev1alpha1.AllocationResult{AllocationStatus: inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusDeleted}}))
+	assert.False(t, allocationInFlight(inferencev1alpha1.AllocationResult{AllocationStatus: inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated, AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated}}))
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	rcache "github.com/openshift/instaslice-operator/internal/controller/cache"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+)
+
+// A what-if simulation places pods with the placement code of the controller without touching
+// the cluster: the simulator reads the Instaslices from a client holding a copy of the cluster,
+// built by the simulation package, and pods go through the same mutation as in the webhook
+// before they are placed one by one. Allocations are only kept in the allocation cache.
+
+// SimulatedPlacement is where the slices of a pod were placed
+type SimulatedPlacement struct {
+	Pod        string                               `json:"pod"`
+	Node       string                               `json:"node"`
+	Slices     []inferencev1alpha1.SliceResult      `json:"slices"`
+	Containers []inferencev1alpha1.ContainerRequest `json:"containers"`
+}
+
+// GPUFragmentation describes the free slots of a GPU
+type GPUFragmentation struct {
+	Node    string `json:"node"`
+	GPUUUID string `json:"gpuUUID"`
+	// FreeSlots is the number of unallocated slots
+	FreeSlots int32 `json:"freeSlots"`
+	// LargestFreeBlock is the length of the longest run of unallocated slots
+	LargestFreeBlock int32 `json:"largestFreeBlock"`
+	// LargestProfile is the largest profile that still has a free placement, empty when none has
+	LargestProfile string `json:"largestProfile,omitempty"`
+}
+
+// Simulator places pods on the Instaslices of a client like the controller would, without
+// writing to the client
+type Simulator struct {
+	r         *InstasliceReconciler
+	annotator *PodAnnotator
+	policy    AllocationPolicy
+}
+
+// NewSimulator returns a simulator placing pods on the Instaslices read from c, resourceCache
+// holds the CPU and memory left on the nodes. A nil cfg uses the default configuration.
+func NewSimulator(ctx context.Context, c client.Client, resourceCache *rcache.ResourceCache, cfg *config.Config) (*Simulator, error) {
+	if cfg == nil {
+		cfg = config.NewConfig()
+	}
+	r := &InstasliceReconciler{Client: c, Config: cfg, ResourceCache: resourceCache}
+	if err := r.ensureAllocationCache(ctx); err != nil {
+		return nil, err
+	}
+	return &Simulator{r: r, annotator: &PodAnnotator{Client: c}, policy: r.allocationPolicy(ctx)}, nil
+}
+
+// PlacePod admits a pod like the webhook and places its slices on the first node they fit on,
+// the pod is mutated like by the webhook. The reason is set when the pod does not fit.
+func (s *Simulator) PlacePod(ctx context.Context, pod *v1.Pod) (*SimulatedPlacement, string, error) {
+	return s.r.simulatePod(ctx, s.annotator, s.policy, pod)
+}
+
+// Fragmentation describes the free slots of every GPU, by node name then GPU UUID
+func (s *Simulator) Fragmentation(ctx context.Context) ([]GPUFragmentation, error) {
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := s.r.List(ctx, &instasliceList); err != nil {
+		return nil, err
+	}
+	sort.Slice(instasliceList.Items, func(i, j int) bool {
+		return instasliceList.Items[i].Name < instasliceList.Items[j].Name
+	})
+	fragmentation := []GPUFragmentation{}
+	for i := range instasliceList.Items {
+		fragmentation = append(fragmentation, s.r.gpuFragmentation(&instasliceList.Items[i])...)
+	}
+	return fragmentation, nil
+}
+
+// simulatePod admits a pod like the webhook and places its slices on the first node they fit on,
+// the reason is set when the pod does not fit
+func (r *InstasliceReconciler) simulatePod(ctx context.Context, annotator *PodAnnotator, policy AllocationPolicy, pod *v1.Pod) (*SimulatedPlacement, string, error) {
+	if !hasMIGResource(pod) {
+		return nil, "the pod requests no MIG slices", nil
+	}
+	if response := annotator.mutatePod(ctx, admission.Request{}, pod); response != nil {
+		return nil, "rejected by the webhook: " + response.Result.Message, nil
+	}
+	containers := r.extractContainerRequests(pod)
+	if len(containers) == 0 {
+		return nil, noGPUContainerInsidePodErr, nil
+	}
+	if _, _, err := podGPUModels(pod); err != nil {
+		return nil, err.Error(), nil
+	}
+
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := r.List(ctx, &instasliceList); err != nil {
+		return nil, "", err
+	}
+	if reason, unsatisfiable := unsatisfiableGPUModel(pod, instasliceList.Items); unsatisfiable {
+		return nil, reason, nil
+	}
+	r.sortInstaslicesForPod(ctx, pod, instasliceList.Items)
+	var reasons []string
+	for i := range instasliceList.Items {
+		instaslice := &instasliceList.Items[i]
+		allocRequest, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, containers, policy, pod)
+		if err != nil {
+			reasons = append(reasons, instaslice.Name+": "+err.Error())
+			continue
+		}
+		r.updateCacheWithNewAllocation(pod.UID, *allocResult)
+		// the CPU and memory of the pod count against the node for the pods placed after it
+		placed := pod.DeepCopy()
+		placed.Spec.NodeName = instaslice.Name
+		r.ResourceCache.ResourceEventHandlerForPod().AddFunc(placed)
+		return &SimulatedPlacement{
+			Pod:        pod.Namespace + "/" + pod.Name,
+			Node:       instaslice.Name,
+			Slices:     allocResult.AllSlices(),
+			Containers: allocRequest.AllContainers(),
+		}, "", nil
+	}
+	if len(reasons) == 0 {
+		return nil, "no node with MIG enabled GPUs", nil
+	}
+	return nil, strings.Join(reasons, "; "), nil
+}
+
+// gpuFragmentation describes the free slots of every GPU of a node
+func (r *InstasliceReconciler) gpuFragmentation(instaslice *inferencev1alpha1.Instaslice) []GPUFragmentation {
+	profileNames := make([]string, 0, len(instaslice.Status.NodeResources.MigPlacement))
+	for profileName, mig := range instaslice.Status.NodeResources.MigPlacement {
+		if !mig.SharesGPUInstance() {
+			profileNames = append(profileNames, profileName)
+		}
+	}
+	sort.Strings(profileNames)
+	var fragmentation []GPUFragmentation
+	for _, gpuUUID := range sortGPUs(instaslice) {
+		allocated := r.gpuAllocatedSlices(instaslice, gpuUUID)
+		gpu := GPUFragmentation{Node: instaslice.Name, GPUUUID: gpuUUID, FreeSlots: allocated.FreeCount()}
+		for start := int32(0); start < allocated.Len(); start++ {
+			if length := allocated.FreeBlockLength(start); length > gpu.LargestFreeBlock {
+				gpu.LargestFreeBlock = length
+			}
+		}
+		var largestSize int32
+		for _, profileName := range profileNames {
+			if size := profileSize(instaslice, profileName); size > largestSize && len(freePlacementStarts(instaslice, profileName, allocated)) > 0 {
+				gpu.LargestProfile, largestSize = profileName, size
+			}
+		}
+		fragmentation = append(fragmentation, gpu)
+	}
+	return fragmentation
+}
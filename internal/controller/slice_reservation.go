@@ -0,0 +1,396 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// A SliceReservation holds placements of a profile for the pods of its namespace. Free reserved
+// slices are kept in the allocation cache under a key of their own, so that no other pod is
+// allocated their slots, and pods of the namespace are placed on them before any other slot.
+// A slice is bound to the pod allocated on it until the allocation is released, when it is held
+// for the reservation again. Bindings are recorded in the status of the reservation, from which
+// the cache is rebuilt after a restart.
+
+// reservedSlice is a slice held by a SliceReservation
+type reservedSlice struct {
+	reservation    types.NamespacedName
+	reservationUID types.UID
+	profile        string
+	inferencev1alpha1.ReservedSlice
+}
+
+// reservedSliceKey returns the key of a reserved slice in the allocation cache
+func reservedSliceKey(reservationUID types.UID, slice inferencev1alpha1.ReservedSlice) types.UID {
+	return types.UID(fmt.Sprintf("reservation-%s-%s-%d", reservationUID, slice.GPUUUID, slice.MigPlacement.Start))
+}
+
+// sliceResult returns the slice of the allocation holding the reserved slice
+func (s *reservedSlice) sliceResult() inferencev1alpha1.SliceResult {
+	return inferencev1alpha1.SliceResult{MigPlacement: s.MigPlacement, GPUUUID: s.GPUUUID, Profile: s.profile}
+}
+
+// allocation returns the entry holding the reserved slice in the allocation cache
+func (s *reservedSlice) allocation() inferencev1alpha1.AllocationResult {
+	return inferencev1alpha1.AllocationResult{
+		MigPlacement:     s.MigPlacement,
+		GPUUUID:          s.GPUUUID,
+		Nodename:         s.Nodename,
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusReserved},
+		Slices:           []inferencev1alpha1.SliceResult{s.sliceResult()},
+	}
+}
+
+// readReservedSlices reads the slices of every SliceReservation from their status, by their key
+func (r *InstasliceReconciler) readReservedSlices(ctx context.Context) (map[types.UID]*reservedSlice, error) {
+	reservedSlices := make(map[types.UID]*reservedSlice)
+	var reservations inferencev1alpha1.SliceReservationList
+	if err := r.List(ctx, &reservations); err != nil {
+		// clusters without the SliceReservation CRD have no reservations
+		if meta.IsNoMatchError(err) {
+			return reservedSlices, nil
+		}
+		return nil, err
+	}
+	for _, reservation := range reservations.Items {
+		for _, slice := range reservation.Status.Slices {
+			reservedSlices[reservedSliceKey(reservation.UID, slice)] = &reservedSlice{
+				reservation:    types.NamespacedName{Namespace: reservation.Namespace, Name: reservation.Name},
+				reservationUID: reservation.UID,
+				profile:        reservation.Spec.Profile,
+				ReservedSlice:  slice,
+			}
+		}
+	}
+	return reservedSlices, nil
+}
+
+// syncReservedSlices binds the reserved slices to the pods allocated on them and holds the
+// others in the allocation cache. Reservations whose bindings changed are reconciled.
+func (r *InstasliceReconciler) syncReservedSlices() {
+	if len(r.reservedSlices) == 0 {
+		return
+	}
+	allocations := r.allocationCache.Snapshot()
+	for key, slice := range r.reservedSlices {
+		holder := slice.PodUID
+		if holder == "" || !r.allocatedOnSlice(holder, slice) {
+			holder = ""
+			for podUID := range allocations {
+				if r.allocatedOnSlice(podUID, slice) {
+					holder = podUID
+					break
+				}
+			}
+		}
+		if holder != slice.PodUID {
+			slice.PodUID = holder
+			r.notifyReservation(slice.reservation)
+		}
+		if holder == "" {
+			r.allocationCache.Set(key, slice.allocation())
+		} else {
+			r.allocationCache.Delete(key)
+		}
+	}
+}
+
+// allocatedOnSlice reports whether the live allocation of a pod has a slice taking exactly the
+// placement of the reserved slice
+func (r *InstasliceReconciler) allocatedOnSlice(podUID types.UID, reserved *reservedSlice) bool {
+	allocResult, ok := r.allocationCache.Get(podUID)
+	if !ok || allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusReserved ||
+		allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted ||
+		allocResult.Nodename != reserved.Nodename {
+		return false
+	}
+	for _, slice := range allocResult.AllSlices() {
+		if slice.GPUUUID == reserved.GPUUUID && slice.MigPlacement == reserved.MigPlacement && slice.ComputePlacement == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyReservation reconciles a reservation, when the reservation controller runs
+func (r *InstasliceReconciler) notifyReservation(name types.NamespacedName) {
+	if r.reservationUpdates == nil {
+		return
+	}
+	reservation := &inferencev1alpha1.SliceReservation{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+	select {
+	case r.reservationUpdates <- event.GenericEvent{Object: reservation}:
+	default:
+	}
+}
+
+// freeReservedSlices returns the free slices reserved for a namespace on a node by their key,
+// leaving out the taken ones
+func (s *placementState) freeReservedSlices(nodeName, namespace string, taken map[types.UID]bool) map[types.UID]*reservedSlice {
+	free := make(map[types.UID]*reservedSlice)
+	for key, slice := range s.reservedSlices {
+		if slice.PodUID == "" && !taken[key] && string(slice.Nodename) == nodeName && slice.reservation.Namespace == namespace {
+			free[key] = slice
+		}
+	}
+	return free
+}
+
+// placeReservedSlices places the slices of the containers on free reserved slices, ok is false
+// unless every slice takes exactly one of them. It returns the keys of the reserved slices taken.
+func (r *InstasliceReconciler) placeReservedSlices(instaslice *inferencev1alpha1.Instaslice, containers []inferencev1alpha1.ContainerRequest, policy AllocationPolicy,
+	candidates []GPUCandidate, reserved map[types.UID]*reservedSlice) ([]inferencev1alpha1.SliceResult, []inferencev1alpha1.ContainerResult, []types.UID, bool) {
+	if len(reserved) == 0 {
+		return nil, nil, nil, false
+	}
+	// only the reserved slots are free
+	restricted := make([]GPUCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		allocated := NewGPUSlots(candidate.Allocated.Len())
+		allocated.Allocate(0, allocated.Len())
+		for _, slice := range reserved {
+			if slice.GPUUUID == candidate.GPUUUID {
+				allocated.Release(slice.MigPlacement.Start, slice.MigPlacement.Size)
+			}
+		}
+		restricted = append(restricted, GPUCandidate{GPUUUID: candidate.GPUUUID, Allocated: allocated})
+	}
+	slices, containerResults, ok := r.placeContainerSlices(instaslice, containers, policy, restricted)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	var taken []types.UID
+	for _, slice := range slices {
+		found := false
+		for key, reservedSlice := range reserved {
+			if reservedSlice.GPUUUID == slice.GPUUUID && reservedSlice.MigPlacement == slice.MigPlacement && slice.ComputePlacement == nil {
+				taken, found = append(taken, key), true
+				break
+			}
+		}
+		if !found {
+			return nil, nil, nil, false
+		}
+	}
+	return slices, containerResults, taken, true
+}
+
+// hasFreeReservedSlices reports whether slices are reserved for the namespace on the node
+func (s *placementState) hasFreeReservedSlices(nodeName, namespace string) bool {
+	return len(s.freeReservedSlices(nodeName, namespace, nil)) > 0
+}
+
+// reserveOtherReservedSlices marks the slices reserved for other namespaces on the node as
+// allocated, bound or not. A bound slice is held for its reservation again as soon as its pod
+// releases it, which placements working from a copy of the state may not see yet.
+func (s *placementState) reserveOtherReservedSlices(nodeName, namespace string, candidates []GPUCandidate) {
+	for _, slice := range s.reservedSlices {
+		if string(slice.Nodename) == nodeName && slice.reservation.Namespace != namespace {
+			reserveSlice(candidates, slice.sliceResult(), inferencev1alpha1.Mig{})
+		}
+	}
+}
+
+// SliceReservationReconciler places the slices of SliceReservations and reports their use in
+// their status. It shares the allocation cache of the InstasliceReconciler.
+type SliceReservationReconciler struct {
+	*InstasliceReconciler
+}
+
+//+kubebuilder:rbac:groups=inference.redhat.com,resources=slicereservations,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=inference.redhat.com,resources=slicereservations/status,verbs=get;update;patch
+
+func (r *SliceReservationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if !r.isRecovered() {
+		return ctrl.Result{RequeueAfter: Requeue1sDelay}, nil
+	}
+	log := logr.FromContext(ctx)
+	if err := r.ensureAllocationCache(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	reservation := &inferencev1alpha1.SliceReservation{}
+	if err := r.Get(ctx, req.NamespacedName, reservation); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.mu.Lock()
+			r.dropReservedSlices(req.NamespacedName, "", "", 0)
+			r.mu.Unlock()
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := r.List(ctx, &instasliceList, client.InNamespace(InstaSliceOperatorNamespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	// the pending pods are woken up when slices are given back
+	nodes := r.readNodes(ctx, instasliceList.Items)
+	policy := r.allocationPolicy(ctx)
+
+	// only the slices are reserved under r.mu, the API is read before and written after
+	r.mu.Lock()
+	r.syncReservedSlices()
+	// free slices beyond the count or of another profile are given back
+	released := r.dropReservedSlices(req.NamespacedName, reservation.UID, reservation.Spec.Profile, reservation.Spec.Count)
+	pending := r.placeReservation(reservation, instasliceList.Items, policy)
+	if released {
+		r.wakePendingPods(ctx, instasliceList.Items, nodes, policy)
+	}
+	status := r.reservationStatus(reservation.UID, pending)
+	r.mu.Unlock()
+
+	if !apiequality.Semantic.DeepEqual(status, reservation.Status) {
+		reservation.Status = status
+		if err := r.Status().Update(ctx, reservation); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if pending > 0 {
+		log.Info("not every reserved slice could be placed", "reservation", req.NamespacedName, "pending", pending)
+		return ctrl.Result{RequeueAfter: Requeue10sDelay}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// reservationSlices returns the slices held by a reservation in the order of their node, GPU and start
+func (r *InstasliceReconciler) reservationSlices(reservationUID types.UID) []*reservedSlice {
+	var slices []*reservedSlice
+	for _, slice := range r.reservedSlices {
+		if slice.reservationUID == reservationUID {
+			slices = append(slices, slice)
+		}
+	}
+	sort.Slice(slices, func(i, j int) bool {
+		a, b := slices[i], slices[j]
+		if a.Nodename != b.Nodename {
+			return a.Nodename < b.Nodename
+		}
+		if a.GPUUUID != b.GPUUUID {
+			return a.GPUUUID < b.GPUUUID
+		}
+		return a.MigPlacement.Start < b.MigPlacement.Start
+	})
+	return slices
+}
+
+// dropReservedSlices stops holding the slices of a reservation that belong to an earlier object
+// of the same name, that are of another profile or that are free and beyond count. Bound slices
+// are dropped once released. It reports whether slots were freed.
+func (r *InstasliceReconciler) dropReservedSlices(name types.NamespacedName, reservationUID types.UID, profile string, count int32) bool {
+	released := false
+	drop := func(key types.UID, slice *reservedSlice) {
+		delete(r.reservedSlices, key)
+		if slice.PodUID == "" {
+			r.allocationCache.Delete(key)
+			released = true
+		}
+	}
+	for key, slice := range r.reservedSlices {
+		if slice.reservation == name && (slice.reservationUID != reservationUID || (slice.PodUID == "" && slice.profile != profile)) {
+			drop(key, slice)
+		}
+	}
+	slices := r.reservationSlices(reservationUID)
+	excess := int32(len(slices)) - count
+	for i := len(slices) - 1; i >= 0 && excess > 0; i-- {
+		if slices[i].PodUID == "" {
+			drop(reservedSliceKey(reservationUID, slices[i].ReservedSlice), slices[i])
+			excess--
+		}
+	}
+	return released
+}
+
+// placeReservation holds slices for a reservation until it has count of them, on the nodes in the
+// order of their names. It returns the number of slices that fit on no node. It runs under r.mu
+// and takes the slot lock of each node, pods are placed meanwhile.
+func (r *InstasliceReconciler) placeReservation(reservation *inferencev1alpha1.SliceReservation, instaslices []inferencev1alpha1.Instaslice, policy AllocationPolicy) int32 {
+	missing := reservation.Spec.Count - int32(len(r.reservationSlices(reservation.UID)))
+	if missing <= 0 {
+		return 0
+	}
+	instaslices = append([]inferencev1alpha1.Instaslice(nil), instaslices...)
+	sort.Slice(instaslices, func(i, j int) bool {
+		return instaslices[i].Name < instaslices[j].Name
+	})
+	if r.reservedSlices == nil {
+		r.reservedSlices = make(map[types.UID]*reservedSlice)
+	}
+	profile := reservation.Spec.Profile
+	for i := range instaslices {
+		instaslice := &instaslices[i]
+		mig, ok := instaslice.Status.NodeResources.MigPlacement[profile]
+		if !ok || mig.SharesGPUInstance() {
+			continue
+		}
+		unlock := r.allocationCache.LockNodeSlots(types.NodeName(instaslice.Name))
+		candidates := r.gpuCandidates(instaslice)
+		r.reserveNominatedSlices(instaslice.Name, nil, nil, candidates)
+		for missing > 0 {
+			gpuUUID, start, ok := policy.SelectPlacement(instaslice, profile, candidates)
+			if !ok {
+				break
+			}
+			slice := &reservedSlice{
+				reservation:    types.NamespacedName{Namespace: reservation.Namespace, Name: reservation.Name},
+				reservationUID: reservation.UID,
+				profile:        profile,
+				ReservedSlice: inferencev1alpha1.ReservedSlice{
+					Nodename:     types.NodeName(instaslice.Name),
+					GPUUUID:      gpuUUID,
+					MigPlacement: inferencev1alpha1.Placement{Start: start, Size: profileSize(instaslice, profile)},
+				},
+			}
+			reserveSlice(candidates, slice.sliceResult(), inferencev1alpha1.Mig{})
+			key := reservedSliceKey(reservation.UID, slice.ReservedSlice)
+			r.reservedSlices[key] = slice
+			r.allocationCache.Set(key, slice.allocation())
+			missing--
+		}
+		unlock()
+	}
+	return missing
+}
+
+// reservationStatus returns the status of a reservation from the slices it holds
+func (r *InstasliceReconciler) reservationStatus(reservationUID types.UID, pending int32) inferencev1alpha1.SliceReservationStatus {
+	status := inferencev1alpha1.SliceReservationStatus{Pending: pending}
+	for _, slice := range r.reservationSlices(reservationUID) {
+		status.Slices = append(status.Slices, slice.ReservedSlice)
+		if slice.PodUID != "" {
+			status.Bound++
+		} else {
+			status.Free++
+		}
+	}
+	return status
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	rcache "github.com/openshift/instaslice-operator/internal/controller/cache"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestSliceReservation(t *testing.T) {
+	ctx := context.Background()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpus := sortGPUs(instaslice)
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(scheme))
+	assert.NoError(t, inferencev1alpha1.AddToScheme(scheme))
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("4"),
+			v1.ResourceMemory: resource.MustParse("8Gi"),
+		}},
+	}
+	reservation := &inferencev1alpha1.SliceReservation{
+		ObjectMeta: metav1.ObjectMeta{Name: "headroom", Namespace: "oncall", UID: "reservation-1"},
+		Spec:       inferencev1alpha1.SliceReservationSpec{Profile: "3g.20gb", Count: 2},
+	}
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instaslice, node, reservation).
+		WithStatusSubresource(instaslice, reservation).Build()
+	resourceCache := rcache.NewResourceCache()
+	resourceCache.ResourceEventHandlerForNode().AddFunc(node)
+	newReconciler := func() *InstasliceReconciler {
+		return &InstasliceReconciler{Client: fakeClient, Config: config.NewConfig(), ResourceCache: resourceCache}
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "oncall", Name: "headroom"}}
+	reconcile := func(r *InstasliceReconciler) inferencev1alpha1.SliceReservationStatus {
+		_, err := (&SliceReservationReconciler{InstasliceReconciler: r}).Reconcile(ctx, req)
+		assert.NoError(t, err)
+		assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, reservation))
+		return reservation.Status
+	}
+	newPod := func(name, namespace string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(name)}}
+	}
+	containers := []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "3g.20gb"}}
+
+	r := newReconciler()
+	assert.Equal(t, inferencev1alpha1.SliceReservationStatus{
+		Slices: []inferencev1alpha1.ReservedSlice{
+			{Nodename: "node-1", GPUUUID: gpus[0], MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 4}},
+			{Nodename: "node-1", GPUUUID: gpus[0], MigPlacement: inferencev1alpha1.Placement{Start: 4, Size: 4}},
+		},
+		Free: 2,
+	}, reconcile(r))
+
+	// pods of other namespaces do not get the reserved slots
+	_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, containers, &FirstFitPolicy{}, newPod("other", "team-b"))
+	assert.NoError(t, err)
+	assert.Equal(t, gpus[1], allocResult.GPUUUID)
+
+	// a pod of the namespace takes a reserved slice and is bound to it
+	_, allocResult, err = r.findNodeAndDeviceForASlice(ctx, instaslice, containers, &FirstFitPolicy{}, newPod("model", "oncall"))
+	assert.NoError(t, err)
+	assert.Equal(t, gpus[0], allocResult.GPUUUID)
+	assert.Equal(t, inferencev1alpha1.Placement{Start: 0, Size: 4}, allocResult.MigPlacement)
+	r.updateCacheWithNewAllocation("model", *allocResult)
+	status := reconcile(r)
+	assert.Equal(t, int32(1), status.Bound)
+	assert.Equal(t, int32(1), status.Free)
+	assert.Equal(t, types.UID("model"), status.Slices[0].PodUID)
+
+	// after a restart the bound slice stays with the pod and the free one is held again
+	instaslice.Status.PodAllocationResults = map[types.UID]inferencev1alpha1.AllocationResult{"model": *allocResult}
+	assert.NoError(t, fakeClient.Status().Update(ctx, instaslice))
+	r = newReconciler()
+	assert.NoError(t, r.ensureAllocationCache(ctx))
+	assert.Equal(t, int32(0), r.gpuAllocatedSlices(instaslice, gpus[0]).FreeCount())
+	free := r.freeReservedSlices("node-1", "oncall", nil)
+	assert.Len(t, free, 1)
+	for _, slice := range free {
+		assert.Equal(t, int32(4), slice.MigPlacement.Start)
+	}
+
+	// a released slice is held for the reservation again
+	r.allocationCache.Delete("model")
+	r.syncReservedSlices()
+	assert.Len(t, r.freeReservedSlices("node-1", "oncall", nil), 2)
+	assert.Equal(t, int32(0), r.gpuAllocatedSlices(instaslice, gpus[0]).FreeCount())
+
+	// lowering the count gives the free slices back
+	reservation.Spec.Count = 0
+	assert.NoError(t, fakeClient.Update(ctx, reservation))
+	assert.Equal(t, inferencev1alpha1.SliceReservationStatus{}, reconcile(r))
+	assert.Equal(t, int32(8), r.gpuAllocatedSlices(instaslice, gpus[0]).FreeCount())
+}
+
+func TestPlaceReservedSlices(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpus := sortGPUs(instaslice)
+	r := &InstasliceReconciler{allocationCache: newAllocationStore(map[types.UID]inferencev1alpha1.AllocationResult{})}
+	reserved := map[types.UID]*reservedSlice{
+		"slot": {profile: "3g.20gb", ReservedSlice: inferencev1alpha1.ReservedSlice{
+			Nodename: "node-1", GPUUUID: gpus[1], MigPlacement: inferencev1alpha1.Placement{Start: 4, Size: 4},
+		}},
+	}
+
+	slices, _, taken, ok := r.placeReservedSlices(instaslice, []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "3g.20gb"}}, &FirstFitPolicy{}, r.gpuCandidates(instaslice), reserved)
+	assert.True(t, ok)
+	assert.Equal(t, []types.UID{"slot"}, taken)
+	assert.Equal(t, gpus[1], slices[0].GPUUUID)
+	assert.Equal(t, inferencev1alpha1.Placement{Start: 4, Size: 4}, slices[0].MigPlacement)
+
+	// a smaller slice would only take part of the reserved slots
+	_, _, _, ok = r.placeReservedSlices(instaslice, []inferencev1alpha1.ContainerRequest{{Name: "main", Profile: "1g.5gb"}}, &FirstFitPolicy{}, r.gpuCandidates(instaslice), reserved)
+	assert.False(t, ok)
+}
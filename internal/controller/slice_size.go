@@ -0,0 +1,179 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// Instead of a profile, whose name depends on the GPU model, a container can request the size of
+// its slice with the accelerator-memory and accelerator-compute-percent resources. On every node
+// the smallest profile with at least that much memory and share of the GPU compute is allocated.
+
+// profileCapacityPattern matches the compute slices and the memory in GB of a profile name,
+// profiles with extensions such as media engines are not considered for sized requests
+var profileCapacityPattern = regexp.MustCompile(`^(\d+)g\.(\d+)gb$`)
+
+// profileCapacity returns the compute slices and the memory of a profile, ok is false for
+// profiles whose name does not follow the <compute>g.<memory>gb pattern. Memory is counted
+// in Gi like the accelerator memory quota.
+func profileCapacity(profileName string) (compute int64, memory resource.Quantity, ok bool) {
+	match := profileCapacityPattern.FindStringSubmatch(profileName)
+	if match == nil {
+		return 0, resource.Quantity{}, false
+	}
+	compute, _ = strconv.ParseInt(match[1], 10, 64)
+	memoryGB, _ := strconv.ParseInt(match[2], 10, 64)
+	return compute, *resource.NewQuantity(memoryGB<<30, resource.BinarySI), true
+}
+
+// smallestProfileForSize returns the profile of the node taking the fewest slots that has at
+// least memory and computePercent of the compute of a GPU, fewer compute slices and then less
+// memory break ties. A nil memory or a zero computePercent is not a constraint.
+func smallestProfileForSize(instaslice *inferencev1alpha1.Instaslice, memory *resource.Quantity, computePercent int32) (string, bool) {
+	var gpuCompute int64
+	for profileName := range instaslice.Status.NodeResources.MigPlacement {
+		if compute, _, ok := profileCapacity(profileName); ok && compute > gpuCompute {
+			gpuCompute = compute
+		}
+	}
+	best, bestSize := "", int32(0)
+	var bestCompute int64
+	var bestMemory resource.Quantity
+	for profileName := range instaslice.Status.NodeResources.MigPlacement {
+		compute, profileMemory, ok := profileCapacity(profileName)
+		size := profileSize(instaslice, profileName)
+		if !ok || size == 0 {
+			continue
+		}
+		if memory != nil && profileMemory.Cmp(*memory) < 0 {
+			continue
+		}
+		if compute*100 < int64(computePercent)*gpuCompute {
+			continue
+		}
+		smaller := best == "" || size < bestSize
+		if !smaller && size == bestSize {
+			switch {
+			case compute != bestCompute:
+				smaller = compute < bestCompute
+			case profileMemory.Cmp(bestMemory) != 0:
+				smaller = profileMemory.Cmp(bestMemory) < 0
+			default:
+				smaller = profileName < best
+			}
+		}
+		if smaller {
+			best, bestSize, bestCompute, bestMemory = profileName, size, compute, profileMemory
+		}
+	}
+	return best, best != ""
+}
+
+// isSizedRequest reports whether the container requests its slice by size instead of a profile
+func isSizedRequest(container inferencev1alpha1.ContainerRequest) bool {
+	return container.AcceleratorMemory != nil || container.ComputePercent > 0
+}
+
+// sizedContainerProfiles returns the containers with the profile their size resolves to on the
+// node, ok is false when the node has no profile large enough for one of them
+func sizedContainerProfiles(instaslice *inferencev1alpha1.Instaslice, containers []inferencev1alpha1.ContainerRequest) ([]inferencev1alpha1.ContainerRequest, bool) {
+	resolved := append([]inferencev1alpha1.ContainerRequest(nil), containers...)
+	for i, container := range containers {
+		if !isSizedRequest(container) {
+			continue
+		}
+		profileName, ok := smallestProfileForSize(instaslice, container.AcceleratorMemory, container.ComputePercent)
+		if !ok {
+			return nil, false
+		}
+		resolved[i].Profile = profileName
+		resolved[i].AcceptableProfiles = nil
+	}
+	return resolved, true
+}
+
+// containerSliceSize returns the size requested by a container through the accelerator-memory and
+// accelerator-compute-percent resources, ok is false when it requests neither
+func containerSliceSize(container *v1.Container) (memory *resource.Quantity, computePercent int32, ok bool, err error) {
+	lookup := func(name string) (resource.Quantity, bool) {
+		if quantity, found := container.Resources.Limits[v1.ResourceName(name)]; found {
+			return quantity, true
+		}
+		quantity, found := container.Resources.Requests[v1.ResourceName(name)]
+		return quantity, found
+	}
+	if quantity, found := lookup(AcceleratorMemoryResourceName); found {
+		if quantity.Sign() <= 0 {
+			return nil, 0, true, fmt.Errorf("%s of container %s must be positive", AcceleratorMemoryResourceName, container.Name)
+		}
+		memory = &quantity
+	}
+	if quantity, found := lookup(AcceleratorComputeResourceName); found {
+		percent := quantity.Value()
+		if percent < 1 || percent > 100 {
+			return nil, 0, true, fmt.Errorf("%s of container %s must be between 1 and 100", AcceleratorComputeResourceName, container.Name)
+		}
+		computePercent = int32(percent)
+	}
+	if memory == nil && computePercent == 0 {
+		return nil, 0, false, nil
+	}
+	for _, resources := range []v1.ResourceList{container.Resources.Limits, container.Resources.Requests} {
+		for resourceName := range resources {
+			if strings.HasPrefix(string(resourceName), NvidiaMIGPrefix) {
+				return nil, 0, true, fmt.Errorf("container %s requests both %s and a slice size", container.Name, resourceName)
+			}
+		}
+	}
+	return memory, computePercent, true, nil
+}
+
+// removeSliceSizeResources drops the slice size resources of a container, nodes do not offer them
+func removeSliceSizeResources(resources *v1.ResourceRequirements) {
+	for _, resourceList := range []v1.ResourceList{resources.Limits, resources.Requests} {
+		delete(resourceList, AcceleratorMemoryResourceName)
+		delete(resourceList, AcceleratorComputeResourceName)
+	}
+}
+
+// sliceSizeQuota returns the memory of the largest profile a slice size resolves to on any of the
+// nodes, which is what the accelerator memory quota of the container has to cover
+func sliceSizeQuota(instaslices []inferencev1alpha1.Instaslice, memory *resource.Quantity, computePercent int32) (resource.Quantity, bool) {
+	var quota resource.Quantity
+	found := false
+	for i := range instaslices {
+		profileName, ok := smallestProfileForSize(&instaslices[i], memory, computePercent)
+		if !ok {
+			continue
+		}
+		_, profileMemory, _ := profileCapacity(profileName)
+		if !found || profileMemory.Cmp(quota) > 0 {
+			quota = profileMemory
+		}
+		found = true
+	}
+	return quota, found
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// a30Capacity returns a node with an A30, whose GPU has 4 slots and 24GB
+func a30Capacity(nodeName string) *inferencev1alpha1.Instaslice {
+	instaslice := utils.GenerateFakeCapacity(nodeName)
+	instaslice.Status.NodeResources.MigPlacement = map[string]inferencev1alpha1.Mig{
+		"1g.6gb":  {Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 1}, {Start: 1, Size: 1}, {Start: 2, Size: 1}, {Start: 3, Size: 1}}},
+		"2g.12gb": {Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 2}, {Start: 2, Size: 2}}},
+		"4g.24gb": {Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 4}}},
+	}
+	return instaslice
+}
+
+func TestSmallestProfileForSize(t *testing.T) {
+	a100 := utils.GenerateFakeCapacity("node-1")
+	a30 := a30Capacity("node-2")
+	memory := func(value string) *resource.Quantity {
+		quantity := resource.MustParse(value)
+		return &quantity
+	}
+
+	tests := []struct {
+		name            string
+		instaslice      *inferencev1alpha1.Instaslice
+		memory          *resource.Quantity
+		computePercent  int32
+		expectedProfile string
+	}{
+		{name: "memory below the smallest profile", instaslice: a100, memory: memory("4Gi"), expectedProfile: "1g.5gb"},
+		{name: "memory of a profile", instaslice: a100, memory: memory("5Gi"), expectedProfile: "1g.5gb"},
+		{name: "fewer compute slices win among profiles of the same size", instaslice: a100, memory: memory("6Gi"), expectedProfile: "1g.10gb"},
+		{name: "memory between profiles", instaslice: a100, memory: memory("12Gi"), expectedProfile: "3g.20gb"},
+		{name: "whole GPU", instaslice: a100, memory: memory("40Gi"), expectedProfile: "7g.40gb"},
+		{name: "more memory than a GPU", instaslice: a100, memory: memory("41Gi")},
+		{name: "compute fraction", instaslice: a100, computePercent: 30, expectedProfile: "3g.20gb"},
+		{name: "half of the compute", instaslice: a100, computePercent: 50, expectedProfile: "4g.20gb"},
+		{name: "memory and compute", instaslice: a100, memory: memory("8Gi"), computePercent: 10, expectedProfile: "1g.10gb"},
+		{name: "same request on another GPU model", instaslice: a30, memory: memory("12Gi"), expectedProfile: "2g.12gb"},
+		{name: "compute fraction on another GPU model", instaslice: a30, computePercent: 30, expectedProfile: "2g.12gb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profileName, ok := smallestProfileForSize(tt.instaslice, tt.memory, tt.computePercent)
+			assert.Equal(t, tt.expectedProfile != "", ok)
+			assert.Equal(t, tt.expectedProfile, profileName)
+		})
+	}
+}
+
+func TestPlaceSizedContainerSlices(t *testing.T) {
+	instaslice := a30Capacity("node-2")
+	gpus := sortGPUs(instaslice)
+	memory := resource.MustParse("12Gi")
+	containers := []inferencev1alpha1.ContainerRequest{{Name: "model", AcceleratorMemory: &memory}}
+
+	r := &InstasliceReconciler{}
+	slices, results, ok := r.placeContainerSlices(instaslice, containers, &FirstFitPolicy{}, r.gpuCandidates(instaslice))
+	assert.True(t, ok)
+	assert.Equal(t, []inferencev1alpha1.SliceResult{
+		{GPUUUID: gpus[0], MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 2}, Profile: "2g.12gb"},
+	}, slices)
+	assert.Equal(t, "2g.12gb", allocatedContainers(containers, slices, results)[0].Profile)
+	assert.Empty(t, containers[0].Profile)
+
+	// no profile of the node is large enough
+	memory = resource.MustParse("40Gi")
+	_, _, ok = r.placeContainerSlices(instaslice, containers, &FirstFitPolicy{}, r.gpuCandidates(instaslice))
+	assert.False(t, ok)
+}
+
+func TestContainerSliceSize(t *testing.T) {
+	tests := []struct {
+		name                   string
+		limits                 v1.ResourceList
+		expectedMemory         string
+		expectedComputePercent int32
+		expectedSized          bool
+		expectedError          string
+	}{
+		{name: "no size", limits: v1.ResourceList{"nvidia.com/mig-1g.5gb": resource.MustParse("1")}},
+		{name: "memory", limits: v1.ResourceList{AcceleratorMemoryResourceName: resource.MustParse("12Gi")}, expectedMemory: "12Gi", expectedSized: true},
+		{name: "compute", limits: v1.ResourceList{AcceleratorComputeResourceName: resource.MustParse("25")}, expectedComputePercent: 25, expectedSized: true},
+		{name: "compute above 100", limits: v1.ResourceList{AcceleratorComputeResourceName: resource.MustParse("150")}, expectedError: "must be between 1 and 100"},
+		{name: "zero memory", limits: v1.ResourceList{AcceleratorMemoryResourceName: resource.MustParse("0")}, expectedError: "must be positive"},
+		{
+			name: "size and profile",
+			limits: v1.ResourceList{
+				AcceleratorMemoryResourceName: resource.MustParse("12Gi"),
+				"nvidia.com/mig-1g.5gb":       resource.MustParse("1"),
+			},
+			expectedError: "requests both nvidia.com/mig-1g.5gb and a slice size",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			container := &v1.Container{Name: "model", Resources: v1.ResourceRequirements{Limits: tt.limits}}
+			memory, computePercent, sized, err := containerSliceSize(container)
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedSized, sized)
+			assert.Equal(t, tt.expectedComputePercent, computePercent)
+			if tt.expectedMemory != "" {
+				assert.Equal(t, resource.MustParse(tt.expectedMemory), *memory)
+			} else {
+				assert.Nil(t, memory)
+			}
+		})
+	}
+}
+
+func TestSliceSizeQuota(t *testing.T) {
+	instaslices := []inferencev1alpha1.Instaslice{*utils.GenerateFakeCapacity("node-1"), *a30Capacity("node-2")}
+	memory := resource.MustParse("12Gi")
+
+	// the A100 node allocates a 3g.20gb slice, the A30 node a 2g.12gb slice
+	quota, ok := sliceSizeQuota(instaslices, &memory, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 0, quota.Cmp(resource.MustParse("20Gi")))
+
+	// only the A100 node has a profile with 30GB
+	memory = resource.MustParse("30Gi")
+	quota, ok = sliceSizeQuota(instaslices, &memory, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 0, quota.Cmp(resource.MustParse("40Gi")))
+
+	memory = resource.MustParse("50Gi")
+	_, ok = sliceSizeQuota(instaslices, &memory, 0)
+	assert.False(t, ok)
+}
@@ -20,12 +20,14 @@ import (
 	"context"
 	"fmt"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	logr "sigs.k8s.io/controller-runtime/pkg/log"
@@ -33,66 +35,189 @@ import (
 
 const InstaSliceOperatorNamespace = "instaslice-system"
 
-func UpdateOrDeleteInstasliceAllocations(ctx context.Context, kubeClient client.Client, name string, allocResult *inferencev1alpha1.AllocationResult, allocRequest *inferencev1alpha1.AllocationRequest) error {
-	var newInstaslice inferencev1alpha1.Instaslice
+// FieldManager names the component writing an Instaslice. The controller owns the allocation
+// requests and, in the allocation results, the placement, the controller status and the Placed,
+// Ungated, Releasing and TimedOut conditions. The daemonset owns the node resources and, in the
+// allocation results, the daemonset status and the other conditions. A write only changes the
+// fields owned by its manager, the others are kept as they are in the latest Instaslice.
+type FieldManager string
+
+const (
+	ControllerFieldManager FieldManager = "instaslice-controller"
+	DaemonsetFieldManager  FieldManager = "instaslice-daemonset"
+)
+
+// daemonsetConditions are the conditions of an allocation owned by the daemonset
+var daemonsetConditions = map[string]bool{
+	inferencev1alpha1.AllocationConditionSliceCreated:   true,
+	inferencev1alpha1.AllocationConditionConfigMapReady: true,
+	inferencev1alpha1.AllocationConditionReleased:       true,
+	inferencev1alpha1.AllocationConditionFailed:         true,
+}
+
+// UpdateOrDeleteInstasliceAllocations writes the allocation of a pod to an Instaslice and drops
+// the allocations whose slices were deleted by the daemonset. The spec and the status are each
+// written with the resourceVersion they were read at and written again on conflicts, from the
+// latest Instaslice. The request is written before the result, and a request added by the write
+// is removed again when the result cannot be written. A write interrupted in between leaves a
+// request without a result, which holds no slots and is removed by the controller before the pod
+// is placed again. The daemonset never adds an allocation, its writes to allocations that are
+// gone are dropped.
+func UpdateOrDeleteInstasliceAllocations(ctx context.Context, kubeClient client.Client, manager FieldManager, name string, allocResult *inferencev1alpha1.AllocationResult, allocRequest *inferencev1alpha1.AllocationRequest) error {
 	typeNamespacedName := types.NamespacedName{
 		Name:      name,
 		Namespace: InstaSliceOperatorNamespace,
 	}
-	err := kubeClient.Get(ctx, typeNamespacedName, &newInstaslice)
-	if err != nil {
-		return fmt.Errorf("error fetching the instaslice object: %s", name)
+	var podUID types.UID
+	if allocRequest != nil && allocResult != nil {
+		podUID = allocRequest.PodRef.UID
 	}
 
-	originalInstaSliceObj := newInstaslice.DeepCopy()
-
-	if newInstaslice.Spec.PodAllocationRequests == nil {
-		newInstaslice.Spec.PodAllocationRequests = make(map[types.UID]inferencev1alpha1.AllocationRequest)
-	}
-	var keysToDelete []types.UID
-	for uuid, alloc := range newInstaslice.Status.PodAllocationResults {
-		if alloc.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
-			keysToDelete = append(keysToDelete, uuid)
+	var requestAdded bool
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var instaslice inferencev1alpha1.Instaslice
+		if err := kubeClient.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+			return fmt.Errorf("error fetching the instaslice object: %s, err: %w", name, err)
+		}
+		original := instaslice.DeepCopy()
+		if instaslice.Spec.PodAllocationRequests == nil {
+			instaslice.Spec.PodAllocationRequests = make(map[types.UID]inferencev1alpha1.AllocationRequest)
 		}
+		for _, uuid := range deletedAllocations(&instaslice) {
+			delete(instaslice.Spec.PodAllocationRequests, uuid)
+		}
+		if podUID != "" && manager == ControllerFieldManager {
+			_, exists := instaslice.Spec.PodAllocationRequests[podUID]
+			requestAdded = !exists
+			instaslice.Spec.PodAllocationRequests[podUID] = *allocRequest
+		}
+		if equality.Semantic.DeepEqual(original.Spec, instaslice.Spec) {
+			return nil
+		}
+		return kubeClient.Patch(ctx, &instaslice, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}), client.FieldOwner(string(manager)))
+	})
+	if err != nil {
+		return fmt.Errorf("error updating the instaslie object, %s, err: %w", name, err)
 	}
 
-	for _, uuid := range keysToDelete {
-		delete(newInstaslice.Spec.PodAllocationRequests, uuid)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var instaslice inferencev1alpha1.Instaslice
+		if err := kubeClient.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+			return fmt.Errorf("error fetching the instaslice object: %s, err: %w", name, err)
+		}
+		original := instaslice.DeepCopy()
+		if instaslice.Status.PodAllocationResults == nil {
+			instaslice.Status.PodAllocationResults = make(map[types.UID]inferencev1alpha1.AllocationResult)
+		}
+		keysToDelete := deletedAllocations(&instaslice)
+		if podUID != "" {
+			latest, exists := instaslice.Status.PodAllocationResults[podUID]
+			switch {
+			case exists:
+				instaslice.Status.PodAllocationResults[podUID] = ownedAllocationResult(manager, latest, *allocResult)
+			case manager == ControllerFieldManager:
+				instaslice.Status.PodAllocationResults[podUID] = *allocResult
+			default:
+				log.FromContext(ctx).Info("allocation is gone, dropping the write", "manager", manager, "pod uuid", podUID)
+			}
+		}
+		for _, uuid := range keysToDelete {
+			delete(instaslice.Status.PodAllocationResults, uuid)
+		}
+		if equality.Semantic.DeepEqual(original.Status, instaslice.Status) {
+			return nil
+		}
+		if podUID != "" {
+			log.FromContext(ctx).Info("setting status ", "controller", allocResult.AllocationStatus.AllocationStatusController, "podid", podUID)
+			log.FromContext(ctx).Info("setting status ", "daemonset", allocResult.AllocationStatus.AllocationStatusDaemonset, "podid", podUID)
+		}
+		return kubeClient.Status().Patch(ctx, &instaslice, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}), client.FieldOwner(string(manager)))
+	})
+	if err != nil {
+		log.FromContext(ctx).Info("error patching allocation result", "error", err, "pod uuid", podUID)
+		if requestAdded {
+			if removeErr := RemoveInstasliceAllocation(ctx, kubeClient, manager, name, podUID); removeErr != nil {
+				log.FromContext(ctx).Info("error removing the allocation request without result", "error", removeErr, "pod uuid", podUID)
+			}
+		}
+		return fmt.Errorf("error updating the instaslice object status, %s, err: %w", name, err)
 	}
-	if allocRequest != nil && allocRequest.PodRef.UID != "" {
-		newInstaslice.Spec.PodAllocationRequests[allocRequest.PodRef.UID] = *allocRequest
+	return nil
+}
+
+// RemoveInstasliceAllocation removes the allocation of a pod from an Instaslice with the same
+// optimistic writes as UpdateOrDeleteInstasliceAllocations. The result is removed before the
+// request, so that an interrupted removal leaves a request without a result.
+func RemoveInstasliceAllocation(ctx context.Context, kubeClient client.Client, manager FieldManager, name string, podUID types.UID) error {
+	typeNamespacedName := types.NamespacedName{
+		Name:      name,
+		Namespace: InstaSliceOperatorNamespace,
 	}
-	err = kubeClient.Patch(ctx, &newInstaslice, client.MergeFrom(originalInstaSliceObj))
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var instaslice inferencev1alpha1.Instaslice
+		if err := kubeClient.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+			return fmt.Errorf("error fetching the instaslice object: %s, err: %w", name, err)
+		}
+		if _, ok := instaslice.Status.PodAllocationResults[podUID]; !ok {
+			return nil
+		}
+		original := instaslice.DeepCopy()
+		delete(instaslice.Status.PodAllocationResults, podUID)
+		return kubeClient.Status().Patch(ctx, &instaslice, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}), client.FieldOwner(string(manager)))
+	})
 	if err != nil {
-		return fmt.Errorf("error updating the instaslie object, %s, err: %v", name, err)
+		return fmt.Errorf("error removing the allocation result from the instaslice object, %s, err: %w", name, err)
 	}
-
-	err = kubeClient.Get(ctx, typeNamespacedName, &newInstaslice)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var instaslice inferencev1alpha1.Instaslice
+		if err := kubeClient.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+			return fmt.Errorf("error fetching the instaslice object: %s, err: %w", name, err)
+		}
+		if _, ok := instaslice.Spec.PodAllocationRequests[podUID]; !ok {
+			return nil
+		}
+		original := instaslice.DeepCopy()
+		delete(instaslice.Spec.PodAllocationRequests, podUID)
+		return kubeClient.Patch(ctx, &instaslice, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}), client.FieldOwner(string(manager)))
+	})
 	if err != nil {
-		return fmt.Errorf("error fetching the instaslice object: %s", name)
+		return fmt.Errorf("error removing the allocation request from the instaslice object, %s, err: %w", name, err)
 	}
+	return nil
+}
 
-	originalInstaSliceObj = newInstaslice.DeepCopy()
-
-	if newInstaslice.Status.PodAllocationResults == nil {
-		newInstaslice.Status.PodAllocationResults = make(map[types.UID]inferencev1alpha1.AllocationResult)
-	}
-	if allocRequest != nil && allocRequest.PodRef.UID != "" {
-		newInstaslice.Status.PodAllocationResults[allocRequest.PodRef.UID] = *allocResult
+// deletedAllocations returns the pods whose allocation the daemonset deleted
+func deletedAllocations(instaslice *inferencev1alpha1.Instaslice) []types.UID {
+	var keysToDelete []types.UID
+	for uuid, alloc := range instaslice.Status.PodAllocationResults {
+		if alloc.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			keysToDelete = append(keysToDelete, uuid)
+		}
 	}
-	for _, uuid := range keysToDelete {
-		delete(newInstaslice.Status.PodAllocationResults, uuid)
+	return keysToDelete
+}
+
+// ownedAllocationResult returns the allocation written by manager on top of the latest one: the
+// fields owned by manager come from allocResult, the others from latest
+func ownedAllocationResult(manager FieldManager, latest, allocResult inferencev1alpha1.AllocationResult) inferencev1alpha1.AllocationResult {
+	controllerSide, daemonsetSide := allocResult, latest
+	if manager == DaemonsetFieldManager {
+		controllerSide, daemonsetSide = latest, allocResult
 	}
-	if allocRequest != nil && allocResult != nil {
-		log.FromContext(ctx).Info("setting status ", "controller", allocResult.AllocationStatus.AllocationStatusController, "podid", allocRequest.PodRef.UID)
-		log.FromContext(ctx).Info("setting status ", "daemonset", allocResult.AllocationStatus.AllocationStatusDaemonset, "podid", allocRequest.PodRef.UID)
+	merged := *controllerSide.DeepCopy()
+	merged.AllocationStatus.AllocationStatusDaemonset = daemonsetSide.AllocationStatus.AllocationStatusDaemonset
+	merged.Conditions = nil
+	for _, condition := range controllerSide.Conditions {
+		if !daemonsetConditions[condition.Type] {
+			merged.Conditions = append(merged.Conditions, condition)
+		}
 	}
-	err = kubeClient.Status().Patch(ctx, &newInstaslice, client.MergeFrom(originalInstaSliceObj)) // TODO - try with update
-	if err != nil {
-		log.FromContext(ctx).Info("error patching allocation result", "error", err, "pod uuid", allocRequest.PodRef.UID)
-		return fmt.Errorf("error updating the instaslice object status, %s, err: %v", name, err)
+	for _, condition := range daemonsetSide.Conditions {
+		if daemonsetConditions[condition.Type] {
+			merged.Conditions = append(merged.Conditions, condition)
+		}
 	}
-	return nil
+	return merged
 }
 
 func RunningOnOpenshift(ctx context.Context, cl client.Client) bool {
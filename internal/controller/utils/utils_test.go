@@ -0,0 +1,183 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+const podUID = types.UID("model")
+
+// allocationFixture returns a client holding an Instaslice with the allocation of a pod
+func allocationFixture(t *testing.T, allocResult inferencev1alpha1.AllocationResult, funcs interceptor.Funcs) (client.Client, *inferencev1alpha1.AllocationRequest) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, inferencev1alpha1.AddToScheme(scheme))
+	allocRequest := &inferencev1alpha1.AllocationRequest{Profile: "1g.5gb", PodRef: v1.ObjectReference{Name: "model", Namespace: "default", UID: podUID}}
+	instaslice := GenerateFakeCapacity("node-1")
+	instaslice.Spec.PodAllocationRequests = map[types.UID]inferencev1alpha1.AllocationRequest{podUID: *allocRequest}
+	instaslice.Status.PodAllocationResults = map[types.UID]inferencev1alpha1.AllocationResult{podUID: allocResult}
+	kubeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instaslice).WithStatusSubresource(instaslice).
+		WithInterceptorFuncs(funcs).Build()
+	return kubeClient, allocRequest
+}
+
+func getAllocation(t *testing.T, kubeClient client.Client) (inferencev1alpha1.AllocationResult, bool) {
+	var instaslice inferencev1alpha1.Instaslice
+	assert.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, &instaslice))
+	allocResult, ok := instaslice.Status.PodAllocationResults[podUID]
+	return allocResult, ok
+}
+
+func TestUpdateOrDeleteInstasliceAllocationsOwnership(t *testing.T) {
+	ctx := context.Background()
+	creating := inferencev1alpha1.AllocationResult{
+		Nodename:         "node-1",
+		GPUUUID:          "GPU-1",
+		MigPlacement:     inferencev1alpha1.Placement{Start: 0, Size: 1},
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating},
+	}
+	kubeClient, allocRequest := allocationFixture(t, creating, interceptor.Funcs{})
+
+	// the daemonset reports the slices created
+	created := *creating.DeepCopy()
+	created.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+	created.SetCondition(inferencev1alpha1.AllocationConditionSliceCreated, metav1.ConditionTrue, "SlicesCreated", "created")
+	assert.NoError(t, UpdateOrDeleteInstasliceAllocations(ctx, kubeClient, DaemonsetFieldManager, "node-1", &created, allocRequest))
+
+	// a controller write from before the slices were created keeps the fields of the daemonset
+	stale := *creating.DeepCopy()
+	stale.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
+	stale.SetCondition(inferencev1alpha1.AllocationConditionReleasing, metav1.ConditionTrue, "PodDeleted", "deleted")
+	assert.NoError(t, UpdateOrDeleteInstasliceAllocations(ctx, kubeClient, ControllerFieldManager, "node-1", &stale, allocRequest))
+	allocResult, ok := getAllocation(t, kubeClient)
+	if assert.True(t, ok) {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, allocResult.AllocationStatus.AllocationStatusController)
+		assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, allocResult.AllocationStatus.AllocationStatusDaemonset)
+		assert.True(t, meta.IsStatusConditionTrue(allocResult.Conditions, inferencev1alpha1.AllocationConditionSliceCreated))
+		assert.True(t, meta.IsStatusConditionTrue(allocResult.Conditions, inferencev1alpha1.AllocationConditionReleasing))
+	}
+
+	// and the daemonset cannot move the allocation back to creating
+	assert.NoError(t, UpdateOrDeleteInstasliceAllocations(ctx, kubeClient, DaemonsetFieldManager, "node-1", &created, allocRequest))
+	allocResult, _ = getAllocation(t, kubeClient)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, allocResult.AllocationStatus.AllocationStatusController)
+	assert.True(t, meta.IsStatusConditionTrue(allocResult.Conditions, inferencev1alpha1.AllocationConditionReleasing))
+
+	// deleted allocations are dropped by the next write, the daemonset does not write them again
+	deleted := *created.DeepCopy()
+	deleted.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+	assert.NoError(t, UpdateOrDeleteInstasliceAllocations(ctx, kubeClient, DaemonsetFieldManager, "node-1", &deleted, allocRequest))
+	_, ok = getAllocation(t, kubeClient)
+	assert.True(t, ok)
+	assert.NoError(t, UpdateOrDeleteInstasliceAllocations(ctx, kubeClient, ControllerFieldManager, "node-1", nil, nil))
+	_, ok = getAllocation(t, kubeClient)
+	assert.False(t, ok)
+	assert.NoError(t, UpdateOrDeleteInstasliceAllocations(ctx, kubeClient, DaemonsetFieldManager, "node-1", &created, allocRequest))
+	_, ok = getAllocation(t, kubeClient)
+	assert.False(t, ok)
+}
+
+func TestUpdateOrDeleteInstasliceAllocationsConflict(t *testing.T) {
+	ctx := context.Background()
+	created := inferencev1alpha1.AllocationResult{
+		Nodename:     "node-1",
+		GPUUUID:      "GPU-1",
+		MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1},
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+			AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated},
+	}
+	// the daemonset writes the status while the controller writes it
+	patches := 0
+	kubeClient, allocRequest := allocationFixture(t, created, interceptor.Funcs{
+		SubResourcePatch: func(ctx context.Context, kubeClient client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			patches++
+			if patches == 1 {
+				var instaslice inferencev1alpha1.Instaslice
+				assert.NoError(t, kubeClient.Get(ctx, client.ObjectKeyFromObject(obj), &instaslice))
+				failed := instaslice.Status.PodAllocationResults[podUID]
+				failed.SetCondition(inferencev1alpha1.AllocationConditionFailed, metav1.ConditionTrue, "ConfigMapCreationFailed", "timeout")
+				instaslice.Status.PodAllocationResults[podUID] = failed
+				assert.NoError(t, kubeClient.Status().Update(ctx, &instaslice))
+			}
+			return kubeClient.Status().Patch(ctx, obj, patch, opts...)
+		},
+	})
+
+	ungated := *created.DeepCopy()
+	ungated.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusUngated
+	assert.NoError(t, UpdateOrDeleteInstasliceAllocations(ctx, kubeClient, ControllerFieldManager, "node-1", &ungated, allocRequest))
+	assert.Equal(t, 2, patches)
+	allocResult, ok := getAllocation(t, kubeClient)
+	if assert.True(t, ok) {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, allocResult.AllocationStatus.AllocationStatusController)
+		assert.True(t, meta.IsStatusConditionTrue(allocResult.Conditions, inferencev1alpha1.AllocationConditionFailed))
+	}
+}
+
+func TestUpdateOrDeleteInstasliceAllocationsStatusFailure(t *testing.T) {
+	ctx := context.Background()
+	kubeClient, _ := allocationFixture(t, inferencev1alpha1.AllocationResult{}, interceptor.Funcs{
+		SubResourcePatch: func(ctx context.Context, kubeClient client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			return fmt.Errorf("status unavailable")
+		},
+	})
+	creating := inferencev1alpha1.AllocationResult{
+		Nodename:         "node-1",
+		GPUUUID:          "GPU-1",
+		MigPlacement:     inferencev1alpha1.Placement{Start: 1, Size: 1},
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating},
+	}
+	allocRequest := &inferencev1alpha1.AllocationRequest{Profile: "1g.5gb", PodRef: v1.ObjectReference{Name: "placed", Namespace: "default", UID: "placed"}}
+
+	// the request added by a write whose result failed is removed again
+	assert.Error(t, UpdateOrDeleteInstasliceAllocations(ctx, kubeClient, ControllerFieldManager, "node-1", &creating, allocRequest))
+	var instaslice inferencev1alpha1.Instaslice
+	assert.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, &instaslice))
+	assert.NotContains(t, instaslice.Spec.PodAllocationRequests, types.UID("placed"))
+	assert.Contains(t, instaslice.Spec.PodAllocationRequests, podUID)
+}
+
+func TestRemoveInstasliceAllocation(t *testing.T) {
+	ctx := context.Background()
+	kubeClient, _ := allocationFixture(t, inferencev1alpha1.AllocationResult{Nodename: "node-1"}, interceptor.Funcs{
+		Patch: func(ctx context.Context, kubeClient client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			assert.Equal(t, string(ControllerFieldManager), (&client.PatchOptions{}).ApplyOptions(opts).FieldManager)
+			return kubeClient.Patch(ctx, obj, patch, opts...)
+		},
+	})
+	assert.NoError(t, RemoveInstasliceAllocation(ctx, kubeClient, ControllerFieldManager, "node-1", podUID))
+	var instaslice inferencev1alpha1.Instaslice
+	assert.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, &instaslice))
+	assert.NotContains(t, instaslice.Spec.PodAllocationRequests, podUID)
+	assert.NotContains(t, instaslice.Status.PodAllocationResults, podUID)
+	// removing it again is a no-op
+	assert.NoError(t, RemoveInstasliceAllocation(ctx, kubeClient, ControllerFieldManager, "node-1", podUID))
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package simulation reports where pods would be placed on a snapshot of the cluster, with the
+// placement code of the controller, without touching the cluster. The snapshot is copied into an
+// in-memory client that the simulator of the controller reads from.
+package simulation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller"
+	rcache "github.com/openshift/instaslice-operator/internal/controller/cache"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+)
+
+// simulatedNodeCapacity is the CPU and memory of the nodes missing from a snapshot, large enough
+// for any pod
+var simulatedNodeCapacity = v1.ResourceList{
+	v1.ResourceCPU:    resource.MustParse("1M"),
+	v1.ResourceMemory: resource.MustParse("1Ei"),
+}
+
+// Snapshot is the state of the cluster a simulation starts from. Nodes missing for an Instaslice
+// are assumed to have room for the CPU and memory of every pod, Pods are the running pods whose
+// CPU and memory requests count against their node. SliceReservations hold the slices listed in
+// their status for the pods of their namespace.
+type Snapshot struct {
+	Instaslices       []inferencev1alpha1.Instaslice
+	Nodes             []v1.Node
+	Pods              []v1.Pod
+	SliceReservations []inferencev1alpha1.SliceReservation
+}
+
+// Report is the outcome of a simulation
+type Report struct {
+	// Placed lists the pods that fit with their slices, in the order they were placed
+	Placed []controller.SimulatedPlacement `json:"placed"`
+	// Unplaced lists the pods that do not fit and why
+	Unplaced []Failure `json:"unplaced"`
+	// Fragmentation describes the free slots of every GPU once the pods are placed
+	Fragmentation []controller.GPUFragmentation `json:"fragmentation"`
+}
+
+// Failure is a pod that does not fit
+type Failure struct {
+	Pod    string `json:"pod"`
+	Reason string `json:"reason"`
+}
+
+// Run places pods on the snapshot in the order the controller would allocate them, by priority
+// and then in the order they are listed, and reports where they went. Neither the snapshot nor
+// the pods are modified. A nil cfg uses the default configuration.
+func Run(ctx context.Context, snapshot Snapshot, pods []v1.Pod, cfg *config.Config) (*Report, error) {
+	c, resourceCache, err := snapshotClient(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	simulator, err := controller.NewSimulator(ctx, c, resourceCache, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	queued := make([]*v1.Pod, 0, len(pods))
+	names := make(map[types.NamespacedName]bool, len(pods))
+	for i := range pods {
+		pod := pods[i].DeepCopy()
+		if pod.Namespace == "" {
+			pod.Namespace = "default"
+		}
+		name := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+		if names[name] {
+			return nil, fmt.Errorf("pod %s is listed twice", name)
+		}
+		names[name] = true
+		if pod.UID == "" {
+			pod.UID = types.UID("simulated-" + pod.Namespace + "-" + pod.Name)
+		}
+		queued = append(queued, pod)
+	}
+	sort.SliceStable(queued, func(i, j int) bool {
+		return podPriority(queued[i]) > podPriority(queued[j])
+	})
+
+	report := &Report{Placed: []controller.SimulatedPlacement{}, Unplaced: []Failure{}}
+	for _, pod := range queued {
+		placement, reason, err := simulator.PlacePod(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		if placement == nil {
+			report.Unplaced = append(report.Unplaced, Failure{Pod: pod.Namespace + "/" + pod.Name, Reason: reason})
+			continue
+		}
+		report.Placed = append(report.Placed, *placement)
+	}
+	if report.Fragmentation, err = simulator.Fragmentation(ctx); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// snapshotClient returns an in-memory client holding a copy of the snapshot, and the CPU and
+// memory left on its nodes
+func snapshotClient(snapshot Snapshot) (client.Client, *rcache.ResourceCache, error) {
+	scheme := runtime.NewScheme()
+	if err := v1.AddToScheme(scheme); err != nil {
+		return nil, nil, err
+	}
+	if err := inferencev1alpha1.AddToScheme(scheme); err != nil {
+		return nil, nil, err
+	}
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	resourceCache := rcache.NewResourceCache()
+	nodes := make(map[string]bool, len(snapshot.Nodes))
+	for i := range snapshot.Nodes {
+		node := snapshot.Nodes[i].DeepCopy()
+		node.ResourceVersion = ""
+		nodes[node.Name] = true
+		builder = builder.WithObjects(node)
+		resourceCache.ResourceEventHandlerForNode().AddFunc(node)
+	}
+	for i := range snapshot.Instaslices {
+		instaslice := snapshot.Instaslices[i].DeepCopy()
+		instaslice.ResourceVersion = ""
+		if instaslice.Namespace == "" {
+			instaslice.Namespace = controller.InstaSliceOperatorNamespace
+		}
+		builder = builder.WithObjects(instaslice)
+		if nodes[instaslice.Name] {
+			continue
+		}
+		node := &v1.Node{}
+		node.Name = instaslice.Name
+		node.Status.Allocatable = simulatedNodeCapacity.DeepCopy()
+		nodes[node.Name] = true
+		builder = builder.WithObjects(node)
+		resourceCache.ResourceEventHandlerForNode().AddFunc(node)
+	}
+	for i := range snapshot.SliceReservations {
+		reservation := snapshot.SliceReservations[i].DeepCopy()
+		reservation.ResourceVersion = ""
+		if reservation.Namespace == "" {
+			reservation.Namespace = "default"
+		}
+		if reservation.UID == "" {
+			reservation.UID = types.UID("simulated-" + reservation.Namespace + "-" + reservation.Name)
+		}
+		builder = builder.WithObjects(reservation)
+	}
+	for i := range snapshot.Pods {
+		if phase := snapshot.Pods[i].Status.Phase; phase == v1.PodSucceeded || phase == v1.PodFailed {
+			continue
+		}
+		resourceCache.ResourceEventHandlerForPod().AddFunc(snapshot.Pods[i].DeepCopy())
+	}
+	return builder.Build(), resourceCache, nil
+}
+
+// podPriority returns the priority of a pod, pods without one have priority 0
+func podPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
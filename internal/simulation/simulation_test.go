@@ -0,0 +1,121 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulation
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// gpuUUIDs returns the sorted UUIDs of the GPUs of a node
+func gpuUUIDs(instaslice *inferencev1alpha1.Instaslice) []string {
+	var uuids []string
+	for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+		uuids = append(uuids, gpu.GPUUUID)
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+// simulatedPod returns a pod with one container requesting a slice of profile
+func simulatedPod(name, profile string, priority int32) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PodSpec{
+			Priority: &priority,
+			Containers: []v1.Container{{
+				Name: "main",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{v1.ResourceName(controller.NvidiaMIGPrefix + profile): resource.MustParse("1")},
+				},
+			}},
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpus := gpuUUIDs(instaslice)
+	snapshot := Snapshot{Instaslices: []inferencev1alpha1.Instaslice{*instaslice}}
+	pods := []v1.Pod{
+		simulatedPod("first", "7g.40gb", 0),
+		simulatedPod("second", "7g.40gb", 0),
+		simulatedPod("urgent", "3g.20gb", 10),
+	}
+
+	report, err := Run(context.Background(), snapshot, pods, nil)
+	assert.NoError(t, err)
+
+	// the higher priority pod is placed first, the last whole GPU pod does not fit
+	assert.Len(t, report.Placed, 2)
+	assert.Equal(t, "default/urgent", report.Placed[0].Pod)
+	assert.Equal(t, "node-1", report.Placed[0].Node)
+	assert.Equal(t, []inferencev1alpha1.SliceResult{
+		{GPUUUID: gpus[0], MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 4}, Profile: "3g.20gb"},
+	}, report.Placed[0].Slices)
+	assert.Equal(t, "default/first", report.Placed[1].Pod)
+	assert.Equal(t, gpus[1], report.Placed[1].Slices[0].GPUUUID)
+	assert.Len(t, report.Unplaced, 1)
+	assert.Equal(t, "default/second", report.Unplaced[0].Pod)
+	assert.Contains(t, report.Unplaced[0].Reason, "node-1")
+
+	assert.Equal(t, []controller.GPUFragmentation{
+		{Node: "node-1", GPUUUID: gpus[0], FreeSlots: 4, LargestFreeBlock: 4, LargestProfile: "3g.20gb"},
+		{Node: "node-1", GPUUUID: gpus[1]},
+	}, report.Fragmentation)
+
+	// the snapshot and the pods are left as they were
+	assert.Equal(t, *utils.GenerateFakeCapacity("node-1"), snapshot.Instaslices[0])
+	assert.Empty(t, pods[0].Namespace)
+	assert.Empty(t, pods[0].Annotations)
+	assert.Empty(t, pods[0].Spec.SchedulingGates)
+}
+
+func TestRunExistingAllocations(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpus := gpuUUIDs(instaslice)
+	instaslice.Status.PodAllocationResults = map[types.UID]inferencev1alpha1.AllocationResult{
+		"running": {
+			GPUUUID:          gpus[0],
+			MigPlacement:     inferencev1alpha1.Placement{Start: 0, Size: 8},
+			Nodename:         "node-1",
+			AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusUngated},
+		},
+	}
+	snapshot := Snapshot{Instaslices: []inferencev1alpha1.Instaslice{*instaslice}}
+	cpuPod := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cpu"}, Spec: v1.PodSpec{Containers: []v1.Container{{Name: "main"}}}}
+
+	report, err := Run(context.Background(), snapshot, []v1.Pod{simulatedPod("new", "7g.40gb", 0), cpuPod}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, report.Placed, 1)
+	assert.Equal(t, gpus[1], report.Placed[0].Slices[0].GPUUUID)
+	assert.Equal(t, []Failure{{Pod: "default/cpu", Reason: "the pod requests no MIG slices"}}, report.Unplaced)
+
+	_, err = Run(context.Background(), snapshot, []v1.Pod{simulatedPod("new", "1g.5gb", 0), simulatedPod("new", "1g.5gb", 0)}, nil)
+	assert.ErrorContains(t, err, "listed twice")
+}